@@ -0,0 +1,184 @@
+package athletes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+)
+
+// RecentWindow is the span of the rolling best power curve.
+const RecentWindow = 90 * 24 * time.Hour
+
+// WorkoutCurve is the power curve of one workout.
+type WorkoutCurve struct {
+	WorkoutID int           `json:"workout_id"`
+	UserID    int           `json:"user_id"`
+	Starts    time.Time     `json:"starts"`
+	Curve     metrics.Curve `json:"curve"`
+}
+
+// PersonalRecord is an athlete's best power for a duration, and the workout
+// it was set in.
+type PersonalRecord struct {
+	Duration  int       `json:"duration"`
+	Watts     float64   `json:"watts"`
+	WorkoutID int       `json:"workout_id"`
+	Starts    time.Time `json:"starts"`
+}
+
+// CurveStore persists workout power curves and answers best-curve queries
+// over them.
+type CurveStore interface {
+	// SaveCurve replaces the stored curve of curve.WorkoutID.
+	SaveCurve(ctx context.Context, curve WorkoutCurve) error
+	// Best returns, for each duration, the highest power in the curves of
+	// userID's workouts that started at or after since and before until,
+	// ordered by duration. Ties go to the earliest workout.
+	Best(ctx context.Context, userID int, since, until time.Time) ([]PersonalRecord, error)
+}
+
+// best reduces curves to the best point for each duration.
+func best(curves []WorkoutCurve) []PersonalRecord {
+	records := make(map[int]PersonalRecord)
+	for _, curve := range curves {
+		for _, point := range curve.Curve {
+			record, found := records[point.Duration]
+			if !found || point.Watts > record.Watts || (point.Watts == record.Watts && curve.Starts.Before(record.Starts)) {
+				records[point.Duration] = PersonalRecord{Duration: point.Duration, Watts: point.Watts, WorkoutID: curve.WorkoutID, Starts: curve.Starts}
+			}
+		}
+	}
+
+	out := make([]PersonalRecord, 0, len(records))
+	for _, record := range records {
+		out = append(out, record)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Duration < out[j].Duration })
+	return out
+}
+
+// MemoryCurveStore is a CurveStore held in process memory.
+type MemoryCurveStore struct {
+	mu     sync.Mutex
+	curves map[int]WorkoutCurve
+}
+
+func NewMemoryCurveStore() *MemoryCurveStore {
+	return &MemoryCurveStore{curves: make(map[int]WorkoutCurve)}
+}
+
+func (s *MemoryCurveStore) SaveCurve(_ context.Context, curve WorkoutCurve) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.curves[curve.WorkoutID] = curve
+	return nil
+}
+
+func (s *MemoryCurveStore) Best(_ context.Context, userID int, since, until time.Time) ([]PersonalRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var curves []WorkoutCurve
+	for _, curve := range s.curves {
+		if curve.UserID == userID && !curve.Starts.Before(since) && curve.Starts.Before(until) {
+			curves = append(curves, curve)
+		}
+	}
+	return best(curves), nil
+}
+
+// SQLiteCurveStore is a CurveStore persisted in SQLite, one row per point.
+type SQLiteCurveStore struct {
+	db *sql.DB
+}
+
+var curveMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create workout_power_curves",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS workout_power_curves (
+				workout_id INTEGER NOT NULL,
+				duration   INTEGER NOT NULL,
+				user_id    INTEGER NOT NULL,
+				starts     INTEGER NOT NULL,
+				watts      REAL NOT NULL,
+				PRIMARY KEY (workout_id, duration)
+			)`,
+			`CREATE INDEX IF NOT EXISTS workout_power_curves_user_starts ON workout_power_curves (user_id, starts)`,
+		},
+	},
+}
+
+func NewSQLiteCurveStore(db *sql.DB) (*SQLiteCurveStore, error) {
+	if err := database.Migrate(db, database.SQLite, "workout_power_curves", curveMigrations); err != nil {
+		return nil, err
+	}
+	return &SQLiteCurveStore{db: db}, nil
+}
+
+func (s *SQLiteCurveStore) SaveCurve(ctx context.Context, curve WorkoutCurve) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error saving power curve for workout %d: %w", curve.WorkoutID, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM workout_power_curves WHERE workout_id = ?`, curve.WorkoutID); err != nil {
+		return fmt.Errorf("error saving power curve for workout %d: %w", curve.WorkoutID, err)
+	}
+	for _, point := range curve.Curve {
+		_, err := tx.ExecContext(ctx, `INSERT INTO workout_power_curves (workout_id, duration, user_id, starts, watts)
+			VALUES (?, ?, ?, ?, ?)`,
+			curve.WorkoutID, point.Duration, curve.UserID, curve.Starts.Unix(), point.Watts)
+		if err != nil {
+			return fmt.Errorf("error saving power curve for workout %d: %w", curve.WorkoutID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error saving power curve for workout %d: %w", curve.WorkoutID, err)
+	}
+	return nil
+}
+
+func (s *SQLiteCurveStore) Best(ctx context.Context, userID int, since, until time.Time) ([]PersonalRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT workout_id, duration, starts, watts FROM workout_power_curves
+		WHERE user_id = ? AND starts >= ? AND starts < ?`,
+		userID, since.Unix(), until.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("error loading power curves for user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	curves := make(map[int]*WorkoutCurve)
+	for rows.Next() {
+		var workoutID int
+		var point metrics.CurvePoint
+		var starts int64
+		if err := rows.Scan(&workoutID, &point.Duration, &starts, &point.Watts); err != nil {
+			return nil, fmt.Errorf("error loading power curves for user %d: %w", userID, err)
+		}
+		curve, ok := curves[workoutID]
+		if !ok {
+			curve = &WorkoutCurve{WorkoutID: workoutID, UserID: userID, Starts: time.Unix(starts, 0).UTC()}
+			curves[workoutID] = curve
+		}
+		curve.Curve = append(curve.Curve, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error loading power curves for user %d: %w", userID, err)
+	}
+
+	list := make([]WorkoutCurve, 0, len(curves))
+	for _, curve := range curves {
+		list = append(list, *curve)
+	}
+	return best(list), nil
+}
@@ -0,0 +1,86 @@
+package athletes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+	"github.com/stretchr/testify/require"
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+var curveStores = []storeCase[CurveStore]{
+	{name: "Memory", open: func(*sql.DB) (CurveStore, error) { return NewMemoryCurveStore(), nil }},
+	{name: "SQLite", open: func(db *sql.DB) (CurveStore, error) { return NewSQLiteCurveStore(db) }},
+}
+
+func TestCurveStores(t *testing.T) {
+
+	march := time.Date(2024, 3, 1, 7, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 1, 7, 0, 0, 0, time.UTC)
+	may := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+
+	runStores(t, curveStores, func(t *testing.T, store CurveStore) {
+		ctx := context.Background()
+
+		require.NoError(t, store.SaveCurve(ctx, WorkoutCurve{WorkoutID: 1, UserID: 1120489, Starts: march,
+			Curve: metrics.Curve{{Duration: 1, Watts: 900}, {Duration: 5, Watts: 700}}}))
+		require.NoError(t, store.SaveCurve(ctx, WorkoutCurve{WorkoutID: 2, UserID: 1120489, Starts: april,
+			Curve: metrics.Curve{{Duration: 1, Watts: 800}, {Duration: 5, Watts: 750}, {Duration: 10, Watts: 600}}}))
+		// Another athlete's workout is never included.
+		require.NoError(t, store.SaveCurve(ctx, WorkoutCurve{WorkoutID: 3, UserID: 1, Starts: april,
+			Curve: metrics.Curve{{Duration: 1, Watts: 1500}}}))
+
+		records, err := store.Best(ctx, 1120489, time.Time{}, may)
+		require.NoError(t, err)
+		require.Equal(t, []PersonalRecord{
+			{Duration: 1, Watts: 900, WorkoutID: 1, Starts: march},
+			{Duration: 5, Watts: 750, WorkoutID: 2, Starts: april},
+			{Duration: 10, Watts: 600, WorkoutID: 2, Starts: april},
+		}, records)
+
+		records, err = store.Best(ctx, 1120489, time.Time{}, april)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+
+		// Saving a workout again replaces its curve.
+		require.NoError(t, store.SaveCurve(ctx, WorkoutCurve{WorkoutID: 2, UserID: 1120489, Starts: april,
+			Curve: metrics.Curve{{Duration: 1, Watts: 800}}}))
+		records, err = store.Best(ctx, 1120489, april, may)
+		require.NoError(t, err)
+		require.Equal(t, []PersonalRecord{{Duration: 1, Watts: 800, WorkoutID: 2, Starts: april}}, records)
+	})
+}
+
+func TestPowerCurveHandler(t *testing.T) {
+
+	ctx := context.Background()
+	curves := NewMemoryCurveStore()
+	recent := time.Now().Add(-30 * 24 * time.Hour).UTC().Truncate(time.Second)
+	old := time.Now().Add(-200 * 24 * time.Hour).UTC().Truncate(time.Second)
+	require.NoError(t, curves.SaveCurve(ctx, WorkoutCurve{WorkoutID: 1, UserID: 1120489, Starts: old, Curve: metrics.Curve{{Duration: 1, Watts: 900}}}))
+	require.NoError(t, curves.SaveCurve(ctx, WorkoutCurve{WorkoutID: 2, UserID: 1120489, Starts: recent, Curve: metrics.Curve{{Duration: 1, Watts: 800}}}))
+
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/athletes/:user_id/power-curve"), PowerCurve(curves))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1120489/power-curve", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+
+	var body PowerCurveResponse
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	require.Equal(t, []PersonalRecord{{Duration: 1, Watts: 900, WorkoutID: 1, Starts: old}}, body.AllTime)
+	require.Equal(t, []PersonalRecord{{Duration: 1, Watts: 800, WorkoutID: 2, Starts: recent}}, body.Last90Days)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1/power-curve", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.JSONEq(t, `{"all_time":[],"last_90_days":[]}`, response.Body.String())
+}
@@ -0,0 +1,166 @@
+package athletes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+	"goji.io/pat"
+)
+
+// GetProfile returns the profile for the :user_id path parameter.
+func GetProfile(profiles ProfileStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		profile, found, err := profiles.Get(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error loading profile for user %d: %v", userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, profile)
+	}
+}
+
+// PutProfile replaces the profile for the :user_id path parameter with the
+// JSON request body. Workouts processed afterwards use the new settings.
+func PutProfile(profiles ProfileStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		var profile Profile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			http.Error(w, "Invalid profile", http.StatusBadRequest)
+			return
+		}
+		if err := profile.Validate(); err != nil {
+			http.Error(w, "Invalid profile: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		profile.UserID = userID
+		profile.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+
+		if err := profiles.Save(r.Context(), profile); err != nil {
+			log.Printf("Error saving profile for user %d: %v", userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Profile updated for user %d", userID)
+		writeJSON(w, http.StatusOK, profile)
+	}
+}
+
+// PowerCurveResponse is an athlete's best power curves.
+type PowerCurveResponse struct {
+	AllTime    []PersonalRecord `json:"all_time"`
+	Last90Days []PersonalRecord `json:"last_90_days"`
+}
+
+// PowerCurve returns the all-time and rolling 90 day best power curves of the
+// :user_id path parameter.
+func PowerCurve(curves CurveStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		// Include workouts starting right now.
+		now := time.Now().Add(time.Second)
+		var response PowerCurveResponse
+		response.AllTime, err = curves.Best(r.Context(), userID, time.Time{}, now)
+		if err == nil {
+			response.Last90Days, err = curves.Best(r.Context(), userID, now.Add(-RecentWindow), now)
+		}
+		if err != nil {
+			log.Printf("Error loading power curves for user %d: %v", userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// PMCResponse is an athlete's performance management chart.
+type PMCResponse struct {
+	UserID int              `json:"user_id"`
+	From   string           `json:"from"`
+	To     string           `json:"to"`
+	Days   []metrics.PMCDay `json:"days"`
+}
+
+// DefaultPMCDays is the number of days the chart covers when no range is given.
+const DefaultPMCDays = 90
+
+// maxPMCDays bounds the range a single request can ask for.
+const maxPMCDays = 3660
+
+// PMC returns the daily fitness, fatigue and form of the :user_id path
+// parameter between the from and to query parameters, UTC days formatted as
+// 2006-01-02 and both included. The range defaults to the last 90 days.
+func PMC(aggregator *PMCAggregator) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		to := time.Now().UTC().Truncate(24 * time.Hour)
+		if value := r.URL.Query().Get("to"); value != "" {
+			if to, err = time.Parse(DateLayout, value); err != nil {
+				http.Error(w, "Invalid to date", http.StatusBadRequest)
+				return
+			}
+		}
+		from := to.AddDate(0, 0, 1-DefaultPMCDays)
+		if value := r.URL.Query().Get("from"); value != "" {
+			if from, err = time.Parse(DateLayout, value); err != nil {
+				http.Error(w, "Invalid from date", http.StatusBadRequest)
+				return
+			}
+		}
+		if to.Before(from) || to.Sub(from) >= maxPMCDays*24*time.Hour {
+			http.Error(w, "Invalid date range", http.StatusBadRequest)
+			return
+		}
+
+		days, err := aggregator.Series(r.Context(), userID, from, to)
+		if err != nil {
+			log.Printf("Error loading training load for user %d: %v", userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, PMCResponse{
+			UserID: userID,
+			From:   from.Format(DateLayout),
+			To:     to.Format(DateLayout),
+			Days:   days,
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(body)
+}
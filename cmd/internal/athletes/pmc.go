@@ -0,0 +1,513 @@
+package athletes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+)
+
+// DateLayout formats the UTC days of the performance management chart.
+const DateLayout = "2006-01-02"
+
+// DefaultPMCInterval is how often the aggregator recomputes stale charts.
+const DefaultPMCInterval = time.Hour
+
+// PMCIntervalFromEnv reads PMC_INTERVAL, returning DefaultPMCInterval when it
+// is unset. It is an error for it not to be a positive duration.
+func PMCIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("PMC_INTERVAL")
+	if value == "" {
+		return DefaultPMCInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid PMC_INTERVAL %q, must be a positive duration such as 1h", value)
+	}
+	return interval, nil
+}
+
+// Sources of a workout's training load.
+const (
+	LoadFromPower = "power"
+	LoadFromWahoo = "wahoo"
+	LoadNone      = "none"
+)
+
+// WorkoutLoad is the training stress of one workout.
+type WorkoutLoad struct {
+	WorkoutID int
+	UserID    int
+	Starts    time.Time
+	TSS       float64
+	// Source says where TSS came from: computed from power, sent by
+	// Wahoo, or none for workouts without any.
+	Source string
+}
+
+// DailyLoad is the total training stress of an athlete's workouts on a day.
+type DailyLoad struct {
+	Date string
+	TSS  float64
+}
+
+// StaleSeries marks an athlete's chart as needing recomputing from the UTC
+// day Since onward. Version changes every time the mark is updated, so a
+// recompute only clears the mark it started from.
+type StaleSeries struct {
+	UserID  int
+	Since   string
+	Version int64
+}
+
+// PMCStore persists workout loads and the performance management charts
+// derived from them. Charts are stored from an athlete's first workout to
+// their last.
+type PMCStore interface {
+	// SaveLoad records a workout's load, replacing any earlier one, and marks
+	// the athlete's chart stale from the day the workout, or its earlier
+	// version, started.
+	SaveLoad(ctx context.Context, load WorkoutLoad) error
+	// Stale returns the charts that need recomputing.
+	Stale(ctx context.Context) ([]StaleSeries, error)
+	// DailyLoads returns the athlete's daily loads from since onward, in date
+	// order, for the days they have workouts.
+	DailyLoads(ctx context.Context, userID int, since string) ([]DailyLoad, error)
+	// Days returns the stored chart between from and to inclusive, in date
+	// order. An empty from reads from the start.
+	Days(ctx context.Context, userID int, from, to string) ([]metrics.PMCDay, error)
+	// ReplaceDays replaces the athlete's chart from stale.Since onward with
+	// days, and clears the stale mark unless it has changed since.
+	ReplaceDays(ctx context.Context, stale StaleSeries, days []metrics.PMCDay) error
+}
+
+// PMCAggregator keeps athletes' performance management charts up to date with
+// the workout loads the pipeline records.
+type PMCAggregator struct {
+	store PMCStore
+	wg    sync.WaitGroup
+	// recomputing serializes recomputes, so that one started from an older
+	// stale mark cannot overwrite days a later one has already stored.
+	recomputing sync.Mutex
+}
+
+func NewPMCAggregator(store PMCStore) *PMCAggregator {
+	return &PMCAggregator{store: store}
+}
+
+// Start recomputes stale charts every interval until ctx is cancelled. Use
+// Wait to block until the last run has finished.
+func (a *PMCAggregator) Start(ctx context.Context, interval time.Duration) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := a.RunStale(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error aggregating training load: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (a *PMCAggregator) Wait() {
+	a.wg.Wait()
+}
+
+// RunStale recomputes every stale chart.
+func (a *PMCAggregator) RunStale(ctx context.Context) error {
+	stale, err := a.store.Stale(ctx)
+	if err != nil {
+		return err
+	}
+	for _, series := range stale {
+		if err := a.recompute(ctx, series); err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("Recomputed training load for %d athletes", len(stale))
+	}
+	return nil
+}
+
+// recompute rebuilds a chart from the day it went stale, continuing from the
+// last stored day before. The rest days in between are stored too, so that
+// fitness and fatigue decay over them.
+func (a *PMCAggregator) recompute(ctx context.Context, stale StaleSeries) error {
+	a.recomputing.Lock()
+	defer a.recomputing.Unlock()
+
+	since, err := time.Parse(DateLayout, stale.Since)
+	if err != nil {
+		return fmt.Errorf("error recomputing training load for user %d: %w", stale.UserID, err)
+	}
+
+	var previous metrics.PMCDay
+	earlier, err := a.store.Days(ctx, stale.UserID, "", since.AddDate(0, 0, -1).Format(DateLayout))
+	if err != nil {
+		return err
+	}
+	start := since
+	if len(earlier) > 0 {
+		previous = earlier[len(earlier)-1]
+		last, err := time.Parse(DateLayout, previous.Date)
+		if err != nil {
+			return fmt.Errorf("error recomputing training load for user %d: %w", stale.UserID, err)
+		}
+		start = last.AddDate(0, 0, 1)
+	}
+
+	loads, err := a.store.DailyLoads(ctx, stale.UserID, stale.Since)
+	if err != nil {
+		return err
+	}
+	var days []metrics.PMCDay
+	if len(loads) > 0 {
+		tss := make(map[string]float64, len(loads))
+		for _, load := range loads {
+			tss[load.Date] = load.TSS
+		}
+		last := loads[len(loads)-1].Date
+		for date := start; date.Format(DateLayout) <= last; date = date.AddDate(0, 0, 1) {
+			key := date.Format(DateLayout)
+			previous = metrics.NextPMCDay(previous, key, tss[key])
+			days = append(days, previous)
+		}
+	}
+	return a.store.ReplaceDays(ctx, stale, days)
+}
+
+// Series returns the athlete's chart for every day from from to to inclusive,
+// recomputing it first if it is stale. Days before the athlete's first workout
+// are zero, and days after their last are projected from it with no training.
+func (a *PMCAggregator) Series(ctx context.Context, userID int, from, to time.Time) ([]metrics.PMCDay, error) {
+	stale, err := a.store.Stale(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, series := range stale {
+		if series.UserID == userID {
+			if err := a.recompute(ctx, series); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	stored, err := a.store.Days(ctx, userID, "", to.Format(DateLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []metrics.PMCDay
+	var previous metrics.PMCDay
+	i := 0
+	for date := from; !date.After(to); date = date.AddDate(0, 0, 1) {
+		key := date.Format(DateLayout)
+		for i < len(stored) && stored[i].Date < key {
+			previous = stored[i]
+			i++
+		}
+		if i < len(stored) && stored[i].Date == key {
+			previous = stored[i]
+			i++
+		} else {
+			previous = metrics.NextPMCDay(previous, key, 0)
+		}
+		out = append(out, previous.Rounded())
+	}
+	return out, nil
+}
+
+// MemoryPMCStore is a PMCStore held in process memory.
+type MemoryPMCStore struct {
+	mu      sync.Mutex
+	loads   map[int]WorkoutLoad
+	days    map[int][]metrics.PMCDay
+	stale   map[int]StaleSeries
+	version int64
+}
+
+func NewMemoryPMCStore() *MemoryPMCStore {
+	return &MemoryPMCStore{
+		loads: make(map[int]WorkoutLoad),
+		days:  make(map[int][]metrics.PMCDay),
+		stale: make(map[int]StaleSeries),
+	}
+}
+
+func (s *MemoryPMCStore) SaveLoad(_ context.Context, load WorkoutLoad) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	since := load.Starts.UTC().Format(DateLayout)
+	if old, ok := s.loads[load.WorkoutID]; ok {
+		if day := old.Starts.UTC().Format(DateLayout); day < since {
+			since = day
+		}
+	}
+	s.loads[load.WorkoutID] = load
+
+	s.version++
+	if stale, ok := s.stale[load.UserID]; ok && stale.Since < since {
+		since = stale.Since
+	}
+	s.stale[load.UserID] = StaleSeries{UserID: load.UserID, Since: since, Version: s.version}
+	return nil
+}
+
+func (s *MemoryPMCStore) Stale(_ context.Context) ([]StaleSeries, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]StaleSeries, 0, len(s.stale))
+	for _, stale := range s.stale {
+		out = append(out, stale)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UserID < out[j].UserID })
+	return out, nil
+}
+
+func (s *MemoryPMCStore) DailyLoads(_ context.Context, userID int, since string) ([]DailyLoad, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals := make(map[string]float64)
+	for _, load := range s.loads {
+		if date := load.Starts.UTC().Format(DateLayout); load.UserID == userID && date >= since {
+			totals[date] += load.TSS
+		}
+	}
+	out := make([]DailyLoad, 0, len(totals))
+	for date, tss := range totals {
+		out = append(out, DailyLoad{Date: date, TSS: tss})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Date < out[j].Date })
+	return out, nil
+}
+
+func (s *MemoryPMCStore) Days(_ context.Context, userID int, from, to string) ([]metrics.PMCDay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []metrics.PMCDay
+	for _, day := range s.days[userID] {
+		if day.Date >= from && day.Date <= to {
+			out = append(out, day)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryPMCStore) ReplaceDays(_ context.Context, stale StaleSeries, days []metrics.PMCDay) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var kept []metrics.PMCDay
+	for _, day := range s.days[stale.UserID] {
+		if day.Date < stale.Since {
+			kept = append(kept, day)
+		}
+	}
+	s.days[stale.UserID] = append(kept, days...)
+
+	if current, ok := s.stale[stale.UserID]; ok && current.Version == stale.Version {
+		delete(s.stale, stale.UserID)
+	}
+	return nil
+}
+
+// SQLitePMCStore is a PMCStore persisted in SQLite.
+type SQLitePMCStore struct {
+	db *sql.DB
+}
+
+var pmcMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create training load tables",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS workout_loads (
+				workout_id INTEGER PRIMARY KEY,
+				user_id    INTEGER NOT NULL,
+				day        TEXT NOT NULL,
+				tss        REAL NOT NULL,
+				source     TEXT NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS workout_loads_user_day ON workout_loads (user_id, day)`,
+			`CREATE TABLE IF NOT EXISTS pmc_days (
+				user_id INTEGER NOT NULL,
+				day     TEXT NOT NULL,
+				tss     REAL NOT NULL,
+				ctl     REAL NOT NULL,
+				atl     REAL NOT NULL,
+				tsb     REAL NOT NULL,
+				PRIMARY KEY (user_id, day)
+			)`,
+			`CREATE TABLE IF NOT EXISTS pmc_stale (
+				user_id INTEGER PRIMARY KEY,
+				since   TEXT NOT NULL,
+				version INTEGER NOT NULL
+			)`,
+		},
+	},
+}
+
+func NewSQLitePMCStore(db *sql.DB) (*SQLitePMCStore, error) {
+	if err := database.Migrate(db, database.SQLite, "training_load", pmcMigrations); err != nil {
+		return nil, err
+	}
+	return &SQLitePMCStore{db: db}, nil
+}
+
+func (s *SQLitePMCStore) SaveLoad(ctx context.Context, load WorkoutLoad) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error saving load for workout %d: %w", load.WorkoutID, err)
+	}
+	defer tx.Rollback()
+
+	since := load.Starts.UTC().Format(DateLayout)
+	var old string
+	err = tx.QueryRowContext(ctx, `SELECT day FROM workout_loads WHERE workout_id = ?`, load.WorkoutID).Scan(&old)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error saving load for workout %d: %w", load.WorkoutID, err)
+	}
+	if old != "" && old < since {
+		since = old
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO workout_loads (workout_id, user_id, day, tss, source)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(workout_id) DO UPDATE SET
+			user_id = excluded.user_id,
+			day = excluded.day,
+			tss = excluded.tss,
+			source = excluded.source`,
+		load.WorkoutID, load.UserID, load.Starts.UTC().Format(DateLayout), load.TSS, load.Source)
+	if err != nil {
+		return fmt.Errorf("error saving load for workout %d: %w", load.WorkoutID, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO pmc_stale (user_id, since, version)
+		VALUES (?, ?, 1)
+		ON CONFLICT(user_id) DO UPDATE SET
+			since = MIN(since, excluded.since),
+			version = version + 1`,
+		load.UserID, since)
+	if err != nil {
+		return fmt.Errorf("error marking training load stale for user %d: %w", load.UserID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error saving load for workout %d: %w", load.WorkoutID, err)
+	}
+	return nil
+}
+
+func (s *SQLitePMCStore) Stale(ctx context.Context) ([]StaleSeries, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id, since, version FROM pmc_stale ORDER BY user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("error loading stale training load: %w", err)
+	}
+	defer rows.Close()
+
+	var out []StaleSeries
+	for rows.Next() {
+		var stale StaleSeries
+		if err := rows.Scan(&stale.UserID, &stale.Since, &stale.Version); err != nil {
+			return nil, fmt.Errorf("error loading stale training load: %w", err)
+		}
+		out = append(out, stale)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error loading stale training load: %w", err)
+	}
+	return out, nil
+}
+
+func (s *SQLitePMCStore) DailyLoads(ctx context.Context, userID int, since string) ([]DailyLoad, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT day, SUM(tss) FROM workout_loads
+		WHERE user_id = ? AND day >= ? GROUP BY day ORDER BY day`, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("error loading daily load for user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var out []DailyLoad
+	for rows.Next() {
+		var load DailyLoad
+		if err := rows.Scan(&load.Date, &load.TSS); err != nil {
+			return nil, fmt.Errorf("error loading daily load for user %d: %w", userID, err)
+		}
+		out = append(out, load)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error loading daily load for user %d: %w", userID, err)
+	}
+	return out, nil
+}
+
+func (s *SQLitePMCStore) Days(ctx context.Context, userID int, from, to string) ([]metrics.PMCDay, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT day, tss, ctl, atl, tsb FROM pmc_days
+		WHERE user_id = ? AND day >= ? AND day <= ? ORDER BY day`, userID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error loading training load for user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var out []metrics.PMCDay
+	for rows.Next() {
+		var day metrics.PMCDay
+		if err := rows.Scan(&day.Date, &day.TSS, &day.CTL, &day.ATL, &day.TSB); err != nil {
+			return nil, fmt.Errorf("error loading training load for user %d: %w", userID, err)
+		}
+		out = append(out, day)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error loading training load for user %d: %w", userID, err)
+	}
+	return out, nil
+}
+
+func (s *SQLitePMCStore) ReplaceDays(ctx context.Context, stale StaleSeries, days []metrics.PMCDay) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error saving training load for user %d: %w", stale.UserID, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM pmc_days WHERE user_id = ? AND day >= ?`, stale.UserID, stale.Since); err != nil {
+		return fmt.Errorf("error saving training load for user %d: %w", stale.UserID, err)
+	}
+	for _, day := range days {
+		_, err := tx.ExecContext(ctx, `INSERT INTO pmc_days (user_id, day, tss, ctl, atl, tsb) VALUES (?, ?, ?, ?, ?, ?)`,
+			stale.UserID, day.Date, day.TSS, day.CTL, day.ATL, day.TSB)
+		if err != nil {
+			return fmt.Errorf("error saving training load for user %d: %w", stale.UserID, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM pmc_stale WHERE user_id = ? AND version = ?`, stale.UserID, stale.Version)
+	if err != nil {
+		return fmt.Errorf("error saving training load for user %d: %w", stale.UserID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error saving training load for user %d: %w", stale.UserID, err)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package athletes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+	"github.com/stretchr/testify/require"
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+// expectedPMC runs the model over every day from from to to with the given
+// daily TSS.
+func expectedPMC(from, to time.Time, tss map[string]float64) []metrics.PMCDay {
+	var days []metrics.PMCDay
+	var previous metrics.PMCDay
+	for date := from; !date.After(to); date = date.AddDate(0, 0, 1) {
+		key := date.Format(DateLayout)
+		previous = metrics.NextPMCDay(previous, key, tss[key])
+		days = append(days, previous.Rounded())
+	}
+	return days
+}
+
+var pmcStores = []storeCase[PMCStore]{
+	{name: "Memory", open: func(*sql.DB) (PMCStore, error) { return NewMemoryPMCStore(), nil }},
+	{name: "SQLite", open: func(db *sql.DB) (PMCStore, error) { return NewSQLitePMCStore(db) }},
+}
+
+func TestPMCStores(t *testing.T) {
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	march := func(day, hour int) time.Time { return time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC) }
+
+	runStores(t, pmcStores, func(t *testing.T, store PMCStore) {
+		ctx := context.Background()
+		aggregator := NewPMCAggregator(store)
+
+		require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 1, UserID: 1120489, Starts: march(10, 7), TSS: 80, Source: LoadFromPower}))
+		require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 2, UserID: 1120489, Starts: march(10, 18), TSS: 20, Source: LoadFromWahoo}))
+		require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 3, UserID: 1120489, Starts: march(14, 7), TSS: 120, Source: LoadFromPower}))
+		// Another athlete's workout is never included.
+		require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 4, UserID: 1, Starts: march(12, 7), TSS: 300, Source: LoadFromPower}))
+
+		require.NoError(t, aggregator.RunStale(ctx))
+		stale, err := store.Stale(ctx)
+		require.NoError(t, err)
+		require.Empty(t, stale)
+
+		days, err := aggregator.Series(ctx, 1120489, from, to)
+		require.NoError(t, err)
+		require.Equal(t, expectedPMC(from, to, map[string]float64{"2024-03-10": 100, "2024-03-14": 120}), days)
+
+		// Backfilling an earlier workout recomputes the chart from its day.
+		require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 5, UserID: 1120489, Starts: march(3, 7), TSS: 60, Source: LoadFromPower}))
+		require.NoError(t, aggregator.RunStale(ctx))
+		days, err = aggregator.Series(ctx, 1120489, from, to)
+		require.NoError(t, err)
+		require.Equal(t, expectedPMC(from, to, map[string]float64{"2024-03-03": 60, "2024-03-10": 100, "2024-03-14": 120}), days)
+
+		// Updating a workout moves its load, without waiting for the
+		// aggregator to run.
+		require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 3, UserID: 1120489, Starts: march(20, 7), TSS: 90, Source: LoadFromPower}))
+		days, err = aggregator.Series(ctx, 1120489, from, to)
+		require.NoError(t, err)
+		require.Equal(t, expectedPMC(from, to, map[string]float64{"2024-03-03": 60, "2024-03-10": 100, "2024-03-20": 90}), days)
+
+		// Only the stored days up to the last workout are kept; later ones
+		// are projected.
+		stored, err := store.Days(ctx, 1120489, "", "2024-12-31")
+		require.NoError(t, err)
+		require.Equal(t, "2024-03-03", stored[0].Date)
+		require.Equal(t, "2024-03-20", stored[len(stored)-1].Date)
+	})
+}
+
+func TestPMCStores_RestDays(t *testing.T) {
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	first := WorkoutLoad{WorkoutID: 1, UserID: 1120489, Starts: time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC), TSS: 100, Source: LoadFromPower}
+	second := WorkoutLoad{WorkoutID: 2, UserID: 1120489, Starts: time.Date(2024, 3, 20, 7, 0, 0, 0, time.UTC), TSS: 100, Source: LoadFromPower}
+
+	// The chart as computed from scratch, with both workouts saved at once.
+	scratch := NewMemoryPMCStore()
+	require.NoError(t, scratch.SaveLoad(context.Background(), first))
+	require.NoError(t, scratch.SaveLoad(context.Background(), second))
+	want, err := NewPMCAggregator(scratch).Series(context.Background(), 1120489, from, to)
+	require.NoError(t, err)
+	require.Equal(t, expectedPMC(from, to, map[string]float64{"2024-03-10": 100, "2024-03-20": 100}), want)
+
+	runStores(t, pmcStores, func(t *testing.T, store PMCStore) {
+		ctx := context.Background()
+		aggregator := NewPMCAggregator(store)
+
+		// A workout after rest days continues from the decayed chart, not
+		// from the last workout's day.
+		require.NoError(t, store.SaveLoad(ctx, first))
+		require.NoError(t, aggregator.RunStale(ctx))
+		require.NoError(t, store.SaveLoad(ctx, second))
+		require.NoError(t, aggregator.RunStale(ctx))
+
+		days, err := aggregator.Series(ctx, 1120489, from, to)
+		require.NoError(t, err)
+		require.Equal(t, want, days)
+
+		stored, err := store.Days(ctx, 1120489, "", "2024-12-31")
+		require.NoError(t, err)
+		require.Len(t, stored, 11)
+	})
+}
+
+func TestPMCStaleVersion(t *testing.T) {
+
+	ctx := context.Background()
+	store := NewMemoryPMCStore()
+	require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 1, UserID: 1120489, Starts: time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC), TSS: 80}))
+
+	stale, err := store.Stale(ctx)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+
+	// A workout saved while the chart was being recomputed keeps it stale, from
+	// the earlier of the two days.
+	require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 2, UserID: 1120489, Starts: time.Date(2024, 3, 12, 7, 0, 0, 0, time.UTC), TSS: 40}))
+	require.NoError(t, store.ReplaceDays(ctx, stale[0], nil))
+
+	remaining, err := store.Stale(ctx)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	require.Equal(t, "2024-03-10", remaining[0].Since)
+}
+
+// pausingPMCStore pauses the first DailyLoads call after reading the loads,
+// until resumed.
+type pausingPMCStore struct {
+	PMCStore
+	calls  atomic.Int32
+	paused chan struct{}
+	resume chan struct{}
+}
+
+func (s *pausingPMCStore) DailyLoads(ctx context.Context, userID int, since string) ([]DailyLoad, error) {
+	loads, err := s.PMCStore.DailyLoads(ctx, userID, since)
+	if s.calls.Add(1) == 1 {
+		close(s.paused)
+		<-s.resume
+	}
+	return loads, err
+}
+
+func TestPMCAggregator_SerializesRecomputes(t *testing.T) {
+
+	ctx := context.Background()
+	store := NewMemoryPMCStore()
+	require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 1, UserID: 1120489, Starts: time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC), TSS: 80}))
+
+	pausing := &pausingPMCStore{PMCStore: store, paused: make(chan struct{}), resume: make(chan struct{})}
+	aggregator := NewPMCAggregator(pausing)
+	background := make(chan error, 1)
+	go func() {
+		background <- aggregator.RunStale(ctx)
+	}()
+	<-pausing.paused
+
+	// A workout arrives while the background recompute holds the loads from
+	// before it, and the chart is read.
+	require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 2, UserID: 1120489, Starts: time.Date(2024, 3, 12, 7, 0, 0, 0, time.UTC), TSS: 40}))
+	from, to := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)
+	type result struct {
+		days []metrics.PMCDay
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		days, err := aggregator.Series(ctx, 1120489, from, to)
+		read <- result{days, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(pausing.resume)
+
+	require.NoError(t, <-background)
+	series := <-read
+	require.NoError(t, series.err)
+	want := expectedPMC(from, to, map[string]float64{"2024-03-10": 80, "2024-03-12": 40})
+	require.Equal(t, want, series.days)
+
+	// The older recompute did not overwrite the days the later one stored.
+	stored, err := store.Days(ctx, 1120489, "", "2024-12-31")
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	require.Equal(t, want[2], stored[2].Rounded())
+}
+
+func TestPMCIntervalFromEnv(t *testing.T) {
+
+	interval, err := PMCIntervalFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, DefaultPMCInterval, interval)
+
+	t.Setenv("PMC_INTERVAL", "15m")
+	interval, err = PMCIntervalFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, 15*time.Minute, interval)
+
+	for _, value := range []string{"hourly", "0s", "-1h"} {
+		t.Setenv("PMC_INTERVAL", value)
+		_, err = PMCIntervalFromEnv()
+		require.Error(t, err, value)
+	}
+}
+
+func TestPMCHandler(t *testing.T) {
+
+	ctx := context.Background()
+	store := NewMemoryPMCStore()
+	require.NoError(t, store.SaveLoad(ctx, WorkoutLoad{WorkoutID: 1, UserID: 1120489, Starts: time.Date(2024, 3, 2, 7, 0, 0, 0, time.UTC), TSS: 100}))
+
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/athletes/:user_id/pmc"), PMC(NewPMCAggregator(store)))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1120489/pmc?from=2024-03-01&to=2024-03-03", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+
+	var body PMCResponse
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	require.Equal(t, PMCResponse{
+		UserID: 1120489,
+		From:   "2024-03-01",
+		To:     "2024-03-03",
+		Days: []metrics.PMCDay{
+			{Date: "2024-03-01"},
+			{Date: "2024-03-02", TSS: 100, CTL: 2.4, ATL: 13.3},
+			{Date: "2024-03-03", CTL: 2.3, ATL: 11.5, TSB: -11},
+		},
+	}, body)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1120489/pmc", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	require.Len(t, body.Days, DefaultPMCDays)
+
+	for _, query := range []string{"from=2024-03-10&to=2024-03-01", "from=yesterday", "to=2024-13-01"} {
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1120489/pmc?"+query, nil))
+		require.Equal(t, http.StatusBadRequest, response.Code, query)
+	}
+}
@@ -0,0 +1,194 @@
+// Package athletes keeps what the service knows about each athlete, keyed on
+// their Wahoo user ID, and serves it over the admin API.
+package athletes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+)
+
+// Profile holds an athlete's physiological settings, used when computing
+// training metrics from their workouts. Zero values mean the setting is not
+// known.
+type Profile struct {
+	UserID int `json:"user_id"`
+	// FTP is the athlete's functional threshold power in watts.
+	FTP int `json:"ftp"`
+	// Heart rates are in beats per minute.
+	MaxHeartRate       int `json:"max_heart_rate,omitempty"`
+	RestingHeartRate   int `json:"resting_heart_rate,omitempty"`
+	ThresholdHeartRate int `json:"threshold_heart_rate,omitempty"`
+	// HeartRateZoneModel is "max", "reserve", "threshold" or "custom"; see
+	// metrics.HeartRateZoneModel. The settings choose one when it is empty.
+	HeartRateZoneModel string `json:"heart_rate_zone_model,omitempty"`
+	// HeartRateZones are the upper bounds of every zone but the last, for
+	// the custom zone model.
+	HeartRateZones []int `json:"heart_rate_zones,omitempty"`
+	// Sex is "male" or "female", and weights TRIMP. Male is assumed when it
+	// is empty.
+	Sex       string    `json:"sex,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate reports settings that are out of range or inconsistent.
+func (p Profile) Validate() error {
+	if p.FTP < 0 {
+		return errors.New("ftp must not be negative")
+	}
+	if p.Sex != "" && p.Sex != "male" && p.Sex != "female" {
+		return fmt.Errorf("unknown sex %q", p.Sex)
+	}
+	return p.Athlete().HeartRate.Validate()
+}
+
+// Athlete returns the profile's settings for computing training metrics.
+func (p Profile) Athlete() metrics.Athlete {
+	athlete := metrics.Athlete{
+		FTP: float64(p.FTP),
+		HeartRate: metrics.HeartRateSettings{
+			Max:       float64(p.MaxHeartRate),
+			Resting:   float64(p.RestingHeartRate),
+			Threshold: float64(p.ThresholdHeartRate),
+			ZoneModel: metrics.HeartRateZoneModel(p.HeartRateZoneModel),
+			Female:    p.Sex == "female",
+		},
+	}
+	for _, upper := range p.HeartRateZones {
+		athlete.HeartRate.Zones = append(athlete.HeartRate.Zones, float64(upper))
+	}
+	return athlete
+}
+
+// ProfileStore persists athlete profiles.
+type ProfileStore interface {
+	// Get returns the profile for userID; found is false if there is none.
+	Get(ctx context.Context, userID int) (profile Profile, found bool, err error)
+	Save(ctx context.Context, profile Profile) error
+}
+
+// MemoryProfileStore is a ProfileStore held in process memory.
+type MemoryProfileStore struct {
+	mu       sync.Mutex
+	profiles map[int]Profile
+}
+
+func NewMemoryProfileStore() *MemoryProfileStore {
+	return &MemoryProfileStore{profiles: make(map[int]Profile)}
+}
+
+func (s *MemoryProfileStore) Get(_ context.Context, userID int) (Profile, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile, ok := s.profiles[userID]
+	return profile, ok, nil
+}
+
+func (s *MemoryProfileStore) Save(_ context.Context, profile Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.profiles[profile.UserID] = profile
+	return nil
+}
+
+// SQLiteProfileStore is a ProfileStore persisted in SQLite.
+type SQLiteProfileStore struct {
+	db *sql.DB
+}
+
+var profileMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create athlete_profiles",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS athlete_profiles (
+				user_id    INTEGER PRIMARY KEY,
+				ftp        INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL
+			)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "add heart rate settings",
+		Columns: []database.Column{
+			{Table: "athlete_profiles", Name: "max_heart_rate", Definition: "INTEGER NOT NULL DEFAULT 0"},
+			{Table: "athlete_profiles", Name: "resting_heart_rate", Definition: "INTEGER NOT NULL DEFAULT 0"},
+			{Table: "athlete_profiles", Name: "threshold_heart_rate", Definition: "INTEGER NOT NULL DEFAULT 0"},
+			{Table: "athlete_profiles", Name: "heart_rate_zone_model", Definition: "TEXT NOT NULL DEFAULT ''"},
+			{Table: "athlete_profiles", Name: "heart_rate_zones", Definition: "TEXT NOT NULL DEFAULT ''"},
+			{Table: "athlete_profiles", Name: "sex", Definition: "TEXT NOT NULL DEFAULT ''"},
+		},
+	},
+}
+
+func NewSQLiteProfileStore(db *sql.DB) (*SQLiteProfileStore, error) {
+	if err := database.Migrate(db, database.SQLite, "athlete_profiles", profileMigrations); err != nil {
+		return nil, err
+	}
+	return &SQLiteProfileStore{db: db}, nil
+}
+
+func (s *SQLiteProfileStore) Get(ctx context.Context, userID int) (Profile, bool, error) {
+	profile := Profile{UserID: userID}
+	var zones string
+	var updatedAt int64
+
+	err := s.db.QueryRowContext(ctx, `SELECT ftp, max_heart_rate, resting_heart_rate, threshold_heart_rate,
+		heart_rate_zone_model, heart_rate_zones, sex, updated_at
+		FROM athlete_profiles WHERE user_id = ?`, userID).
+		Scan(&profile.FTP, &profile.MaxHeartRate, &profile.RestingHeartRate, &profile.ThresholdHeartRate,
+			&profile.HeartRateZoneModel, &zones, &profile.Sex, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Profile{}, false, nil
+	}
+	if err != nil {
+		return Profile{}, false, fmt.Errorf("error loading profile for user %d: %w", userID, err)
+	}
+	if zones != "" {
+		if err := json.Unmarshal([]byte(zones), &profile.HeartRateZones); err != nil {
+			return Profile{}, false, fmt.Errorf("error decoding heart rate zones for user %d: %w", userID, err)
+		}
+	}
+
+	profile.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return profile, true, nil
+}
+
+func (s *SQLiteProfileStore) Save(ctx context.Context, profile Profile) error {
+	var zones []byte
+	if len(profile.HeartRateZones) > 0 {
+		var err error
+		if zones, err = json.Marshal(profile.HeartRateZones); err != nil {
+			return fmt.Errorf("error encoding heart rate zones for user %d: %w", profile.UserID, err)
+		}
+	}
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO athlete_profiles (user_id, ftp, max_heart_rate, resting_heart_rate,
+		threshold_heart_rate, heart_rate_zone_model, heart_rate_zones, sex, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			ftp = excluded.ftp,
+			max_heart_rate = excluded.max_heart_rate,
+			resting_heart_rate = excluded.resting_heart_rate,
+			threshold_heart_rate = excluded.threshold_heart_rate,
+			heart_rate_zone_model = excluded.heart_rate_zone_model,
+			heart_rate_zones = excluded.heart_rate_zones,
+			sex = excluded.sex,
+			updated_at = excluded.updated_at`,
+		profile.UserID, profile.FTP, profile.MaxHeartRate, profile.RestingHeartRate, profile.ThresholdHeartRate,
+		profile.HeartRateZoneModel, string(zones), profile.Sex, profile.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("error saving profile for user %d: %w", profile.UserID, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package athletes
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+	"github.com/stretchr/testify/require"
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+// storeCase opens one implementation of a store, on db when it needs one.
+type storeCase[S any] struct {
+	name string
+	open func(db *sql.DB) (S, error)
+}
+
+// runStores runs test against each of the stores, opening every one on a
+// SQLite database of its own.
+func runStores[S any](t *testing.T, stores []storeCase[S], test func(t *testing.T, store S)) {
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "athletes.db"))
+			require.NoError(t, err)
+			defer db.Close()
+
+			opened, err := store.open(db)
+			require.NoError(t, err)
+			test(t, opened)
+		})
+	}
+}
+
+var profileStores = []storeCase[ProfileStore]{
+	{name: "Memory", open: func(*sql.DB) (ProfileStore, error) { return NewMemoryProfileStore(), nil }},
+	{name: "SQLite", open: func(db *sql.DB) (ProfileStore, error) { return NewSQLiteProfileStore(db) }},
+}
+
+func TestProfileStores(t *testing.T) {
+
+	runStores(t, profileStores, func(t *testing.T, store ProfileStore) {
+		ctx := context.Background()
+
+		_, found, err := store.Get(ctx, 1120489)
+		require.NoError(t, err)
+		require.False(t, found)
+
+		saved := Profile{
+			UserID:             1120489,
+			FTP:                250,
+			MaxHeartRate:       190,
+			RestingHeartRate:   48,
+			HeartRateZoneModel: "custom",
+			HeartRateZones:     []int{120, 140, 160},
+			Sex:                "female",
+			UpdatedAt:          time.Date(2024, 4, 12, 18, 0, 0, 0, time.UTC),
+		}
+		require.NoError(t, store.Save(ctx, saved))
+		saved.FTP = 260
+		require.NoError(t, store.Save(ctx, saved))
+
+		profile, found, err := store.Get(ctx, 1120489)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, saved, profile)
+	})
+}
+
+func TestProfile_Athlete(t *testing.T) {
+
+	athlete := Profile{FTP: 250, MaxHeartRate: 190, RestingHeartRate: 48, HeartRateZones: []int{120, 140}, Sex: "female"}.Athlete()
+	require.Equal(t, metrics.Athlete{
+		FTP:       250,
+		HeartRate: metrics.HeartRateSettings{Max: 190, Resting: 48, Zones: []float64{120, 140}, Female: true},
+	}, athlete)
+}
+
+func TestProfileHandlers(t *testing.T) {
+
+	profiles := NewMemoryProfileStore()
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/athletes/:user_id/profile"), GetProfile(profiles))
+	router.HandleFunc(pat.Put("/athletes/:user_id/profile"), PutProfile(profiles))
+
+	testCases := []struct {
+		method   string
+		path     string
+		body     string
+		status   int
+		contains string
+	}{
+		{method: http.MethodGet, path: "/athletes/1120489/profile", status: http.StatusNotFound},
+		{method: http.MethodPut, path: "/athletes/1120489/profile", body: `{"ftp":250}`, status: http.StatusOK, contains: `"user_id":1120489,"ftp":250`},
+		{method: http.MethodGet, path: "/athletes/1120489/profile", status: http.StatusOK, contains: `"ftp":250`},
+		{method: http.MethodPut, path: "/athletes/1120489/profile", body: `{"ftp":-1}`, status: http.StatusBadRequest},
+		{method: http.MethodPut, path: "/athletes/1120489/profile", body: `ftp=250`, status: http.StatusBadRequest},
+		{method: http.MethodPut, path: "/athletes/1120489/profile", body: `{"heart_rate_zone_model":"reserve","max_heart_rate":190}`, status: http.StatusBadRequest, contains: "need max and resting"},
+		{method: http.MethodPut, path: "/athletes/1120489/profile", body: `{"sex":"x"}`, status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/athletes/abc/profile", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		require.Equal(t, tc.status, response.Code, "%s %s %s", tc.method, tc.path, tc.body)
+		require.Contains(t, response.Body.String(), tc.contains)
+	}
+}
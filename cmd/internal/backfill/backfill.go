@@ -0,0 +1,298 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/webhook"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/wahoo"
+)
+
+// ErrAlreadyRunning is returned when a backfill is requested for a user whose
+// backfill is still in progress.
+var ErrAlreadyRunning = errors.New("backfill already running")
+
+const (
+	defaultPerPage       = 50
+	maxRateLimitWaits    = 5
+	defaultRateLimitWait = time.Minute
+)
+
+// AccessTokenProvider returns a valid Wahoo access token for a user.
+type AccessTokenProvider interface {
+	AccessToken(ctx context.Context, userID int) (string, error)
+}
+
+// Processor runs a workout summary event through the storage and forwarding pipeline.
+type Processor interface {
+	Process(ctx context.Context, wahooWorkout webhook.WahooCloudApiResponseBody) error
+}
+
+// Backfiller pages through an athlete's Wahoo workout history and pushes each
+// workout through the same pipeline as the live webhook.
+type Backfiller struct {
+	tokens        AccessTokenProvider
+	checkpoints   CheckpointStore
+	processor     Processor
+	clientOptions []wahoo.Option
+	perPage       int
+	sleep         func(ctx context.Context, d time.Duration) error
+
+	mu      sync.Mutex
+	running map[int]bool
+	wg      sync.WaitGroup
+}
+
+func NewBackfiller(tokens AccessTokenProvider, checkpoints CheckpointStore, processor Processor, clientOptions ...wahoo.Option) *Backfiller {
+	return &Backfiller{
+		tokens:        tokens,
+		checkpoints:   checkpoints,
+		processor:     processor,
+		clientOptions: clientOptions,
+		perPage:       defaultPerPage,
+		sleep:         sleepContext,
+		running:       make(map[int]bool),
+	}
+}
+
+// Run backfills userID synchronously. With restart set any existing
+// checkpoint is discarded, otherwise the run retries the workouts that failed
+// before and resumes from it.
+func (b *Backfiller) Run(ctx context.Context, userID int, restart bool) (Checkpoint, error) {
+	if !b.begin(userID) {
+		return Checkpoint{}, ErrAlreadyRunning
+	}
+	defer b.end(userID)
+
+	return b.run(ctx, userID, restart)
+}
+
+// Start backfills userID in the background until ctx is cancelled, returning
+// immediately. A cancelled run keeps its checkpoint, so it resumes when next
+// started. Use Wait to block until background runs have stopped.
+func (b *Backfiller) Start(ctx context.Context, userID int, restart bool) error {
+	if !b.begin(userID) {
+		return ErrAlreadyRunning
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer b.end(userID)
+
+		checkpoint, err := b.run(ctx, userID, restart)
+		if err != nil {
+			log.Printf("Backfill for user %d: stopped: %v", userID, err)
+			return
+		}
+		log.Printf("Backfill for user %d: completed. processed=%d skipped=%d failed=%d",
+			userID, checkpoint.Processed, checkpoint.Skipped, checkpoint.Failed)
+	}()
+	return nil
+}
+
+func (b *Backfiller) Wait() {
+	b.wg.Wait()
+}
+
+// Status returns the stored checkpoint for userID and whether a run is in progress.
+func (b *Backfiller) Status(ctx context.Context, userID int) (Checkpoint, bool, error) {
+	checkpoint, err := b.checkpoints.Load(ctx, userID)
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return checkpoint, b.running[userID], nil
+}
+
+func (b *Backfiller) begin(userID int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.running[userID] {
+		return false
+	}
+	b.running[userID] = true
+	return true
+}
+
+func (b *Backfiller) end(userID int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.running, userID)
+}
+
+func (b *Backfiller) run(ctx context.Context, userID int, restart bool) (Checkpoint, error) {
+	if restart {
+		if err := b.checkpoints.Reset(ctx, userID); err != nil {
+			return Checkpoint{}, err
+		}
+	}
+
+	checkpoint, err := b.checkpoints.Load(ctx, userID)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if checkpoint.Completed && len(checkpoint.FailedWorkouts) == 0 {
+		log.Printf("Backfill for user %d: already completed; pass restart to run it again", userID)
+		return checkpoint, nil
+	}
+	if checkpoint.Page < 1 {
+		checkpoint.Page = 1
+	}
+
+	tokens := wahoo.TokenSourceFunc(func(ctx context.Context) (string, error) {
+		return b.tokens.AccessToken(ctx, userID)
+	})
+	client := wahoo.NewClient(tokens, b.clientOptions...)
+
+	for waits := 0; ; waits++ {
+		err = b.retryFailed(ctx, client, &checkpoint)
+		if err == nil {
+			err = b.walk(ctx, client, &checkpoint)
+		}
+		if err == nil {
+			break
+		}
+
+		var apiErr *wahoo.APIError
+		if !wahoo.IsRateLimited(err) || waits >= maxRateLimitWaits || !errors.As(err, &apiErr) {
+			return checkpoint, err
+		}
+
+		wait := apiErr.RetryAfter
+		if wait <= 0 {
+			wait = defaultRateLimitWait
+		}
+		log.Printf("Backfill for user %d: rate limited, resuming from page %d in %s", userID, checkpoint.Page, wait)
+		if err := b.sleep(ctx, wait); err != nil {
+			return checkpoint, err
+		}
+	}
+
+	checkpoint.Completed = true
+	if err := b.save(ctx, &checkpoint); err != nil {
+		return checkpoint, err
+	}
+	return checkpoint, nil
+}
+
+// walk processes workouts from the checkpoint onwards, saving progress after each one.
+func (b *Backfiller) walk(ctx context.Context, client *wahoo.Client, checkpoint *Checkpoint) error {
+	opts := wahoo.ListOptions{Page: checkpoint.Page, PerPage: b.perPage}
+
+	return client.EachWorkout(ctx, opts, func(page int, workout wahoo.Workout) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		checkpoint.Page = page
+
+		// Workouts are listed newest first, so anything newer than the oldest
+		// workout already handled was covered by an earlier run.
+		if checkpoint.covers(workout) {
+			return nil
+		}
+
+		if err := b.processWorkout(ctx, client, checkpoint, workout); err != nil {
+			return err
+		}
+
+		checkpoint.OldestStarts = workout.Starts
+		checkpoint.LastWorkoutID = workout.ID
+		return b.save(ctx, checkpoint)
+	})
+}
+
+// retryFailed processes the workouts that failed in earlier runs again,
+// keeping those that fail once more for the next run.
+func (b *Backfiller) retryFailed(ctx context.Context, client *wahoo.Client, checkpoint *Checkpoint) error {
+	failed := checkpoint.FailedWorkouts
+	checkpoint.FailedWorkouts = nil
+	for i, workout := range failed {
+		checkpoint.Failed--
+		if err := b.processWorkout(ctx, client, checkpoint, workout); err != nil {
+			checkpoint.Failed++
+			checkpoint.FailedWorkouts = append(checkpoint.FailedWorkouts, failed[i:]...)
+			return err
+		}
+		if err := b.save(ctx, checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processWorkout runs workout through the processor, counting it in the
+// checkpoint. A workout that fails is recorded for the next run to retry; the
+// error is only returned when the run cannot carry on, such as when ctx is
+// done, in which case the workout is not counted at all.
+func (b *Backfiller) processWorkout(ctx context.Context, client *wahoo.Client, checkpoint *Checkpoint, workout wahoo.Workout) error {
+	summary, err := client.GetWorkoutSummary(ctx, workout.ID)
+	if wahoo.IsNotFound(err) {
+		checkpoint.Skipped++
+		return nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if wahoo.IsRateLimited(err) || wahoo.IsUnauthorized(err) {
+			return err
+		}
+		log.Printf("Backfill for user %d: error fetching summary for workout %d: %v", checkpoint.UserID, workout.ID, err)
+		checkpoint.fail(workout)
+		return nil
+	}
+
+	if summary.File.URL == "" {
+		checkpoint.Skipped++
+		return nil
+	}
+	if summary.Workout.ID == 0 {
+		summary.Workout = workout
+	}
+
+	wahooWorkout := webhook.WahooCloudApiResponseBody{
+		EventType:      "workout_summary",
+		User:           webhook.User{ID: checkpoint.UserID},
+		WorkoutSummary: *summary,
+	}
+	if err := b.processor.Process(ctx, wahooWorkout); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Backfill for user %d: error processing workout %d: %v", checkpoint.UserID, workout.ID, err)
+		checkpoint.fail(workout)
+		return nil
+	}
+
+	checkpoint.Processed++
+	return nil
+}
+
+func (b *Backfiller) save(ctx context.Context, checkpoint *Checkpoint) error {
+	checkpoint.UpdatedAt = time.Now().UTC()
+	if err := b.checkpoints.Save(ctx, *checkpoint); err != nil {
+		return fmt.Errorf("error saving checkpoint: %w", err)
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,267 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/webhook"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/wahoo"
+	"github.com/stretchr/testify/require"
+)
+
+type staticTokens struct{}
+
+func (staticTokens) AccessToken(context.Context, int) (string, error) {
+	return "my_access_token", nil
+}
+
+type recordingProcessor struct {
+	mu        sync.Mutex
+	processed []int
+	failAfter int
+}
+
+func (p *recordingProcessor) Process(_ context.Context, wahooWorkout webhook.WahooCloudApiResponseBody) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failAfter > 0 && len(p.processed) >= p.failAfter {
+		return errors.New("pipeline unavailable")
+	}
+	p.processed = append(p.processed, wahooWorkout.WorkoutSummary.Workout.ID)
+	return nil
+}
+
+// startWahooAPI serves five workouts, newest first, two per page. Workout 3
+// has no summary.
+func startWahooAPI(t *testing.T, rateLimitPage string) *httptest.Server {
+	starts := func(id int) string {
+		return time.Date(2024, 4, id, 17, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	}
+	rateLimited := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/workouts" {
+			page := r.URL.Query().Get("page")
+			if page == rateLimitPage && !rateLimited {
+				rateLimited = true
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			pageNumber, _ := strconv.Atoi(page)
+			var workouts string
+			for id := 5 - (pageNumber-1)*2; id > 5-pageNumber*2 && id > 0; id-- {
+				if workouts != "" {
+					workouts += ","
+				}
+				workouts += fmt.Sprintf(`{"id":%d,"starts":"%s","name":"Ride %d"}`, id, starts(id), id)
+			}
+			_, _ = fmt.Fprintf(w, `{"workouts":[%s],"total":5,"page":%d,"per_page":2}`, workouts, pageNumber)
+			return
+		}
+
+		var workoutID int
+		if _, err := fmt.Sscanf(r.URL.Path, "/v1/workouts/%d/workout_summary", &workoutID); err == nil {
+			if workoutID == 3 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"id":%d,"file":{"url":"https://cdn.example.com/%d.fit"}}`, workoutID+1000, workoutID)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestBackfiller(server *httptest.Server, checkpoints CheckpointStore, processor Processor) *Backfiller {
+	backfiller := NewBackfiller(staticTokens{}, checkpoints, processor, wahoo.WithBaseURL(server.URL))
+	backfiller.perPage = 2
+	backfiller.sleep = func(context.Context, time.Duration) error { return nil }
+	return backfiller
+}
+
+func TestBackfiller_ProcessesEveryWorkout(t *testing.T) {
+
+	server := startWahooAPI(t, "")
+	processor := &recordingProcessor{}
+
+	checkpoint, err := newTestBackfiller(server, NewMemoryCheckpointStore(), processor).Run(context.Background(), 1120489, false)
+	require.NoError(t, err)
+
+	require.Equal(t, []int{5, 4, 2, 1}, processor.processed)
+	require.True(t, checkpoint.Completed)
+	require.Equal(t, 4, checkpoint.Processed)
+	require.Equal(t, 1, checkpoint.Skipped)
+}
+
+func TestBackfiller_ResumesFromCheckpoint(t *testing.T) {
+
+	server := startWahooAPI(t, "")
+	checkpoints := NewMemoryCheckpointStore()
+
+	// Simulate a run that was interrupted after handling workout 4 on page 1.
+	_ = checkpoints.Save(context.Background(), Checkpoint{
+		UserID:        1120489,
+		Page:          1,
+		OldestStarts:  time.Date(2024, 4, 4, 17, 0, 0, 0, time.UTC),
+		LastWorkoutID: 4,
+		Processed:     2,
+	})
+
+	processor := &recordingProcessor{}
+	checkpoint, err := newTestBackfiller(server, checkpoints, processor).Run(context.Background(), 1120489, false)
+	require.NoError(t, err)
+
+	require.Equal(t, []int{2, 1}, processor.processed)
+	require.Equal(t, 4, checkpoint.Processed)
+	require.True(t, checkpoint.Completed)
+
+	// A completed backfill only runs again when restarted.
+	processor.processed = nil
+	_, err = newTestBackfiller(server, checkpoints, processor).Run(context.Background(), 1120489, false)
+	require.NoError(t, err)
+	require.Empty(t, processor.processed)
+
+	_, err = newTestBackfiller(server, checkpoints, processor).Run(context.Background(), 1120489, true)
+	require.NoError(t, err)
+	require.Equal(t, []int{5, 4, 2, 1}, processor.processed)
+}
+
+func TestBackfiller_WaitsOutRateLimits(t *testing.T) {
+
+	server := startWahooAPI(t, "2")
+	processor := &recordingProcessor{}
+
+	checkpoint, err := newTestBackfiller(server, NewMemoryCheckpointStore(), processor).Run(context.Background(), 1120489, false)
+	require.NoError(t, err)
+
+	require.Equal(t, []int{5, 4, 2, 1}, processor.processed)
+	require.True(t, checkpoint.Completed)
+}
+
+func TestBackfiller_CountsPipelineFailures(t *testing.T) {
+
+	server := startWahooAPI(t, "")
+	checkpoints := NewMemoryCheckpointStore()
+	processor := &recordingProcessor{failAfter: 2}
+
+	checkpoint, err := newTestBackfiller(server, checkpoints, processor).Run(context.Background(), 1120489, false)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, checkpoint.Processed)
+	require.Equal(t, 2, checkpoint.Failed)
+	require.True(t, checkpoint.Completed)
+	require.Len(t, checkpoint.FailedWorkouts, 2)
+
+	// The next run retries the failed workouts, even though the walk completed.
+	processor.failAfter = 0
+	checkpoint, err = newTestBackfiller(server, checkpoints, processor).Run(context.Background(), 1120489, false)
+	require.NoError(t, err)
+
+	require.Equal(t, []int{5, 4, 2, 1}, processor.processed)
+	require.Equal(t, 4, checkpoint.Processed)
+	require.Zero(t, checkpoint.Failed)
+	require.Empty(t, checkpoint.FailedWorkouts)
+}
+
+func TestBackfiller_RejectsConcurrentRuns(t *testing.T) {
+
+	backfiller := NewBackfiller(staticTokens{}, NewMemoryCheckpointStore(), &recordingProcessor{})
+	require.True(t, backfiller.begin(1120489))
+
+	_, err := backfiller.Run(context.Background(), 1120489, false)
+	require.ErrorIs(t, err, ErrAlreadyRunning)
+	require.ErrorIs(t, backfiller.Start(context.Background(), 1120489, false), ErrAlreadyRunning)
+}
+
+// cancellingProcessor cancels the backfill's context once it has processed a
+// workout.
+type cancellingProcessor struct {
+	recordingProcessor
+	cancel context.CancelFunc
+}
+
+func (p *cancellingProcessor) Process(ctx context.Context, wahooWorkout webhook.WahooCloudApiResponseBody) error {
+	p.cancel()
+	return p.recordingProcessor.Process(ctx, wahooWorkout)
+}
+
+func TestBackfiller_StartStopsWhenCancelled(t *testing.T) {
+
+	server := startWahooAPI(t, "")
+	checkpoints := NewMemoryCheckpointStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	processor := &cancellingProcessor{cancel: cancel}
+	backfiller := newTestBackfiller(server, checkpoints, processor)
+
+	require.NoError(t, backfiller.Start(ctx, 1120489, false))
+	backfiller.Wait()
+
+	checkpoint, running, err := backfiller.Status(context.Background(), 1120489)
+	require.NoError(t, err)
+	require.False(t, running)
+	require.False(t, checkpoint.Completed)
+	require.Equal(t, []int{5}, processor.processed)
+
+	// The next run resumes from the checkpoint.
+	resumed := &recordingProcessor{}
+	checkpoint, err = newTestBackfiller(server, checkpoints, resumed).Run(context.Background(), 1120489, false)
+	require.NoError(t, err)
+	require.True(t, checkpoint.Completed)
+	require.Equal(t, []int{4, 2, 1}, resumed.processed)
+}
+
+// interruptedProcessor cancels the backfill's context while processing
+// workout 4, failing it as a pipeline would on shutdown.
+type interruptedProcessor struct {
+	recordingProcessor
+	cancel context.CancelFunc
+}
+
+func (p *interruptedProcessor) Process(ctx context.Context, wahooWorkout webhook.WahooCloudApiResponseBody) error {
+	if wahooWorkout.WorkoutSummary.Workout.ID == 4 {
+		p.cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return p.recordingProcessor.Process(ctx, wahooWorkout)
+}
+
+func TestBackfiller_CancelledWorkoutIsNotFailed(t *testing.T) {
+
+	server := startWahooAPI(t, "")
+	checkpoints := NewMemoryCheckpointStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	processor := &interruptedProcessor{cancel: cancel}
+
+	_, err := newTestBackfiller(server, checkpoints, processor).Run(ctx, 1120489, false)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []int{5}, processor.processed)
+
+	checkpoint, err := checkpoints.Load(context.Background(), 1120489)
+	require.NoError(t, err)
+	require.Equal(t, 5, checkpoint.LastWorkoutID)
+	require.Equal(t, 1, checkpoint.Processed)
+	require.Zero(t, checkpoint.Failed)
+	require.Empty(t, checkpoint.FailedWorkouts)
+
+	// The interrupted workout is processed when the backfill resumes.
+	resumed := &recordingProcessor{}
+	checkpoint, err = newTestBackfiller(server, checkpoints, resumed).Run(context.Background(), 1120489, false)
+	require.NoError(t, err)
+	require.True(t, checkpoint.Completed)
+	require.Equal(t, []int{4, 2, 1}, resumed.processed)
+	require.Equal(t, 4, checkpoint.Processed)
+}
@@ -0,0 +1,202 @@
+package backfill
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/wahoo"
+)
+
+// Checkpoint records how far a user's backfill has progressed so an
+// interrupted run can continue where it stopped.
+type Checkpoint struct {
+	UserID int `json:"user_id"`
+	// Page is the page of the workout listing currently being processed.
+	Page int `json:"page"`
+	// OldestStarts is the start time of the oldest workout processed so far.
+	OldestStarts time.Time `json:"oldest_starts"`
+	// LastWorkoutID is the ID of the workout that set OldestStarts.
+	LastWorkoutID int `json:"last_workout_id"`
+	Processed     int `json:"processed"`
+	Skipped       int `json:"skipped"`
+	Failed        int `json:"failed"`
+	// FailedWorkouts are the workouts counted in Failed, which the next run
+	// retries before carrying on from the checkpoint.
+	FailedWorkouts []wahoo.Workout `json:"failed_workouts,omitempty"`
+	// Completed is set once the run has walked to the oldest workout.
+	Completed bool      `json:"completed"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// covers reports whether workout was already handled by the run that produced the checkpoint.
+func (c Checkpoint) covers(workout wahoo.Workout) bool {
+	if c.OldestStarts.IsZero() {
+		return false
+	}
+	return workout.Starts.After(c.OldestStarts) ||
+		(workout.Starts.Equal(c.OldestStarts) && workout.ID == c.LastWorkoutID)
+}
+
+// fail counts workout as failed, to be retried by the next run.
+func (c *Checkpoint) fail(workout wahoo.Workout) {
+	c.Failed++
+	c.FailedWorkouts = append(c.FailedWorkouts, workout)
+}
+
+type CheckpointStore interface {
+	// Load returns the checkpoint for userID, or a zero Checkpoint if none exists.
+	Load(ctx context.Context, userID int) (Checkpoint, error)
+	Save(ctx context.Context, checkpoint Checkpoint) error
+	Reset(ctx context.Context, userID int) error
+}
+
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[int]Checkpoint
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[int]Checkpoint)}
+}
+
+func (s *MemoryCheckpointStore) Load(_ context.Context, userID int) (Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoint, ok := s.checkpoints[userID]
+	if !ok {
+		return Checkpoint{UserID: userID}, nil
+	}
+	return checkpoint, nil
+}
+
+func (s *MemoryCheckpointStore) Save(_ context.Context, checkpoint Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints[checkpoint.UserID] = checkpoint
+	return nil
+}
+
+func (s *MemoryCheckpointStore) Reset(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.checkpoints, userID)
+	return nil
+}
+
+type SQLiteCheckpointStore struct {
+	db *sql.DB
+}
+
+var checkpointMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create backfill_checkpoints",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS backfill_checkpoints (
+				user_id         INTEGER PRIMARY KEY,
+				page            INTEGER NOT NULL,
+				oldest_starts   INTEGER NOT NULL,
+				last_workout_id INTEGER NOT NULL,
+				processed       INTEGER NOT NULL,
+				skipped         INTEGER NOT NULL,
+				failed          INTEGER NOT NULL,
+				completed       INTEGER NOT NULL,
+				updated_at      INTEGER NOT NULL
+			)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "record failed workouts",
+		Columns: []database.Column{
+			{Table: "backfill_checkpoints", Name: "failed_workouts", Definition: "TEXT NOT NULL DEFAULT ''"},
+		},
+	},
+}
+
+func NewSQLiteCheckpointStore(db *sql.DB) (*SQLiteCheckpointStore, error) {
+	if err := database.Migrate(db, database.SQLite, "backfill_checkpoints", checkpointMigrations); err != nil {
+		return nil, err
+	}
+	return &SQLiteCheckpointStore{db: db}, nil
+}
+
+func (s *SQLiteCheckpointStore) Load(ctx context.Context, userID int) (Checkpoint, error) {
+	checkpoint := Checkpoint{UserID: userID}
+	var oldestStarts, updatedAt int64
+	var failedWorkouts string
+
+	err := s.db.QueryRowContext(ctx, `SELECT page, oldest_starts, last_workout_id, processed, skipped, failed,
+		failed_workouts, completed, updated_at
+		FROM backfill_checkpoints WHERE user_id = ?`, userID).
+		Scan(&checkpoint.Page, &oldestStarts, &checkpoint.LastWorkoutID, &checkpoint.Processed, &checkpoint.Skipped,
+			&checkpoint.Failed, &failedWorkouts, &checkpoint.Completed, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("error loading backfill checkpoint for user %d: %w", userID, err)
+	}
+	if failedWorkouts != "" {
+		if err := json.Unmarshal([]byte(failedWorkouts), &checkpoint.FailedWorkouts); err != nil {
+			return Checkpoint{}, fmt.Errorf("error decoding failed workouts of backfill for user %d: %w", userID, err)
+		}
+	}
+
+	if oldestStarts != 0 {
+		checkpoint.OldestStarts = time.Unix(oldestStarts, 0).UTC()
+	}
+	checkpoint.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return checkpoint, nil
+}
+
+func (s *SQLiteCheckpointStore) Save(ctx context.Context, checkpoint Checkpoint) error {
+	var oldestStarts int64
+	if !checkpoint.OldestStarts.IsZero() {
+		oldestStarts = checkpoint.OldestStarts.Unix()
+	}
+	var failedWorkouts []byte
+	if len(checkpoint.FailedWorkouts) > 0 {
+		var err error
+		if failedWorkouts, err = json.Marshal(checkpoint.FailedWorkouts); err != nil {
+			return fmt.Errorf("error encoding failed workouts of backfill for user %d: %w", checkpoint.UserID, err)
+		}
+	}
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO backfill_checkpoints
+		(user_id, page, oldest_starts, last_workout_id, processed, skipped, failed, failed_workouts, completed, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			page = excluded.page,
+			oldest_starts = excluded.oldest_starts,
+			last_workout_id = excluded.last_workout_id,
+			processed = excluded.processed,
+			skipped = excluded.skipped,
+			failed = excluded.failed,
+			failed_workouts = excluded.failed_workouts,
+			completed = excluded.completed,
+			updated_at = excluded.updated_at`,
+		checkpoint.UserID, checkpoint.Page, oldestStarts, checkpoint.LastWorkoutID, checkpoint.Processed, checkpoint.Skipped,
+		checkpoint.Failed, string(failedWorkouts), checkpoint.Completed, checkpoint.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("error saving backfill checkpoint for user %d: %w", checkpoint.UserID, err)
+	}
+	return nil
+}
+
+func (s *SQLiteCheckpointStore) Reset(ctx context.Context, userID int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM backfill_checkpoints WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("error resetting backfill checkpoint for user %d: %w", userID, err)
+	}
+	return nil
+}
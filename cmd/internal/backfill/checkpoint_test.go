@@ -0,0 +1,63 @@
+package backfill
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/wahoo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointStores(t *testing.T) {
+
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "backfill.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqliteStore, err := NewSQLiteCheckpointStore(db)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		store CheckpointStore
+	}{
+		{name: "Memory", store: NewMemoryCheckpointStore()},
+		{name: "SQLite", store: sqliteStore},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			checkpoint, err := tc.store.Load(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, Checkpoint{UserID: 1}, checkpoint)
+
+			saved := Checkpoint{
+				UserID:        1,
+				Page:          3,
+				OldestStarts:  time.Date(2021, 6, 1, 7, 30, 0, 0, time.UTC),
+				LastWorkoutID: 99,
+				Processed:     120,
+				Skipped:       4,
+				Failed:        1,
+				FailedWorkouts: []wahoo.Workout{{ID: 98, Name: "Ride", WorkoutTypeID: 1,
+					Starts: time.Date(2021, 6, 2, 7, 30, 0, 0, time.UTC)}},
+				UpdatedAt: time.Date(2024, 4, 12, 18, 0, 0, 0, time.UTC),
+			}
+			require.NoError(t, tc.store.Save(ctx, saved))
+
+			checkpoint, err = tc.store.Load(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, saved, checkpoint)
+
+			require.NoError(t, tc.store.Reset(ctx, 1))
+			checkpoint, err = tc.store.Load(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, Checkpoint{UserID: 1}, checkpoint)
+		})
+	}
+}
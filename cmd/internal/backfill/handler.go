@@ -0,0 +1,74 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"goji.io/pat"
+)
+
+// StatusResponse describes the progress of a user's backfill.
+type StatusResponse struct {
+	Running    bool       `json:"running"`
+	Checkpoint Checkpoint `json:"checkpoint"`
+}
+
+// Trigger starts a background backfill for the :user_id path parameter, which
+// runs until ctx is cancelled. Passing ?restart=true discards any existing
+// checkpoint.
+func Trigger(ctx context.Context, backfiller *Backfiller) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+		restart, _ := strconv.ParseBool(r.URL.Query().Get("restart"))
+
+		// The run outlives this request, so it must not inherit its context.
+		err = backfiller.Start(ctx, userID, restart)
+		if errors.Is(err, ErrAlreadyRunning) {
+			http.Error(w, "Backfill already running", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error starting backfill for user %d: %v", userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Backfill started for user %d", userID)
+		writeStatus(w, r, backfiller, userID, http.StatusAccepted)
+	}
+}
+
+// Status reports the checkpoint for the :user_id path parameter.
+func Status(backfiller *Backfiller) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+		writeStatus(w, r, backfiller, userID, http.StatusOK)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, r *http.Request, backfiller *Backfiller, userID int, statusCode int) {
+	checkpoint, running, err := backfiller.Status(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error loading backfill status for user %d: %v", userID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(StatusResponse{Running: running, Checkpoint: checkpoint})
+}
@@ -0,0 +1,199 @@
+// Package calendar publishes each athlete's workouts as an iCalendar feed at a
+// secret URL that calendar apps can subscribe to.
+package calendar
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/webhook"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/ical"
+	"goji.io/pat"
+)
+
+// Window is how far back a feed goes.
+const Window = 365 * 24 * time.Hour
+
+// Signer issues the tokens that make up athletes' secret feed URLs.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// SignerFromEnv signs feed URLs with CALENDAR_SECRET, returning nil when it is
+// unset, which disables the feeds. Changing the secret revokes every URL.
+func SignerFromEnv() *Signer {
+	secret := os.Getenv("CALENDAR_SECRET")
+	if secret == "" {
+		log.Println("No CALENDAR_SECRET configured; calendar feeds are disabled.")
+		return nil
+	}
+	return NewSigner([]byte(secret))
+}
+
+// Token returns the athlete's feed token.
+func (s *Signer) Token(userID int) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("calendar:" + strconv.Itoa(userID)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether token is the athlete's feed token.
+func (s *Signer) Verify(userID int, token string) bool {
+	return hmac.Equal([]byte(token), []byte(s.Token(userID)))
+}
+
+// Path returns the athlete's secret feed path.
+func (s *Signer) Path(userID int) string {
+	return fmt.Sprintf("/athletes/%d/workouts.ics?token=%s", userID, s.Token(userID))
+}
+
+// UID identifies a workout's event. It only depends on the workout ID, so an
+// updated workout replaces its event.
+func UID(workoutID int) string {
+	return fmt.Sprintf("workout-%d@go-wahoo-cloud-api", workoutID)
+}
+
+// Build returns the calendar of the athlete's workouts that started since.
+func Build(ctx context.Context, store workouts.Store, userID int, since time.Time) (ical.Calendar, error) {
+	calendar := ical.Calendar{
+		ProdID: "-//go-wahoo-cloud-api//Workouts//EN",
+		Name:   "Wahoo workouts",
+	}
+	query := workouts.Query{UserID: userID, From: since, Limit: workouts.MaxLimit}
+	for {
+		page, err := store.List(ctx, query)
+		if err != nil {
+			return ical.Calendar{}, err
+		}
+		for _, record := range page.Workouts {
+			event, err := newEvent(record)
+			if err != nil {
+				return ical.Calendar{}, err
+			}
+			calendar.Events = append(calendar.Events, event)
+		}
+		if page.NextCursor == "" {
+			return calendar, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// newEvent renders a workout as an event lasting its total duration, or its
+// length in minutes when the summary has none.
+func newEvent(record workouts.Record) (ical.Event, error) {
+	var summary webhook.WorkoutSummary
+	if err := json.Unmarshal(record.Summary, &summary); err != nil {
+		return ical.Event{}, fmt.Errorf("error decoding summary of workout %d: %w", record.WorkoutID, err)
+	}
+
+	duration, ok := summary.DurationTotalAccum.Duration()
+	if !ok {
+		duration = time.Duration(summary.Workout.Minutes) * time.Minute
+	}
+	name := record.Name
+	if name == "" {
+		name = strings.ReplaceAll(record.WorkoutType, "_", " ")
+	}
+	return ical.Event{
+		UID:          UID(record.WorkoutID),
+		Stamp:        record.UpdatedAt,
+		Start:        record.Starts,
+		End:          record.Starts.Add(duration),
+		LastModified: summary.UpdatedAt,
+		Summary:      name,
+		Description:  describe(record, summary, duration),
+	}, nil
+}
+
+// describe lists the workout's key metrics, one per line, leaving out those
+// the summary does not have.
+func describe(record workouts.Record, summary webhook.WorkoutSummary, duration time.Duration) string {
+	lines := []string{"Type: " + record.WorkoutType}
+	add := func(label, format string, value float64, ok bool) {
+		if ok {
+			lines = append(lines, label+": "+fmt.Sprintf(format, value))
+		}
+	}
+	if duration > 0 {
+		lines = append(lines, "Duration: "+duration.Round(time.Second).String())
+	}
+	distance, ok := summary.DistanceAccum.Float64()
+	add("Distance", "%.1f km", distance/1000, ok)
+	ascent, ok := summary.AscentAccum.Float64()
+	add("Ascent", "%.0f m", ascent, ok)
+	speed, ok := summary.SpeedAvg.Float64()
+	add("Average speed", "%.1f km/h", speed*3.6, ok)
+	power, ok := summary.PowerAvg.Float64()
+	add("Average power", "%.0f W", power, ok)
+	normalized, ok := summary.PowerBikeNpLast.Float64()
+	add("Normalized power", "%.0f W", normalized, ok)
+	tss, ok := summary.PowerBikeTssLast.Float64()
+	add("TSS", "%.0f", tss, ok)
+	heartRate, ok := summary.HeartRateAvg.Float64()
+	add("Average heart rate", "%.0f bpm", heartRate, ok)
+	calories, ok := summary.CaloriesAccum.Float64()
+	add("Calories", "%.0f kcal", calories, ok)
+	return strings.Join(lines, "\n")
+}
+
+// Feed serves the iCalendar feed of the :user_id path parameter's workouts
+// from the last year. The token query parameter must be the athlete's feed
+// token; feeds that are disabled or asked for with the wrong token are not
+// found.
+func Feed(store workouts.Store, signer *Signer) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil || signer == nil || !signer.Verify(userID, r.URL.Query().Get("token")) {
+			http.Error(w, "Calendar not found", http.StatusNotFound)
+			return
+		}
+
+		calendar, err := Build(r.Context(), store, userID, time.Now().Add(-Window))
+		if err != nil {
+			log.Printf("Error building calendar for user %d: %v", userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", ical.ContentType)
+		w.Header().Set("Content-Disposition", `inline; filename="workouts.ics"`)
+		if err := ical.Write(w, calendar); err != nil {
+			log.Printf("Error writing calendar for user %d: %v", userID, err)
+		}
+	}
+}
+
+// URL returns the secret feed path of the :user_id path parameter, to share
+// with the athlete.
+func URL(signer *Signer) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+		if signer == nil {
+			http.Error(w, "Calendar feeds are not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"path": signer.Path(userID)})
+	}
+}
@@ -0,0 +1,120 @@
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/ical"
+	"github.com/stretchr/testify/require"
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+func TestSigner(t *testing.T) {
+
+	signer := NewSigner([]byte("secret"))
+	token := signer.Token(1120489)
+	require.True(t, signer.Verify(1120489, token))
+	require.False(t, signer.Verify(1, token))
+	require.False(t, signer.Verify(1120489, ""))
+	require.False(t, NewSigner([]byte("rotated")).Verify(1120489, token))
+	require.Equal(t, "/athletes/1120489/workouts.ics?token="+token, signer.Path(1120489))
+
+	require.Nil(t, SignerFromEnv())
+	t.Setenv("CALENDAR_SECRET", "secret")
+	require.Equal(t, token, SignerFromEnv().Token(1120489))
+}
+
+func TestFeed(t *testing.T) {
+
+	starts := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Second)
+	updated := starts.Add(2 * time.Hour)
+	store := workouts.NewMemoryStore()
+	for _, record := range []workouts.Record{
+		{WorkoutID: 281788767, UserID: 1120489, Name: "Hill repeats", WorkoutTypeID: 0, Starts: starts, UpdatedAt: updated,
+			Summary: json.RawMessage(`{"distance_accum":"24300.0","duration_total_accum":"3725.0","power_avg":"210.4",
+				"power_bike_tss_last":"65.2","heart_rate_avg":null,"updated_at":"` + updated.Format(time.RFC3339) + `"}`)},
+		{WorkoutID: 2, UserID: 1120489, WorkoutTypeID: 1, Starts: starts.Add(24 * time.Hour), UpdatedAt: updated,
+			Summary: json.RawMessage(`{"workout":{"minutes":30}}`)},
+		{WorkoutID: 3, UserID: 1120489, WorkoutTypeID: 0, Starts: starts.AddDate(-2, 0, 0), Summary: json.RawMessage(`{}`)},
+		{WorkoutID: 4, UserID: 1, WorkoutTypeID: 0, Starts: starts, Summary: json.RawMessage(`{}`)},
+	} {
+		require.NoError(t, store.Save(context.Background(), record))
+	}
+
+	signer := NewSigner([]byte("secret"))
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/athletes/:user_id/workouts.ics"), Feed(store, signer))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, signer.Path(1120489), nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, ical.ContentType, response.Header().Get("Content-Type"))
+
+	body := strings.ReplaceAll(response.Body.String(), "\r\n ", "")
+	require.Equal(t, 2, strings.Count(body, "BEGIN:VEVENT"))
+	require.Contains(t, body, "UID:workout-281788767@go-wahoo-cloud-api\r\n")
+	require.Contains(t, body, "UID:workout-2@go-wahoo-cloud-api\r\n")
+	require.Contains(t, body, "SUMMARY:Hill repeats\r\n")
+	require.Contains(t, body, "SUMMARY:running\r\n")
+	require.Contains(t, body, "DTEND:"+starts.Add(3725*time.Second).Format("20060102T150405Z")+"\r\n")
+	require.Contains(t, body, "DTEND:"+starts.Add(24*time.Hour+30*time.Minute).Format("20060102T150405Z")+"\r\n")
+	require.Contains(t, body, "LAST-MODIFIED:"+updated.Format("20060102T150405Z")+"\r\n")
+	require.Contains(t, body, `DESCRIPTION:Type: biking\nDuration: 1h2m5s\nDistance: 24.3 km\nAverage power: 210 W\nTSS: 65`+"\r\n")
+
+	// A workout updated upstream keeps its UID, so it replaces its event.
+	require.NoError(t, store.Save(context.Background(), workouts.Record{WorkoutID: 2, UserID: 1120489, Name: "Easy run",
+		WorkoutTypeID: 1, Starts: starts.Add(24 * time.Hour), Summary: json.RawMessage(`{"workout":{"minutes":30}}`)}))
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, signer.Path(1120489), nil))
+	body = response.Body.String()
+	require.Equal(t, 1, strings.Count(body, "UID:workout-2@go-wahoo-cloud-api\r\n"))
+	require.Contains(t, body, "SUMMARY:Easy run\r\n")
+
+	for _, path := range []string{
+		"/athletes/1120489/workouts.ics",
+		"/athletes/1120489/workouts.ics?token=" + NewSigner([]byte("other")).Token(1120489),
+		"/athletes/1/workouts.ics?token=" + signer.Token(1120489),
+		"/athletes/me/workouts.ics?token=" + signer.Token(1120489),
+	} {
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusNotFound, response.Code, path)
+	}
+
+	disabled := goji.NewMux()
+	disabled.HandleFunc(pat.Get("/athletes/:user_id/workouts.ics"), Feed(store, nil))
+	response = httptest.NewRecorder()
+	disabled.ServeHTTP(response, httptest.NewRequest(http.MethodGet, signer.Path(1120489), nil))
+	require.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestURL(t *testing.T) {
+
+	signer := NewSigner([]byte("secret"))
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/athletes/:user_id/calendar"), URL(signer))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1120489/calendar", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	require.Equal(t, signer.Path(1120489), body["path"])
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/me/calendar", nil))
+	require.Equal(t, http.StatusBadRequest, response.Code)
+
+	disabled := goji.NewMux()
+	disabled.HandleFunc(pat.Get("/athletes/:user_id/calendar"), URL(nil))
+	response = httptest.NewRecorder()
+	disabled.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1120489/calendar", nil))
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenSQLite_CreatesDatabase(t *testing.T) {
+
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if mode != "wal" {
+		t.Errorf("Expected journal mode wal, but got %s", mode)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	migrations := []Migration{
+		{Version: 1, Description: "create things", Statements: []string{`CREATE TABLE things (id INTEGER PRIMARY KEY)`}},
+		{Version: 2, Description: "add colour", Statements: []string{`ALTER TABLE things ADD COLUMN colour TEXT NOT NULL DEFAULT 'red'`}},
+	}
+
+	// Applied migrations are skipped, so running twice must not fail.
+	for i := 0; i < 2; i++ {
+		if err := Migrate(db, SQLite, "things", migrations); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	migrations = append(migrations, Migration{Version: 3, Description: "broken", Statements: []string{
+		`ALTER TABLE things ADD COLUMN size INTEGER`,
+		`NOT SQL`,
+	}})
+	if err := Migrate(db, SQLite, "things", migrations); err == nil {
+		t.Fatalf("Expected an error from the broken migration")
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations WHERE component = 'things'`).Scan(&version); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if version != 2 {
+		t.Errorf("Expected version 2, but got %d", version)
+	}
+	// The failed migration was rolled back as a whole.
+	var sized bool
+	if err := db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info('things') WHERE name = 'size'`).Scan(&sized); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sized {
+		t.Errorf("Expected the size column to have been rolled back")
+	}
+}
+
+func TestMigrate_AddsMissingColumns(t *testing.T) {
+
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	// A table from before the component was migrated, which already has one
+	// of the columns the migration adds.
+	if _, err := db.Exec(`CREATE TABLE things (id INTEGER PRIMARY KEY, colour TEXT NOT NULL DEFAULT 'blue')`); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	migrations := []Migration{
+		{Version: 1, Description: "create things", Statements: []string{`CREATE TABLE IF NOT EXISTS things (id INTEGER PRIMARY KEY)`}},
+		{Version: 2, Description: "add colour and size", Columns: []Column{
+			{Table: "things", Name: "colour", Definition: "TEXT NOT NULL DEFAULT 'red'"},
+			{Table: "things", Name: "size", Definition: "INTEGER NOT NULL DEFAULT 1"},
+		}},
+	}
+	if err := Migrate(db, SQLite, "things", migrations); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO things (id) VALUES (1)`); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var colour string
+	var size int
+	if err := db.QueryRow(`SELECT colour, size FROM things`).Scan(&colour, &size); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if colour != "blue" || size != 1 {
+		t.Errorf("Expected colour blue and size 1, but got %s and %d", colour, size)
+	}
+}
+
+func TestDialectRebind(t *testing.T) {
+
+	query := `SELECT * FROM things WHERE id = ? AND colour = ?`
+	if got := SQLite.Rebind(query); got != query {
+		t.Errorf("Expected the query unchanged, but got %s", got)
+	}
+	if got := Postgres.Rebind(query); got != `SELECT * FROM things WHERE id = $1 AND colour = $2` {
+		t.Errorf("Unexpected query %s", got)
+	}
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect is the flavour of SQL a database speaks.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// Rebind rewrites the ? placeholders in query into the dialect's own.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Migration is one numbered change to a schema. Its statements are written in
+// SQL that both SQLite and Postgres accept.
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+	// Columns are added after the statements run, skipping any their table
+	// already has: tables created before their component was migrated may
+	// have picked some of them up already.
+	Columns []Column
+}
+
+// Column is a column a migration adds to an existing table, as its name and
+// the definition that follows it in ALTER TABLE ADD COLUMN.
+type Column struct {
+	Table      string
+	Name       string
+	Definition string
+}
+
+// Migrate applies the migrations of component that have not been applied yet,
+// in version order, each in its own transaction. Applied versions are
+// recorded in the schema_migrations table.
+func Migrate(db *sql.DB, dialect Dialect, component string, migrations []Migration) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		component   TEXT NOT NULL,
+		version     INTEGER NOT NULL,
+		description TEXT NOT NULL,
+		applied_at  BIGINT NOT NULL,
+		PRIMARY KEY (component, version)
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	var current int
+	err = db.QueryRow(dialect.Rebind(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations WHERE component = ?`), component).
+		Scan(&current)
+	if err != nil {
+		return fmt.Errorf("error reading %s schema version: %w", component, err)
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		if err := apply(db, dialect, component, migration); err != nil {
+			return fmt.Errorf("error applying %s migration %d (%s): %w", component, migration.Version, migration.Description, err)
+		}
+		current = migration.Version
+	}
+	return nil
+}
+
+func apply(db *sql.DB, dialect Dialect, component string, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range migration.Statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	for _, column := range migration.Columns {
+		if err := addColumn(tx, dialect, column); err != nil {
+			return fmt.Errorf("error adding column %s to %s: %w", column.Name, column.Table, err)
+		}
+	}
+	_, err = tx.Exec(dialect.Rebind(`INSERT INTO schema_migrations (component, version, description, applied_at) VALUES (?, ?, ?, ?)`),
+		component, migration.Version, migration.Description, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addColumn adds the column to its table unless the table already has it.
+func addColumn(tx *sql.Tx, dialect Dialect, column Column) error {
+	if dialect == Postgres {
+		_, err := tx.Exec(`ALTER TABLE ` + column.Table + ` ADD COLUMN IF NOT EXISTS ` + column.Name + ` ` + column.Definition)
+		return err
+	}
+
+	var exists bool
+	err := tx.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, column.Table, column.Name).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE ` + column.Table + ` ADD COLUMN ` + column.Name + ` ` + column.Definition)
+	return err
+}
@@ -0,0 +1,290 @@
+// Package feeds publishes the workouts most recently received from Wahoo as
+// Atom and RSS feeds, across all athletes or for one, at secret URLs that feed
+// readers can poll.
+package feeds
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/webhook"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/syndication"
+	"goji.io/pat"
+)
+
+// DefaultEntries is the number of workouts in a feed unless asked otherwise.
+const DefaultEntries = 50
+
+// Signer issues the tokens that make up the feeds' secret URLs.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// SignerFromEnv signs feed URLs with FEED_SECRET, returning nil when it is
+// unset, which disables the feeds. Changing the secret revokes every URL.
+func SignerFromEnv() *Signer {
+	secret := os.Getenv("FEED_SECRET")
+	if secret == "" {
+		log.Println("No FEED_SECRET configured; workout feeds are disabled.")
+		return nil
+	}
+	return NewSigner([]byte(secret))
+}
+
+// Token returns the token of the athlete's feed, or of the feed across all
+// athletes when userID is 0. It is the same for both formats.
+func (s *Signer) Token(userID int) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("feed:" + strconv.Itoa(userID)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether token is the token of the athlete's feed.
+func (s *Signer) Verify(userID int, token string) bool {
+	return hmac.Equal([]byte(token), []byte(s.Token(userID)))
+}
+
+// Path returns the secret path of the athlete's feed in format, or of the
+// feed across all athletes when userID is 0.
+func (s *Signer) Path(format string, userID int) string {
+	query := url.Values{"token": {s.Token(userID)}}
+	if userID != 0 {
+		query.Set("user_id", strconv.Itoa(userID))
+	}
+	return "/feeds/workouts." + format + "?" + query.Encode()
+}
+
+// EntryID identifies a workout's entry. It only depends on the workout ID, so
+// an updated workout replaces its entry.
+func EntryID(workoutID int) string {
+	return fmt.Sprintf("urn:go-wahoo-cloud-api:workout:%d", workoutID)
+}
+
+// Build returns the feed of the records, which are newest first.
+func Build(records []workouts.Record, userID int, link string) (syndication.Feed, error) {
+	feed := syndication.Feed{
+		ID:          "urn:go-wahoo-cloud-api:workouts",
+		Title:       "Wahoo workouts",
+		Description: "Workouts recently received from Wahoo",
+		Link:        link,
+		Updated:     lastModified(records),
+	}
+	if userID != 0 {
+		feed.ID += ":" + strconv.Itoa(userID)
+		feed.Title += " of athlete " + strconv.Itoa(userID)
+	}
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now().UTC().Truncate(time.Second)
+	}
+
+	for _, record := range records {
+		var summary webhook.WorkoutSummary
+		if err := json.Unmarshal(record.Summary, &summary); err != nil {
+			return syndication.Feed{}, fmt.Errorf("error decoding summary of workout %d: %w", record.WorkoutID, err)
+		}
+		title := record.Name
+		if title == "" {
+			title = strings.ReplaceAll(record.WorkoutType, "_", " ")
+		}
+		feed.Entries = append(feed.Entries, syndication.Entry{
+			ID:        EntryID(record.WorkoutID),
+			Title:     title,
+			Author:    "Athlete " + strconv.Itoa(record.UserID),
+			Content:   describe(record, summary),
+			Published: record.ReceivedAt,
+			Updated:   record.UpdatedAt,
+		})
+	}
+	return feed, nil
+}
+
+// describe summarises a workout in a sentence, leaving out the metrics its
+// summary does not have.
+func describe(record workouts.Record, summary webhook.WorkoutSummary) string {
+	parts := []string{
+		strings.ReplaceAll(record.WorkoutType, "_", " ") + " started " + record.Starts.UTC().Format("2006-01-02 15:04 UTC"),
+	}
+	if distance, ok := summary.DistanceAccum.Float64(); ok {
+		parts = append(parts, fmt.Sprintf("%.1f km", distance/1000))
+	}
+	if duration, ok := summary.DurationActiveAccum.Duration(); ok {
+		parts = append(parts, duration.Round(time.Second).String()+" active")
+	}
+	if ascent, ok := summary.AscentAccum.Float64(); ok {
+		parts = append(parts, fmt.Sprintf("%.0f m climbing", ascent))
+	}
+	if power, ok := summary.PowerAvg.Float64(); ok {
+		parts = append(parts, fmt.Sprintf("%.0f W average", power))
+	}
+	if tss, ok := summary.PowerBikeTssLast.Float64(); ok {
+		parts = append(parts, fmt.Sprintf("TSS %.0f", tss))
+	}
+	text := strings.Join(parts, ", ")
+	if record.Status == workouts.StatusFailed {
+		text += ". Processing failed: " + record.Error
+	}
+	return text
+}
+
+// lastModified is when the most recently updated record was updated.
+func lastModified(records []workouts.Record) time.Time {
+	var latest time.Time
+	for _, record := range records {
+		if record.UpdatedAt.After(latest) {
+			latest = record.UpdatedAt
+		}
+	}
+	return latest
+}
+
+// etag is a validator for the feed of the records, which changes whenever a
+// workout enters or leaves it or is updated.
+func etag(format string, records []workouts.Record) string {
+	hash := sha256.New()
+	fmt.Fprintln(hash, format)
+	for _, record := range records {
+		fmt.Fprintln(hash, record.WorkoutID, record.UpdatedAt.Unix(), record.Status)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// notModified reports whether the request's conditional headers match the
+// feed, in which case it need not be sent again. If-None-Match takes
+// precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.IsZero() && !lastModified.After(since)
+}
+
+// Workouts serves the feed of the most recently received workouts in the
+// :format path parameter, atom or rss. The user_id query parameter limits it
+// to one athlete and limit sets the number of workouts, DefaultEntries by
+// default. The token query parameter must be the feed's, or the feed is not
+// found. Responses carry an ETag and Last-Modified, and conditional requests
+// for an unchanged feed are answered 304 Not Modified.
+func Workouts(store workouts.Store, signer *Signer) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := pat.Param(r, "format")
+		write, contentType := syndication.WriteAtom, syndication.AtomContentType
+		switch format {
+		case "atom":
+		case "rss":
+			write, contentType = syndication.WriteRSS, syndication.RSSContentType
+		default:
+			http.Error(w, "Unsupported feed format "+format, http.StatusNotFound)
+			return
+		}
+
+		query := workouts.Query{Sort: workouts.SortReceivedAt, Descending: true, Limit: DefaultEntries}
+		var err error
+		if value := r.URL.Query().Get("user_id"); value != "" {
+			if query.UserID, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "Invalid user id", http.StatusBadRequest)
+				return
+			}
+		}
+		if signer == nil || !signer.Verify(query.UserID, r.URL.Query().Get("token")) {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return
+		}
+		if value := r.URL.Query().Get("limit"); value != "" {
+			if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit < 1 || query.Limit > workouts.MaxLimit {
+				http.Error(w, fmt.Sprintf("Invalid limit, must be between 1 and %d", workouts.MaxLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		page, err := store.List(r.Context(), query)
+		if err != nil {
+			log.Printf("Error listing workouts for feed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		tag, modified := etag(format, page.Workouts), lastModified(page.Workouts)
+		w.Header().Set("ETag", tag)
+		if !modified.IsZero() {
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+		if notModified(r, tag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		feed, err := Build(page.Workouts, query.UserID, selfLink(r))
+		if err != nil {
+			log.Printf("Error building feed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		var body bytes.Buffer
+		if err := write(&body, feed); err != nil {
+			log.Printf("Error writing feed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(body.Bytes())
+	}
+}
+
+// URL returns the secret paths of the feed in each format, for the athlete in
+// the user_id query parameter or across all athletes without it, to share
+// with whoever polls it.
+func URL(signer *Signer) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var userID int
+		if value := r.URL.Query().Get("user_id"); value != "" {
+			var err error
+			if userID, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "Invalid user id", http.StatusBadRequest)
+				return
+			}
+		}
+		if signer == nil {
+			http.Error(w, "Workout feeds are not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"atom": signer.Path("atom", userID),
+			"rss":  signer.Path("rss", userID),
+		})
+	}
+}
+
+// selfLink is the absolute URL the feed was requested at.
+func selfLink(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
@@ -0,0 +1,200 @@
+package feeds
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/calendar"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/syndication"
+	"github.com/stretchr/testify/require"
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+func testStore(t *testing.T) workouts.Store {
+	store := workouts.NewMemoryStore()
+	received := time.Date(2024, 4, 9, 22, 0, 0, 0, time.UTC)
+	for _, record := range []workouts.Record{
+		{WorkoutID: 1, UserID: 1120489, Name: "Hill repeats", WorkoutTypeID: 0, Starts: time.Date(2024, 4, 9, 20, 40, 25, 0, time.UTC),
+			Status: workouts.StatusProcessed, ReceivedAt: received, UpdatedAt: received.Add(time.Minute),
+			Summary: json.RawMessage(`{"distance_accum":"24300.0","duration_active_accum":"3600.0","power_avg":"210.4","power_bike_tss_last":"65.2"}`)},
+		{WorkoutID: 2, UserID: 1, WorkoutTypeID: 1, Starts: time.Date(2024, 4, 10, 7, 0, 0, 0, time.UTC),
+			Status: workouts.StatusFailed, Error: "invalid FIT file", ReceivedAt: received.Add(time.Hour), UpdatedAt: received.Add(time.Hour),
+			Summary: json.RawMessage(`{}`)},
+	} {
+		require.NoError(t, store.Save(context.Background(), record))
+		require.NoError(t, store.SetStatus(context.Background(), record.WorkoutID, record.Status, record.Error))
+	}
+	return store
+}
+
+func TestSigner(t *testing.T) {
+
+	signer := NewSigner([]byte("secret"))
+	token := signer.Token(1120489)
+	require.True(t, signer.Verify(1120489, token))
+	require.False(t, signer.Verify(0, token))
+	require.False(t, signer.Verify(1120489, ""))
+	require.False(t, NewSigner([]byte("rotated")).Verify(1120489, token))
+	require.NotEqual(t, calendar.NewSigner([]byte("secret")).Token(1120489), token)
+	require.Equal(t, "/feeds/workouts.rss?token="+token+"&user_id=1120489", signer.Path("rss", 1120489))
+	require.Equal(t, "/feeds/workouts.atom?token="+signer.Token(0), signer.Path("atom", 0))
+
+	require.Nil(t, SignerFromEnv())
+	t.Setenv("FEED_SECRET", "secret")
+	require.Equal(t, token, SignerFromEnv().Token(1120489))
+}
+
+func TestWorkouts(t *testing.T) {
+
+	signer := NewSigner([]byte("secret"))
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/feeds/workouts.:format"), Workouts(testStore(t), signer))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "https://example.com"+signer.Path("atom", 0), nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, syndication.AtomContentType, response.Header().Get("Content-Type"))
+
+	var atom struct {
+		ID    string `xml:"id"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Title   string `xml:"title"`
+			Author  string `xml:"author>name"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	require.NoError(t, xml.Unmarshal(response.Body.Bytes(), &atom))
+	require.Equal(t, "urn:go-wahoo-cloud-api:workouts", atom.ID)
+	require.Equal(t, "https://example.com"+signer.Path("atom", 0), atom.Links[0].Href)
+	require.Len(t, atom.Entries, 2)
+	require.Equal(t, EntryID(2), atom.Entries[0].ID)
+	require.Equal(t, "running", atom.Entries[0].Title)
+	require.Equal(t, "running started 2024-04-10 07:00 UTC. Processing failed: invalid FIT file", atom.Entries[0].Content)
+	require.Equal(t, EntryID(1), atom.Entries[1].ID)
+	require.Equal(t, "Athlete 1120489", atom.Entries[1].Author)
+	require.Equal(t, "biking started 2024-04-09 20:40 UTC, 24.3 km, 1h0m0s active, 210 W average, TSS 65", atom.Entries[1].Content)
+
+	tag := response.Header().Get("ETag")
+	require.NotEmpty(t, tag)
+	lastModified := response.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified)
+
+	for header, value := range map[string]string{"If-None-Match": "W/" + tag, "If-Modified-Since": lastModified} {
+		request := httptest.NewRequest(http.MethodGet, signer.Path("atom", 0), nil)
+		request.Header.Set(header, value)
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		require.Equal(t, http.StatusNotModified, response.Code, header)
+		require.Empty(t, response.Body.String())
+	}
+
+	request := httptest.NewRequest(http.MethodGet, signer.Path("atom", 0), nil)
+	request.Header.Set("If-None-Match", `"stale"`)
+	request.Header.Set("If-Modified-Since", lastModified)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, signer.Path("rss", 1120489), nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, syndication.RSSContentType, response.Header().Get("Content-Type"))
+	require.NotEqual(t, tag, response.Header().Get("ETag"))
+
+	var rss struct {
+		Channel struct {
+			Title string `xml:"title"`
+			Items []struct {
+				GUID string `xml:"guid"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	require.NoError(t, xml.Unmarshal(response.Body.Bytes(), &rss))
+	require.Equal(t, "Wahoo workouts of athlete 1120489", rss.Channel.Title)
+	require.Len(t, rss.Channel.Items, 1)
+	require.Equal(t, EntryID(1), rss.Channel.Items[0].GUID)
+
+	token := signer.Token(0)
+	for path, status := range map[string]int{
+		"/feeds/workouts.json?token=" + token:                               http.StatusNotFound,
+		"/feeds/workouts.atom?user_id=me&token=" + token:                    http.StatusBadRequest,
+		"/feeds/workouts.atom?limit=0&token=" + token:                       http.StatusBadRequest,
+		"/feeds/workouts.rss?limit=501&token=" + token:                      http.StatusBadRequest,
+		"/feeds/workouts.atom":                                              http.StatusNotFound,
+		"/feeds/workouts.atom?token=" + NewSigner([]byte("other")).Token(0): http.StatusNotFound,
+		"/feeds/workouts.atom?user_id=1&token=" + signer.Token(1120489):     http.StatusNotFound,
+		"/feeds/workouts.atom?user_id=1&token=" + token:                     http.StatusNotFound,
+	} {
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, status, response.Code, path)
+	}
+
+	disabled := goji.NewMux()
+	disabled.HandleFunc(pat.Get("/feeds/workouts.:format"), Workouts(testStore(t), nil))
+	response = httptest.NewRecorder()
+	disabled.ServeHTTP(response, httptest.NewRequest(http.MethodGet, signer.Path("atom", 0), nil))
+	require.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestWorkouts_ChangesValidatorsOnUpdate(t *testing.T) {
+
+	store := testStore(t)
+	signer := NewSigner([]byte("secret"))
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/feeds/workouts.:format"), Workouts(store, signer))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, signer.Path("atom", 0), nil))
+	tag := response.Header().Get("ETag")
+
+	require.NoError(t, store.SetStatus(context.Background(), 2, workouts.StatusProcessed, ""))
+
+	request := httptest.NewRequest(http.MethodGet, signer.Path("atom", 0), nil)
+	request.Header.Set("If-None-Match", tag)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.NotEqual(t, tag, response.Header().Get("ETag"))
+}
+
+func TestURL(t *testing.T) {
+
+	signer := NewSigner([]byte("secret"))
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/feeds/workouts"), URL(signer))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/feeds/workouts?user_id=1120489", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	require.Equal(t, map[string]string{"atom": signer.Path("atom", 1120489), "rss": signer.Path("rss", 1120489)}, body)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/feeds/workouts", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	require.Equal(t, signer.Path("atom", 0), body["atom"])
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/feeds/workouts?user_id=me", nil))
+	require.Equal(t, http.StatusBadRequest, response.Code)
+
+	disabled := goji.NewMux()
+	disabled.HandleFunc(pat.Get("/feeds/workouts"), URL(nil))
+	response = httptest.NewRecorder()
+	disabled.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/feeds/workouts", nil))
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+}
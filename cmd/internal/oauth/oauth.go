@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/utils"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/wahoo"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 )
 
 type WahooTokenResponse struct {
@@ -20,40 +22,55 @@ type WahooTokenResponse struct {
 	CreatedAt    int    `json:"created_at" validate:"required"`
 }
 
-func Authorize() func(w http.ResponseWriter, r *http.Request) {
+func Authorize(states *StateSigner) func(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("Authorize called")
 	wahooClientId := os.Getenv("WAHOO_CLIENT_ID")
 	wahooRedirectUri := os.Getenv("REDIRECT_URI")
-
-	//Redirect to Wahoo API
-	redirectUrl, err := utils.GetWahooAuthorizeUrl(wahooClientId, wahooRedirectUri)
-	log.Println(redirectUrl.String())
+	pkceEnabled := isPKCEEnabled()
 
 	return func(w http.ResponseWriter, r *http.Request) {
-
-		if err != nil {
-			panic(err)
-		}
-		http.Redirect(w, r, redirectUrl.String(), 301)
+		redirectToWahoo(w, r, states, wahooClientId, wahooRedirectUri, pkceEnabled)
 	}
 }
 
-func AuthCallback() func(w http.ResponseWriter, r *http.Request) {
+func AuthCallback(store TokenStore, states *StateSigner) func(w http.ResponseWriter, r *http.Request) {
 
 	wahooClientId := os.Getenv("WAHOO_CLIENT_ID")
 	wahooClientSecret := os.Getenv("WAHOO_CLIENT_SECRET")
 	wahooRedirectUri := os.Getenv("REDIRECT_URI")
+	pkceEnabled := isPKCEEnabled()
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		code := r.URL.Query().Get("code")
 
-		if utils.CheckIfAuthCodeDoesntExist(w, r, code, wahooClientId, wahooRedirectUri) {
+		if code == "" {
+			log.Printf("No code found in the URL")
+			redirectToWahoo(w, r, states, wahooClientId, wahooRedirectUri, pkceEnabled)
+			return
+		}
+
+		if err := states.verifyCallbackState(r); err != nil {
+			log.Printf("Rejecting OAuth callback: %v", err)
+			http.Error(w, "Invalid OAuth state, please restart the authorization.", stateErrorStatus(err))
 			return
 		}
+		clearFlowCookie(w, stateCookieName)
+
+		codeVerifier := ""
+		if pkceEnabled {
+			cookie, err := r.Cookie(verifierCookieName)
+			if err != nil || cookie.Value == "" {
+				log.Printf("Rejecting OAuth callback: missing PKCE code verifier")
+				http.Error(w, "Missing PKCE code verifier, please restart the authorization.", http.StatusBadRequest)
+				return
+			}
+			codeVerifier = cookie.Value
+			clearFlowCookie(w, verifierCookieName)
+		}
 
-		oauthUrl, err := utils.GetWahooOAuthExchangeURL(wahooClientId, wahooClientSecret, code, wahooRedirectUri)
+		oauthUrl, err := utils.GetWahooOAuthExchangeURL(wahooClientId, wahooClientSecret, code, wahooRedirectUri, codeVerifier)
 		if err != nil {
 			log.Printf("Error getting the OAuth exchange URL: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -80,41 +97,82 @@ func AuthCallback() func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		enc := json.NewEncoder(w)
-		enc.SetEscapeHTML(false)
+		var tokenResponse WahooTokenResponse
+		jErr := json.Unmarshal(body, &tokenResponse)
+		if jErr != nil {
+			fmt.Println("Error unmarshalling JSON:", jErr)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
 
-		if oauthResponse.StatusCode == http.StatusOK {
-			fmt.Println("OAuth exchange successful. Response body:", string(body))
-			var tokenResponse WahooTokenResponse
-			jErr := json.Unmarshal(body, &tokenResponse)
-			if jErr != nil {
-				fmt.Println("Error unmarshalling JSON:", jErr)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
+		tokenValidator := validator.New(validator.WithRequiredStructEnabled())
+		err = tokenValidator.Struct(tokenResponse)
+		if err != nil {
+			log.Printf("Token response failed validation: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
 
-			tokenValidator := validator.New(validator.WithRequiredStructEnabled())
-			err = tokenValidator.Struct(tokenResponse)
-			if err != nil {
-				fmt.Println("Error unmarshalling JSON:", jErr)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
+		wahooClient := wahoo.NewClient(wahoo.StaticToken(tokenResponse.AccessToken), wahoo.WithBaseURL(utils.GetWahooApiBaseUrl()))
+		wahooUser, err := wahooClient.User(r.Context())
+		if err != nil {
+			log.Printf("Error fetching the Wahoo user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
 
-			enc.Encode(tokenResponse)
+		err = store.Save(r.Context(), NewToken(wahooUser.ID, tokenResponse))
+		if err != nil {
+			log.Printf("Error storing token: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		log.Printf("Response status code: %d", oauthResponse.StatusCode)
-		log.Printf("Error response: %s", string(body))
-		// Respond with an error message if needed.
-		fprintf, err := fmt.Fprintf(w, string(body))
+		log.Printf("OAuth exchange successful. Stored grant for Wahoo user %d", wahooUser.ID)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := successPage.Execute(w, wahooUser); err != nil {
+			log.Printf("Error rendering success page: %v", err)
+		}
+	}
+}
+
+// redirectToWahoo starts an authorization flow by binding a fresh state (and
+// optionally a PKCE verifier) to the browser and redirecting to Wahoo.
+func redirectToWahoo(w http.ResponseWriter, r *http.Request, states *StateSigner, wahooClientId, wahooRedirectUri string, pkceEnabled bool) {
+	state, err := states.Issue()
+	if err != nil {
+		log.Printf("Error issuing OAuth state: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	setFlowCookie(w, r, stateCookieName, state, states.ttl)
+
+	codeChallenge := ""
+	if pkceEnabled {
+		codeVerifier, err := newCodeVerifier()
 		if err != nil {
-			log.Fatal(fprintf)
+			log.Printf("Error generating PKCE code verifier: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		setFlowCookie(w, r, verifierCookieName, codeVerifier, states.ttl)
+		codeChallenge = codeChallengeS256(codeVerifier)
+	}
 
-		enc.Encode(body)
+	redirectUrl, err := utils.GetWahooAuthorizeUrl(wahooClientId, wahooRedirectUri, state, codeChallenge)
+	if err != nil {
+		log.Printf("Error building the Wahoo authorize URL: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+
+	http.Redirect(w, r, redirectUrl.String(), http.StatusFound)
+}
+
+func isPKCEEnabled() bool {
+	pkceEnabled, err := strconv.ParseBool(os.Getenv("WAHOO_PKCE_ENABLED"))
+	if err != nil {
+		return false
+	}
+	return pkceEnabled
 }
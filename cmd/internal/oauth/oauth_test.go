@@ -1,7 +1,7 @@
 package oauth
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/magiconair/properties/assert"
 	"github.com/ory/dockertest/v3"
@@ -10,6 +10,8 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -24,13 +26,17 @@ func TestOAuthHappyPath_RedirectCorrectly(t *testing.T) {
 	request, _ := http.NewRequest("GET", "/authorize", nil)
 
 	response := httptest.NewRecorder()
-	handler := http.HandlerFunc(Authorize())
+	handler := http.HandlerFunc(Authorize(NewStateSigner([]byte("secret"))))
 	handler.ServeHTTP(response, request)
 
+	stateCookie := findCookie(response.Result().Cookies(), stateCookieName)
+	assert.Equal(t, stateCookie != nil, true)
+
 	assert.Equal(t,
 		response.Result().Header.Get("Location"),
 		"https://api.wahooligan.com/oauth/authorize?client_id=client123&redirect_uri="+
-			"https://example.com/callback&scope=user_read%20workouts_read%20offline_data&response_type=code")
+			"https://example.com/callback&scope=user_read%20workouts_read%20offline_data&response_type=code"+
+			"&state="+url.QueryEscape(stateCookie.Value))
 }
 
 func TestAuthCallback_AuthCodeReceived_HappyPath(t *testing.T) {
@@ -43,6 +49,14 @@ func TestAuthCallback_AuthCodeReceived_HappyPath(t *testing.T) {
 	defer wiremockClient.Reset()
 
 	t.Setenv("WAHOO_TOKEN_BASE_URL", "http://localhost:"+wiremockPort+"/oauth/token")
+	t.Setenv("WAHOO_API_BASE_URL", "http://localhost:"+wiremockPort)
+
+	_ = wiremockClient.StubFor(wiremock.Get(wiremock.URLPathMatching("/v1/user")).
+		WillReturnResponse(
+			wiremock.NewResponse().WithStatus(200).WithJSONBody(map[string]any{
+				"id":    1120489,
+				"first": "James",
+			})))
 
 	_ = wiremockClient.StubFor(wiremock.Post(wiremock.URLPathMatching("/oauth/token")).
 		WillReturnResponse(
@@ -56,16 +70,21 @@ func TestAuthCallback_AuthCodeReceived_HappyPath(t *testing.T) {
 			})))
 	defer wiremockClient.Reset()
 
-	request, _ := http.NewRequest("GET", "/?code=abc", nil)
+	states := NewStateSigner([]byte("secret"))
+	request := newCallbackRequest(states, "abc")
 
+	store := NewMemoryTokenStore()
 	response := httptest.NewRecorder()
-	handler := http.HandlerFunc(AuthCallback())
+	handler := http.HandlerFunc(AuthCallback(store, states))
 	handler.ServeHTTP(response, request)
 
 	assert.Equal(t, response.Code, 200)
+	assert.Equal(t, strings.Contains(response.Body.String(), "my_access_token"), false)
 
-	expectedResponseBody := unMarshallResponse(response.Body.String())
-	assert.Equal(t, expectedResponseBody.AccessToken, "my_access_token")
+	storedToken, err := store.Get(context.Background(), 1120489)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, storedToken.AccessToken, "my_access_token")
+	assert.Equal(t, storedToken.RefreshToken, "my_refresh_token")
 }
 
 func TestAuthCallback_AuthCodeReceived_WahooUnavailable(t *testing.T) {
@@ -83,10 +102,11 @@ func TestAuthCallback_AuthCodeReceived_WahooUnavailable(t *testing.T) {
 		WillReturnResponse(
 			wiremock.NewResponse().WithStatus(500)))
 
-	request, _ := http.NewRequest("GET", "/?code=abc", nil)
+	states := NewStateSigner([]byte("secret"))
+	request := newCallbackRequest(states, "abc")
 
 	response := httptest.NewRecorder()
-	handler := http.HandlerFunc(AuthCallback())
+	handler := http.HandlerFunc(AuthCallback(NewMemoryTokenStore(), states))
 	handler.ServeHTTP(response, request)
 
 	assert.Equal(t, response.Code, 500)
@@ -111,10 +131,11 @@ func TestAuthCallback_AuthCodeReceived_JsonTokenPayloadChanged(t *testing.T) {
 			})))
 	defer wiremockClient.Reset()
 
-	request, _ := http.NewRequest("GET", "/?code=abc", nil)
+	states := NewStateSigner([]byte("secret"))
+	request := newCallbackRequest(states, "abc")
 
 	response := httptest.NewRecorder()
-	handler := http.HandlerFunc(AuthCallback())
+	handler := http.HandlerFunc(AuthCallback(NewMemoryTokenStore(), states))
 	handler.ServeHTTP(response, request)
 
 	assert.Equal(t, response.Code, 500)
@@ -165,8 +186,18 @@ func startWiremock() (*dockertest.Resource, *dockertest.Network, string) {
 	return r, network, wiremockPort
 }
 
-func unMarshallResponse(wahooRequestBody string) WahooTokenResponse {
-	var tokenResponse WahooTokenResponse
-	_ = json.Unmarshal([]byte(wahooRequestBody), &tokenResponse)
-	return tokenResponse
+func newCallbackRequest(states *StateSigner, code string) *http.Request {
+	state, _ := states.Issue()
+	request, _ := http.NewRequest("GET", "/?code="+code+"&state="+url.QueryEscape(state), nil)
+	request.AddCookie(&http.Cookie{Name: stateCookieName, Value: state})
+	return request
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
 }
@@ -0,0 +1,120 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+)
+
+// SQLiteTokenStore is a TokenStore backed by a SQLite database.
+type SQLiteTokenStore struct {
+	db *sql.DB
+}
+
+var tokenMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create oauth_tokens",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS oauth_tokens (
+				user_id       INTEGER PRIMARY KEY,
+				access_token  TEXT    NOT NULL,
+				refresh_token TEXT    NOT NULL,
+				scope         TEXT    NOT NULL,
+				expires_at    INTEGER NOT NULL,
+				updated_at    INTEGER NOT NULL
+			)`,
+		},
+	},
+}
+
+func NewSQLiteTokenStore(db *sql.DB) (*SQLiteTokenStore, error) {
+	if err := database.Migrate(db, database.SQLite, "oauth_tokens", tokenMigrations); err != nil {
+		return nil, err
+	}
+	return &SQLiteTokenStore{db: db}, nil
+}
+
+func (s *SQLiteTokenStore) Save(ctx context.Context, token Token) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO oauth_tokens
+		(user_id, access_token, refresh_token, scope, expires_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			access_token = excluded.access_token,
+			refresh_token = excluded.refresh_token,
+			scope = excluded.scope,
+			expires_at = excluded.expires_at,
+			updated_at = excluded.updated_at`,
+		token.UserID, token.AccessToken, token.RefreshToken, token.Scope,
+		token.ExpiresAt.Unix(), token.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("error saving token for user %d: %w", token.UserID, err)
+	}
+	return nil
+}
+
+func (s *SQLiteTokenStore) Get(ctx context.Context, userID int) (Token, error) {
+	var token Token
+	var expiresAt, updatedAt int64
+
+	err := s.db.QueryRowContext(ctx, `SELECT user_id, access_token, refresh_token, scope, expires_at, updated_at
+		FROM oauth_tokens WHERE user_id = ?`, userID).
+		Scan(&token.UserID, &token.AccessToken, &token.RefreshToken, &token.Scope, &expiresAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Token{}, ErrTokenNotFound
+	}
+	if err != nil {
+		return Token{}, fmt.Errorf("error loading token for user %d: %w", userID, err)
+	}
+
+	token.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+	token.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return token, nil
+}
+
+func (s *SQLiteTokenStore) Rotate(ctx context.Context, previousRefreshToken string, token Token) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting token rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE oauth_tokens SET
+			access_token = ?, refresh_token = ?, scope = ?, expires_at = ?, updated_at = ?
+		WHERE user_id = ? AND refresh_token = ?`,
+		token.AccessToken, token.RefreshToken, token.Scope, token.ExpiresAt.Unix(), token.UpdatedAt.Unix(),
+		token.UserID, previousRefreshToken)
+	if err != nil {
+		return fmt.Errorf("error rotating token for user %d: %w", token.UserID, err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error rotating token for user %d: %w", token.UserID, err)
+	}
+	if updated == 0 {
+		var exists int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM oauth_tokens WHERE user_id = ?`, token.UserID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error rotating token for user %d: %w", token.UserID, err)
+		}
+		if exists == 0 {
+			return ErrTokenNotFound
+		}
+		return ErrTokenConflict
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteTokenStore) Delete(ctx context.Context, userID int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM oauth_tokens WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting token for user %d: %w", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stateCookieName    = "wahoo_oauth_state"
+	verifierCookieName = "wahoo_oauth_verifier"
+
+	// DefaultStateTTL bounds how long a user has to complete the Wahoo consent screen.
+	DefaultStateTTL = 10 * time.Minute
+)
+
+var (
+	ErrMissingState  = errors.New("missing oauth state")
+	ErrInvalidState  = errors.New("invalid oauth state")
+	ErrExpiredState  = errors.New("expired oauth state")
+	ErrStateMismatch = errors.New("oauth state does not match cookie")
+)
+
+// StateSigner issues and verifies the signed, expiring `state` values used to
+// protect the authorization callback against CSRF.
+type StateSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewStateSigner(secret []byte) *StateSigner {
+	return &StateSigner{secret: secret, ttl: DefaultStateTTL, now: time.Now}
+}
+
+// NewStateSignerFromEnv signs states with OAUTH_STATE_SECRET. Without it a random
+// per-process key is used, which breaks flows spanning restarts or replicas.
+func NewStateSignerFromEnv() (*StateSigner, error) {
+	secret := os.Getenv("OAUTH_STATE_SECRET")
+	if secret != "" {
+		return NewStateSigner([]byte(secret)), nil
+	}
+
+	log.Println("No OAUTH_STATE_SECRET configured; generating an ephemeral key for OAuth state signing.")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("error generating state key: %w", err)
+	}
+	return NewStateSigner(key), nil
+}
+
+// Issue returns a new state of the form nonce.expiry.signature.
+func (s *StateSigner) Issue() (string, error) {
+	nonce, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	payload := nonce + "." + strconv.FormatInt(s.now().Add(s.ttl).Unix(), 10)
+	return payload + "." + s.sign(payload), nil
+}
+
+// Verify checks the signature and expiry of a state previously returned by Issue.
+func (s *StateSigner) Verify(state string) error {
+	if state == "" {
+		return ErrMissingState
+	}
+
+	lastDot := strings.LastIndex(state, ".")
+	if lastDot < 0 {
+		return ErrInvalidState
+	}
+	payload, signature := state[:lastDot], state[lastDot+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return ErrInvalidState
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return ErrInvalidState
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrInvalidState
+	}
+	if s.now().After(time.Unix(expiry, 0)) {
+		return ErrExpiredState
+	}
+	return nil
+}
+
+func (s *StateSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCallbackState checks the state echoed back by Wahoo against both its
+// signature and the cookie set when the flow started.
+func (s *StateSigner) verifyCallbackState(r *http.Request) error {
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		return ErrMissingState
+	}
+
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil || cookie.Value == "" {
+		return ErrMissingState
+	}
+	if subtle.ConstantTimeCompare([]byte(state), []byte(cookie.Value)) != 1 {
+		return ErrStateMismatch
+	}
+
+	return s.Verify(state)
+}
+
+// stateErrorStatus maps a state verification failure onto a 4xx status code.
+func stateErrorStatus(err error) int {
+	if errors.Is(err, ErrMissingState) || errors.Is(err, ErrExpiredState) {
+		return http.StatusBadRequest
+	}
+	return http.StatusForbidden
+}
+
+// newCodeVerifier returns a PKCE code_verifier (RFC 7636, 43 characters).
+func newCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// codeChallengeS256 derives the S256 code_challenge for a code_verifier.
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func setFlowCookie(w http.ResponseWriter, r *http.Request, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearFlowCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
@@ -0,0 +1,108 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateSigner_IssueAndVerify(t *testing.T) {
+
+	states := NewStateSigner([]byte("secret"))
+
+	state, err := states.Issue()
+	require.NoError(t, err)
+	require.NoError(t, states.Verify(state))
+
+	require.ErrorIs(t, NewStateSigner([]byte("other")).Verify(state), ErrInvalidState)
+	require.ErrorIs(t, states.Verify(state+"x"), ErrInvalidState)
+	require.ErrorIs(t, states.Verify(""), ErrMissingState)
+
+	states.now = func() time.Time { return time.Now().Add(DefaultStateTTL + time.Minute) }
+	require.ErrorIs(t, states.Verify(state), ErrExpiredState)
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+
+	// Test vector from RFC 7636 appendix B.
+	require.Equal(t,
+		"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		codeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestAuthCallback_RejectsInvalidState(t *testing.T) {
+
+	states := NewStateSigner([]byte("secret"))
+	validState, _ := states.Issue()
+	otherState, _ := states.Issue()
+	forgedState, _ := NewStateSigner([]byte("forged")).Issue()
+
+	testCases := []struct {
+		name           string
+		queryState     string
+		cookieState    string
+		expectedStatus int
+	}{
+		{name: "Missing state", queryState: "", cookieState: validState, expectedStatus: http.StatusBadRequest},
+		{name: "Missing cookie", queryState: validState, cookieState: "", expectedStatus: http.StatusBadRequest},
+		{name: "Cookie mismatch", queryState: validState, cookieState: otherState, expectedStatus: http.StatusForbidden},
+		{name: "Forged signature", queryState: forgedState, cookieState: forgedState, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "/?code=abc&state="+url.QueryEscape(tc.queryState), nil)
+			if tc.cookieState != "" {
+				request.AddCookie(&http.Cookie{Name: stateCookieName, Value: tc.cookieState})
+			}
+
+			response := httptest.NewRecorder()
+			handler := http.HandlerFunc(AuthCallback(NewMemoryTokenStore(), states))
+			handler.ServeHTTP(response, request)
+
+			require.Equal(t, tc.expectedStatus, response.Code)
+		})
+	}
+}
+
+func TestAuthorize_WithPKCE(t *testing.T) {
+
+	t.Setenv("WAHOO_CLIENT_ID", "client123")
+	t.Setenv("REDIRECT_URI", "https://example.com/callback")
+	t.Setenv("WAHOO_AUTH_BASE_URL", "https://api.wahooligan.com/oauth/authorize")
+	t.Setenv("WAHOO_PKCE_ENABLED", "true")
+
+	request, _ := http.NewRequest("GET", "/authorize", nil)
+
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(Authorize(NewStateSigner([]byte("secret"))))
+	handler.ServeHTTP(response, request)
+
+	require.Equal(t, http.StatusFound, response.Code)
+
+	verifierCookie := findCookie(response.Result().Cookies(), verifierCookieName)
+	require.NotNil(t, verifierCookie)
+	require.True(t, verifierCookie.HttpOnly)
+
+	location, err := url.Parse(response.Result().Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, codeChallengeS256(verifierCookie.Value), location.Query().Get("code_challenge"))
+	require.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+}
+
+func TestAuthCallback_WithPKCE_MissingVerifier(t *testing.T) {
+
+	t.Setenv("WAHOO_PKCE_ENABLED", "true")
+
+	states := NewStateSigner([]byte("secret"))
+
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(AuthCallback(NewMemoryTokenStore(), states))
+	handler.ServeHTTP(response, newCallbackRequest(states, "abc"))
+
+	require.Equal(t, http.StatusBadRequest, response.Code)
+}
@@ -0,0 +1,17 @@
+package oauth
+
+import "html/template"
+
+var successPage = template.Must(template.New("success").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>Wahoo connected</title>
+</head>
+<body>
+	<h1>Wahoo account connected</h1>
+	<p>{{if .First}}Thanks {{.First}}, your{{else}}Your{{end}} Wahoo account is now linked. New workouts will be synced automatically.</p>
+	<p>You can close this window.</p>
+</body>
+</html>
+`))
@@ -0,0 +1,136 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/keylock"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/utils"
+)
+
+// DefaultRefreshWindow is how long before expiry an access token is treated
+// as stale and proactively refreshed.
+const DefaultRefreshWindow = 5 * time.Minute
+
+// TokenManager hands out valid access tokens for stored grants, rotating them
+// against the Wahoo token endpoint when they have expired or are about to.
+type TokenManager struct {
+	store             TokenStore
+	wahooClientId     string
+	wahooClientSecret string
+	httpClient        *http.Client
+	refreshWindow     time.Duration
+	now               func() time.Time
+
+	// userLocks serialises the refreshes of each grant.
+	userLocks keylock.Striped
+}
+
+func NewTokenManager(store TokenStore) *TokenManager {
+	return &TokenManager{
+		store:             store,
+		wahooClientId:     os.Getenv("WAHOO_CLIENT_ID"),
+		wahooClientSecret: os.Getenv("WAHOO_CLIENT_SECRET"),
+		httpClient:        &http.Client{Timeout: 30 * time.Second},
+		refreshWindow:     DefaultRefreshWindow,
+		now:               time.Now,
+	}
+}
+
+// AccessToken returns a usable access token for userID, refreshing the grant
+// first if required.
+func (m *TokenManager) AccessToken(ctx context.Context, userID int) (string, error) {
+	token, err := m.Token(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// Token returns the stored grant for userID, refreshing it first if the access
+// token has expired or expires within the refresh window.
+func (m *TokenManager) Token(ctx context.Context, userID int) (Token, error) {
+	token, err := m.store.Get(ctx, userID)
+	if err != nil {
+		return Token{}, err
+	}
+	if !m.needsRefresh(token) {
+		return token, nil
+	}
+
+	unlock := m.userLocks.Lock(userID)
+	defer unlock()
+
+	// Another goroutine may have refreshed the grant while we were waiting.
+	token, err = m.store.Get(ctx, userID)
+	if err != nil {
+		return Token{}, err
+	}
+	if !m.needsRefresh(token) {
+		return token, nil
+	}
+
+	return m.refresh(ctx, token)
+}
+
+func (m *TokenManager) needsRefresh(token Token) bool {
+	return !m.now().Add(m.refreshWindow).Before(token.ExpiresAt)
+}
+
+func (m *TokenManager) refresh(ctx context.Context, token Token) (Token, error) {
+	refreshUrl, err := utils.GetWahooRefreshTokenURL(m.wahooClientId, m.wahooClientSecret, token.RefreshToken)
+	if err != nil {
+		return Token{}, fmt.Errorf("error building refresh URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, refreshUrl.String(), nil)
+	if err != nil {
+		return Token{}, fmt.Errorf("error creating refresh request: %w", err)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("error sending refresh request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Token{}, fmt.Errorf("error reading refresh response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Token{}, fmt.Errorf("token endpoint returned status %d for user %d", resp.StatusCode, token.UserID)
+	}
+
+	var tokenResponse WahooTokenResponse
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return Token{}, fmt.Errorf("error unmarshalling refresh response: %w", err)
+	}
+
+	tokenValidator := validator.New(validator.WithRequiredStructEnabled())
+	if err := tokenValidator.Struct(tokenResponse); err != nil {
+		return Token{}, fmt.Errorf("refresh response failed validation: %w", err)
+	}
+
+	rotated := NewToken(token.UserID, tokenResponse)
+	err = m.store.Rotate(ctx, token.RefreshToken, rotated)
+	if errors.Is(err, ErrTokenConflict) {
+		// Another process rotated the grant first; theirs is the one to use.
+		log.Printf("Refresh token for user %d was rotated concurrently, reloading", token.UserID)
+		return m.store.Get(ctx, token.UserID)
+	}
+	if err != nil {
+		return Token{}, err
+	}
+
+	log.Printf("Refreshed Wahoo access token for user %d", token.UserID)
+	return rotated, nil
+}
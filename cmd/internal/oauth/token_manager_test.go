@@ -0,0 +1,119 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startTokenEndpoint(t *testing.T, calls *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		require.Equal(t, "refresh_token", r.URL.Query().Get("grant_type"))
+		require.Equal(t, "old_refresh", r.URL.Query().Get("refresh_token"))
+
+		// Make concurrent callers pile up behind the in-flight refresh.
+		time.Sleep(20 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "new_access",
+			"token_type":    "Bearer",
+			"expires_in":    7200,
+			"refresh_token": "new_refresh",
+			"scope":         "user_read workouts_read offline_data",
+			"created_at":    time.Now().Unix(),
+		})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("WAHOO_TOKEN_BASE_URL", server.URL+"/oauth/token")
+	return server
+}
+
+func TestTokenManager_ReturnsValidTokenWithoutRefreshing(t *testing.T) {
+
+	var calls int32
+	startTokenEndpoint(t, &calls)
+
+	store := NewMemoryTokenStore()
+	_ = store.Save(context.Background(), Token{
+		UserID:       1,
+		AccessToken:  "old_access",
+		RefreshToken: "old_refresh",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	})
+
+	accessToken, err := NewTokenManager(store).AccessToken(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, "old_access", accessToken)
+	require.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestTokenManager_RefreshesSoonToExpireToken(t *testing.T) {
+
+	var calls int32
+	startTokenEndpoint(t, &calls)
+
+	store := NewMemoryTokenStore()
+	_ = store.Save(context.Background(), Token{
+		UserID:       1,
+		AccessToken:  "old_access",
+		RefreshToken: "old_refresh",
+		ExpiresAt:    time.Now().Add(time.Minute),
+	})
+
+	accessToken, err := NewTokenManager(store).AccessToken(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, "new_access", accessToken)
+
+	stored, err := store.Get(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, "new_refresh", stored.RefreshToken)
+	require.True(t, stored.ExpiresAt.After(time.Now().Add(time.Hour)))
+}
+
+func TestTokenManager_SerialisesConcurrentRefreshes(t *testing.T) {
+
+	var calls int32
+	startTokenEndpoint(t, &calls)
+
+	store := NewMemoryTokenStore()
+	_ = store.Save(context.Background(), Token{
+		UserID:       1,
+		AccessToken:  "old_access",
+		RefreshToken: "old_refresh",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+	})
+
+	manager := NewTokenManager(store)
+
+	var wg sync.WaitGroup
+	accessTokens := make([]string, 10)
+	errs := make([]error, 10)
+	for i := range accessTokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			accessTokens[i], errs[i] = manager.AccessToken(context.Background(), 1)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range accessTokens {
+		require.NoError(t, errs[i])
+		require.Equal(t, "new_access", accessTokens[i])
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTokenManager_UnknownUser(t *testing.T) {
+
+	_, err := NewTokenManager(NewMemoryTokenStore()).AccessToken(context.Background(), 99)
+	require.ErrorIs(t, err, ErrTokenNotFound)
+}
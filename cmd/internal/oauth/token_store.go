@@ -0,0 +1,102 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTokenNotFound is returned when no grant has been stored for a user.
+var ErrTokenNotFound = errors.New("token not found")
+
+// ErrTokenConflict is returned by Rotate when the stored refresh token no
+// longer matches the one that was exchanged, i.e. another refresh won.
+var ErrTokenConflict = errors.New("token was rotated concurrently")
+
+// Token is a persisted Wahoo OAuth grant for a single athlete.
+type Token struct {
+	UserID       int
+	AccessToken  string
+	RefreshToken string
+	Scope        string
+	ExpiresAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// TokenStore persists OAuth grants so the service can call Wahoo on an
+// athlete's behalf after the authorization flow has completed.
+type TokenStore interface {
+	Save(ctx context.Context, token Token) error
+	Get(ctx context.Context, userID int) (Token, error)
+	Delete(ctx context.Context, userID int) error
+	// Rotate atomically replaces the grant for token.UserID, but only if the
+	// stored refresh token still equals previousRefreshToken.
+	Rotate(ctx context.Context, previousRefreshToken string, token Token) error
+}
+
+// NewToken builds a Token for userID from a token endpoint response.
+func NewToken(userID int, tokenResponse WahooTokenResponse) Token {
+	createdAt := time.Unix(int64(tokenResponse.CreatedAt), 0).UTC()
+	return Token{
+		UserID:       userID,
+		AccessToken:  tokenResponse.AccessToken,
+		RefreshToken: tokenResponse.RefreshToken,
+		Scope:        tokenResponse.Scope,
+		ExpiresAt:    createdAt.Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+		UpdatedAt:    time.Now().UTC(),
+	}
+}
+
+// MemoryTokenStore is a TokenStore that keeps grants in process memory.
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[int]Token
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[int]Token)}
+}
+
+func (s *MemoryTokenStore) Save(_ context.Context, token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.UserID] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) Get(_ context.Context, userID int) (Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[userID]
+	if !ok {
+		return Token{}, ErrTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *MemoryTokenStore) Rotate(_ context.Context, previousRefreshToken string, token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.tokens[token.UserID]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	if current.RefreshToken != previousRefreshToken {
+		return ErrTokenConflict
+	}
+
+	s.tokens[token.UserID] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) Delete(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, userID)
+	return nil
+}
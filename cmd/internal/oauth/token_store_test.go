@@ -0,0 +1,89 @@
+package oauth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewToken_ComputesExpiry(t *testing.T) {
+
+	token := NewToken(42, WahooTokenResponse{
+		AccessToken:  "access",
+		TokenType:    "Bearer",
+		ExpiresIn:    7200,
+		RefreshToken: "refresh",
+		Scope:        "user_read",
+		CreatedAt:    1712943371,
+	})
+
+	require.Equal(t, 42, token.UserID)
+	require.Equal(t, time.Unix(1712943371+7200, 0).UTC(), token.ExpiresAt)
+}
+
+func TestTokenStores(t *testing.T) {
+
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "tokens.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqliteStore, err := NewSQLiteTokenStore(db)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		store TokenStore
+	}{
+		{name: "Memory", store: NewMemoryTokenStore()},
+		{name: "SQLite", store: sqliteStore},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := tc.store.Get(ctx, 1)
+			require.ErrorIs(t, err, ErrTokenNotFound)
+
+			token := Token{
+				UserID:       1,
+				AccessToken:  "access",
+				RefreshToken: "refresh",
+				Scope:        "user_read workouts_read",
+				ExpiresAt:    time.Unix(1712950571, 0).UTC(),
+				UpdatedAt:    time.Unix(1712943371, 0).UTC(),
+			}
+			require.NoError(t, tc.store.Save(ctx, token))
+
+			stored, err := tc.store.Get(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, token, stored)
+
+			token.AccessToken = "rotated"
+			require.NoError(t, tc.store.Save(ctx, token))
+
+			stored, err = tc.store.Get(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, "rotated", stored.AccessToken)
+
+			rotated := token
+			rotated.AccessToken = "new_access"
+			rotated.RefreshToken = "new_refresh"
+			require.ErrorIs(t, tc.store.Rotate(ctx, "stale_refresh", rotated), ErrTokenConflict)
+			require.NoError(t, tc.store.Rotate(ctx, "refresh", rotated))
+
+			stored, err = tc.store.Get(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, "new_refresh", stored.RefreshToken)
+
+			require.NoError(t, tc.store.Delete(ctx, 1))
+			require.ErrorIs(t, tc.store.Rotate(ctx, "new_refresh", rotated), ErrTokenNotFound)
+			_, err = tc.store.Get(ctx, 1)
+			require.ErrorIs(t, err, ErrTokenNotFound)
+		})
+	}
+}
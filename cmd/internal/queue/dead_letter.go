@@ -0,0 +1,229 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+)
+
+// ErrDeadLetterNotFound is returned when a dead letter ID does not exist.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a job that failed after exhausting its retries, kept so it
+// can be inspected and re-driven once the underlying problem is fixed.
+type DeadLetter struct {
+	ID       int64           `json:"id"`
+	JobID    int64           `json:"job_id"`
+	Kind     string          `json:"kind"`
+	Payload  json.RawMessage `json:"payload"`
+	Error    string          `json:"error"`
+	Steps    []Step          `json:"steps"`
+	FailedAt time.Time       `json:"failed_at"`
+	// RedrivenJobID is the job created by the most recent re-drive, if any.
+	RedrivenJobID int64      `json:"redriven_job_id,omitempty"`
+	RedrivenAt    *time.Time `json:"redriven_at,omitempty"`
+}
+
+type DeadLetterStore interface {
+	Add(ctx context.Context, letter DeadLetter) (DeadLetter, error)
+	// List returns up to limit dead letters, newest first.
+	List(ctx context.Context, limit int) ([]DeadLetter, error)
+	Get(ctx context.Context, id int64) (DeadLetter, error)
+	MarkRedriven(ctx context.Context, id int64, jobID int64) error
+}
+
+// MemoryDeadLetterStore is a DeadLetterStore held in process memory.
+type MemoryDeadLetterStore struct {
+	mu      sync.Mutex
+	nextID  int64
+	letters map[int64]DeadLetter
+}
+
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{letters: make(map[int64]DeadLetter)}
+}
+
+func (s *MemoryDeadLetterStore) Add(_ context.Context, letter DeadLetter) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	letter.ID = s.nextID
+	letter.Steps = append([]Step(nil), letter.Steps...)
+	s.letters[letter.ID] = letter
+	return letter, nil
+}
+
+func (s *MemoryDeadLetterStore) List(_ context.Context, limit int) ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].ID > letters[j].ID })
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return letters, nil
+}
+
+func (s *MemoryDeadLetterStore) Get(_ context.Context, id int64) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letter, ok := s.letters[id]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return letter, nil
+}
+
+func (s *MemoryDeadLetterStore) MarkRedriven(_ context.Context, id int64, jobID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letter, ok := s.letters[id]
+	if !ok {
+		return ErrDeadLetterNotFound
+	}
+	now := time.Now().UTC()
+	letter.RedrivenJobID = jobID
+	letter.RedrivenAt = &now
+	s.letters[id] = letter
+	return nil
+}
+
+// SQLiteDeadLetterStore is a DeadLetterStore persisted in SQLite.
+type SQLiteDeadLetterStore struct {
+	db *sql.DB
+}
+
+var deadLetterMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create dead_letters",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS dead_letters (
+				id              INTEGER PRIMARY KEY AUTOINCREMENT,
+				job_id          INTEGER NOT NULL,
+				kind            TEXT    NOT NULL,
+				payload         BLOB    NOT NULL,
+				error           TEXT    NOT NULL,
+				steps           TEXT    NOT NULL DEFAULT '[]',
+				failed_at       INTEGER NOT NULL,
+				redriven_job_id INTEGER NOT NULL DEFAULT 0,
+				redriven_at     INTEGER
+			)`,
+		},
+	},
+}
+
+func NewSQLiteDeadLetterStore(db *sql.DB) (*SQLiteDeadLetterStore, error) {
+	if err := database.Migrate(db, database.SQLite, "dead_letters", deadLetterMigrations); err != nil {
+		return nil, err
+	}
+	return &SQLiteDeadLetterStore{db: db}, nil
+}
+
+const deadLetterColumns = `id, job_id, kind, payload, error, steps, failed_at, redriven_job_id, redriven_at`
+
+func (s *SQLiteDeadLetterStore) Add(ctx context.Context, letter DeadLetter) (DeadLetter, error) {
+	steps, err := json.Marshal(letter.Steps)
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("error encoding steps: %w", err)
+	}
+
+	row := s.db.QueryRowContext(ctx, `INSERT INTO dead_letters (job_id, kind, payload, error, steps, failed_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING `+deadLetterColumns,
+		letter.JobID, letter.Kind, []byte(letter.Payload), letter.Error, string(steps), letter.FailedAt.Unix())
+
+	added, err := scanDeadLetter(row)
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("error saving dead letter for job %d: %w", letter.JobID, err)
+	}
+	return added, nil
+}
+
+func (s *SQLiteDeadLetterStore) List(ctx context.Context, limit int) ([]DeadLetter, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading dead letter: %w", err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+func (s *SQLiteDeadLetterStore) Get(ctx context.Context, id int64) (DeadLetter, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = ?`, id)
+
+	letter, err := scanDeadLetter(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("error loading dead letter %d: %w", id, err)
+	}
+	return letter, nil
+}
+
+func (s *SQLiteDeadLetterStore) MarkRedriven(ctx context.Context, id int64, jobID int64) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE dead_letters SET redriven_job_id = ?, redriven_at = ? WHERE id = ?`,
+		jobID, time.Now().UTC().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("error updating dead letter %d: %w", id, err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDeadLetter(row scanner) (DeadLetter, error) {
+	var letter DeadLetter
+	var payload []byte
+	var steps string
+	var failedAt int64
+	var redrivenAt sql.NullInt64
+
+	err := row.Scan(&letter.ID, &letter.JobID, &letter.Kind, &payload, &letter.Error, &steps,
+		&failedAt, &letter.RedrivenJobID, &redrivenAt)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	if err := json.Unmarshal([]byte(steps), &letter.Steps); err != nil {
+		return DeadLetter{}, fmt.Errorf("error decoding steps: %w", err)
+	}
+
+	letter.Payload = payload
+	letter.FailedAt = time.Unix(failedAt, 0).UTC()
+	if redrivenAt.Valid {
+		at := time.Unix(redrivenAt.Int64, 0).UTC()
+		letter.RedrivenAt = &at
+	}
+	return letter, nil
+}
@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"goji.io/pat"
+)
+
+// Status reports the state and per-step progress of the :job_id path parameter.
+func Status(q Queue) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID, err := strconv.ParseInt(pat.Param(r, "job_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid job id", http.StatusBadRequest)
+			return
+		}
+
+		job, err := q.Get(r.Context(), jobID)
+		if errors.Is(err, ErrJobNotFound) {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading job %d: %v", jobID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// ListDeadLetters lists failed jobs, newest first. ?limit caps the result,
+// defaulting to 100.
+func ListDeadLetters(deadLetters DeadLetterStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		letters, err := deadLetters.List(r.Context(), limit)
+		if err != nil {
+			log.Printf("Error listing dead letters: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, letters)
+	}
+}
+
+// GetDeadLetter returns the :dead_letter_id dead letter, including its payload
+// and the steps the failed job recorded.
+func GetDeadLetter(deadLetters DeadLetterStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		letter, ok := loadDeadLetter(w, r, deadLetters)
+		if ok {
+			writeJSON(w, http.StatusOK, letter)
+		}
+	}
+}
+
+// Redrive re-enqueues the :dead_letter_id dead letter's payload as a new job.
+func Redrive(q Queue, deadLetters DeadLetterStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		letter, ok := loadDeadLetter(w, r, deadLetters)
+		if !ok {
+			return
+		}
+
+		job, err := q.Enqueue(r.Context(), letter.Kind, letter.Payload)
+		if err != nil {
+			log.Printf("Error re-driving dead letter %d: %v", letter.ID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := deadLetters.MarkRedriven(r.Context(), letter.ID, job.ID); err != nil {
+			log.Printf("Error marking dead letter %d as re-driven: %v", letter.ID, err)
+		}
+
+		log.Printf("Dead letter %d re-driven as job %d", letter.ID, job.ID)
+		w.Header().Set("X-Job-Id", strconv.FormatInt(job.ID, 10))
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+func loadDeadLetter(w http.ResponseWriter, r *http.Request, deadLetters DeadLetterStore) (DeadLetter, bool) {
+	id, err := strconv.ParseInt(pat.Param(r, "dead_letter_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid dead letter id", http.StatusBadRequest)
+		return DeadLetter{}, false
+	}
+
+	letter, err := deadLetters.Get(r.Context(), id)
+	if errors.Is(err, ErrDeadLetterNotFound) {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return DeadLetter{}, false
+	}
+	if err != nil {
+		log.Printf("Error loading dead letter %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return DeadLetter{}, false
+	}
+	return letter, true
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
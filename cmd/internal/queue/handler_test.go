@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+func TestRedrive(t *testing.T) {
+
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	deadLetters := NewMemoryDeadLetterStore()
+
+	letter, err := deadLetters.Add(ctx, DeadLetter{
+		JobID:    1,
+		Kind:     "test",
+		Payload:  json.RawMessage(`{"n":1}`),
+		Error:    "boom",
+		FailedAt: time.Now().UTC(),
+	})
+	require.NoError(t, err)
+
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/dead-letters"), ListDeadLetters(deadLetters))
+	router.HandleFunc(pat.Post("/dead-letters/:dead_letter_id/redrive"), Redrive(q, deadLetters))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/dead-letters/1/redrive", nil))
+	require.Equal(t, http.StatusAccepted, response.Code)
+	require.Equal(t, "1", response.Header().Get("X-Job-Id"))
+
+	job, ok, err := q.Claim(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "test", job.Kind)
+	require.JSONEq(t, `{"n":1}`, string(job.Payload))
+
+	letter, err = deadLetters.Get(ctx, letter.ID)
+	require.NoError(t, err)
+	require.Equal(t, job.ID, letter.RedrivenJobID)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/dead-letters/99/redrive", nil))
+	require.Equal(t, http.StatusNotFound, response.Code)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/dead-letters?limit=nope", nil))
+	require.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/dead-letters", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	var letters []DeadLetter
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &letters))
+	require.Len(t, letters, 1)
+}
@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is a Queue held in process memory. Jobs do not survive a restart.
+type MemoryQueue struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[int64]*Job
+	order  []int64
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{jobs: make(map[int64]*Job)}
+}
+
+func (q *MemoryQueue) Enqueue(_ context.Context, kind string, payload json.RawMessage) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextID++
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        q.nextID,
+		Kind:      kind,
+		Payload:   payload,
+		Status:    JobPending,
+		NextRunAt: now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	q.jobs[job.ID] = job
+	q.order = append(q.order, job.ID)
+	return copyJob(job), nil
+}
+
+func (q *MemoryQueue) Claim(_ context.Context) (Job, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, id := range q.order {
+		job := q.jobs[id]
+		if job.Status != JobPending || job.NextRunAt.After(now) {
+			continue
+		}
+		job.Status = JobRunning
+		job.Attempts++
+		job.UpdatedAt = now
+		return copyJob(job), true, nil
+	}
+	return Job{}, false, nil
+}
+
+func (q *MemoryQueue) SetStep(_ context.Context, jobID int64, step Step) error {
+	return q.update(jobID, func(job *Job) {
+		job.Steps = setStep(job.Steps, step)
+	})
+}
+
+func (q *MemoryQueue) Complete(_ context.Context, jobID int64) error {
+	return q.update(jobID, func(job *Job) {
+		job.Status = JobSucceeded
+		job.LastError = ""
+	})
+}
+
+func (q *MemoryQueue) Retry(_ context.Context, jobID int64, jobErr error, runAt time.Time) error {
+	return q.update(jobID, func(job *Job) {
+		job.Status = JobPending
+		job.LastError = jobErr.Error()
+		job.NextRunAt = runAt.UTC()
+	})
+}
+
+func (q *MemoryQueue) Fail(_ context.Context, jobID int64, jobErr error) error {
+	return q.update(jobID, func(job *Job) {
+		job.Status = JobFailed
+		job.LastError = jobErr.Error()
+	})
+}
+
+func (q *MemoryQueue) Get(_ context.Context, jobID int64) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[jobID]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return copyJob(job), nil
+}
+
+func (q *MemoryQueue) Recover(_ context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	recovered := 0
+	for _, job := range q.jobs {
+		if job.Status == JobRunning {
+			job.Status = JobPending
+			recovered++
+		}
+	}
+	return recovered, nil
+}
+
+func (q *MemoryQueue) update(jobID int64, fn func(job *Job)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[jobID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	fn(job)
+	job.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+func copyJob(job *Job) Job {
+	c := *job
+	c.Steps = append([]Step(nil), job.Steps...)
+	return c
+}
@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrJobNotFound is returned when a job ID does not exist.
+var ErrJobNotFound = errors.New("job not found")
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+type StepStatus string
+
+const (
+	StepRunning   StepStatus = "running"
+	StepSucceeded StepStatus = "succeeded"
+	StepFailed    StepStatus = "failed"
+	StepSkipped   StepStatus = "skipped"
+)
+
+// Step is the progress of one named stage of a job, e.g. "download".
+type Step struct {
+	Name   string     `json:"name"`
+	Status StepStatus `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	// Permanent is set when the step failed in a way retrying cannot fix,
+	// such as a corrupt download, so redriving the job is pointless.
+	Permanent bool      `json:"permanent,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Job is a unit of queued work. Payload is the JSON document the job was
+// enqueued with.
+type Job struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    JobStatus       `json:"status"`
+	Attempts  int             `json:"attempts"`
+	Steps     []Step          `json:"steps"`
+	LastError string          `json:"last_error,omitempty"`
+	// NextRunAt is when a pending job can next be claimed, later than it was
+	// enqueued while a failed attempt backs off.
+	NextRunAt time.Time `json:"next_run_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Queue is a durable FIFO of jobs. Claim hands each pending job to exactly
+// one worker; jobs left running by a crash are returned to pending by Recover.
+type Queue interface {
+	Enqueue(ctx context.Context, kind string, payload json.RawMessage) (Job, error)
+	// Claim marks the oldest pending job that is due to run as running and
+	// returns it. ok is false when no job is available.
+	Claim(ctx context.Context) (job Job, ok bool, err error)
+	SetStep(ctx context.Context, jobID int64, step Step) error
+	Complete(ctx context.Context, jobID int64) error
+	// Retry returns a job whose attempt failed to pending, to be claimed
+	// again from runAt.
+	Retry(ctx context.Context, jobID int64, jobErr error, runAt time.Time) error
+	Fail(ctx context.Context, jobID int64, jobErr error) error
+	Get(ctx context.Context, jobID int64) (Job, error)
+	// Recover returns jobs stuck in running, e.g. after a crash, to pending.
+	Recover(ctx context.Context) (int, error)
+}
+
+// setStep replaces the step with the same name, or appends it.
+func setStep(steps []Step, step Step) []Step {
+	for i := range steps {
+		if steps[i].Name == step.Name {
+			steps[i] = step
+			return steps
+		}
+	}
+	return append(steps, step)
+}
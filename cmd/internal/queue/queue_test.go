@@ -0,0 +1,253 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/retry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueues(t *testing.T) {
+
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "queue.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqliteQueue, err := NewSQLiteQueue(db)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		queue Queue
+	}{
+		{name: "Memory", queue: NewMemoryQueue()},
+		{name: "SQLite", queue: sqliteQueue},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, ok, err := tc.queue.Claim(ctx)
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			first, err := tc.queue.Enqueue(ctx, "test", json.RawMessage(`{"n":1}`))
+			require.NoError(t, err)
+			require.Equal(t, JobPending, first.Status)
+			second, err := tc.queue.Enqueue(ctx, "test", json.RawMessage(`{"n":2}`))
+			require.NoError(t, err)
+
+			claimed, ok, err := tc.queue.Claim(ctx)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, first.ID, claimed.ID)
+			require.Equal(t, JobRunning, claimed.Status)
+			require.Equal(t, 1, claimed.Attempts)
+			require.JSONEq(t, `{"n":1}`, string(claimed.Payload))
+
+			require.NoError(t, tc.queue.SetStep(ctx, first.ID, Step{Name: "download", Status: StepRunning}))
+			require.NoError(t, tc.queue.SetStep(ctx, first.ID, Step{Name: "download", Status: StepSucceeded}))
+			require.NoError(t, tc.queue.SetStep(ctx, first.ID, Step{Name: "forward", Status: StepFailed, Error: "boom"}))
+			require.NoError(t, tc.queue.Fail(ctx, first.ID, errors.New("boom")))
+
+			job, err := tc.queue.Get(ctx, first.ID)
+			require.NoError(t, err)
+			require.Equal(t, JobFailed, job.Status)
+			require.Equal(t, "boom", job.LastError)
+			require.Len(t, job.Steps, 2)
+			require.Equal(t, StepSucceeded, job.Steps[0].Status)
+			require.Equal(t, "forward", job.Steps[1].Name)
+
+			// A job left running by a crash is handed out again after Recover.
+			claimed, ok, err = tc.queue.Claim(ctx)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, second.ID, claimed.ID)
+
+			recovered, err := tc.queue.Recover(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, recovered)
+
+			claimed, ok, err = tc.queue.Claim(ctx)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, second.ID, claimed.ID)
+			require.Equal(t, 2, claimed.Attempts)
+			require.NoError(t, tc.queue.Complete(ctx, second.ID))
+
+			job, err = tc.queue.Get(ctx, second.ID)
+			require.NoError(t, err)
+			require.Equal(t, JobSucceeded, job.Status)
+
+			// A retried job waits until it is due to run again.
+			third, err := tc.queue.Enqueue(ctx, "test", json.RawMessage(`{"n":3}`))
+			require.NoError(t, err)
+			_, ok, err = tc.queue.Claim(ctx)
+			require.NoError(t, err)
+			require.True(t, ok)
+			runAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+			require.NoError(t, tc.queue.Retry(ctx, third.ID, errors.New("try later"), runAt))
+
+			job, err = tc.queue.Get(ctx, third.ID)
+			require.NoError(t, err)
+			require.Equal(t, JobPending, job.Status)
+			require.Equal(t, "try later", job.LastError)
+			require.Equal(t, runAt, job.NextRunAt)
+			_, ok, err = tc.queue.Claim(ctx)
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			require.NoError(t, tc.queue.Retry(ctx, third.ID, errors.New("try now"), time.Now().Add(-time.Second)))
+			claimed, ok, err = tc.queue.Claim(ctx)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, third.ID, claimed.ID)
+			require.Equal(t, 2, claimed.Attempts)
+
+			_, err = tc.queue.Get(ctx, 999)
+			require.ErrorIs(t, err, ErrJobNotFound)
+			require.ErrorIs(t, tc.queue.Complete(ctx, 999), ErrJobNotFound)
+			require.ErrorIs(t, tc.queue.Retry(ctx, 999, errors.New("boom"), time.Now()), ErrJobNotFound)
+		})
+	}
+}
+
+func TestWorkers_RunsJobsByKind(t *testing.T) {
+
+	ctx := context.Background()
+	q := NewMemoryQueue()
+
+	ok, err := q.Enqueue(ctx, "ok", json.RawMessage(`{}`))
+	require.NoError(t, err)
+	failing, err := q.Enqueue(ctx, "failing", json.RawMessage(`{}`))
+	require.NoError(t, err)
+	permanent, err := q.Enqueue(ctx, "permanent", json.RawMessage(`{}`))
+	require.NoError(t, err)
+	unknown, err := q.Enqueue(ctx, "unknown", json.RawMessage(`{}`))
+	require.NoError(t, err)
+
+	deadLetters := NewMemoryDeadLetterStore()
+	workers := NewWorkers(q, deadLetters, 2)
+	workers.pollInterval = 10 * time.Millisecond
+	workers.SetRetryPolicy(retry.Policy{MaxAttempts: 3})
+	workers.Handle("ok", func(ctx context.Context, job Job, steps StepRecorder) error {
+		steps.Record(ctx, "only", StepSucceeded, nil)
+		return nil
+	})
+	workers.Handle("failing", func(ctx context.Context, job Job, steps StepRecorder) error {
+		return errors.New("handler failed")
+	})
+	workers.Handle("permanent", func(ctx context.Context, job Job, steps StepRecorder) error {
+		return retry.Permanent(errors.New("handler failed for good"))
+	})
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	require.NoError(t, workers.Start(workerCtx))
+
+	require.Eventually(t, func() bool {
+		for _, id := range []int64{ok.ID, failing.ID, permanent.ID, unknown.ID} {
+			job, _ := q.Get(ctx, id)
+			if job.Status != JobSucceeded && job.Status != JobFailed {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	workers.Wait()
+
+	job, _ := q.Get(ctx, ok.ID)
+	require.Equal(t, JobSucceeded, job.Status)
+	require.Equal(t, "only", job.Steps[0].Name)
+
+	// Failed jobs are retried until they run out of attempts, unless the
+	// failure is permanent.
+	job, _ = q.Get(ctx, failing.ID)
+	require.Equal(t, JobFailed, job.Status)
+	require.Equal(t, "handler failed", job.LastError)
+	require.Equal(t, 3, job.Attempts)
+
+	job, _ = q.Get(ctx, permanent.ID)
+	require.Equal(t, JobFailed, job.Status)
+	require.Equal(t, 1, job.Attempts)
+
+	job, _ = q.Get(ctx, unknown.ID)
+	require.Equal(t, JobFailed, job.Status)
+	require.Contains(t, job.LastError, "no handler registered")
+	require.Equal(t, 1, job.Attempts)
+
+	letters, err := deadLetters.List(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 3)
+}
+
+func TestDeadLetterStores(t *testing.T) {
+
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "queue.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqliteStore, err := NewSQLiteDeadLetterStore(db)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		store DeadLetterStore
+	}{
+		{name: "Memory", store: NewMemoryDeadLetterStore()},
+		{name: "SQLite", store: sqliteStore},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			failedAt := time.Date(2024, 4, 12, 18, 36, 11, 0, time.UTC)
+
+			first, err := tc.store.Add(ctx, DeadLetter{
+				JobID:    7,
+				Kind:     "test",
+				Payload:  json.RawMessage(`{"n":1}`),
+				Error:    "boom",
+				Steps:    []Step{{Name: "download", Status: StepFailed, Error: "boom", UpdatedAt: failedAt}},
+				FailedAt: failedAt,
+			})
+			require.NoError(t, err)
+			second, err := tc.store.Add(ctx, DeadLetter{JobID: 8, Kind: "test", Payload: json.RawMessage(`{}`), FailedAt: failedAt})
+			require.NoError(t, err)
+
+			letters, err := tc.store.List(ctx, 10)
+			require.NoError(t, err)
+			require.Len(t, letters, 2)
+			require.Equal(t, second.ID, letters[0].ID)
+
+			letters, err = tc.store.List(ctx, 1)
+			require.NoError(t, err)
+			require.Len(t, letters, 1)
+
+			letter, err := tc.store.Get(ctx, first.ID)
+			require.NoError(t, err)
+			require.Equal(t, int64(7), letter.JobID)
+			require.Equal(t, failedAt, letter.FailedAt)
+			require.Equal(t, first.Steps, letter.Steps)
+			require.JSONEq(t, `{"n":1}`, string(letter.Payload))
+			require.Nil(t, letter.RedrivenAt)
+
+			require.NoError(t, tc.store.MarkRedriven(ctx, first.ID, 42))
+			letter, err = tc.store.Get(ctx, first.ID)
+			require.NoError(t, err)
+			require.Equal(t, int64(42), letter.RedrivenJobID)
+			require.NotNil(t, letter.RedrivenAt)
+
+			_, err = tc.store.Get(ctx, 999)
+			require.ErrorIs(t, err, ErrDeadLetterNotFound)
+			require.ErrorIs(t, tc.store.MarkRedriven(ctx, 999, 1), ErrDeadLetterNotFound)
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+)
+
+// SQLiteQueue is a Queue persisted in SQLite, so accepted jobs survive restarts.
+type SQLiteQueue struct {
+	db *sql.DB
+}
+
+var jobMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create jobs",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS jobs (
+				id         INTEGER PRIMARY KEY AUTOINCREMENT,
+				kind       TEXT    NOT NULL,
+				payload    BLOB    NOT NULL,
+				status     TEXT    NOT NULL,
+				attempts   INTEGER NOT NULL DEFAULT 0,
+				steps      TEXT    NOT NULL DEFAULT '[]',
+				last_error TEXT    NOT NULL DEFAULT '',
+				created_at INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS jobs_status_id ON jobs (status, id)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "schedule job retries",
+		Columns: []database.Column{
+			{Table: "jobs", Name: "next_run_at", Definition: "INTEGER NOT NULL DEFAULT 0"},
+		},
+	},
+}
+
+func NewSQLiteQueue(db *sql.DB) (*SQLiteQueue, error) {
+	if err := database.Migrate(db, database.SQLite, "jobs", jobMigrations); err != nil {
+		return nil, err
+	}
+	return &SQLiteQueue{db: db}, nil
+}
+
+const jobColumns = `id, kind, payload, status, attempts, steps, last_error, next_run_at, created_at, updated_at`
+
+func (q *SQLiteQueue) Enqueue(ctx context.Context, kind string, payload json.RawMessage) (Job, error) {
+	now := time.Now().UTC().Unix()
+	row := q.db.QueryRowContext(ctx, `INSERT INTO jobs (kind, payload, status, next_run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING `+jobColumns,
+		kind, []byte(payload), JobPending, now, now, now)
+
+	job, err := scanJob(row)
+	if err != nil {
+		return Job{}, fmt.Errorf("error enqueueing %s job: %w", kind, err)
+	}
+	return job, nil
+}
+
+func (q *SQLiteQueue) Claim(ctx context.Context) (Job, bool, error) {
+	now := time.Now().UTC().Unix()
+	row := q.db.QueryRowContext(ctx, `UPDATE jobs SET status = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = (SELECT id FROM jobs WHERE status = ? AND next_run_at <= ? ORDER BY id LIMIT 1)
+		RETURNING `+jobColumns,
+		JobRunning, now, JobPending, now)
+
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, fmt.Errorf("error claiming job: %w", err)
+	}
+	return job, true, nil
+}
+
+func (q *SQLiteQueue) SetStep(ctx context.Context, jobID int64, step Step) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting step update: %w", err)
+	}
+	defer tx.Rollback()
+
+	var stepsJSON string
+	err = tx.QueryRowContext(ctx, `SELECT steps FROM jobs WHERE id = ?`, jobID).Scan(&stepsJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error loading steps for job %d: %w", jobID, err)
+	}
+
+	var steps []Step
+	if err := json.Unmarshal([]byte(stepsJSON), &steps); err != nil {
+		return fmt.Errorf("error decoding steps for job %d: %w", jobID, err)
+	}
+	updated, err := json.Marshal(setStep(steps, step))
+	if err != nil {
+		return fmt.Errorf("error encoding steps for job %d: %w", jobID, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE jobs SET steps = ?, updated_at = ? WHERE id = ?`,
+		string(updated), time.Now().UTC().Unix(), jobID)
+	if err != nil {
+		return fmt.Errorf("error saving steps for job %d: %w", jobID, err)
+	}
+	return tx.Commit()
+}
+
+func (q *SQLiteQueue) Complete(ctx context.Context, jobID int64) error {
+	return q.setStatus(ctx, jobID, JobSucceeded, "")
+}
+
+func (q *SQLiteQueue) Retry(ctx context.Context, jobID int64, jobErr error, runAt time.Time) error {
+	result, err := q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, last_error = ?, next_run_at = ?, updated_at = ? WHERE id = ?`,
+		JobPending, jobErr.Error(), runAt.UTC().Unix(), time.Now().UTC().Unix(), jobID)
+	if err != nil {
+		return fmt.Errorf("error updating job %d: %w", jobID, err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func (q *SQLiteQueue) Fail(ctx context.Context, jobID int64, jobErr error) error {
+	return q.setStatus(ctx, jobID, JobFailed, jobErr.Error())
+}
+
+func (q *SQLiteQueue) Get(ctx context.Context, jobID int64) (Job, error) {
+	row := q.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = ?`, jobID)
+
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, ErrJobNotFound
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("error loading job %d: %w", jobID, err)
+	}
+	return job, nil
+}
+
+func (q *SQLiteQueue) Recover(ctx context.Context) (int, error) {
+	result, err := q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, updated_at = ? WHERE status = ?`,
+		JobPending, time.Now().UTC().Unix(), JobRunning)
+	if err != nil {
+		return 0, fmt.Errorf("error recovering running jobs: %w", err)
+	}
+	recovered, err := result.RowsAffected()
+	return int(recovered), err
+}
+
+func (q *SQLiteQueue) setStatus(ctx context.Context, jobID int64, status JobStatus, lastError string) error {
+	result, err := q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, last_error = ?, updated_at = ? WHERE id = ?`,
+		status, lastError, time.Now().UTC().Unix(), jobID)
+	if err != nil {
+		return fmt.Errorf("error updating job %d: %w", jobID, err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func scanJob(row scanner) (Job, error) {
+	var job Job
+	var payload []byte
+	var stepsJSON string
+	var nextRunAt, createdAt, updatedAt int64
+
+	err := row.Scan(&job.ID, &job.Kind, &payload, &job.Status, &job.Attempts,
+		&stepsJSON, &job.LastError, &nextRunAt, &createdAt, &updatedAt)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := json.Unmarshal([]byte(stepsJSON), &job.Steps); err != nil {
+		return Job{}, fmt.Errorf("error decoding steps: %w", err)
+	}
+	job.Payload = payload
+
+	// Jobs enqueued before next_run_at was added can run at once.
+	if nextRunAt != 0 {
+		job.NextRunAt = time.Unix(nextRunAt, 0).UTC()
+	}
+	job.CreatedAt = time.Unix(createdAt, 0).UTC()
+	job.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return job, nil
+}
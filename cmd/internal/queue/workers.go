@@ -0,0 +1,206 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/retry"
+)
+
+// DefaultPollInterval is how long an idle worker waits before looking for new jobs.
+const DefaultPollInterval = 500 * time.Millisecond
+
+// Defaults for re-running failed jobs. The wait before each new attempt
+// doubles from DefaultJobRetryDelay up to DefaultJobMaxRetryDelay.
+const (
+	DefaultJobAttempts      = 5
+	DefaultJobRetryDelay    = time.Minute
+	DefaultJobMaxRetryDelay = time.Hour
+)
+
+// StepRecorder records the progress of the named steps of a running job.
+type StepRecorder interface {
+	Record(ctx context.Context, name string, status StepStatus, err error)
+}
+
+// Handler runs a claimed job. Returning an error fails the attempt; errors
+// marked retry.Permanent fail the job outright.
+type Handler func(ctx context.Context, job Job, steps StepRecorder) error
+
+// Workers is a pool of goroutines that claim jobs from a Queue and dispatch
+// them to the Handler registered for their kind. Failed attempts are re-queued
+// with backoff; jobs that fail permanently or run out of attempts are copied
+// to the dead-letter store so they can be re-driven.
+type Workers struct {
+	queue        Queue
+	deadLetters  DeadLetterStore
+	concurrency  int
+	pollInterval time.Duration
+	retry        retry.Policy
+	handlers     map[string]Handler
+	wg           sync.WaitGroup
+}
+
+func NewWorkers(q Queue, deadLetters DeadLetterStore, concurrency int) *Workers {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Workers{
+		queue:        q,
+		deadLetters:  deadLetters,
+		concurrency:  concurrency,
+		pollInterval: DefaultPollInterval,
+		retry: retry.Policy{
+			MaxAttempts: DefaultJobAttempts,
+			BaseDelay:   DefaultJobRetryDelay,
+			MaxDelay:    DefaultJobMaxRetryDelay,
+		},
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler for jobs of the given kind. It must be called
+// before Start.
+func (w *Workers) Handle(kind string, handler Handler) {
+	w.handlers[kind] = handler
+}
+
+// SetRetryPolicy sets how many attempts a job gets and how long failed
+// attempts back off, DefaultJobAttempts starting DefaultJobRetryDelay apart by
+// default. It must be called before Start.
+func (w *Workers) SetRetryPolicy(policy retry.Policy) {
+	w.retry = policy
+}
+
+// Start recovers jobs interrupted by a previous shutdown and starts the pool.
+// Cancelling ctx stops workers from claiming new jobs; jobs already running
+// are allowed to finish, use Wait to block until they have.
+func (w *Workers) Start(ctx context.Context) error {
+	recovered, err := w.queue.Recover(ctx)
+	if err != nil {
+		return err
+	}
+	if recovered > 0 {
+		log.Printf("Recovered %d interrupted jobs", recovered)
+	}
+
+	for i := 0; i < w.concurrency; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	return nil
+}
+
+// Wait blocks until every worker has stopped.
+func (w *Workers) Wait() {
+	w.wg.Wait()
+}
+
+func (w *Workers) loop(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, ok, err := w.queue.Claim(ctx)
+		if err != nil {
+			log.Printf("Error claiming job: %v", err)
+		}
+		if err != nil || !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.pollInterval):
+			}
+			continue
+		}
+
+		// Run on a fresh context so a shutdown does not abort a job halfway.
+		w.run(context.Background(), job)
+	}
+}
+
+func (w *Workers) run(ctx context.Context, job Job) {
+	handler, ok := w.handlers[job.Kind]
+	if !ok {
+		w.fail(ctx, job, retry.Permanent(fmt.Errorf("no handler registered for %s jobs", job.Kind)))
+		return
+	}
+
+	if err := handler(ctx, job, jobSteps{queue: w.queue, jobID: job.ID}); err != nil {
+		w.fail(ctx, job, err)
+		return
+	}
+
+	if err := w.queue.Complete(ctx, job.ID); err != nil {
+		log.Printf("Error completing job %d: %v", job.ID, err)
+	}
+}
+
+// fail re-queues the job after a failed attempt, unless the failure is
+// permanent or it was the last attempt, in which case the job is failed and
+// dead-lettered.
+func (w *Workers) fail(ctx context.Context, job Job, jobErr error) {
+	if !retry.IsPermanent(jobErr) && job.Attempts < w.retry.MaxAttempts {
+		delay := w.retry.Backoff(job.Attempts)
+		log.Printf("Job %d (%s) failed attempt %d of %d, retrying in %s: %v",
+			job.ID, job.Kind, job.Attempts, w.retry.MaxAttempts, delay, jobErr)
+		if err := w.queue.Retry(ctx, job.ID, jobErr, time.Now().Add(delay)); err != nil {
+			log.Printf("Error re-queueing job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Job %d (%s) failed: %v", job.ID, job.Kind, jobErr)
+	if err := w.queue.Fail(ctx, job.ID, jobErr); err != nil {
+		log.Printf("Error failing job %d: %v", job.ID, err)
+	}
+
+	// Reload the job to capture the steps its handler recorded.
+	if failed, err := w.queue.Get(ctx, job.ID); err == nil {
+		job = failed
+	}
+	letter, err := w.deadLetters.Add(ctx, DeadLetter{
+		JobID:    job.ID,
+		Kind:     job.Kind,
+		Payload:  job.Payload,
+		Error:    jobErr.Error(),
+		Steps:    job.Steps,
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Error dead-lettering job %d: %v", job.ID, err)
+		return
+	}
+	log.Printf("Job %d dead-lettered as %d", job.ID, letter.ID)
+}
+
+// jobSteps records step progress against a job in the queue.
+type jobSteps struct {
+	queue Queue
+	jobID int64
+}
+
+func (s jobSteps) Record(ctx context.Context, name string, status StepStatus, err error) {
+	step := Step{Name: name, Status: status, UpdatedAt: time.Now().UTC()}
+	if err != nil {
+		step.Error = err.Error()
+		step.Permanent = retry.IsPermanent(err)
+	}
+	if setErr := s.queue.SetStep(ctx, s.jobID, step); setErr != nil {
+		log.Printf("Error recording step %s of job %d: %v", name, s.jobID, setErr)
+	}
+}
+
+// NopSteps discards step progress, for running a handler outside a queue.
+var NopSteps StepRecorder = nopSteps{}
+
+type nopSteps struct{}
+
+func (nopSteps) Record(context.Context, string, StepStatus, error) {}
@@ -0,0 +1,251 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+)
+
+// DigestHour is the hour, UTC, on Mondays that the weekly digest is sent.
+const DigestHour = 6
+
+// AthleteTotals are one athlete's totals in a digest.
+type AthleteTotals struct {
+	UserID int `json:"user_id"`
+	Totals
+}
+
+// Digest is every athlete's totals for an ISO week. Athletes without workouts
+// that week are left out.
+type Digest struct {
+	Week     string          `json:"week"`
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	Athletes []AthleteTotals `json:"athletes"`
+}
+
+// WeeklyDigest totals the workouts of every athlete in the week t falls in,
+// with their training load from loads.
+func WeeklyDigest(ctx context.Context, store workouts.Store, loads athletes.PMCStore, t time.Time) (Digest, error) {
+	start := Week.Start(t)
+	digest := Digest{Week: Week.Label(start), Start: start, End: Week.Add(start, 1), Athletes: []AthleteTotals{}}
+
+	totals := make(map[int]*Totals)
+	err := eachWorkout(ctx, store, workouts.Query{From: digest.Start, To: digest.End}, func(record workouts.Record) error {
+		if totals[record.UserID] == nil {
+			totals[record.UserID] = &Totals{}
+		}
+		return totals[record.UserID].add(record)
+	})
+	if err != nil {
+		return Digest{}, err
+	}
+
+	for userID, athlete := range totals {
+		err := addLoads(ctx, loads, userID, digest.Start, digest.End, func(_ time.Time, tss float64) {
+			athlete.TSS += tss
+		})
+		if err != nil {
+			return Digest{}, err
+		}
+		digest.Athletes = append(digest.Athletes, AthleteTotals{UserID: userID, Totals: athlete.rounded()})
+	}
+	sort.Slice(digest.Athletes, func(i, j int) bool { return digest.Athletes[i].UserID < digest.Athletes[j].UserID })
+	return digest, nil
+}
+
+// Text renders the digest as plain text, one line per athlete.
+func (d Digest) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Weekly training digest for %s (%s to %s)\n\n", d.Week,
+		d.Start.Format(time.DateOnly), d.End.AddDate(0, 0, -1).Format(time.DateOnly))
+	if len(d.Athletes) == 0 {
+		b.WriteString("No workouts this week.\n")
+	}
+	for _, athlete := range d.Athletes {
+		fmt.Fprintf(&b, "Athlete %d: %d workouts (%d rides), %.1f km, %.0f m climbing, %s active, %.0f kcal, %.0f kJ, TSS %.0f\n",
+			athlete.UserID, athlete.Workouts, athlete.Rides, athlete.DistanceMeters/1000, athlete.AscentMeters,
+			(time.Duration(athlete.ActiveSeconds) * time.Second).String(), athlete.Calories, athlete.WorkKilojoules, athlete.TSS)
+	}
+	return b.String()
+}
+
+// Sink is where digests are sent.
+type Sink interface {
+	Send(ctx context.Context, digest Digest) error
+}
+
+// WebhookSink posts digests as JSON to a URL.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s WebhookSink) Send(ctx context.Context, digest Digest) error {
+	body, err := json.Marshal(digest)
+	if err != nil {
+		return fmt.Errorf("error encoding digest: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating digest request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting digest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error posting digest: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPSink emails digests as plain text. Username and Password are optional,
+// for servers that require authentication.
+type SMTPSink struct {
+	// Addr is the server's host:port.
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s SMTPSink) Send(_ context.Context, digest Digest) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("error emailing digest: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if err := smtp.SendMail(s.Addr, auth, s.From, s.To, s.message(digest)); err != nil {
+		return fmt.Errorf("error emailing digest: %w", err)
+	}
+	return nil
+}
+
+// message is the digest as an RFC 5322 email.
+func (s SMTPSink) message(digest Digest) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: Weekly training digest %s\r\n", digest.Week)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(digest.Text(), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// SinkFromEnv returns the sink configured by DIGEST_WEBHOOK_URL, or by
+// DIGEST_SMTP_ADDR and its companion DIGEST_SMTP_USERNAME,
+// DIGEST_SMTP_PASSWORD, DIGEST_EMAIL_FROM and DIGEST_EMAIL_TO (comma
+// separated). It returns nil when neither is set, disabling the digest.
+func SinkFromEnv() (Sink, error) {
+	webhookURL := os.Getenv("DIGEST_WEBHOOK_URL")
+	smtpAddr := os.Getenv("DIGEST_SMTP_ADDR")
+	switch {
+	case webhookURL != "" && smtpAddr != "":
+		return nil, errors.New("only one of DIGEST_WEBHOOK_URL and DIGEST_SMTP_ADDR may be set")
+	case webhookURL != "":
+		return WebhookSink{URL: webhookURL, Client: &http.Client{Timeout: 30 * time.Second}}, nil
+	case smtpAddr != "":
+		sink := SMTPSink{
+			Addr:     smtpAddr,
+			Username: os.Getenv("DIGEST_SMTP_USERNAME"),
+			Password: os.Getenv("DIGEST_SMTP_PASSWORD"),
+			From:     os.Getenv("DIGEST_EMAIL_FROM"),
+		}
+		for _, to := range strings.Split(os.Getenv("DIGEST_EMAIL_TO"), ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				sink.To = append(sink.To, to)
+			}
+		}
+		if sink.From == "" || len(sink.To) == 0 {
+			return nil, errors.New("DIGEST_SMTP_ADDR needs DIGEST_EMAIL_FROM and DIGEST_EMAIL_TO")
+		}
+		return sink, nil
+	}
+	return nil, nil
+}
+
+// DigestJob sends the weekly digest to a sink.
+type DigestJob struct {
+	store workouts.Store
+	loads athletes.PMCStore
+	sink  Sink
+	wg    sync.WaitGroup
+}
+
+func NewDigestJob(store workouts.Store, loads athletes.PMCStore, sink Sink) *DigestJob {
+	return &DigestJob{store: store, loads: loads, sink: sink}
+}
+
+// Start sends the previous week's digest every Monday at DigestHour until ctx
+// is cancelled. Use Wait to block until a send in progress has finished.
+func (j *DigestJob) Start(ctx context.Context) {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		for {
+			next := nextDigest(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+			if err := j.Send(ctx, Week.Add(Week.Start(next), -1)); err != nil {
+				log.Printf("Error sending weekly digest: %v", err)
+			}
+		}
+	}()
+}
+
+func (j *DigestJob) Wait() {
+	j.wg.Wait()
+}
+
+// Send sends the digest of the week t falls in.
+func (j *DigestJob) Send(ctx context.Context, t time.Time) error {
+	digest, err := WeeklyDigest(ctx, j.store, j.loads, t)
+	if err != nil {
+		return err
+	}
+	if err := j.sink.Send(ctx, digest); err != nil {
+		return err
+	}
+	log.Printf("Sent weekly digest for %s covering %d athletes", digest.Week, len(digest.Athletes))
+	return nil
+}
+
+// nextDigest is the first Monday at DigestHour after now.
+func nextDigest(now time.Time) time.Time {
+	next := Week.Start(now).Add(DigestHour * time.Hour)
+	if !next.After(now) {
+		next = Week.Add(next, 1)
+	}
+	return next
+}
@@ -0,0 +1,65 @@
+package reports
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"goji.io/pat"
+)
+
+// DefaultBuckets is the number of periods a report covers when no range is
+// given.
+const DefaultBuckets = 12
+
+// Get returns the totals of the :user_id path parameter for each :period
+// (week, month or year) between the from and to query parameters, UTC dates
+// formatted as 2006-01-02. The range defaults to the last 12 periods, up to
+// and including the current one. Training load is read from loads.
+func Get(store workouts.Store, loads athletes.PMCStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(pat.Param(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+		period, err := ParsePeriod(pat.Param(r, "period"))
+		if err != nil {
+			http.Error(w, "Unsupported period", http.StatusNotFound)
+			return
+		}
+
+		to := time.Now().UTC()
+		if value := r.URL.Query().Get("to"); value != "" {
+			if to, err = time.Parse(time.DateOnly, value); err != nil {
+				http.Error(w, "Invalid to date", http.StatusBadRequest)
+				return
+			}
+		}
+		from := period.Add(period.Start(to), 1-DefaultBuckets)
+		if value := r.URL.Query().Get("from"); value != "" {
+			if from, err = time.Parse(time.DateOnly, value); err != nil {
+				http.Error(w, "Invalid from date", http.StatusBadRequest)
+				return
+			}
+		}
+		if to.Before(from) || !period.Add(period.Start(from), MaxBuckets).After(to) {
+			http.Error(w, "Invalid date range", http.StatusBadRequest)
+			return
+		}
+
+		report, err := Build(r.Context(), store, loads, userID, period, from, to)
+		if err != nil {
+			log.Printf("Error building %s report for user %d: %v", period, userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
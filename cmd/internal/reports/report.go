@@ -0,0 +1,221 @@
+// Package reports totals athletes' training volume over calendar periods from
+// the workout store and their training load, and sends a weekly digest of it.
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/webhook"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/workouttype"
+)
+
+// Period is a calendar period workouts are totalled over. Periods are in UTC
+// and weeks are ISO weeks, starting on Monday.
+type Period string
+
+const (
+	Week  Period = "week"
+	Month Period = "month"
+	Year  Period = "year"
+)
+
+// ParsePeriod returns the named period.
+func ParsePeriod(name string) (Period, error) {
+	switch period := Period(name); period {
+	case Week, Month, Year:
+		return period, nil
+	}
+	return "", fmt.Errorf("unknown period %q", name)
+}
+
+// Start returns the start of the period t falls in.
+func (p Period) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch p {
+	case Month:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case Year:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// Add returns the start of the period n periods after the one starting at
+// start; n may be negative.
+func (p Period) Add(start time.Time, n int) time.Time {
+	switch p {
+	case Month:
+		return start.AddDate(0, n, 0)
+	case Year:
+		return start.AddDate(n, 0, 0)
+	}
+	return start.AddDate(0, 0, 7*n)
+}
+
+// Label names the period starting at start: 2024-W15, 2024-04 or 2024.
+func (p Period) Label(start time.Time) string {
+	switch p {
+	case Month:
+		return start.Format("2006-01")
+	case Year:
+		return start.Format("2006")
+	}
+	year, week := start.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// Totals are the summed volume of a set of workouts. Metrics a workout's
+// summary does not have count as zero.
+type Totals struct {
+	Workouts int `json:"workouts"`
+	// Rides counts the workouts in the biking family.
+	Rides          int     `json:"rides"`
+	DistanceMeters float64 `json:"distance_meters"`
+	AscentMeters   float64 `json:"ascent_meters"`
+	ActiveSeconds  float64 `json:"active_seconds"`
+	Calories       float64 `json:"calories"`
+	WorkKilojoules float64 `json:"work_kilojoules"`
+	// TSS is the training load recorded for the performance management
+	// chart, so the two agree: computed from power where the FIT file has it,
+	// else Wahoo's.
+	TSS float64 `json:"tss"`
+}
+
+// add counts a workout in the totals.
+func (t *Totals) add(record workouts.Record) error {
+	var summary webhook.WorkoutSummary
+	if err := json.Unmarshal(record.Summary, &summary); err != nil {
+		return fmt.Errorf("error decoding summary of workout %d: %w", record.WorkoutID, err)
+	}
+
+	t.Workouts++
+	if workouttype.ByID(record.WorkoutTypeID).Family == workouttype.FamilyBiking {
+		t.Rides++
+	}
+	t.DistanceMeters += summary.DistanceAccum.Or(0)
+	t.AscentMeters += summary.AscentAccum.Or(0)
+	t.ActiveSeconds += summary.DurationActiveAccum.Or(0)
+	t.Calories += summary.CaloriesAccum.Or(0)
+	t.WorkKilojoules += summary.WorkAccum.Or(0) / 1000
+	return nil
+}
+
+// addLoads calls add with the athlete's training load on each day from from
+// up to to that has any.
+func addLoads(ctx context.Context, loads athletes.PMCStore, userID int, from, to time.Time, add func(day time.Time, tss float64)) error {
+	daily, err := loads.DailyLoads(ctx, userID, from.Format(athletes.DateLayout))
+	if err != nil {
+		return err
+	}
+	for _, load := range daily {
+		day, err := time.Parse(athletes.DateLayout, load.Date)
+		if err != nil {
+			return fmt.Errorf("error reading training load of user %d: %w", userID, err)
+		}
+		if !day.Before(to) {
+			break
+		}
+		add(day, load.TSS)
+	}
+	return nil
+}
+
+// rounded returns the totals rounded for display.
+func (t Totals) rounded() Totals {
+	round := func(value float64) float64 { return math.Round(value*10) / 10 }
+	t.DistanceMeters = round(t.DistanceMeters)
+	t.AscentMeters = round(t.AscentMeters)
+	t.ActiveSeconds = round(t.ActiveSeconds)
+	t.Calories = round(t.Calories)
+	t.WorkKilojoules = round(t.WorkKilojoules)
+	t.TSS = round(t.TSS)
+	return t
+}
+
+// Bucket is the totals of one period, from Start up to End.
+type Bucket struct {
+	Label string    `json:"label"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Totals
+}
+
+// Report is an athlete's totals for each period in a range, including periods
+// without workouts.
+type Report struct {
+	UserID  int      `json:"user_id"`
+	Period  Period   `json:"period"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// MaxBuckets bounds the number of periods a report can cover.
+const MaxBuckets = 520
+
+// Build totals the athlete's workouts in each period from the one from falls in
+// to the one to falls in, with their training load from loads.
+func Build(ctx context.Context, store workouts.Store, loads athletes.PMCStore, userID int, period Period, from, to time.Time) (Report, error) {
+	report := Report{UserID: userID, Period: period, Buckets: []Bucket{}}
+	index := make(map[time.Time]int)
+	for start := period.Start(from); !start.After(to); start = period.Add(start, 1) {
+		if len(report.Buckets) == MaxBuckets {
+			return Report{}, fmt.Errorf("report covers more than %d %ss", MaxBuckets, period)
+		}
+		index[start] = len(report.Buckets)
+		report.Buckets = append(report.Buckets, Bucket{Label: period.Label(start), Start: start, End: period.Add(start, 1)})
+	}
+	if len(report.Buckets) == 0 {
+		return report, nil
+	}
+
+	query := workouts.Query{
+		UserID: userID,
+		From:   report.Buckets[0].Start,
+		To:     report.Buckets[len(report.Buckets)-1].End,
+	}
+	err := eachWorkout(ctx, store, query, func(record workouts.Record) error {
+		bucket := &report.Buckets[index[period.Start(record.Starts)]]
+		return bucket.add(record)
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	err = addLoads(ctx, loads, userID, query.From, query.To, func(day time.Time, tss float64) {
+		report.Buckets[index[period.Start(day)]].TSS += tss
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	for i := range report.Buckets {
+		report.Buckets[i].Totals = report.Buckets[i].Totals.rounded()
+	}
+	return report, nil
+}
+
+// eachWorkout calls fn with every workout the query selects, a page at a time.
+func eachWorkout(ctx context.Context, store workouts.Store, query workouts.Query, fn func(workouts.Record) error) error {
+	query.Sort = workouts.SortStarts
+	query.Limit = workouts.MaxLimit
+	for {
+		page, err := store.List(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, record := range page.Workouts {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,228 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/stretchr/testify/require"
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+func TestPeriods(t *testing.T) {
+
+	// Wednesday 10 April 2024 is in ISO week 15, which starts on Monday 8 April.
+	wednesday := time.Date(2024, 4, 10, 18, 30, 0, 0, time.UTC)
+
+	testCases := []struct {
+		period Period
+		start  time.Time
+		next   time.Time
+		label  string
+	}{
+		{Week, time.Date(2024, 4, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC), "2024-W15"},
+		{Month, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), "2024-04"},
+		{Year, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), "2024"},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.period), func(t *testing.T) {
+			require.Equal(t, tc.start, tc.period.Start(wednesday))
+			require.Equal(t, tc.next, tc.period.Add(tc.start, 1))
+			require.Equal(t, tc.label, tc.period.Label(tc.start))
+		})
+	}
+
+	// ISO weeks belong to the year of their Thursday.
+	require.Equal(t, "2025-W01", Week.Label(Week.Start(time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC))))
+	// Sunday is the last day of the week.
+	require.Equal(t, time.Date(2024, 4, 8, 0, 0, 0, 0, time.UTC), Week.Start(time.Date(2024, 4, 14, 23, 0, 0, 0, time.UTC)))
+
+	_, err := ParsePeriod("fortnight")
+	require.Error(t, err)
+}
+
+// testStore holds two rides and a run for one athlete, across two ISO weeks,
+// and a ride for another athlete.
+func testStore(t *testing.T) workouts.Store {
+	store := workouts.NewMemoryStore()
+	for _, record := range []workouts.Record{
+		{WorkoutID: 1, UserID: 1120489, WorkoutTypeID: 0, Starts: time.Date(2024, 4, 8, 7, 0, 0, 0, time.UTC),
+			Summary: json.RawMessage(`{"distance_accum":"40000.0","ascent_accum":"350.0","duration_active_accum":"5400.0",
+				"calories_accum":"900.0","work_accum":"1000000.0","power_bike_tss_last":"75.5"}`)},
+		{WorkoutID: 2, UserID: 1120489, WorkoutTypeID: 1, Starts: time.Date(2024, 4, 10, 7, 0, 0, 0, time.UTC),
+			Summary: json.RawMessage(`{"distance_accum":"10000.0","ascent_accum":null,"duration_active_accum":"3000.0",
+				"calories_accum":"600.0"}`)},
+		{WorkoutID: 3, UserID: 1120489, WorkoutTypeID: 61, Starts: time.Date(2024, 4, 16, 7, 0, 0, 0, time.UTC),
+			Summary: json.RawMessage(`{"duration_active_accum":"3600.0","work_accum":"700000.0","power_bike_tss_last":"60"}`)},
+		{WorkoutID: 4, UserID: 1, WorkoutTypeID: 0, Starts: time.Date(2024, 4, 9, 7, 0, 0, 0, time.UTC),
+			Summary: json.RawMessage(`{"distance_accum":"80000.0"}`)},
+	} {
+		require.NoError(t, store.Save(context.Background(), record))
+	}
+	return store
+}
+
+// testLoads holds the training load of the workouts in testStore. The ride on
+// 8 April had its TSS computed from power, which differs from Wahoo's.
+func testLoads(t *testing.T) athletes.PMCStore {
+	loads := athletes.NewMemoryPMCStore()
+	for _, load := range []athletes.WorkoutLoad{
+		{WorkoutID: 1, UserID: 1120489, Starts: time.Date(2024, 4, 8, 7, 0, 0, 0, time.UTC), TSS: 80.2, Source: athletes.LoadFromPower},
+		{WorkoutID: 2, UserID: 1120489, Starts: time.Date(2024, 4, 10, 7, 0, 0, 0, time.UTC), Source: athletes.LoadNone},
+		{WorkoutID: 3, UserID: 1120489, Starts: time.Date(2024, 4, 16, 7, 0, 0, 0, time.UTC), TSS: 60, Source: athletes.LoadFromWahoo},
+		{WorkoutID: 4, UserID: 1, Starts: time.Date(2024, 4, 9, 7, 0, 0, 0, time.UTC), Source: athletes.LoadNone},
+	} {
+		require.NoError(t, loads.SaveLoad(context.Background(), load))
+	}
+	return loads
+}
+
+func TestBuild(t *testing.T) {
+
+	store, loads := testStore(t), testLoads(t)
+	report, err := Build(context.Background(), store, loads, 1120489, Week,
+		time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	require.Equal(t, []Bucket{
+		{Label: "2024-W14", Start: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 4, 8, 0, 0, 0, 0, time.UTC)},
+		{Label: "2024-W15", Start: time.Date(2024, 4, 8, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
+			Totals: Totals{Workouts: 2, Rides: 1, DistanceMeters: 50000, AscentMeters: 350, ActiveSeconds: 8400,
+				Calories: 1500, WorkKilojoules: 1000, TSS: 80.2}},
+		{Label: "2024-W16", Start: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 4, 22, 0, 0, 0, 0, time.UTC),
+			Totals: Totals{Workouts: 1, Rides: 1, ActiveSeconds: 3600, WorkKilojoules: 700, TSS: 60}},
+	}, report.Buckets)
+
+	report, err = Build(context.Background(), store, loads, 1120489, Month,
+		time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 1)
+	require.Equal(t, 3, report.Buckets[0].Workouts)
+	require.Equal(t, 140.2, report.Buckets[0].TSS)
+
+	// Load after the range is left out.
+	report, err = Build(context.Background(), store, loads, 1120489, Week,
+		time.Date(2024, 4, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 14, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 1)
+	require.Equal(t, 80.2, report.Buckets[0].TSS)
+}
+
+func TestWeeklyDigest(t *testing.T) {
+
+	// The handler runs on the server's goroutine, so it only records what it
+	// received, for the test to check.
+	var contentType string
+	var received Digest
+	var decodeErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		decodeErr = json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	job := NewDigestJob(testStore(t), testLoads(t), WebhookSink{URL: server.URL})
+	require.NoError(t, job.Send(context.Background(), time.Date(2024, 4, 14, 0, 0, 0, 0, time.UTC)))
+
+	require.Equal(t, "application/json", contentType)
+	require.NoError(t, decodeErr)
+	require.Equal(t, "2024-W15", received.Week)
+	require.Equal(t, []AthleteTotals{
+		{UserID: 1, Totals: Totals{Workouts: 1, Rides: 1, DistanceMeters: 80000}},
+		{UserID: 1120489, Totals: Totals{Workouts: 2, Rides: 1, DistanceMeters: 50000, AscentMeters: 350,
+			ActiveSeconds: 8400, Calories: 1500, WorkKilojoules: 1000, TSS: 80.2}},
+	}, received.Athletes)
+
+	require.Equal(t, "Weekly training digest for 2024-W15 (2024-04-08 to 2024-04-14)\n\n"+
+		"Athlete 1: 1 workouts (1 rides), 80.0 km, 0 m climbing, 0s active, 0 kcal, 0 kJ, TSS 0\n"+
+		"Athlete 1120489: 2 workouts (1 rides), 50.0 km, 350 m climbing, 2h20m0s active, 1500 kcal, 1000 kJ, TSS 80\n",
+		received.Text())
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	job = NewDigestJob(testStore(t), testLoads(t), WebhookSink{URL: failing.URL})
+	require.Error(t, job.Send(context.Background(), time.Date(2024, 4, 14, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestSMTPSinkMessage(t *testing.T) {
+
+	sink := SMTPSink{Addr: "smtp.example.com:587", From: "digest@example.com", To: []string{"coach@example.com", "athlete@example.com"}}
+	message := string(sink.message(Digest{Week: "2024-W15", Start: time.Date(2024, 4, 8, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)}))
+
+	require.Contains(t, message, "To: coach@example.com, athlete@example.com\r\n")
+	require.Contains(t, message, "Subject: Weekly training digest 2024-W15\r\n")
+	require.True(t, strings.HasSuffix(message, "\r\n\r\nWeekly training digest for 2024-W15 (2024-04-08 to 2024-04-14)\r\n\r\nNo workouts this week.\r\n"))
+}
+
+func TestNextDigest(t *testing.T) {
+
+	require.Equal(t, time.Date(2024, 4, 15, DigestHour, 0, 0, 0, time.UTC), nextDigest(time.Date(2024, 4, 10, 12, 0, 0, 0, time.UTC)))
+	require.Equal(t, time.Date(2024, 4, 8, DigestHour, 0, 0, 0, time.UTC), nextDigest(time.Date(2024, 4, 8, 1, 0, 0, 0, time.UTC)))
+	require.Equal(t, time.Date(2024, 4, 15, DigestHour, 0, 0, 0, time.UTC), nextDigest(time.Date(2024, 4, 8, DigestHour, 0, 0, 0, time.UTC)))
+}
+
+func TestSinkFromEnv(t *testing.T) {
+
+	sink, err := SinkFromEnv()
+	require.NoError(t, err)
+	require.Nil(t, sink)
+
+	t.Setenv("DIGEST_SMTP_ADDR", "smtp.example.com:587")
+	_, err = SinkFromEnv()
+	require.Error(t, err)
+
+	t.Setenv("DIGEST_EMAIL_FROM", "digest@example.com")
+	t.Setenv("DIGEST_EMAIL_TO", "coach@example.com, athlete@example.com")
+	sink, err = SinkFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, []string{"coach@example.com", "athlete@example.com"}, sink.(SMTPSink).To)
+
+	t.Setenv("DIGEST_WEBHOOK_URL", "https://example.com/digest")
+	_, err = SinkFromEnv()
+	require.Error(t, err)
+}
+
+func TestGet(t *testing.T) {
+
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/athletes/:user_id/reports/:period"), Get(testStore(t), testLoads(t)))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1120489/reports/year?from=2023-06-01&to=2024-04-30", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+
+	var report Report
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &report))
+	require.Equal(t, Year, report.Period)
+	require.Len(t, report.Buckets, 2)
+	require.Equal(t, "2023", report.Buckets[0].Label)
+	require.Equal(t, 0, report.Buckets[0].Workouts)
+	require.Equal(t, 3, report.Buckets[1].Workouts)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/athletes/1120489/reports/week", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &report))
+	require.Len(t, report.Buckets, DefaultBuckets)
+
+	for path, status := range map[string]int{
+		"/athletes/me/reports/week":                                    http.StatusBadRequest,
+		"/athletes/1120489/reports/fortnight":                          http.StatusNotFound,
+		"/athletes/1120489/reports/week?from=2024-04-10&to=2024-04-01": http.StatusBadRequest,
+		"/athletes/1120489/reports/week?from=2000-01-01&to=2024-04-01": http.StatusBadRequest,
+		"/athletes/1120489/reports/month?to=April":                     http.StatusBadRequest,
+	} {
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, status, response.Code, path)
+	}
+}
@@ -0,0 +1,213 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+)
+
+// Sources of the FTP an analysis was computed against.
+const (
+	FTPFromProfile = "profile"
+	FTPFromFitFile = "fit_file"
+	FTPFromDefault = "default"
+)
+
+// Analysis is the training metrics computed from a workout's FIT file, stored
+// alongside it.
+type Analysis struct {
+	WorkoutID int       `json:"workout_id"`
+	UserID    int       `json:"user_id"`
+	Starts    time.Time `json:"starts"`
+	// FTPSource says where the FTP in the power metrics came from, and is
+	// empty when none was known.
+	FTPSource string `json:"ftp_source,omitempty"`
+	metrics.Analysis
+	// PersonalRecords are the durations the workout beat the athlete's
+	// previous best power for.
+	PersonalRecords []PersonalRecord `json:"personal_records,omitempty"`
+}
+
+// Windows a personal record can be set in.
+const (
+	RecordAllTime    = "all_time"
+	RecordLast90Days = "last_90_days"
+)
+
+// PersonalRecord is a best power a workout set, and the record it beat.
+type PersonalRecord struct {
+	Window   string                  `json:"window"`
+	Duration int                     `json:"duration"`
+	Watts    float64                 `json:"watts"`
+	Previous athletes.PersonalRecord `json:"previous"`
+}
+
+// PipelineOption configures optional parts of a Pipeline.
+type PipelineOption func(*Pipeline)
+
+// WithProfiles looks athletes' settings up in profiles when analysing their
+// workouts.
+func WithProfiles(profiles athletes.ProfileStore) PipelineOption {
+	return func(p *Pipeline) {
+		p.profiles = profiles
+	}
+}
+
+// WithPowerCurves saves each workout's power curve to curves, and flags the
+// personal records it sets against the athlete's earlier workouts.
+func WithPowerCurves(curves athletes.CurveStore) PipelineOption {
+	return func(p *Pipeline) {
+		p.curves = curves
+	}
+}
+
+// WithTrainingLoad records each workout's TSS in loads, for the athletes'
+// performance management charts.
+func WithTrainingLoad(loads athletes.PMCStore) PipelineOption {
+	return func(p *Pipeline) {
+		p.loads = loads
+	}
+}
+
+// defaultFTPFromEnv reads DEFAULT_FTP, returning zero when it is unset. It is
+// an error for it not to be a whole number of watts.
+func defaultFTPFromEnv() (int, error) {
+	value := os.Getenv("DEFAULT_FTP")
+	if value == "" {
+		return 0, nil
+	}
+	ftp, err := strconv.Atoi(value)
+	if err != nil || ftp < 0 {
+		return 0, fmt.Errorf("invalid DEFAULT_FTP %q, must be a whole number of watts", value)
+	}
+	return ftp, nil
+}
+
+// analyse decodes a downloaded FIT file and computes its training metrics,
+// using the athlete's profile for heart rate settings. The FTP is the one in
+// the profile, else the one the head unit recorded in the file, else
+// DEFAULT_FTP. The analysis is stored with the file; a workout that cannot be
+// analysed is logged, and still stored and forwarded without one.
+func (p *Pipeline) analyse(ctx context.Context, wahooWorkout WahooCloudApiResponseBody, fileBytes []byte) (*Analysis, error) {
+	file, err := fit.DecodeBytes(fileBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding fit file: %w", err)
+	}
+
+	summary := wahooWorkout.WorkoutSummary
+	analysis := &Analysis{
+		WorkoutID: summary.Workout.ID,
+		UserID:    wahooWorkout.User.ID,
+		Starts:    starts(summary),
+	}
+
+	var athlete metrics.Athlete
+	if p.profiles != nil {
+		profile, found, err := p.profiles.Get(ctx, wahooWorkout.User.ID)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			athlete = profile.Athlete()
+		}
+		if athlete.FTP > 0 {
+			analysis.FTPSource = FTPFromProfile
+		}
+	}
+	if athlete.FTP == 0 {
+		for _, session := range file.Sessions {
+			if session.ThresholdPower > 0 {
+				athlete.FTP = float64(session.ThresholdPower)
+				analysis.FTPSource = FTPFromFitFile
+				break
+			}
+		}
+	}
+	if athlete.FTP == 0 && p.defaultFTP > 0 {
+		athlete.FTP = float64(p.defaultFTP)
+		analysis.FTPSource = FTPFromDefault
+	}
+
+	analysis.Analysis = metrics.Analyse(file, athlete)
+	if analysis.Power == nil {
+		analysis.FTPSource = ""
+	}
+	return analysis, nil
+}
+
+// needsAnalysis reports whether anything keeps a workout's analysis: storage,
+// which saves it with the file, or the power curve and training load stores
+// fed from it.
+func (p *Pipeline) needsAnalysis() bool {
+	return p.storage != nil || p.curves != nil || p.loads != nil
+}
+
+// trackRecords compares the analysis' power curve with the bests of the
+// athlete's workouts that started before it, all time and in the preceding 90
+// days, then saves it. Durations the athlete has no earlier record for are not
+// flagged, so a first workout does not set records across the board.
+func (p *Pipeline) trackRecords(ctx context.Context, analysis *Analysis) error {
+	if p.curves == nil || analysis.PowerCurve == nil {
+		return nil
+	}
+
+	for _, window := range []struct {
+		name  string
+		since time.Time
+	}{
+		{RecordAllTime, time.Time{}},
+		{RecordLast90Days, analysis.Starts.Add(-athletes.RecentWindow)},
+	} {
+		previous, err := p.curves.Best(ctx, analysis.UserID, window.since, analysis.Starts)
+		if err != nil {
+			return err
+		}
+		for _, record := range previous {
+			if watts, ok := analysis.PowerCurve.Watts(record.Duration); ok && watts > record.Watts {
+				analysis.PersonalRecords = append(analysis.PersonalRecords, PersonalRecord{
+					Window:   window.name,
+					Duration: record.Duration,
+					Watts:    watts,
+					Previous: record,
+				})
+			}
+		}
+	}
+
+	return p.curves.SaveCurve(ctx, athletes.WorkoutCurve{
+		WorkoutID: analysis.WorkoutID,
+		UserID:    analysis.UserID,
+		Starts:    analysis.Starts,
+		Curve:     analysis.PowerCurve,
+	})
+}
+
+// recordLoad saves the workout's TSS: the one computed from power, else the one
+// Wahoo sent. Workouts with neither are saved with no load, so that updating a
+// workout never leaves its earlier load behind. Failing to save it is logged
+// but does not fail the job.
+func (p *Pipeline) recordLoad(ctx context.Context, wahooWorkout WahooCloudApiResponseBody, analysis *Analysis) error {
+	if p.loads == nil {
+		return nil
+	}
+
+	summary := wahooWorkout.WorkoutSummary
+	load := athletes.WorkoutLoad{
+		WorkoutID: summary.Workout.ID,
+		UserID:    wahooWorkout.User.ID,
+		Starts:    starts(summary),
+		Source:    athletes.LoadNone,
+	}
+	if analysis != nil && analysis.Power != nil && analysis.Power.TSS > 0 {
+		load.TSS, load.Source = analysis.Power.TSS, athletes.LoadFromPower
+	} else if tss, ok := summary.PowerBikeTssLast.Float64(); ok {
+		load.TSS, load.Source = tss, athletes.LoadFromWahoo
+	}
+	return p.loads.SaveLoad(ctx, load)
+}
@@ -0,0 +1,39 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/queue"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/retry"
+)
+
+// JobKind is the queue job kind for accepted workout summary events.
+const JobKind = "workout_summary"
+
+// jobPayload is the queued form of an accepted event.
+type jobPayload struct {
+	Decision Decision        `json:"decision"`
+	Event    json.RawMessage `json:"event"`
+}
+
+// JobHandler runs queued workout summary events through the pipeline.
+func JobHandler(pipeline *Pipeline) queue.Handler {
+	return func(ctx context.Context, job queue.Job, steps queue.StepRecorder) error {
+		var payload jobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return retry.Permanent(fmt.Errorf("error decoding job payload: %w", err))
+		}
+		// Jobs queued before decisions were recorded hold the bare event.
+		if len(payload.Event) == 0 {
+			payload = jobPayload{Decision: DecisionNew, Event: job.Payload}
+		}
+
+		var wahooWorkout WahooCloudApiResponseBody
+		if err := json.Unmarshal(payload.Event, &wahooWorkout); err != nil {
+			return retry.Permanent(fmt.Errorf("error decoding job payload: %w", err))
+		}
+		return pipeline.ProcessWithSteps(ctx, wahooWorkout, payload.Decision, steps)
+	}
+}
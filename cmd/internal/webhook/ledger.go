@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+)
+
+// Decision is what the webhook did with a workout summary event, based on
+// what the ledger had already seen for that summary.
+type Decision string
+
+const (
+	// DecisionNew is the first event seen for a workout summary.
+	DecisionNew Decision = "new"
+	// DecisionUpdated is an event with a newer UpdatedAt than the last one
+	// processed; stored artifacts are overwritten and re-forwarded.
+	DecisionUpdated Decision = "updated"
+	// DecisionDuplicate is an exact replay of an event already processed.
+	DecisionDuplicate Decision = "duplicate"
+	// DecisionStale is an event older than the last one processed, e.g. a
+	// redelivery that arrived after an update.
+	DecisionStale Decision = "stale"
+)
+
+// Process reports whether events with this decision should be run through the pipeline.
+func (d Decision) Process() bool {
+	return d == DecisionNew || d == DecisionUpdated
+}
+
+// LedgerEntry records the latest accepted revision of a workout summary.
+type LedgerEntry struct {
+	SummaryID   int       `json:"summary_id"`
+	WorkoutID   int       `json:"workout_id"`
+	UserID      int       `json:"user_id"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Revision    int       `json:"revision"`
+	JobID       int64     `json:"job_id"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// Decide compares an incoming event's UpdatedAt with the entry. found is false
+// when the ledger has no entry for the summary yet.
+func (e LedgerEntry) Decide(found bool, updatedAt time.Time) Decision {
+	switch {
+	case !found:
+		return DecisionNew
+	case updatedAt.Equal(e.UpdatedAt):
+		return DecisionDuplicate
+	case updatedAt.After(e.UpdatedAt):
+		return DecisionUpdated
+	default:
+		return DecisionStale
+	}
+}
+
+// Ledger is the dedup ledger of workout summaries keyed on WorkoutSummary.ID.
+type Ledger interface {
+	// Get returns the entry for summaryID; found is false if there is none.
+	Get(ctx context.Context, summaryID int) (entry LedgerEntry, found bool, err error)
+	Save(ctx context.Context, entry LedgerEntry) error
+}
+
+// MemoryLedger is a Ledger held in process memory.
+type MemoryLedger struct {
+	mu      sync.Mutex
+	entries map[int]LedgerEntry
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{entries: make(map[int]LedgerEntry)}
+}
+
+func (l *MemoryLedger) Get(_ context.Context, summaryID int) (LedgerEntry, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[summaryID]
+	return entry, ok, nil
+}
+
+func (l *MemoryLedger) Save(_ context.Context, entry LedgerEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[entry.SummaryID] = entry
+	return nil
+}
+
+// SQLiteLedger is a Ledger persisted in SQLite.
+type SQLiteLedger struct {
+	db *sql.DB
+}
+
+var ledgerMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create workout_summary_ledger",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS workout_summary_ledger (
+				summary_id    INTEGER PRIMARY KEY,
+				workout_id    INTEGER NOT NULL,
+				user_id       INTEGER NOT NULL,
+				updated_at    INTEGER NOT NULL,
+				revision      INTEGER NOT NULL,
+				job_id        INTEGER NOT NULL,
+				first_seen_at INTEGER NOT NULL,
+				last_seen_at  INTEGER NOT NULL
+			)`,
+		},
+	},
+}
+
+func NewSQLiteLedger(db *sql.DB) (*SQLiteLedger, error) {
+	if err := database.Migrate(db, database.SQLite, "workout_summary_ledger", ledgerMigrations); err != nil {
+		return nil, err
+	}
+	return &SQLiteLedger{db: db}, nil
+}
+
+func (l *SQLiteLedger) Get(ctx context.Context, summaryID int) (LedgerEntry, bool, error) {
+	entry := LedgerEntry{SummaryID: summaryID}
+	var updatedAt, firstSeenAt, lastSeenAt int64
+
+	err := l.db.QueryRowContext(ctx, `SELECT workout_id, user_id, updated_at, revision, job_id, first_seen_at, last_seen_at
+		FROM workout_summary_ledger WHERE summary_id = ?`, summaryID).
+		Scan(&entry.WorkoutID, &entry.UserID, &updatedAt, &entry.Revision, &entry.JobID, &firstSeenAt, &lastSeenAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LedgerEntry{}, false, nil
+	}
+	if err != nil {
+		return LedgerEntry{}, false, fmt.Errorf("error loading ledger entry for summary %d: %w", summaryID, err)
+	}
+
+	// UpdatedAt keeps sub-second precision so replays compare exactly.
+	entry.UpdatedAt = time.Unix(0, updatedAt).UTC()
+	entry.FirstSeenAt = time.Unix(firstSeenAt, 0).UTC()
+	entry.LastSeenAt = time.Unix(lastSeenAt, 0).UTC()
+	return entry, true, nil
+}
+
+func (l *SQLiteLedger) Save(ctx context.Context, entry LedgerEntry) error {
+	_, err := l.db.ExecContext(ctx, `INSERT INTO workout_summary_ledger
+		(summary_id, workout_id, user_id, updated_at, revision, job_id, first_seen_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(summary_id) DO UPDATE SET
+			workout_id = excluded.workout_id,
+			user_id = excluded.user_id,
+			updated_at = excluded.updated_at,
+			revision = excluded.revision,
+			job_id = excluded.job_id,
+			last_seen_at = excluded.last_seen_at`,
+		entry.SummaryID, entry.WorkoutID, entry.UserID, entry.UpdatedAt.UnixNano(), entry.Revision, entry.JobID,
+		entry.FirstSeenAt.Unix(), entry.LastSeenAt.Unix())
+	if err != nil {
+		return fmt.Errorf("error saving ledger entry for summary %d: %w", entry.SummaryID, err)
+	}
+	return nil
+}
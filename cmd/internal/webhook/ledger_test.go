@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedgers(t *testing.T) {
+
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "ledger.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqliteLedger, err := NewSQLiteLedger(db)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		ledger Ledger
+	}{
+		{name: "Memory", ledger: NewMemoryLedger()},
+		{name: "SQLite", ledger: sqliteLedger},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, found, err := tc.ledger.Get(ctx, 252869305)
+			require.NoError(t, err)
+			require.False(t, found)
+
+			entry := LedgerEntry{
+				SummaryID:   252869305,
+				WorkoutID:   281788767,
+				UserID:      1120489,
+				UpdatedAt:   time.Date(2024, 4, 12, 18, 36, 11, 120000000, time.UTC),
+				Revision:    1,
+				JobID:       3,
+				FirstSeenAt: time.Date(2024, 4, 12, 18, 36, 20, 0, time.UTC),
+				LastSeenAt:  time.Date(2024, 4, 12, 18, 36, 20, 0, time.UTC),
+			}
+			require.NoError(t, tc.ledger.Save(ctx, entry))
+
+			saved, found, err := tc.ledger.Get(ctx, 252869305)
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, entry, saved)
+			require.Equal(t, DecisionDuplicate, saved.Decide(true, entry.UpdatedAt))
+			require.Equal(t, DecisionUpdated, saved.Decide(true, entry.UpdatedAt.Add(time.Millisecond)))
+			require.Equal(t, DecisionStale, saved.Decide(true, entry.UpdatedAt.Add(-time.Millisecond)))
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/queue"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/retry"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/storage"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/utils"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/workouttype"
+)
+
+// Names of the steps recorded by ProcessWithSteps.
+const (
+	StepDownload = "download"
+	StepUpload   = "upload"
+	StepForward  = "forward"
+)
+
+const fitContentType = "application/vnd.ant.fit"
+
+// Pipeline downloads a workout's FIT file, stores it and forwards it on. It is
+// shared by the live webhook and the historical backfill.
+type Pipeline struct {
+	storage            storage.Backend
+	keys               storage.KeyTemplate
+	externalServiceURL string
+	forwardTypes       workouttype.Filter
+	retry              retry.Policy
+	profiles           athletes.ProfileStore
+	curves             athletes.CurveStore
+	loads              athletes.PMCStore
+	workouts           workouts.Store
+	defaultFTP         int
+}
+
+// NewPipeline stores FIT files in backend, which may be nil to disable storage,
+// under keys built from the template. Only workouts whose type matches
+// FORWARD_WORKOUT_TYPES, when set, are forwarded. It is an error for it or
+// DEFAULT_FTP to be invalid.
+func NewPipeline(backend storage.Backend, keys storage.KeyTemplate, opts ...PipelineOption) (*Pipeline, error) {
+	forwardTypes, err := workouttype.ParseFilter(os.Getenv("FORWARD_WORKOUT_TYPES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FORWARD_WORKOUT_TYPES: %w", err)
+	}
+	defaultFTP, err := defaultFTPFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	p := &Pipeline{
+		storage:            backend,
+		keys:               keys,
+		externalServiceURL: os.Getenv("FITFILE_SERVICE_URL"),
+		forwardTypes:       forwardTypes,
+		retry:              retry.PolicyFromEnv(),
+		defaultFTP:         defaultFTP,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
+}
+
+// Process runs a validated workout summary event through the pipeline.
+func (p *Pipeline) Process(ctx context.Context, wahooWorkout WahooCloudApiResponseBody) error {
+	return p.ProcessWithSteps(ctx, wahooWorkout, DecisionNew, queue.NopSteps)
+}
+
+// ProcessWithSteps runs the download, upload and forward steps, recording the
+// outcome of each, and forwards the file with the decision as its status.
+// Transient failures in a step are retried per the pipeline's retry policy,
+// except for downloads that are not valid FIT files, which fail permanently
+// without being stored or forwarded. Upload and forward are independent of
+// each other, so a failure in one does not stop the other; their errors are
+// joined. Only the steps fail the job.
+func (p *Pipeline) ProcessWithSteps(ctx context.Context, wahooWorkout WahooCloudApiResponseBody, decision Decision, steps queue.StepRecorder) error {
+	p.recordWorkout(ctx, wahooWorkout)
+	err := p.process(ctx, wahooWorkout, decision, steps)
+	p.recordStatus(ctx, wahooWorkout, err)
+	return err
+}
+
+func (p *Pipeline) process(ctx context.Context, wahooWorkout WahooCloudApiResponseBody, decision Decision, steps queue.StepRecorder) error {
+	steps.Record(ctx, StepDownload, queue.StepRunning, nil)
+	// Download the fit file once for both S3 and external service
+	var reader *bytes.Reader
+	err := p.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		reader, err = utils.DownloadFitFileContentsToBuffer(wahooWorkout.WorkoutSummary.File.URL)
+		var invalid *utils.InvalidFitFileError
+		if errors.As(err, &invalid) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+	if err != nil {
+		var invalid *utils.InvalidFitFileError
+		if errors.As(err, &invalid) {
+			stats.Add("invalid_fit_files", 1)
+		}
+		err = fmt.Errorf("error downloading fit file: %w", err)
+		steps.Record(ctx, StepDownload, queue.StepFailed, err)
+		return err
+	}
+
+	// Store the bytes for reuse
+	fileBytes := make([]byte, reader.Len())
+	_, err = reader.Read(fileBytes)
+	if err != nil {
+		err = fmt.Errorf("error reading file bytes: %w", err)
+		steps.Record(ctx, StepDownload, queue.StepFailed, err)
+		return err
+	}
+	steps.Record(ctx, StepDownload, queue.StepSucceeded, nil)
+
+	fileName := strconv.Itoa(wahooWorkout.WorkoutSummary.Workout.ID) + ".fit"
+	workoutType := wahooWorkout.WorkoutSummary.Workout.Type()
+
+	var analysis *Analysis
+	if p.needsAnalysis() {
+		analysis, err = p.analyse(ctx, wahooWorkout, fileBytes)
+		if err != nil {
+			log.Printf("Error analysing workout %d: %v", wahooWorkout.WorkoutSummary.Workout.ID, err)
+			stats.Add("analysis_failed", 1)
+		} else if err := p.trackRecords(ctx, analysis); err != nil {
+			log.Printf("Error tracking personal records for workout %d: %v", wahooWorkout.WorkoutSummary.Workout.ID, err)
+			stats.Add("analysis_failed", 1)
+		} else if len(analysis.PersonalRecords) > 0 {
+			log.Printf("Workout %d set %d personal records", wahooWorkout.WorkoutSummary.Workout.ID, len(analysis.PersonalRecords))
+			stats.Add("personal_records", int64(len(analysis.PersonalRecords)))
+		}
+	}
+	if err := p.recordLoad(ctx, wahooWorkout, analysis); err != nil {
+		log.Printf("Error recording training load for workout %d: %v", wahooWorkout.WorkoutSummary.Workout.ID, err)
+		stats.Add("analysis_failed", 1)
+	}
+
+	var errs []error
+	if p.storage != nil {
+		steps.Record(ctx, StepUpload, queue.StepRunning, nil)
+		if err := p.store(ctx, wahooWorkout, fileBytes, analysis); err != nil {
+			steps.Record(ctx, StepUpload, queue.StepFailed, err)
+			errs = append(errs, err)
+		} else {
+			steps.Record(ctx, StepUpload, queue.StepSucceeded, nil)
+		}
+	} else {
+		steps.Record(ctx, StepUpload, queue.StepSkipped, nil)
+	}
+
+	// POST file to external service if URL is configured
+	switch {
+	case p.externalServiceURL == "":
+		log.Println("No external service URL configured; skipping POST to send fit file data.")
+		steps.Record(ctx, StepForward, queue.StepSkipped, nil)
+	case !p.forwardTypes.Match(workoutType):
+		log.Printf("Workout %d is %s, which FORWARD_WORKOUT_TYPES excludes; skipping POST to send fit file data.",
+			wahooWorkout.WorkoutSummary.Workout.ID, workoutType)
+		stats.Add("forward_filtered", 1)
+		steps.Record(ctx, StepForward, queue.StepSkipped, nil)
+	default:
+		steps.Record(ctx, StepForward, queue.StepRunning, nil)
+		err = p.retry.Do(ctx, func(ctx context.Context) error {
+			return utils.PostFitFileToExternalService(fileBytes, fileName, p.externalServiceURL, map[string]string{
+				"status":         string(decision),
+				"workout_type":   workoutType.Name,
+				"workout_family": string(workoutType.Family),
+				"indoor":         strconv.FormatBool(workoutType.Indoor),
+			})
+		})
+		if err != nil {
+			err = fmt.Errorf("error posting file to external service: %w", err)
+			steps.Record(ctx, StepForward, queue.StepFailed, err)
+			errs = append(errs, err)
+		} else {
+			steps.Record(ctx, StepForward, queue.StepSucceeded, nil)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// WithWorkoutStore records every workout the pipeline processes, and the
+// outcome, in store.
+func WithWorkoutStore(store workouts.Store) PipelineOption {
+	return func(p *Pipeline) {
+		p.workouts = store
+	}
+}
+
+// recordWorkout saves the workout to the workout store as being processed,
+// for recordStatus to update with the outcome. Failing to save it is logged
+// but does not fail the job.
+func (p *Pipeline) recordWorkout(ctx context.Context, wahooWorkout WahooCloudApiResponseBody) {
+	if p.workouts == nil {
+		return
+	}
+
+	summary := wahooWorkout.WorkoutSummary
+	body, err := json.Marshal(summary)
+	if err != nil {
+		log.Printf("Error encoding workout %d for the workout store: %v", summary.Workout.ID, err)
+		stats.Add("workout_store_failed", 1)
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	record := workouts.Record{
+		WorkoutID:     summary.Workout.ID,
+		SummaryID:     summary.ID,
+		UserID:        wahooWorkout.User.ID,
+		Name:          summary.Workout.Name,
+		WorkoutTypeID: summary.Workout.WorkoutTypeID,
+		Starts:        starts(summary),
+		Status:        workouts.StatusProcessing,
+		Summary:       body,
+		ReceivedAt:    now,
+		UpdatedAt:     now,
+	}
+	if distance, ok := summary.DistanceAccum.Float64(); ok {
+		record.DistanceMeters = &distance
+	}
+	if duration, ok := summary.DurationTotalAccum.Float64(); ok {
+		record.DurationSeconds = &duration
+	}
+	if p.storage != nil {
+		record.StorageKey = p.Key(wahooWorkout)
+	}
+	if err := p.workouts.Save(ctx, record); err != nil {
+		log.Printf("Error saving workout %d to the workout store: %v", summary.Workout.ID, err)
+		stats.Add("workout_store_failed", 1)
+	}
+}
+
+// recordStatus saves the outcome of processing the workout to the workout
+// store.
+func (p *Pipeline) recordStatus(ctx context.Context, wahooWorkout WahooCloudApiResponseBody, processErr error) {
+	if p.workouts == nil {
+		return
+	}
+
+	status, message := workouts.StatusProcessed, ""
+	if processErr != nil {
+		status, message = workouts.StatusFailed, processErr.Error()
+	}
+	workoutID := wahooWorkout.WorkoutSummary.Workout.ID
+	if err := p.workouts.SetStatus(ctx, workoutID, status, message); err != nil {
+		log.Printf("Error saving status of workout %d to the workout store: %v", workoutID, err)
+		stats.Add("workout_store_failed", 1)
+	}
+}
+
+// Key returns the storage key of the event's FIT file.
+func (p *Pipeline) Key(wahooWorkout WahooCloudApiResponseBody) string {
+	summary := wahooWorkout.WorkoutSummary
+	return p.keys.Key(storage.KeyFields{
+		UserID:    wahooWorkout.User.ID,
+		WorkoutID: summary.Workout.ID,
+		SummaryID: summary.ID,
+		Starts:    starts(summary),
+	})
+}
+
+// starts is when the workout started, falling back to when the summary was
+// created for workouts without a start time.
+func starts(summary WorkoutSummary) time.Time {
+	if summary.Workout.Starts.IsZero() {
+		return summary.CreatedAt
+	}
+	return summary.Workout.Starts
+}
+
+// store writes the FIT file with descriptive object metadata, followed by a
+// JSON sidecar of the workout summary, its analysis if there is one, and the
+// workout's index entries. An update that moves the workout to another day
+// removes its entry under the old one, and one that moves it to another key
+// removes the files stored under the old key.
+func (p *Pipeline) store(ctx context.Context, wahooWorkout WahooCloudApiResponseBody, fileBytes []byte, analysis *Analysis) error {
+	key := p.Key(wahooWorkout)
+	summary := wahooWorkout.WorkoutSummary
+
+	previousKey, previousDateKey, err := p.previousKeys(ctx, summary.Workout.ID)
+	if err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(fileBytes)
+	workoutType := summary.Workout.Type()
+	metadata := map[string]string{
+		"workout-name":    mime.QEncoding.Encode("utf-8", summary.Workout.Name),
+		"workout-type-id": strconv.Itoa(summary.Workout.WorkoutTypeID),
+		"workout-type":    workoutType.Name,
+		"workout-family":  string(workoutType.Family),
+		"workout-indoor":  strconv.FormatBool(workoutType.Indoor),
+		"workout-starts":  starts(summary).UTC().Format(time.RFC3339),
+		"sha256":          hex.EncodeToString(checksum[:]),
+	}
+
+	err = p.retry.Do(ctx, func(ctx context.Context) error {
+		return p.storage.Put(ctx, key, fileBytes, storage.PutOptions{ContentType: fitContentType, Metadata: metadata})
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Successfully stored %s", key)
+
+	sidecar, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding sidecar for %s: %w", key, err)
+	}
+	err = p.retry.Do(ctx, func(ctx context.Context) error {
+		return p.storage.Put(ctx, storage.SidecarKey(key), sidecar, storage.PutOptions{ContentType: "application/json"})
+	})
+	if err != nil {
+		return err
+	}
+
+	if analysis != nil {
+		body, err := json.MarshalIndent(analysis, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding analysis for %s: %w", key, err)
+		}
+		err = p.retry.Do(ctx, func(ctx context.Context) error {
+			return p.storage.Put(ctx, storage.AnalysisKey(key), body, storage.PutOptions{ContentType: "application/json"})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Written last, so the indexes only point at files that are fully stored.
+	dateKey := storage.DateIndexKey(starts(summary), summary.Workout.ID)
+	for _, indexKey := range []string{storage.IndexKey(summary.Workout.ID), dateKey} {
+		err := p.retry.Do(ctx, func(ctx context.Context) error {
+			return p.storage.Put(ctx, indexKey, []byte(key), storage.PutOptions{ContentType: "text/plain"})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var stale []string
+	if previousDateKey != "" && previousDateKey != dateKey {
+		stale = append(stale, previousDateKey)
+	}
+	if previousKey != "" && previousKey != key {
+		stale = append(stale, previousKey, storage.SidecarKey(previousKey), storage.AnalysisKey(previousKey))
+	}
+	for _, staleKey := range stale {
+		err := p.retry.Do(ctx, func(ctx context.Context) error {
+			return p.storage.Delete(ctx, staleKey)
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting %s: %w", staleKey, err)
+		}
+	}
+	return nil
+}
+
+// previousKeys returns the key the workout's FIT file was stored under when it
+// was last stored, as its index points at, and the date index key it was filed
+// under, found from the sidecar stored with it. Either is "" if there is
+// nothing stored to find it from.
+func (p *Pipeline) previousKeys(ctx context.Context, workoutID int) (string, string, error) {
+	fitKey, _, err := p.storage.Get(ctx, storage.IndexKey(workoutID))
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("error loading index of workout %d: %w", workoutID, err)
+	}
+	sidecar, _, err := p.storage.Get(ctx, storage.SidecarKey(string(fitKey)))
+	if errors.Is(err, storage.ErrNotFound) {
+		return string(fitKey), "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("error loading sidecar of workout %d: %w", workoutID, err)
+	}
+	var previous WorkoutSummary
+	if err := json.Unmarshal(sidecar, &previous); err != nil {
+		return "", "", fmt.Errorf("error decoding sidecar of workout %d: %w", workoutID, err)
+	}
+	return string(fitKey), storage.DateIndexKey(starts(previous), workoutID), nil
+}
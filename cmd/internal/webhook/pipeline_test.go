@@ -0,0 +1,613 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/queue"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/metrics"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/retry"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/storage"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/units"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestFitFile(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("../../pkg/utils/testdata/small-fit-file.fit")
+	require.NoError(t, err)
+	return data
+}
+
+// testPipeline returns a pipeline that stores files under the default key
+// template.
+func testPipeline(t *testing.T, backend storage.Backend, opts ...PipelineOption) *Pipeline {
+	t.Helper()
+	pipeline, err := NewPipeline(backend, storage.KeyTemplate{}, opts...)
+	require.NoError(t, err)
+	return pipeline
+}
+
+func TestPipeline_ForwardsDownloadedFitFile(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	var forwardedName, forwardedStatus, forwardedType, forwardedFamily string
+	var forwardedBody []byte
+	externalService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		require.NoError(t, err)
+		forwardedName = header.Filename
+		forwardedBody, _ = io.ReadAll(file)
+		forwardedStatus = r.FormValue("status")
+		forwardedType = r.FormValue("workout_type")
+		forwardedFamily = r.FormValue("workout_family")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer externalService.Close()
+
+	t.Setenv("FITFILE_SERVICE_URL", externalService.URL)
+
+	wahooWorkout := WahooCloudApiResponseBody{
+		EventType: "workout_summary",
+		User:      User{ID: 1120489},
+		WorkoutSummary: WorkoutSummary{
+			ID:   252869305,
+			File: File{URL: fitServer.URL + "/file.fit"},
+			Workout: Workout{
+				ID:     281788767,
+				Name:   "Morning Ride – Côte",
+				Starts: time.Date(2024, 4, 12, 17, 34, 45, 0, time.UTC),
+			},
+		},
+	}
+
+	backend := storage.NewMemoryBackend()
+	err := testPipeline(t, backend).Process(context.Background(), wahooWorkout)
+	require.NoError(t, err)
+
+	stored, object, err := backend.Get(context.Background(), "1120489/2024/04/281788767-252869305.fit")
+	require.NoError(t, err)
+	require.Equal(t, fitFile, stored)
+	require.Equal(t, "application/vnd.ant.fit", object.ContentType)
+	require.Equal(t, "2024-04-12T17:34:45Z", object.Metadata["workout-starts"])
+	require.Equal(t, "0", object.Metadata["workout-type-id"])
+	require.Equal(t, "biking", object.Metadata["workout-type"])
+	require.Equal(t, "biking", object.Metadata["workout-family"])
+	require.Equal(t, "false", object.Metadata["workout-indoor"])
+	require.Equal(t, "0fbd4fc805ced58b7d90b7f1ffeb55f1373c23562420fdb8e003fdaa4e70e954", object.Metadata["sha256"])
+
+	name, err := new(mime.WordDecoder).DecodeHeader(object.Metadata["workout-name"])
+	require.NoError(t, err)
+	require.Equal(t, "Morning Ride – Côte", name)
+
+	sidecar, _, err := backend.Get(context.Background(), "1120489/2024/04/281788767-252869305.json")
+	require.NoError(t, err)
+	var summary WorkoutSummary
+	require.NoError(t, json.Unmarshal(sidecar, &summary))
+	require.Equal(t, wahooWorkout.WorkoutSummary, summary)
+
+	stored, _, err = backend.Get(context.Background(), "1120489/2024/04/281788767-252869305.analysis.json")
+	require.NoError(t, err)
+	var analysis Analysis
+	require.NoError(t, json.Unmarshal(stored, &analysis))
+	require.Equal(t, 281788767, analysis.WorkoutID)
+	require.Equal(t, FTPFromFitFile, analysis.FTPSource)
+	require.Equal(t, 227.0, analysis.Power.FTP)
+	require.Equal(t, 79.4, analysis.Power.NormalizedPower)
+	require.Equal(t, 0.2, analysis.Power.TSS)
+
+	index, _, err := backend.Get(context.Background(), "index/workouts/281788767")
+	require.NoError(t, err)
+	require.Equal(t, "1120489/2024/04/281788767-252869305.fit", string(index))
+
+	index, _, err = backend.Get(context.Background(), "index/dates/2024-04-12/281788767")
+	require.NoError(t, err)
+	require.Equal(t, "1120489/2024/04/281788767-252869305.fit", string(index))
+	require.Equal(t, "281788767.fit", forwardedName)
+	require.Equal(t, fitFile, forwardedBody)
+	require.Equal(t, "new", forwardedStatus)
+	require.Equal(t, "biking", forwardedType)
+	require.Equal(t, "biking", forwardedFamily)
+}
+
+func TestPipeline_MovesStoredFilesWithStart(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	ctx := context.Background()
+	wahooWorkout := WahooCloudApiResponseBody{
+		User: User{ID: 1120489},
+		WorkoutSummary: WorkoutSummary{
+			ID:      252869305,
+			File:    File{URL: fitServer.URL + "/file.fit"},
+			Workout: Workout{ID: 281788767, Starts: time.Date(2024, 4, 30, 23, 30, 0, 0, time.UTC)},
+		},
+	}
+
+	backend := storage.NewMemoryBackend()
+	pipeline := testPipeline(t, backend)
+	require.NoError(t, pipeline.Process(ctx, wahooWorkout))
+
+	// Wahoo moves the start past midnight, into the next month's key.
+	wahooWorkout.WorkoutSummary.Workout.Starts = time.Date(2024, 5, 1, 0, 30, 0, 0, time.UTC)
+	require.NoError(t, pipeline.ProcessWithSteps(ctx, wahooWorkout, DecisionUpdated, queue.NopSteps))
+
+	objects, err := backend.List(ctx, "")
+	require.NoError(t, err)
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	require.Equal(t, []string{
+		"1120489/2024/05/281788767-252869305.analysis.json",
+		"1120489/2024/05/281788767-252869305.fit",
+		"1120489/2024/05/281788767-252869305.json",
+		"index/dates/2024-05-01/281788767",
+		"index/workouts/281788767",
+	}, keys)
+
+	index, _, err := backend.Get(ctx, storage.IndexKey(281788767))
+	require.NoError(t, err)
+	require.Equal(t, "1120489/2024/05/281788767-252869305.fit", string(index))
+}
+
+func TestPipeline_FallsBackToCreatedAtForStart(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	ctx := context.Background()
+	wahooWorkout := WahooCloudApiResponseBody{
+		User: User{ID: 1120489},
+		WorkoutSummary: WorkoutSummary{
+			ID:        252869305,
+			File:      File{URL: fitServer.URL + "/file.fit"},
+			CreatedAt: time.Date(2024, 3, 31, 18, 5, 0, 0, time.UTC),
+			Workout:   Workout{ID: 281788767},
+		},
+	}
+
+	backend := storage.NewMemoryBackend()
+	require.NoError(t, testPipeline(t, backend).Process(ctx, wahooWorkout))
+
+	_, object, err := backend.Get(ctx, "1120489/2024/03/281788767-252869305.fit")
+	require.NoError(t, err)
+	require.Equal(t, "2024-03-31T18:05:00Z", object.Metadata["workout-starts"])
+	_, _, err = backend.Get(ctx, "index/dates/2024-03-31/281788767")
+	require.NoError(t, err)
+}
+
+func TestJobHandler_RecordsSteps(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	forwardAttempts := 0
+	externalService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardAttempts++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer externalService.Close()
+
+	t.Setenv("FITFILE_SERVICE_URL", externalService.URL)
+	t.Setenv("RETRY_MAX_ATTEMPTS", "3")
+	t.Setenv("RETRY_BASE_DELAY", "1ms")
+
+	ctx := context.Background()
+	jobs := queue.NewMemoryQueue()
+	deadLetters := queue.NewMemoryDeadLetterStore()
+	payload := `{"event_type":"workout_summary","user":{"id":1120489},"workout_summary":{"id":252869305,"file":{"url":"` +
+		fitServer.URL + `/file.fit"},"workout":{"id":281788767}}}`
+	_, err := jobs.Enqueue(ctx, JobKind, json.RawMessage(payload))
+	require.NoError(t, err)
+
+	workers := queue.NewWorkers(jobs, deadLetters, 1)
+	workers.SetRetryPolicy(retry.Policy{MaxAttempts: 2})
+	workers.Handle(JobKind, JobHandler(testPipeline(t, nil)))
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	require.NoError(t, workers.Start(workerCtx))
+
+	var job queue.Job
+	require.Eventually(t, func() bool {
+		job, err = jobs.Get(ctx, 1)
+		return err == nil && job.Status == queue.JobFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	workers.Wait()
+
+	require.Len(t, job.Steps, 3)
+	require.Equal(t, queue.StepSucceeded, job.Steps[0].Status)
+	require.Equal(t, queue.StepSkipped, job.Steps[1].Status)
+	require.Equal(t, StepForward, job.Steps[2].Name)
+	require.Equal(t, queue.StepFailed, job.Steps[2].Status)
+	require.Contains(t, job.LastError, "external service")
+	// Both attempts at the job retried the forward step three times.
+	require.Equal(t, 2, job.Attempts)
+	require.Equal(t, 6, forwardAttempts)
+
+	letters, err := deadLetters.List(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, job.ID, letters[0].JobID)
+	require.JSONEq(t, payload, string(letters[0].Payload))
+	require.Len(t, letters[0].Steps, 3)
+}
+
+func TestPipeline_DoesNotRetryClientErrors(t *testing.T) {
+
+	downloadAttempts := 0
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloadAttempts++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer fitServer.Close()
+
+	t.Setenv("RETRY_BASE_DELAY", "1ms")
+
+	wahooWorkout := WahooCloudApiResponseBody{
+		WorkoutSummary: WorkoutSummary{
+			File:    File{URL: fitServer.URL + "/file.fit"},
+			Workout: Workout{ID: 281788767},
+		},
+	}
+
+	err := testPipeline(t, nil).Process(context.Background(), wahooWorkout)
+	require.Error(t, err)
+	require.Equal(t, 1, downloadAttempts)
+}
+
+func TestJobHandler_RejectsInvalidFitFiles(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	corrupt := append([]byte{}, fitFile...)
+	corrupt[100] ^= 0xFF
+
+	testCases := []struct {
+		name string
+		body []byte
+	}{
+		{name: "ErrorPage", body: []byte("<html><body>Service unavailable</body></html>")},
+		{name: "Truncated", body: fitFile[:len(fitFile)/2]},
+		{name: "Corrupt", body: corrupt},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			downloadAttempts := 0
+			fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				downloadAttempts++
+				_, _ = w.Write(tc.body)
+			}))
+			defer fitServer.Close()
+
+			forwarded := false
+			externalService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+			}))
+			defer externalService.Close()
+
+			t.Setenv("FITFILE_SERVICE_URL", externalService.URL)
+			t.Setenv("RETRY_BASE_DELAY", "1ms")
+
+			ctx := context.Background()
+			jobs := queue.NewMemoryQueue()
+			payload := `{"workout_summary":{"id":252869305,"file":{"url":"` + fitServer.URL + `/file.fit"},"workout":{"id":281788767}}}`
+			job, err := jobs.Enqueue(ctx, JobKind, json.RawMessage(payload))
+			require.NoError(t, err)
+
+			backend := storage.NewMemoryBackend()
+			invalidBefore := counterValue("invalid_fit_files")
+			workers := queue.NewWorkers(jobs, queue.NewMemoryDeadLetterStore(), 1)
+			workers.Handle(JobKind, JobHandler(testPipeline(t, backend)))
+			workerCtx, cancel := context.WithCancel(ctx)
+			require.NoError(t, workers.Start(workerCtx))
+
+			require.Eventually(t, func() bool {
+				job, err = jobs.Get(ctx, job.ID)
+				return err == nil && job.Status == queue.JobFailed
+			}, 5*time.Second, 10*time.Millisecond)
+			cancel()
+			workers.Wait()
+
+			require.Len(t, job.Steps, 1)
+			require.Equal(t, StepDownload, job.Steps[0].Name)
+			require.Equal(t, queue.StepFailed, job.Steps[0].Status)
+			require.True(t, job.Steps[0].Permanent)
+			require.Contains(t, job.LastError, "invalid fit file")
+			require.Equal(t, 1, downloadAttempts, "invalid files are not retried")
+			require.Equal(t, 1, job.Attempts, "jobs with invalid files are not retried")
+			require.False(t, forwarded)
+			require.Equal(t, invalidBefore+1, counterValue("invalid_fit_files"))
+
+			objects, err := backend.List(ctx, "")
+			require.NoError(t, err)
+			require.Empty(t, objects)
+
+			var invalid *utils.InvalidFitFileError
+			_, err = utils.DownloadFitFileContentsToBuffer(fitServer.URL + "/file.fit")
+			require.ErrorAs(t, err, &invalid)
+		})
+	}
+}
+
+func TestPipeline_FiltersForwardedWorkoutTypes(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	forwarded := 0
+	externalService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer externalService.Close()
+
+	t.Setenv("FITFILE_SERVICE_URL", externalService.URL)
+	t.Setenv("FORWARD_WORKOUT_TYPES", "biking,indoor")
+
+	testCases := []struct {
+		name          string
+		workoutTypeID int
+		forwarded     bool
+	}{
+		{name: "indoor trainer", workoutTypeID: 61, forwarded: true},
+		{name: "outdoor ride", workoutTypeID: 15, forwarded: false},
+		{name: "treadmill run", workoutTypeID: 5, forwarded: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forwarded = 0
+			filteredBefore := counterValue("forward_filtered")
+			wahooWorkout := WahooCloudApiResponseBody{
+				WorkoutSummary: WorkoutSummary{
+					File:    File{URL: fitServer.URL + "/file.fit"},
+					Workout: Workout{ID: 281788767, WorkoutTypeID: tc.workoutTypeID},
+				},
+			}
+
+			var steps recordedSteps
+			err := testPipeline(t, nil).ProcessWithSteps(context.Background(), wahooWorkout, DecisionNew, &steps)
+			require.NoError(t, err)
+
+			if tc.forwarded {
+				require.Equal(t, 1, forwarded)
+				require.Equal(t, queue.StepSucceeded, steps[StepForward])
+				require.Equal(t, filteredBefore, counterValue("forward_filtered"))
+			} else {
+				require.Equal(t, 0, forwarded)
+				require.Equal(t, queue.StepSkipped, steps[StepForward])
+				require.Equal(t, filteredBefore+1, counterValue("forward_filtered"))
+			}
+		})
+	}
+
+	// A typo must not silently forward every workout.
+	t.Setenv("FORWARD_WORKOUT_TYPES", "biking,runing")
+	_, err := NewPipeline(nil, storage.KeyTemplate{})
+	require.Error(t, err)
+}
+
+func TestNewPipeline_RejectsInvalidDefaultFTP(t *testing.T) {
+
+	for _, value := range []string{"250W", "-1"} {
+		t.Setenv("DEFAULT_FTP", value)
+		_, err := NewPipeline(nil, storage.KeyTemplate{})
+		require.ErrorContains(t, err, "DEFAULT_FTP", value)
+	}
+
+	t.Setenv("DEFAULT_FTP", "250")
+	pipeline, err := NewPipeline(nil, storage.KeyTemplate{})
+	require.NoError(t, err)
+	require.Equal(t, 250, pipeline.defaultFTP)
+}
+
+func TestPipeline_AnalysesWithProfileFTP(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	ctx := context.Background()
+	profiles := athletes.NewMemoryProfileStore()
+	require.NoError(t, profiles.Save(ctx, athletes.Profile{UserID: 1120489, FTP: 160}))
+
+	wahooWorkout := WahooCloudApiResponseBody{
+		User: User{ID: 1120489},
+		WorkoutSummary: WorkoutSummary{
+			ID:      252869305,
+			File:    File{URL: fitServer.URL + "/file.fit"},
+			Workout: Workout{ID: 281788767, Starts: time.Date(2024, 4, 9, 20, 40, 25, 0, time.UTC)},
+		},
+	}
+
+	backend := storage.NewMemoryBackend()
+	err := testPipeline(t, backend, WithProfiles(profiles)).Process(ctx, wahooWorkout)
+	require.NoError(t, err)
+
+	stored, _, err := backend.Get(ctx, "1120489/2024/04/281788767-252869305.analysis.json")
+	require.NoError(t, err)
+	var analysis Analysis
+	require.NoError(t, json.Unmarshal(stored, &analysis))
+	require.Equal(t, FTPFromProfile, analysis.FTPSource)
+	require.Equal(t, 160.0, analysis.Power.FTP)
+	require.Equal(t, 0.496, analysis.Power.IntensityFactor)
+}
+
+func TestPipeline_FlagsPersonalRecords(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	ctx := context.Background()
+	starts := time.Date(2024, 4, 9, 20, 40, 25, 0, time.UTC)
+	curves := athletes.NewMemoryCurveStore()
+	previous := athletes.WorkoutCurve{WorkoutID: 1, UserID: 1120489, Starts: starts.Add(-100 * 24 * time.Hour),
+		Curve: metrics.Curve{{Duration: 1, Watts: 150}, {Duration: 5, Watts: 140}}}
+	require.NoError(t, curves.SaveCurve(ctx, previous))
+	recent := athletes.WorkoutCurve{WorkoutID: 2, UserID: 1120489, Starts: starts.Add(-10 * 24 * time.Hour),
+		Curve: metrics.Curve{{Duration: 1, Watts: 500}, {Duration: 5, Watts: 130}}}
+	require.NoError(t, curves.SaveCurve(ctx, recent))
+
+	wahooWorkout := WahooCloudApiResponseBody{
+		User: User{ID: 1120489},
+		WorkoutSummary: WorkoutSummary{
+			ID:      252869305,
+			File:    File{URL: fitServer.URL + "/file.fit"},
+			Workout: Workout{ID: 281788767, Starts: starts},
+		},
+	}
+
+	backend := storage.NewMemoryBackend()
+	pipeline := testPipeline(t, backend, WithPowerCurves(curves))
+	require.NoError(t, pipeline.Process(ctx, wahooWorkout))
+
+	stored, _, err := backend.Get(ctx, "1120489/2024/04/281788767-252869305.analysis.json")
+	require.NoError(t, err)
+	var analysis Analysis
+	require.NoError(t, json.Unmarshal(stored, &analysis))
+
+	// The 1s best of 176W is short of 500W, while the 5s best beats both the
+	// all-time and the 90 day record, and no earlier workout has a 10s best.
+	require.Equal(t, []PersonalRecord{
+		{Window: RecordAllTime, Duration: 5, Watts: 159.6, Previous: athletes.PersonalRecord{Duration: 5, Watts: 140, WorkoutID: 1, Starts: previous.Starts}},
+		{Window: RecordLast90Days, Duration: 5, Watts: 159.6, Previous: athletes.PersonalRecord{Duration: 5, Watts: 130, WorkoutID: 2, Starts: recent.Starts}},
+	}, analysis.PersonalRecords)
+
+	best, err := curves.Best(ctx, 1120489, starts, starts.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, best, len(analysis.PowerCurve))
+
+	// Reprocessing an update compares against the earlier workouts, not itself.
+	require.NoError(t, pipeline.ProcessWithSteps(ctx, wahooWorkout, DecisionUpdated, queue.NopSteps))
+	stored, _, err = backend.Get(ctx, "1120489/2024/04/281788767-252869305.analysis.json")
+	require.NoError(t, err)
+	var updated Analysis
+	require.NoError(t, json.Unmarshal(stored, &updated))
+	require.Equal(t, analysis.PersonalRecords, updated.PersonalRecords)
+}
+
+func TestPipeline_RecordsTrainingLoad(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	ctx := context.Background()
+	loads := athletes.NewMemoryPMCStore()
+	wahooWorkout := WahooCloudApiResponseBody{
+		User: User{ID: 1120489},
+		WorkoutSummary: WorkoutSummary{
+			ID:      252869305,
+			File:    File{URL: fitServer.URL + "/file.fit"},
+			Workout: Workout{ID: 281788767, Starts: time.Date(2024, 4, 9, 20, 40, 25, 0, time.UTC)},
+		},
+	}
+
+	pipeline := testPipeline(t, storage.NewMemoryBackend(), WithTrainingLoad(loads))
+	require.NoError(t, pipeline.Process(ctx, wahooWorkout))
+
+	// The TSS is computed against the FTP recorded in the file.
+	daily, err := loads.DailyLoads(ctx, 1120489, "")
+	require.NoError(t, err)
+	require.Equal(t, []athletes.DailyLoad{{Date: "2024-04-09", TSS: 0.2}}, daily)
+
+	stale, err := loads.Stale(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "2024-04-09", stale[0].Since)
+}
+
+func TestPipeline_RecordsWorkouts(t *testing.T) {
+
+	fitFile := readTestFitFile(t)
+	fitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.fit" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write(fitFile)
+	}))
+	defer fitServer.Close()
+
+	ctx := context.Background()
+	store := workouts.NewMemoryStore()
+	pipeline := testPipeline(t, storage.NewMemoryBackend(), WithWorkoutStore(store))
+
+	wahooWorkout := WahooCloudApiResponseBody{
+		User: User{ID: 1120489},
+		WorkoutSummary: WorkoutSummary{
+			ID:            252869305,
+			DistanceAccum: units.Metres{Decimal: units.NewDecimal(24323.54)},
+			File:          File{URL: fitServer.URL + "/file.fit"},
+			Workout:       Workout{ID: 281788767, Name: "Cycling", WorkoutTypeID: 61, Starts: time.Date(2024, 4, 9, 20, 40, 25, 0, time.UTC)},
+		},
+	}
+	require.NoError(t, pipeline.Process(ctx, wahooWorkout))
+
+	wahooWorkout.WorkoutSummary.ID = 252869306
+	wahooWorkout.WorkoutSummary.File.URL = fitServer.URL + "/missing.fit"
+	wahooWorkout.WorkoutSummary.Workout.ID = 281788768
+	require.Error(t, pipeline.Process(ctx, wahooWorkout))
+
+	page, err := store.List(ctx, workouts.Query{UserID: 1120489})
+	require.NoError(t, err)
+	require.Len(t, page.Workouts, 2)
+
+	processed := page.Workouts[0]
+	require.Equal(t, 281788767, processed.WorkoutID)
+	require.Equal(t, workouts.StatusProcessed, processed.Status)
+	require.Equal(t, "biking_indoor_trainer", processed.WorkoutType)
+	require.Equal(t, 24323.54, *processed.DistanceMeters)
+	require.Nil(t, processed.DurationSeconds)
+	require.Equal(t, "1120489/2024/04/281788767-252869305.fit", processed.StorageKey)
+
+	failed := page.Workouts[1]
+	require.Equal(t, workouts.StatusFailed, failed.Status)
+	require.Contains(t, failed.Error, "error downloading fit file")
+}
+
+// recordedSteps keeps the last status recorded for each step.
+type recordedSteps map[string]queue.StepStatus
+
+func (r *recordedSteps) Record(_ context.Context, name string, status queue.StepStatus, _ error) {
+	if *r == nil {
+		*r = make(recordedSteps)
+	}
+	(*r)[name] = status
+}
@@ -1,21 +1,19 @@
 package webhook
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/go-playground/validator/v10"
-	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/utils"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/queue"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/keylock"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/units"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/workouttype"
 )
 
 type User struct {
@@ -26,25 +24,29 @@ type File struct {
 	URL string `json:"url"`
 }
 
+// WorkoutSummary is the summary Wahoo computes for a workout. Metrics the
+// device did not record are null; see the units package for how they are
+// decoded and re-encoded.
 type WorkoutSummary struct {
-	ID                  int       `json:"id"`
-	AscentAccum         string    `json:"ascent_accum"`
-	CadenceAvg          string    `json:"cadence_avg"`
-	CaloriesAccum       string    `json:"calories_accum"`
-	DistanceAccum       string    `json:"distance_accum"`
-	DurationActiveAccum string    `json:"duration_active_accum"`
-	DurationPausedAccum string    `json:"duration_paused_accum"`
-	DurationTotalAccum  string    `json:"duration_total_accum"`
-	HeartRateAvg        string    `json:"heart_rate_avg"`
-	PowerBikeNpLast     string    `json:"power_bike_np_last"`
-	PowerBikeTssLast    string    `json:"power_bike_tss_last"`
-	PowerAvg            string    `json:"power_avg"`
-	SpeedAvg            string    `json:"speed_avg"`
-	WorkAccum           string    `json:"work_accum"`
-	CreatedAt           time.Time `json:"created_at"`
-	UpdatedAt           time.Time `json:"updated_at"`
-	File                File      `json:"file"`
-	Workout             Workout   `json:"workout"`
+	ID                  int                        `json:"id"`
+	AscentAccum         units.Metres               `json:"ascent_accum"`
+	CadenceAvg          units.RevolutionsPerMinute `json:"cadence_avg"`
+	CaloriesAccum       units.Kilocalories         `json:"calories_accum"`
+	DistanceAccum       units.Metres               `json:"distance_accum"`
+	DurationActiveAccum units.Seconds              `json:"duration_active_accum"`
+	DurationPausedAccum units.Seconds              `json:"duration_paused_accum"`
+	DurationTotalAccum  units.Seconds              `json:"duration_total_accum"`
+	HeartRateAvg        units.BeatsPerMinute       `json:"heart_rate_avg"`
+	PowerBikeNpLast     units.Watts                `json:"power_bike_np_last"`
+	// PowerBikeTssLast is a Training Stress Score, which has no unit.
+	PowerBikeTssLast units.Decimal         `json:"power_bike_tss_last"`
+	PowerAvg         units.Watts           `json:"power_avg"`
+	SpeedAvg         units.MetresPerSecond `json:"speed_avg"`
+	WorkAccum        units.Joules          `json:"work_accum"`
+	CreatedAt        time.Time             `json:"created_at"`
+	UpdatedAt        time.Time             `json:"updated_at"`
+	File             File                  `json:"file"`
+	Workout          Workout               `json:"workout"`
 }
 
 type Workout struct {
@@ -59,6 +61,11 @@ type Workout struct {
 	WorkoutTypeID int       `json:"workout_type_id"`
 }
 
+// Type looks the workout's WorkoutTypeID up in the workout type catalogue.
+func (w Workout) Type() workouttype.Type {
+	return workouttype.ByID(w.WorkoutTypeID)
+}
+
 type WahooCloudApiResponseBody struct {
 	EventType      string         `json:"event_type" validate:"required"`
 	WebhookToken   string         `json:"webhook_token" validate:"required"`
@@ -66,11 +73,23 @@ type WahooCloudApiResponseBody struct {
 	WorkoutSummary WorkoutSummary `json:"workout_summary" validate:"required"`
 }
 
-func Callback() func(w http.ResponseWriter, r *http.Request) {
+// callbackResponse echoes the event back with the ledger's decision.
+type callbackResponse struct {
+	WahooCloudApiResponseBody
+	Decision Decision `json:"decision"`
+}
+
+// Callback validates a Wahoo webhook event and enqueues it for the workers,
+// acknowledging as soon as the job is durably stored. Replays of a summary
+// already processed are acknowledged without being enqueued again.
+func Callback(jobs queue.Queue, ledger Ledger) func(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("Callback called")
+	webhookTokens := newWebhookTokenVerifier()
+	var locks keylock.Striped
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		stats.Add("received", 1)
 		enc := json.NewEncoder(w)
 		enc.SetEscapeHTML(false)
 
@@ -81,12 +100,11 @@ func Callback() func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		log.Println("Request Body: ", string(requestBody))
-
 		var wahooWorkout WahooCloudApiResponseBody
 		jErr := json.Unmarshal(requestBody, &wahooWorkout)
 		if jErr != nil {
 			fmt.Println("Error unmarshalling JSON:", jErr)
+			stats.Add("invalid_payload", 1)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
@@ -95,82 +113,83 @@ func Callback() func(w http.ResponseWriter, r *http.Request) {
 		err = tokenValidator.Struct(wahooWorkout)
 		if err != nil {
 			fmt.Println("Error unmarshalling JSON:", jErr)
+			stats.Add("invalid_payload", 1)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		// Print the decoded data
-		log.Println("Decoded data: ", wahooWorkout)
-		rEncErr := enc.Encode(wahooWorkout)
-		if rEncErr != nil {
-			fmt.Println("Error encoding JSON response:", rEncErr)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if !webhookTokens.Verify(wahooWorkout.WebhookToken) {
+			log.Printf("Rejected webhook for user %d with unknown webhook_token %s from %s",
+				wahooWorkout.User.ID, maskToken(wahooWorkout.WebhookToken), r.RemoteAddr)
+			stats.Add("rejected_token", 1)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		stats.Add("accepted", 1)
 
-		// Retrieve the environment variable
-		tigrisEnvConfigFlag := os.Getenv("TIGRIS_ENABLED")
+		log.Printf("Accepted %s event for user %d, summary %d, with webhook_token %s",
+			wahooWorkout.EventType, wahooWorkout.User.ID, wahooWorkout.WorkoutSummary.ID, maskToken(wahooWorkout.WebhookToken))
 
-		// Convert the environment variable to a boolean
-		tigrisEnabled, err := strconv.ParseBool(tigrisEnvConfigFlag)
-		if err != nil {
-			log.Printf("Could not determine if Tigiris is enabled. Here's why: %v\n", err)
-			tigrisEnabled = false //Fall back to false
-		}
+		// Serialise deliveries of the same summary so concurrent replays
+		// cannot both be treated as new.
+		summary := wahooWorkout.WorkoutSummary
+		unlock := locks.Lock(summary.ID)
+		defer unlock()
 
-		// Download the fit file once for both S3 and external service
-		reader, err := utils.DownloadFitFileContentsToBuffer(wahooWorkout.WorkoutSummary.File.URL)
+		entry, found, err := ledger.Get(r.Context(), summary.ID)
 		if err != nil {
-			fmt.Println("Error downloading file:", err)
-			http.Error(w, "Internal Server Error. Unable to download fit file.", http.StatusInternalServerError)
-			return
-		}
-
-		// Store the bytes for reuse
-		fileBytes := make([]byte, reader.Len())
-		_, err = reader.Read(fileBytes)
-		if err != nil {
-			log.Printf("Error reading file bytes: %v\n", err)
+			log.Printf("Error loading ledger entry for summary %d: %v\n", summary.ID, err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-
-		fileName := strconv.Itoa(wahooWorkout.WorkoutSummary.Workout.ID) + ".fit"
-
-		if tigrisEnabled {
-			sdkConfig, err := config.LoadDefaultConfig(context.TODO())
+		decision := entry.Decide(found, summary.UpdatedAt)
+		log.Printf("Workout summary %d (workout %d, %s, updated %s) is %s",
+			summary.ID, summary.Workout.ID, summary.Workout.Type(), summary.UpdatedAt.Format(time.RFC3339Nano), decision)
+		stats.Add("decision_"+string(decision), 1)
+		w.Header().Set("X-Webhook-Decision", string(decision))
+
+		if decision.Process() {
+			payload, err := json.Marshal(jobPayload{Decision: decision, Event: requestBody})
 			if err != nil {
-				log.Printf("Couldn't load default configuration. Here's why: %v\n", err)
+				log.Printf("Error encoding job payload: %v\n", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 				return
 			}
 
-			// Create S3 service client
-			svc := s3.NewFromConfig(sdkConfig, func(o *s3.Options) {
-				o.BaseEndpoint = aws.String("https://fly.storage.tigris.dev")
-				o.Region = "auto"
-			})
-
-			_, err = svc.PutObject(context.TODO(), &s3.PutObjectInput{
-				Bucket: aws.String(os.Getenv("BUCKET_NAME")),
-				Key:    aws.String(fileName),
-				Body:   bytes.NewReader(fileBytes),
-			})
+			job, err := jobs.Enqueue(r.Context(), JobKind, payload)
 			if err != nil {
-				log.Printf("Couldn't upload file to S3. Here's why: %v\n", err)
-			} else {
-				log.Printf("Successfully uploaded %s to S3", fileName)
+				log.Printf("Error enqueueing workout %d: %v\n", summary.Workout.ID, err)
+				stats.Add("enqueue_failed", 1)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
 			}
-		}
+			stats.Add("enqueued", 1)
+			w.Header().Set("X-Job-Id", strconv.FormatInt(job.ID, 10))
 
-		// POST file to external service if URL is configured
-		externalServiceURL := os.Getenv("FITFILE_SERVICE_URL")
-		if externalServiceURL != "" {
-			err = utils.PostFitFileToExternalService(fileBytes, fileName, externalServiceURL)
-			if err != nil {
-				log.Printf("Failed to POST file to external service: %v\n", err)
+			now := time.Now().UTC()
+			if !found {
+				entry = LedgerEntry{SummaryID: summary.ID, FirstSeenAt: now}
+			}
+			entry.WorkoutID = summary.Workout.ID
+			entry.UserID = wahooWorkout.User.ID
+			entry.UpdatedAt = summary.UpdatedAt
+			entry.Revision++
+			entry.JobID = job.ID
+			entry.LastSeenAt = now
+			// The job is already queued, so acknowledge the delivery even if
+			// the entry cannot be saved; failing it would have Wahoo redeliver
+			// a summary the ledger does not know, queueing it twice.
+			if err := ledger.Save(r.Context(), entry); err != nil {
+				log.Printf("Error recording summary %d in the ledger after queueing job %d: %v\n", summary.ID, job.ID, err)
+				stats.Add("ledger_failed", 1)
 			}
-		} else {
-			log.Println("No external service URL configured; skipping POST to send fit file data.")
+		}
+
+		rEncErr := enc.Encode(callbackResponse{WahooCloudApiResponseBody: wahooWorkout, Decision: decision})
+		if rEncErr != nil {
+			fmt.Println("Error encoding JSON response:", rEncErr)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
 	}
 }
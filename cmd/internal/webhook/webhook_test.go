@@ -1,29 +1,55 @@
 package webhook
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/queue"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWahooCallback_HappyPath(t *testing.T) {
 
+	t.Setenv("WAHOO_WEBHOOK_TOKENS", "b50faa0a-a399-40a7-9c5b-321e9af299df")
+
 	str := "{\"event_type\":\"workout_summary\",\"webhook_token\":\"b50faa0a-a399-40a7-9c5b-321e9af299df\",\"user\":{\"id\":1120489},\"workout_summary\":{\"id\":252869305,\"ascent_accum\":\"179.0\",\"cadence_avg\":\"67.0\",\"calories_accum\":\"438.0\",\"distance_accum\":\"24323.54\",\"duration_active_accum\":\"3557.0\",\"duration_paused_accum\":\"121.0\",\"duration_total_accum\":\"3678.0\",\"heart_rate_avg\":\"153.0\",\"power_bike_np_last\":\"154.0\",\"power_bike_tss_last\":\"45.0\",\"power_avg\":\"122.0\",\"speed_avg\":\"6.84\",\"work_accum\":\"435276.0\",\"created_at\":\"2024-04-12T18:36:11.000Z\",\"updated_at\":\"2024-04-12T18:36:11.000Z\",\"file\":{\"url\":\"https://cdn.wahooligan.com/wahoo-cloud/production/uploads/workout_file/file/A8dm1z2TPq-mXCZ_5KrKtg/2024-04-12-173445-ELEMNT_BOLT_A6D5-177-0.fit\"},\"workout\":{\"id\":281788767,\"starts\":\"2024-04-12T17:34:45.000Z\",\"minutes\":61,\"name\":\"Cycling\",\"created_at\":\"2024-04-12T18:36:11.000Z\",\"updated_at\":\"2024-04-12T18:36:11.000Z\",\"plan_id\":null,\"workout_token\":\"ELEMNT BOLT A6D5:177\",\"workout_type_id\":0}}}"
 	expectedResponseBody := unMarshallResponse(str)
 
 	request, _ := http.NewRequest("POST", "/webhook", strings.NewReader(str))
 
+	jobs := queue.NewMemoryQueue()
 	response := httptest.NewRecorder()
-	handler := http.HandlerFunc(Callback())
+	handler := http.HandlerFunc(Callback(jobs, NewMemoryLedger()))
 	handler.ServeHTTP(response, request)
 
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code 200, but got %v", response.Code)
 	}
 
+	job, err := jobs.Get(request.Context(), 1)
+	if err != nil {
+		t.Fatalf("Expected the workout to be enqueued: %v", err)
+	}
+	var payload jobPayload
+	_ = json.Unmarshal(job.Payload, &payload)
+	if job.Kind != JobKind || job.Status != queue.JobPending || payload.Decision != DecisionNew || string(payload.Event) != str {
+		t.Errorf("Unexpected job enqueued: %+v", job)
+	}
+	if response.Header().Get("X-Webhook-Decision") != "new" {
+		t.Errorf("Expected X-Webhook-Decision header new, but got %q", response.Header().Get("X-Webhook-Decision"))
+	}
+	if response.Header().Get("X-Job-Id") != "1" {
+		t.Errorf("Expected X-Job-Id header 1, but got %q", response.Header().Get("X-Job-Id"))
+	}
+
 	actualResponseBody := unMarshallResponse(response.Body.String())
 
 	if !reflect.DeepEqual(expectedResponseBody, actualResponseBody) {
@@ -34,6 +60,156 @@ func TestWahooCallback_HappyPath(t *testing.T) {
 	}
 }
 
+func TestWahooCallback_DeduplicatesReplays(t *testing.T) {
+
+	t.Setenv("WAHOO_WEBHOOK_TOKENS", "b50faa0a-a399-40a7-9c5b-321e9af299df")
+
+	event := func(updatedAt string) string {
+		return "{\"event_type\":\"workout_summary\",\"webhook_token\":\"b50faa0a-a399-40a7-9c5b-321e9af299df\",\"user\":{\"id\":1120489},\"workout_summary\":{\"id\":252869305,\"updated_at\":\"" + updatedAt + "\",\"file\":{\"url\":\"\"},\"workout\":{\"id\":281788767}}}"
+	}
+
+	jobs := queue.NewMemoryQueue()
+	ledger := NewMemoryLedger()
+	handler := http.HandlerFunc(Callback(jobs, ledger))
+
+	testCases := []struct {
+		name      string
+		updatedAt string
+		decision  Decision
+		jobID     string
+	}{
+		{name: "First delivery", updatedAt: "2024-04-12T18:36:11.000Z", decision: DecisionNew, jobID: "1"},
+		{name: "Replay", updatedAt: "2024-04-12T18:36:11.000Z", decision: DecisionDuplicate},
+		{name: "Update", updatedAt: "2024-04-12T19:02:45.120Z", decision: DecisionUpdated, jobID: "2"},
+		{name: "Late replay of the original", updatedAt: "2024-04-12T18:36:11.000Z", decision: DecisionStale},
+		{name: "Replay of the update", updatedAt: "2024-04-12T19:02:45.120Z", decision: DecisionDuplicate},
+	}
+
+	for _, tc := range testCases {
+		request, _ := http.NewRequest("POST", "/webhook", strings.NewReader(event(tc.updatedAt)))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Errorf("%s: expected status code 200, but got %v", tc.name, response.Code)
+		}
+		if got := response.Header().Get("X-Webhook-Decision"); got != string(tc.decision) {
+			t.Errorf("%s: expected decision %s, but got %s", tc.name, tc.decision, got)
+		}
+		if got := response.Header().Get("X-Job-Id"); got != tc.jobID {
+			t.Errorf("%s: expected job id %q, but got %q", tc.name, tc.jobID, got)
+		}
+
+		var body callbackResponse
+		_ = json.Unmarshal(response.Body.Bytes(), &body)
+		if body.Decision != tc.decision {
+			t.Errorf("%s: expected decision %s in the body, but got %s", tc.name, tc.decision, body.Decision)
+		}
+	}
+
+	entry, found, _ := ledger.Get(context.Background(), 252869305)
+	if !found || entry.Revision != 2 || entry.JobID != 2 || entry.WorkoutID != 281788767 {
+		t.Errorf("Unexpected ledger entry: %+v", entry)
+	}
+
+	job, _ := jobs.Get(context.Background(), 2)
+	var payload jobPayload
+	_ = json.Unmarshal(job.Payload, &payload)
+	if payload.Decision != DecisionUpdated {
+		t.Errorf("Expected the update to be queued as updated, but got %s", payload.Decision)
+	}
+}
+
+// failingLedger fails to save while failing is set.
+type failingLedger struct {
+	*MemoryLedger
+	failing bool
+}
+
+func (l *failingLedger) Save(ctx context.Context, entry LedgerEntry) error {
+	if l.failing {
+		return errors.New("database is locked")
+	}
+	return l.MemoryLedger.Save(ctx, entry)
+}
+
+func TestWahooCallback_AcknowledgesWhenLedgerSaveFails(t *testing.T) {
+
+	t.Setenv("WAHOO_WEBHOOK_TOKENS", "b50faa0a-a399-40a7-9c5b-321e9af299df")
+	str := "{\"event_type\":\"workout_summary\",\"webhook_token\":\"b50faa0a-a399-40a7-9c5b-321e9af299df\",\"user\":{\"id\":1120489},\"workout_summary\":{\"id\":252869305,\"updated_at\":\"2024-04-12T18:36:11.000Z\",\"file\":{\"url\":\"\"},\"workout\":{\"id\":281788767}}}"
+
+	jobs := queue.NewMemoryQueue()
+	ledger := &failingLedger{MemoryLedger: NewMemoryLedger(), failing: true}
+	handler := http.HandlerFunc(Callback(jobs, ledger))
+	failedBefore := counterValue("ledger_failed")
+
+	// The job is queued, so Wahoo must not redeliver and queue it again.
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(str)))
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %v", response.Code)
+	}
+	if response.Header().Get("X-Job-Id") != "1" {
+		t.Errorf("Expected X-Job-Id header 1, but got %q", response.Header().Get("X-Job-Id"))
+	}
+	if _, err := jobs.Get(context.Background(), 1); err != nil {
+		t.Errorf("Expected the workout to be enqueued: %v", err)
+	}
+	if got := counterValue("ledger_failed"); got != failedBefore+1 {
+		t.Errorf("Expected ledger_failed to be %d, but got %d", failedBefore+1, got)
+	}
+}
+
+func TestWahooCallback_WebhookTokenRotation(t *testing.T) {
+
+	t.Setenv("WAHOO_WEBHOOK_TOKENS", "new-token, b50faa0a-a399-40a7-9c5b-321e9af299df")
+
+	str := "{\"event_type\":\"workout_summary\",\"webhook_token\":\"b50faa0a-a399-40a7-9c5b-321e9af299df\",\"user\":{\"id\":1120489},\"workout_summary\":{\"id\":252869305,\"file\":{\"url\":\"\"},\"workout\":{\"id\":281788767}}}"
+
+	request, _ := http.NewRequest("POST", "/webhook", strings.NewReader(str))
+
+	response := httptest.NewRecorder()
+	handler := http.HandlerFunc(Callback(queue.NewMemoryQueue(), NewMemoryLedger()))
+	handler.ServeHTTP(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %v", response.Code)
+	}
+}
+
+func TestWahooCallback_InvalidWebhookToken(t *testing.T) {
+
+	testCases := []struct {
+		name          string
+		webhookTokens string
+	}{
+		{name: "Unknown token", webhookTokens: "expected-token,other-token"},
+		{name: "No tokens configured", webhookTokens: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("WAHOO_WEBHOOK_TOKENS", tc.webhookTokens)
+
+			str := "{\"event_type\":\"workout_summary\",\"webhook_token\":\"attacker-token\",\"user\":{\"id\":1120489},\"workout_summary\":{\"id\":252869305,\"file\":{\"url\":\"http://169.254.169.254/\"},\"workout\":{\"id\":281788767}}}"
+
+			request, _ := http.NewRequest("POST", "/webhook", strings.NewReader(str))
+
+			rejectedBefore := counterValue("rejected_token")
+			response := httptest.NewRecorder()
+			handler := http.HandlerFunc(Callback(queue.NewMemoryQueue(), NewMemoryLedger()))
+			handler.ServeHTTP(response, request)
+
+			if response.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status code 401, but got %v", response.Code)
+			}
+			if counterValue("rejected_token") != rejectedBefore+1 {
+				t.Errorf("Expected the rejected_token counter to be incremented")
+			}
+		})
+	}
+}
+
 func TestWahooCallback_InvalidJson(t *testing.T) {
 
 	str := "{id\":0}}}"
@@ -41,7 +217,7 @@ func TestWahooCallback_InvalidJson(t *testing.T) {
 	request, _ := http.NewRequest("POST", "/webhook", strings.NewReader(str))
 
 	response := httptest.NewRecorder()
-	handler := http.HandlerFunc(Callback())
+	handler := http.HandlerFunc(Callback(queue.NewMemoryQueue(), NewMemoryLedger()))
 	handler.ServeHTTP(response, request)
 
 	if response.Code != http.StatusInternalServerError {
@@ -56,7 +232,7 @@ func TestWahooCallback_InvalidWorkoutSummaryJson(t *testing.T) {
 	request, _ := http.NewRequest("POST", "/webhook", strings.NewReader(str))
 
 	response := httptest.NewRecorder()
-	handler := http.HandlerFunc(Callback())
+	handler := http.HandlerFunc(Callback(queue.NewMemoryQueue(), NewMemoryLedger()))
 	handler.ServeHTTP(response, request)
 
 	actualResponseBody := unMarshallResponse(response.Body.String())
@@ -66,6 +242,45 @@ func TestWahooCallback_InvalidWorkoutSummaryJson(t *testing.T) {
 	}
 }
 
+func TestWorkoutSummary_TypedMetrics(t *testing.T) {
+
+	payload := `{"id":252869305,"ascent_accum":"179.0","cadence_avg":null,"calories_accum":"438.0","distance_accum":"24323.54",` +
+		`"duration_active_accum":"3557.0","duration_paused_accum":"121.0","duration_total_accum":"3678.0","heart_rate_avg":null,` +
+		`"power_bike_np_last":"154.0","power_bike_tss_last":"45.0","power_avg":"0.0","speed_avg":"6.84","work_accum":"435276.0",` +
+		`"created_at":"2024-04-12T18:36:11Z","updated_at":"2024-04-12T18:36:11Z","file":{"url":""},` +
+		`"workout":{"id":281788767,"starts":"2024-04-12T17:34:45Z","minutes":61,"name":"Cycling","created_at":"2024-04-12T18:36:11Z",` +
+		`"updated_at":"2024-04-12T18:36:11Z","plan_id":null,"workout_token":"ELEMNT BOLT A6D5:177","workout_type_id":0}}`
+
+	var summary WorkoutSummary
+	require.NoError(t, json.Unmarshal([]byte(payload), &summary))
+
+	distance, ok := summary.DistanceAccum.Float64()
+	require.True(t, ok)
+	require.Equal(t, 24323.54, distance)
+
+	active, ok := summary.DurationActiveAccum.Duration()
+	require.True(t, ok)
+	require.Equal(t, 3557*time.Second, active)
+
+	power, ok := summary.PowerAvg.Float64()
+	require.True(t, ok, "zero is a value")
+	require.Equal(t, 0.0, power)
+	require.False(t, summary.HeartRateAvg.Valid(), "null is not zero")
+	require.False(t, summary.CadenceAvg.Valid())
+
+	forwarded, err := json.Marshal(summary)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(forwarded))
+}
+
+func counterValue(name string) int64 {
+	counter, ok := stats.Get(name).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return counter.Value()
+}
+
 func unMarshallResponse(wahooRequestBody string) WahooCloudApiResponseBody {
 	var wahooWorkout WahooCloudApiResponseBody
 	_ = json.Unmarshal([]byte(wahooRequestBody), &wahooWorkout)
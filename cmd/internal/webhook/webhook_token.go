@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"log"
+	"os"
+	"strings"
+)
+
+// stats exposes webhook counters on /debug/vars.
+var stats = expvar.NewMap("webhook")
+
+// webhookTokenVerifier checks the webhook_token Wahoo sends with every event
+// against the tokens configured in WAHOO_WEBHOOK_TOKENS. Several comma
+// separated tokens may be configured so they can be rotated without downtime.
+type webhookTokenVerifier struct {
+	tokens [][]byte
+}
+
+func newWebhookTokenVerifier() webhookTokenVerifier {
+	var verifier webhookTokenVerifier
+	for _, token := range strings.Split(os.Getenv("WAHOO_WEBHOOK_TOKENS"), ",") {
+		token = strings.TrimSpace(token)
+		if token != "" {
+			verifier.tokens = append(verifier.tokens, []byte(token))
+		}
+	}
+
+	if len(verifier.tokens) == 0 {
+		log.Println("No WAHOO_WEBHOOK_TOKENS configured; all webhook callbacks will be rejected.")
+	}
+	return verifier
+}
+
+// Verify compares token against every configured token in constant time.
+func (v webhookTokenVerifier) Verify(token string) bool {
+	matched := 0
+	for _, expected := range v.tokens {
+		matched |= subtle.ConstantTimeCompare([]byte(token), expected)
+	}
+	return matched == 1
+}
+
+// maskToken keeps rejected tokens recognisable in logs without leaking them.
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return "****"
+	}
+	return token[:4] + "****"
+}
@@ -0,0 +1,337 @@
+// Package workouts indexes the workouts the webhook pipeline receives, and
+// serves the FIT files it has stored, converted to other formats, and the
+// metrics computed from them.
+package workouts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/convert"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/export"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/storage"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/workouttype"
+	"goji.io/pat"
+)
+
+// LoadFitFile returns the stored FIT file of a workout, or storage.ErrNotFound
+// if the pipeline has not stored one.
+func LoadFitFile(ctx context.Context, backend storage.Backend, workoutID int) ([]byte, error) {
+	key, _, err := backend.Get(ctx, storage.IndexKey(workoutID))
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := backend.Get(ctx, string(key))
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// LoadAnalysis returns the stored training metrics of a workout as JSON, or
+// storage.ErrNotFound if the pipeline has not stored any.
+func LoadAnalysis(ctx context.Context, backend storage.Backend, workoutID int) ([]byte, error) {
+	key, _, err := backend.Get(ctx, storage.IndexKey(workoutID))
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := backend.Get(ctx, storage.AnalysisKey(string(key)))
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WorkoutsBetween returns the IDs of the stored workouts that started on the
+// UTC days from to to, inclusive, in date order. Only the months in the range
+// are listed, and each workout is returned once.
+func WorkoutsBetween(ctx context.Context, backend storage.Backend, from, to time.Time) ([]int, error) {
+	var workoutIDs []int
+	seen := make(map[int]bool)
+	from, to = from.UTC(), to.UTC()
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(to); month = month.AddDate(0, 1, 0) {
+		objects, err := backend.List(ctx, storage.DateIndexMonthPrefix(month))
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			day, workoutID, err := storage.ParseDateIndexKey(object.Key)
+			if err != nil {
+				log.Printf("Skipping unexpected index object %s: %v", object.Key, err)
+				continue
+			}
+			if !day.Before(from) && !day.After(to) && !seen[workoutID] {
+				seen[workoutID] = true
+				workoutIDs = append(workoutIDs, workoutID)
+			}
+		}
+	}
+	return workoutIDs, nil
+}
+
+// Export converts the :workout_id workout's stored FIT file to the :format
+// path parameter: gpx, tcx or geojson, or csv or parquet for its records.
+func Export(backend storage.Backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workoutID, err := strconv.Atoi(pat.Param(r, "workout_id"))
+		if err != nil {
+			http.Error(w, "Invalid workout id", http.StatusBadRequest)
+			return
+		}
+		extension := pat.Param(r, "format")
+		convertFormat, convertErr := convert.ParseFormat(extension)
+		tableFormat, tableErr := export.ParseFormat(extension)
+		if convertErr != nil && tableErr != nil {
+			http.Error(w, "Unsupported format", http.StatusNotFound)
+			return
+		}
+		if backend == nil {
+			http.Error(w, "Workout storage is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		data, err := LoadFitFile(r.Context(), backend, workoutID)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Workout not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading workout %d: %v", workoutID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		var body bytes.Buffer
+		var contentType string
+		if convertErr == nil {
+			contentType = convertFormat.ContentType()
+			err = convert.Convert(&body, data, convertFormat)
+		} else {
+			contentType = tableFormat.ContentType()
+			err = writeRecords(&body, tableFormat, workoutID, data)
+		}
+		if err != nil {
+			log.Printf("Error converting workout %d to %s: %v", workoutID, extension, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%d.%s"`, workoutID, extension))
+		_, _ = w.Write(body.Bytes())
+	}
+}
+
+// Analysis returns the training metrics computed for the :workout_id workout.
+func Analysis(backend storage.Backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workoutID, err := strconv.Atoi(pat.Param(r, "workout_id"))
+		if err != nil {
+			http.Error(w, "Invalid workout id", http.StatusBadRequest)
+			return
+		}
+		if backend == nil {
+			http.Error(w, "Workout storage is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		data, err := LoadAnalysis(r.Context(), backend, workoutID)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Analysis not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading analysis of workout %d: %v", workoutID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}
+}
+
+func writeRecords(w io.Writer, format export.Format, workoutID int, data []byte) error {
+	file, err := fit.DecodeBytes(data)
+	if err != nil {
+		return err
+	}
+	writer, err := export.NewWriter(w, format)
+	if err != nil {
+		return err
+	}
+	if err := writer.Write(workoutID, file.Records); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// ExportRecords streams the records of every workout that started between
+// the ?from and ?to dates (YYYY-MM-DD, inclusive) in the :format path
+// parameter, csv or parquet. Workouts that cannot be loaded are logged and
+// left out, as the response is already under way.
+func ExportRecords(backend storage.Backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := export.ParseFormat(pat.Param(r, "format"))
+		if err != nil {
+			http.Error(w, "Unsupported format", http.StatusNotFound)
+			return
+		}
+		from, err := time.Parse(time.DateOnly, r.URL.Query().Get("from"))
+		if err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+		to, err := time.Parse(time.DateOnly, r.URL.Query().Get("to"))
+		if err != nil || to.Before(from) {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+		if backend == nil {
+			http.Error(w, "Workout storage is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		workoutIDs, err := WorkoutsBetween(r.Context(), backend, from, to)
+		if err != nil {
+			log.Printf("Error listing workouts: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="records-%s-%s.%s"`,
+			from.Format(time.DateOnly), to.Format(time.DateOnly), format))
+		writer, err := export.NewWriter(w, format)
+		if err != nil {
+			log.Printf("Error starting %s export: %v", format, err)
+			return
+		}
+
+		for _, workoutID := range workoutIDs {
+			data, err := LoadFitFile(r.Context(), backend, workoutID)
+			if err != nil {
+				log.Printf("Skipping workout %d in export: %v", workoutID, err)
+				continue
+			}
+			file, err := fit.DecodeBytes(data)
+			if err != nil {
+				log.Printf("Skipping workout %d in export: %v", workoutID, err)
+				continue
+			}
+			if err := writer.Write(workoutID, file.Records); err != nil {
+				log.Printf("Error writing workout %d to export: %v", workoutID, err)
+				return
+			}
+		}
+		if err := writer.Close(); err != nil {
+			log.Printf("Error finishing %s export: %v", format, err)
+		}
+	}
+}
+
+// List returns a page of the workouts the service has received, filtered by
+// the query parameters:
+//
+//   - user_id: the athlete's Wahoo user ID
+//   - from, to: UTC start dates (YYYY-MM-DD, inclusive)
+//   - type: workout types, as in FORWARD_WORKOUT_TYPES
+//   - min_distance, max_distance: metres
+//   - min_duration, max_duration: seconds
+//
+// sort orders them by starts (the default), received_at, distance or duration,
+// and order is asc or desc (the default). limit sets the page size, and cursor
+// continues from the next_cursor of the previous page.
+func List(store Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := store.List(r.Context(), query)
+		if errors.Is(err, ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Error listing workouts: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(page)
+	}
+}
+
+// parseQuery reads List's query parameters.
+func parseQuery(values url.Values) (Query, error) {
+	var query Query
+	var err error
+	if value := values.Get("user_id"); value != "" {
+		if query.UserID, err = strconv.Atoi(value); err != nil {
+			return Query{}, errors.New("user_id must be a number")
+		}
+	}
+	if value := values.Get("from"); value != "" {
+		if query.From, err = time.Parse(time.DateOnly, value); err != nil {
+			return Query{}, errors.New("from must be a date (YYYY-MM-DD)")
+		}
+	}
+	if value := values.Get("to"); value != "" {
+		to, err := time.Parse(time.DateOnly, value)
+		if err != nil || to.Before(query.From) {
+			return Query{}, errors.New("to must be a date (YYYY-MM-DD) no earlier than from")
+		}
+		query.To = to.AddDate(0, 0, 1)
+	}
+	if query.Types, err = workouttype.ParseFilter(values.Get("type")); err != nil {
+		return Query{}, err
+	}
+	for _, bound := range []struct {
+		name  string
+		value **float64
+	}{
+		{"min_distance", &query.MinDistance},
+		{"max_distance", &query.MaxDistance},
+		{"min_duration", &query.MinDuration},
+		{"max_duration", &query.MaxDuration},
+	} {
+		if value := values.Get(bound.name); value != "" {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil || number < 0 {
+				return Query{}, fmt.Errorf("%s must be a non-negative number", bound.name)
+			}
+			*bound.value = &number
+		}
+	}
+	if query.Sort, err = ParseSortField(values.Get("sort")); err != nil {
+		return Query{}, err
+	}
+	switch values.Get("order") {
+	case "", "desc":
+		query.Descending = true
+	case "asc":
+	default:
+		return Query{}, errors.New("order must be asc or desc")
+	}
+	if value := values.Get("limit"); value != "" {
+		if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit < 1 || query.Limit > MaxLimit {
+			return Query{}, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+	}
+	query.Cursor = values.Get("cursor")
+	return query, nil
+}
@@ -0,0 +1,151 @@
+package workouts
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/storage"
+	"github.com/stretchr/testify/require"
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+func TestExport(t *testing.T) {
+
+	ctx := context.Background()
+	fitFile, err := os.ReadFile("../../pkg/utils/testdata/small-fit-file.fit")
+	require.NoError(t, err)
+
+	backend := storage.NewMemoryBackend()
+	key := "1120489/2024/04/281788767-252869305.fit"
+	require.NoError(t, backend.Put(ctx, key, fitFile, storage.PutOptions{}))
+	require.NoError(t, backend.Put(ctx, storage.IndexKey(281788767), []byte(key), storage.PutOptions{}))
+
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/workouts/:workout_id.:format"), Export(backend))
+
+	testCases := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{path: "/workouts/281788767.gpx", status: http.StatusOK, contentType: "application/gpx+xml", contains: "<gpx"},
+		{path: "/workouts/281788767.tcx", status: http.StatusOK, contentType: "application/vnd.garmin.tcx+xml", contains: "<TrainingCenterDatabase"},
+		{path: "/workouts/281788767.geojson", status: http.StatusOK, contentType: "application/geo+json", contains: `"LineString"`},
+		{path: "/workouts/281788767.csv", status: http.StatusOK, contentType: "text/csv; charset=utf-8", contains: "workout_id,timestamp,"},
+		{path: "/workouts/281788767.parquet", status: http.StatusOK, contentType: "application/vnd.apache.parquet", contains: "PAR1"},
+		{path: "/workouts/281788767.kml", status: http.StatusNotFound},
+		{path: "/workouts/1.gpx", status: http.StatusNotFound},
+		{path: "/workouts/abc.gpx", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			require.Equal(t, tc.status, response.Code)
+			if tc.status == http.StatusOK {
+				require.Equal(t, tc.contentType, response.Header().Get("Content-Type"))
+				require.True(t, strings.HasPrefix(response.Header().Get("Content-Disposition"), `attachment; filename="281788767.`))
+				require.Contains(t, response.Body.String(), tc.contains)
+			}
+		})
+	}
+}
+
+func TestAnalysis(t *testing.T) {
+
+	ctx := context.Background()
+	backend := storage.NewMemoryBackend()
+	key := "1120489/2024/04/281788767-252869305.fit"
+	require.NoError(t, backend.Put(ctx, storage.AnalysisKey(key), []byte(`{"workout_id":281788767}`), storage.PutOptions{}))
+	require.NoError(t, backend.Put(ctx, storage.IndexKey(281788767), []byte(key), storage.PutOptions{}))
+	require.NoError(t, backend.Put(ctx, storage.IndexKey(281788768), []byte("1120489/2024/04/281788768-1.fit"), storage.PutOptions{}))
+
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/workouts/:workout_id/analysis"), Analysis(backend))
+
+	testCases := []struct {
+		path   string
+		status int
+	}{
+		{path: "/workouts/281788767/analysis", status: http.StatusOK},
+		{path: "/workouts/281788768/analysis", status: http.StatusNotFound},
+		{path: "/workouts/1/analysis", status: http.StatusNotFound},
+		{path: "/workouts/abc/analysis", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		require.Equal(t, tc.status, response.Code, tc.path)
+		if tc.status == http.StatusOK {
+			require.Equal(t, "application/json", response.Header().Get("Content-Type"))
+			require.JSONEq(t, `{"workout_id":281788767}`, response.Body.String())
+		}
+	}
+}
+
+func TestExportRecords(t *testing.T) {
+
+	ctx := context.Background()
+	fitFile, err := os.ReadFile("../../pkg/utils/testdata/small-fit-file.fit")
+	require.NoError(t, err)
+
+	backend := storage.NewMemoryBackend()
+	for workoutID, starts := range map[int]time.Time{
+		1: time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC),
+		2: time.Date(2024, 4, 1, 7, 0, 0, 0, time.UTC),
+		3: time.Date(2024, 4, 30, 18, 0, 0, 0, time.UTC),
+		4: time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC),
+	} {
+		key := fmt.Sprintf("1120489/%d.fit", workoutID)
+		require.NoError(t, backend.Put(ctx, key, fitFile, storage.PutOptions{}))
+		require.NoError(t, backend.Put(ctx, storage.IndexKey(workoutID), []byte(key), storage.PutOptions{}))
+		require.NoError(t, backend.Put(ctx, storage.DateIndexKey(starts, workoutID), []byte(key), storage.PutOptions{}))
+	}
+
+	// A workout left filed under two days is only returned once.
+	require.NoError(t, backend.Put(ctx, storage.DateIndexKey(time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC), 2), []byte("1120489/2.fit"), storage.PutOptions{}))
+
+	workoutIDs, err := WorkoutsBetween(ctx, backend, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, workoutIDs)
+
+	workoutIDs, err = WorkoutsBetween(ctx, backend, time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3, 4}, workoutIDs)
+
+	router := goji.NewMux()
+	router.HandleFunc(pat.Get("/exports/records.:format"), ExportRecords(backend))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/exports/records.csv?from=2024-04-01&to=2024-04-30", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `attachment; filename="records-2024-04-01-2024-04-30.csv"`, response.Header().Get("Content-Disposition"))
+
+	rows, err := csv.NewReader(response.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 1+2*69)
+	require.Equal(t, "2", rows[1][0])
+	require.Equal(t, "3", rows[len(rows)-1][0])
+
+	for _, path := range []string{
+		"/exports/records.csv?from=2024-04-01",
+		"/exports/records.csv?from=2024-04-30&to=2024-04-01",
+		"/exports/records.csv?from=yesterday&to=2024-04-01",
+	} {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusBadRequest, response.Code, path)
+	}
+}
@@ -0,0 +1,533 @@
+package workouts
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/workouttype"
+)
+
+// Status is how far the pipeline has got with a workout.
+type Status string
+
+const (
+	StatusProcessing Status = "processing"
+	StatusProcessed  Status = "processed"
+	StatusFailed     Status = "failed"
+)
+
+// Record is what the service knows about a workout it has received.
+type Record struct {
+	WorkoutID int    `json:"workout_id"`
+	SummaryID int    `json:"summary_id"`
+	UserID    int    `json:"user_id"`
+	Name      string `json:"name"`
+	// WorkoutTypeID is Wahoo's type ID; the name and family are looked up in
+	// the workout type catalogue when the record is read.
+	WorkoutTypeID int       `json:"workout_type_id"`
+	WorkoutType   string    `json:"workout_type"`
+	WorkoutFamily string    `json:"workout_family"`
+	Starts        time.Time `json:"starts"`
+	// DistanceMeters and DurationSeconds are nil when the summary has none.
+	DistanceMeters  *float64 `json:"distance_meters"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+	// StorageKey is where the FIT file is stored, or empty when storage is
+	// disabled.
+	StorageKey string `json:"storage_key,omitempty"`
+	Status     Status `json:"status"`
+	// Error is why processing last failed.
+	Error string `json:"error,omitempty"`
+	// Summary is the workout summary as Wahoo sent it.
+	Summary    json.RawMessage `json:"summary"`
+	ReceivedAt time.Time       `json:"received_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// withType fills in the type name and family from the catalogue.
+func (r Record) withType() Record {
+	t := workouttype.ByID(r.WorkoutTypeID)
+	r.WorkoutType = t.Name
+	r.WorkoutFamily = string(t.Family)
+	return r
+}
+
+// SortField is a field workouts can be listed in order of.
+type SortField string
+
+const (
+	SortStarts     SortField = "starts"
+	SortReceivedAt SortField = "received_at"
+	SortDistance   SortField = "distance"
+	SortDuration   SortField = "duration"
+)
+
+// ParseSortField returns the named sort field; an empty name sorts by start
+// time.
+func ParseSortField(name string) (SortField, error) {
+	switch field := SortField(name); field {
+	case "":
+		return SortStarts, nil
+	case SortStarts, SortReceivedAt, SortDistance, SortDuration:
+		return field, nil
+	}
+	return "", fmt.Errorf("unknown sort field %q", name)
+}
+
+// value is the record's value of the field. Missing distances and durations
+// sort as zero.
+func (f SortField) value(r Record) float64 {
+	switch f {
+	case SortReceivedAt:
+		return float64(r.ReceivedAt.Unix())
+	case SortDistance:
+		if r.DistanceMeters != nil {
+			return *r.DistanceMeters
+		}
+	case SortDuration:
+		if r.DurationSeconds != nil {
+			return *r.DurationSeconds
+		}
+	default:
+		return float64(r.Starts.Unix())
+	}
+	return 0
+}
+
+// DefaultLimit and MaxLimit bound the number of workouts in a page.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Query selects and orders workouts. Zero fields do not filter.
+type Query struct {
+	UserID int
+	// From and To bound the start time, From included and To excluded.
+	From  time.Time
+	To    time.Time
+	Types workouttype.Filter
+	// Distance bounds are in metres and duration bounds in seconds, both
+	// included. Workouts without the value never match a bound on it.
+	MinDistance *float64
+	MaxDistance *float64
+	MinDuration *float64
+	MaxDuration *float64
+	Sort        SortField
+	Descending  bool
+	// Limit is the page size, DefaultLimit when zero.
+	Limit int
+	// Cursor continues from the page that returned it.
+	Cursor string
+}
+
+// Page is one page of a listing. NextCursor is empty on the last page.
+type Page struct {
+	Workouts   []Record `json:"workouts"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// ErrInvalidCursor is returned for cursors that were not returned by the same
+// query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position after the last workout of a page.
+type cursor struct {
+	Sort       SortField `json:"s"`
+	Descending bool      `json:"d"`
+	Value      float64   `json:"v"`
+	WorkoutID  int       `json:"id"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// position decodes the query's cursor, returning nil when it has none.
+func (q Query) position() (*cursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != q.sort() || c.Descending != q.Descending {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func (q Query) sort() SortField {
+	if q.Sort == "" {
+		return SortStarts
+	}
+	return q.Sort
+}
+
+func (q Query) limit() int {
+	if q.Limit <= 0 {
+		return DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		return MaxLimit
+	}
+	return q.Limit
+}
+
+// page trims records, one more than the limit when there is a further page,
+// to the limit and sets the cursor to follow it.
+func (q Query) page(records []Record) Page {
+	page := Page{Workouts: records}
+	if page.Workouts == nil {
+		page.Workouts = []Record{}
+	}
+	if len(records) > q.limit() {
+		page.Workouts = records[:q.limit()]
+		last := page.Workouts[len(page.Workouts)-1]
+		page.NextCursor = cursor{
+			Sort:       q.sort(),
+			Descending: q.Descending,
+			Value:      q.sort().value(last),
+			WorkoutID:  last.WorkoutID,
+		}.encode()
+	}
+	return page
+}
+
+// Store persists the workouts the pipeline receives.
+type Store interface {
+	// Save records a workout, replacing any earlier record of it but keeping
+	// when it was first received.
+	Save(ctx context.Context, record Record) error
+	// SetStatus records the outcome of processing a workout, with the error
+	// message when it failed.
+	SetStatus(ctx context.Context, workoutID int, status Status, message string) error
+	List(ctx context.Context, query Query) (Page, error)
+}
+
+// MemoryStore is a Store held in process memory.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[int]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[int]Record)}
+}
+
+func (s *MemoryStore) Save(_ context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[record.WorkoutID]; ok {
+		record.ReceivedAt = existing.ReceivedAt
+	}
+	s.records[record.WorkoutID] = record.withType()
+	return nil
+}
+
+func (s *MemoryStore) SetStatus(_ context.Context, workoutID int, status Status, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[workoutID]
+	if !ok {
+		return nil
+	}
+	record.Status = status
+	record.Error = message
+	record.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.records[workoutID] = record
+	return nil
+}
+
+func (s *MemoryStore) List(_ context.Context, query Query) (Page, error) {
+	position, err := query.position()
+	if err != nil {
+		return Page{}, err
+	}
+	field := query.sort()
+
+	s.mu.Lock()
+	var records []Record
+	for _, record := range s.records {
+		if query.match(record) && position.before(field, record) {
+			records = append(records, record)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		a, b := field.value(records[i]), field.value(records[j])
+		if a == b {
+			a, b = float64(records[i].WorkoutID), float64(records[j].WorkoutID)
+		}
+		if query.Descending {
+			return a > b
+		}
+		return a < b
+	})
+	if len(records) > query.limit()+1 {
+		records = records[:query.limit()+1]
+	}
+	return query.page(records), nil
+}
+
+// match reports whether the record passes the query's filters.
+func (q Query) match(r Record) bool {
+	inRange := func(value, min, max *float64) bool {
+		if min == nil && max == nil {
+			return true
+		}
+		return value != nil && (min == nil || *value >= *min) && (max == nil || *value <= *max)
+	}
+	return (q.UserID == 0 || r.UserID == q.UserID) &&
+		(q.From.IsZero() || !r.Starts.Before(q.From)) &&
+		(q.To.IsZero() || r.Starts.Before(q.To)) &&
+		q.Types.Match(workouttype.ByID(r.WorkoutTypeID)) &&
+		inRange(r.DistanceMeters, q.MinDistance, q.MaxDistance) &&
+		inRange(r.DurationSeconds, q.MinDuration, q.MaxDuration)
+}
+
+// before reports whether the record comes after the cursor, so belongs on a
+// later page. Every record does when there is no cursor.
+func (c *cursor) before(field SortField, r Record) bool {
+	if c == nil {
+		return true
+	}
+	value := field.value(r)
+	if value == c.Value {
+		if c.Descending {
+			return r.WorkoutID < c.WorkoutID
+		}
+		return r.WorkoutID > c.WorkoutID
+	}
+	if c.Descending {
+		return value < c.Value
+	}
+	return value > c.Value
+}
+
+// SQLStore is a Store persisted in SQLite or Postgres.
+type SQLStore struct {
+	db      *sql.DB
+	dialect database.Dialect
+}
+
+// migrations are the workouts schema, oldest first. Released migrations must
+// not be changed; add a new one instead.
+var migrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "create workouts",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS workouts (
+				workout_id       BIGINT PRIMARY KEY,
+				summary_id       BIGINT NOT NULL,
+				user_id          BIGINT NOT NULL,
+				name             TEXT NOT NULL,
+				workout_type_id  INTEGER NOT NULL,
+				starts           BIGINT NOT NULL,
+				distance_meters  DOUBLE PRECISION,
+				duration_seconds DOUBLE PRECISION,
+				storage_key      TEXT NOT NULL,
+				status           TEXT NOT NULL,
+				error            TEXT NOT NULL,
+				summary          TEXT NOT NULL,
+				received_at      BIGINT NOT NULL,
+				updated_at       BIGINT NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS workouts_user_starts ON workouts (user_id, starts)`,
+			`CREATE INDEX IF NOT EXISTS workouts_starts ON workouts (starts)`,
+		},
+	},
+}
+
+func NewSQLStore(db *sql.DB, dialect database.Dialect) (*SQLStore, error) {
+	if err := database.Migrate(db, dialect, "workouts", migrations); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db, dialect: dialect}, nil
+}
+
+func (s *SQLStore) Save(ctx context.Context, record Record) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`INSERT INTO workouts (workout_id, summary_id, user_id, name,
+		workout_type_id, starts, distance_meters, duration_seconds, storage_key, status, error, summary,
+		received_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (workout_id) DO UPDATE SET
+			summary_id = excluded.summary_id,
+			user_id = excluded.user_id,
+			name = excluded.name,
+			workout_type_id = excluded.workout_type_id,
+			starts = excluded.starts,
+			distance_meters = excluded.distance_meters,
+			duration_seconds = excluded.duration_seconds,
+			storage_key = excluded.storage_key,
+			status = excluded.status,
+			error = excluded.error,
+			summary = excluded.summary,
+			updated_at = excluded.updated_at`),
+		record.WorkoutID, record.SummaryID, record.UserID, record.Name, record.WorkoutTypeID, record.Starts.Unix(),
+		record.DistanceMeters, record.DurationSeconds, record.StorageKey, string(record.Status), record.Error,
+		string(record.Summary), record.ReceivedAt.Unix(), record.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("error saving workout %d: %w", record.WorkoutID, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) SetStatus(ctx context.Context, workoutID int, status Status, message string) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`UPDATE workouts SET status = ?, error = ?, updated_at = ?
+		WHERE workout_id = ?`), string(status), message, time.Now().Unix(), workoutID)
+	if err != nil {
+		return fmt.Errorf("error saving status of workout %d: %w", workoutID, err)
+	}
+	return nil
+}
+
+// sortColumns are the SQL expressions of the sort fields.
+var sortColumns = map[SortField]string{
+	SortStarts:     "starts",
+	SortReceivedAt: "received_at",
+	SortDistance:   "COALESCE(distance_meters, 0)",
+	SortDuration:   "COALESCE(duration_seconds, 0)",
+}
+
+func (s *SQLStore) List(ctx context.Context, query Query) (Page, error) {
+	position, err := query.position()
+	if err != nil {
+		return Page{}, err
+	}
+
+	var where []string
+	var args []any
+	if query.UserID != 0 {
+		where, args = append(where, "user_id = ?"), append(args, query.UserID)
+	}
+	if !query.From.IsZero() {
+		where, args = append(where, "starts >= ?"), append(args, query.From.Unix())
+	}
+	if !query.To.IsZero() {
+		where, args = append(where, "starts < ?"), append(args, query.To.Unix())
+	}
+	for _, bound := range []struct {
+		condition string
+		value     *float64
+	}{
+		{"distance_meters >= ?", query.MinDistance},
+		{"distance_meters <= ?", query.MaxDistance},
+		{"duration_seconds >= ?", query.MinDuration},
+		{"duration_seconds <= ?", query.MaxDuration},
+	} {
+		if bound.value != nil {
+			where, args = append(where, bound.condition), append(args, *bound.value)
+		}
+	}
+	if !query.Types.MatchesAll() {
+		ids, err := s.typeIDs(ctx, query)
+		if err != nil {
+			return Page{}, err
+		}
+		if len(ids) == 0 {
+			return query.page(nil), nil
+		}
+		where = append(where, "workout_type_id IN (?"+strings.Repeat(", ?", len(ids)-1)+")")
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	field := query.sort()
+	column := sortColumns[field]
+	order, op := "ASC", ">"
+	if query.Descending {
+		order, op = "DESC", "<"
+	}
+	if position != nil {
+		// Unix times are integer columns, which Postgres will not compare
+		// with a fractional parameter.
+		var value any = position.Value
+		if field == SortStarts || field == SortReceivedAt {
+			value = int64(position.Value)
+		}
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND workout_id %s ?))", column, op, column, op))
+		args = append(args, value, value, position.WorkoutID)
+	}
+
+	statement := `SELECT workout_id, summary_id, user_id, name, workout_type_id, starts, distance_meters,
+		duration_seconds, storage_key, status, error, summary, received_at, updated_at FROM workouts`
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += fmt.Sprintf(" ORDER BY %s %s, workout_id %s LIMIT %d", column, order, order, query.limit()+1)
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.Rebind(statement), args...)
+	if err != nil {
+		return Page{}, fmt.Errorf("error listing workouts: %w", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		var status, summary string
+		var starts, receivedAt, updatedAt int64
+		err := rows.Scan(&record.WorkoutID, &record.SummaryID, &record.UserID, &record.Name, &record.WorkoutTypeID,
+			&starts, &record.DistanceMeters, &record.DurationSeconds, &record.StorageKey, &status, &record.Error,
+			&summary, &receivedAt, &updatedAt)
+		if err != nil {
+			return Page{}, fmt.Errorf("error listing workouts: %w", err)
+		}
+		record.Status = Status(status)
+		record.Summary = json.RawMessage(summary)
+		record.Starts = time.Unix(starts, 0).UTC()
+		record.ReceivedAt = time.Unix(receivedAt, 0).UTC()
+		record.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+		records = append(records, record.withType())
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, fmt.Errorf("error listing workouts: %w", err)
+	}
+	return query.page(records), nil
+}
+
+// typeIDs returns the IDs the query's type filter matches among the types of
+// the workouts it could select.
+func (s *SQLStore) typeIDs(ctx context.Context, query Query) ([]int, error) {
+	statement, args := `SELECT DISTINCT workout_type_id FROM workouts`, []any{}
+	if query.UserID != 0 {
+		statement, args = statement+` WHERE user_id = ?`, append(args, query.UserID)
+	}
+	rows, err := s.db.QueryContext(ctx, s.dialect.Rebind(statement), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing workout types: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error listing workout types: %w", err)
+		}
+		if query.Types.Match(workouttype.ByID(id)) {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing workout types: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+)
+
+// runBackfill implements the `backfill` subcommand:
+//
+//	run-app backfill -user 1120489 [-restart]
+func runBackfill(svc *services, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	userID := flags.Int("user", 0, "Wahoo user ID to backfill")
+	restart := flags.Bool("restart", false, "discard any checkpoint and start from the newest workout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userID == 0 {
+		flags.Usage()
+		return errors.New("-user is required")
+	}
+
+	// Interrupting saves the checkpoint, so the next run picks up where this one stopped.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	checkpoint, err := svc.backfiller.Run(ctx, *userID, *restart)
+	if err != nil {
+		log.Printf("Backfill for user %d stopped on page %d: processed=%d skipped=%d failed=%d",
+			*userID, checkpoint.Page, checkpoint.Processed, checkpoint.Skipped, checkpoint.Failed)
+		return err
+	}
+
+	log.Printf("Backfill for user %d complete: processed=%d skipped=%d failed=%d",
+		*userID, checkpoint.Processed, checkpoint.Skipped, checkpoint.Failed)
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"expvar"
 	goji "goji.io"
 	"log"
 	"net/http"
@@ -10,9 +11,17 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/backfill"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/calendar"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/feeds"
 	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/health"
 	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/oauth"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/queue"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/reports"
 	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/webhook"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/utils"
 
 	"goji.io/pat"
 )
@@ -20,14 +29,36 @@ import (
 // main function
 func main() {
 
+	svc, err := newServices()
+	if err != nil {
+		log.Fatalf("Unable to initialise services: %v", err)
+	}
+	defer svc.Close()
+
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		if err := runBackfill(svc, os.Args[2:]); err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		return
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: handlersMethod(),
+		Handler: handlersMethod(workerCtx, svc),
+	}
+
+	if err := svc.workers.Start(workerCtx); err != nil {
+		log.Fatalf("Unable to start webhook workers: %v", err)
+	}
+	svc.pmc.Start(workerCtx, svc.pmcInterval)
+	if svc.digest != nil {
+		svc.digest.Start(workerCtx)
 	}
 
 	log.Printf("Starting server on port %v", port)
@@ -56,13 +87,50 @@ func main() {
 	} else {
 		log.Printf("HTTP Server shutdown!")
 	}
+
+	// Let jobs already in flight finish; anything still pending stays queued.
+	stopWorkers()
+	svc.workers.Wait()
+	svc.backfiller.Wait()
+	svc.pmc.Wait()
+	if svc.digest != nil {
+		svc.digest.Wait()
+	}
+	log.Println("Webhook workers stopped")
 }
 
-func handlersMethod() *goji.Mux {
+// handlersMethod routes requests to svc. Background work started by a request,
+// such as a backfill, runs until ctx is cancelled.
+func handlersMethod(ctx context.Context, svc *services) *goji.Mux {
 	router := goji.NewMux()
 
 	router.HandleFunc(pat.Get("/healthz"), health.Health())
-	router.HandleFunc(pat.Get("/"), oauth.AuthCallback())
-	router.HandleFunc(pat.Post("/callback"), webhook.Callback())
+	router.HandleFunc(pat.Get("/authorize"), oauth.Authorize(svc.states))
+	router.HandleFunc(pat.Get("/"), oauth.AuthCallback(svc.tokenStore, svc.states))
+	router.HandleFunc(pat.Post("/callback"), webhook.Callback(svc.jobs, svc.ledger))
+	router.HandleFunc(pat.Post("/backfill/:user_id"), utils.RequireAdminToken(backfill.Trigger(ctx, svc.backfiller)))
+	router.HandleFunc(pat.Get("/backfill/:user_id"), utils.RequireAdminToken(backfill.Status(svc.backfiller)))
+	router.HandleFunc(pat.Get("/jobs/:job_id"), utils.RequireAdminToken(queue.Status(svc.jobs)))
+	router.HandleFunc(pat.Get("/dead-letters"), utils.RequireAdminToken(queue.ListDeadLetters(svc.deadLetters)))
+	router.HandleFunc(pat.Get("/dead-letters/:dead_letter_id"), utils.RequireAdminToken(queue.GetDeadLetter(svc.deadLetters)))
+	router.HandleFunc(pat.Post("/dead-letters/:dead_letter_id/redrive"), utils.RequireAdminToken(queue.Redrive(svc.jobs, svc.deadLetters)))
+	router.HandleFunc(pat.Get("/workouts"), utils.RequireAdminToken(workouts.List(svc.workoutStore)))
+	router.HandleFunc(pat.Get("/feeds/workouts"), utils.RequireAdminToken(feeds.URL(svc.feeds)))
+	// Feeds are authorised by the token in their secret URL, so feed readers
+	// can poll them.
+	router.HandleFunc(pat.Get("/feeds/workouts.:format"), feeds.Workouts(svc.workoutStore, svc.feeds))
+	router.HandleFunc(pat.Get("/workouts/:workout_id.:format"), utils.RequireAdminToken(workouts.Export(svc.storage)))
+	router.HandleFunc(pat.Get("/workouts/:workout_id/analysis"), utils.RequireAdminToken(workouts.Analysis(svc.storage)))
+	router.HandleFunc(pat.Get("/athletes/:user_id/profile"), utils.RequireAdminToken(athletes.GetProfile(svc.profiles)))
+	router.HandleFunc(pat.Put("/athletes/:user_id/profile"), utils.RequireAdminToken(athletes.PutProfile(svc.profiles)))
+	router.HandleFunc(pat.Get("/athletes/:user_id/power-curve"), utils.RequireAdminToken(athletes.PowerCurve(svc.curves)))
+	router.HandleFunc(pat.Get("/athletes/:user_id/reports/:period"), utils.RequireAdminToken(reports.Get(svc.workoutStore, svc.loads)))
+	router.HandleFunc(pat.Get("/athletes/:user_id/pmc"), utils.RequireAdminToken(athletes.PMC(svc.pmc)))
+	router.HandleFunc(pat.Get("/athletes/:user_id/calendar"), utils.RequireAdminToken(calendar.URL(svc.calendars)))
+	// The feed is authorised by the token in its secret URL, so calendar apps
+	// can subscribe to it.
+	router.HandleFunc(pat.Get("/athletes/:user_id/workouts.ics"), calendar.Feed(svc.workoutStore, svc.calendars))
+	router.HandleFunc(pat.Get("/exports/records.:format"), utils.RequireAdminToken(workouts.ExportRecords(svc.storage)))
+	router.HandleFunc(pat.Get("/debug/vars"), utils.RequireAdminToken(expvar.Handler().ServeHTTP))
 	return router
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/athletes"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/backfill"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/calendar"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/database"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/feeds"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/oauth"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/queue"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/reports"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/webhook"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/workouts"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/storage"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/utils"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/wahoo"
+)
+
+const defaultWebhookWorkers = 2
+
+// services holds the long-lived dependencies shared by the HTTP handlers and
+// the command line subcommands.
+type services struct {
+	db           *sql.DB
+	workoutDB    *sql.DB
+	tokenStore   oauth.TokenStore
+	tokenManager *oauth.TokenManager
+	states       *oauth.StateSigner
+	storage      storage.Backend
+	pipeline     *webhook.Pipeline
+	backfiller   *backfill.Backfiller
+	jobs         queue.Queue
+	deadLetters  queue.DeadLetterStore
+	ledger       webhook.Ledger
+	profiles     athletes.ProfileStore
+	curves       athletes.CurveStore
+	loads        athletes.PMCStore
+	pmc          *athletes.PMCAggregator
+	pmcInterval  time.Duration
+	workoutStore workouts.Store
+	digest       *reports.DigestJob
+	calendars    *calendar.Signer
+	feeds        *feeds.Signer
+	workers      *queue.Workers
+}
+
+// newServices persists state to SQLite when DATABASE_PATH is set, and
+// otherwise falls back to keeping it in memory. The workout store is kept in
+// Postgres instead when WORKOUT_DATABASE_URL is set.
+func newServices() (*services, error) {
+	svc := &services{}
+
+	var checkpoints backfill.CheckpointStore
+	databasePath := os.Getenv("DATABASE_PATH")
+	if databasePath == "" {
+		log.Println("No DATABASE_PATH configured; state will only be held in memory.")
+		svc.tokenStore = oauth.NewMemoryTokenStore()
+		checkpoints = backfill.NewMemoryCheckpointStore()
+		svc.jobs = queue.NewMemoryQueue()
+		svc.deadLetters = queue.NewMemoryDeadLetterStore()
+		svc.ledger = webhook.NewMemoryLedger()
+		svc.profiles = athletes.NewMemoryProfileStore()
+		svc.curves = athletes.NewMemoryCurveStore()
+		svc.loads = athletes.NewMemoryPMCStore()
+	} else {
+		db, err := database.OpenSQLite(databasePath)
+		if err != nil {
+			return nil, err
+		}
+		svc.db = db
+
+		if svc.tokenStore, err = oauth.NewSQLiteTokenStore(db); err != nil {
+			return nil, err
+		}
+		if checkpoints, err = backfill.NewSQLiteCheckpointStore(db); err != nil {
+			return nil, err
+		}
+		if svc.jobs, err = queue.NewSQLiteQueue(db); err != nil {
+			return nil, err
+		}
+		if svc.deadLetters, err = queue.NewSQLiteDeadLetterStore(db); err != nil {
+			return nil, err
+		}
+		if svc.ledger, err = webhook.NewSQLiteLedger(db); err != nil {
+			return nil, err
+		}
+		if svc.profiles, err = athletes.NewSQLiteProfileStore(db); err != nil {
+			return nil, err
+		}
+		if svc.curves, err = athletes.NewSQLiteCurveStore(db); err != nil {
+			return nil, err
+		}
+		if svc.loads, err = athletes.NewSQLitePMCStore(db); err != nil {
+			return nil, err
+		}
+	}
+
+	switch url := os.Getenv("WORKOUT_DATABASE_URL"); {
+	case url != "":
+		db, err := database.OpenPostgres(url)
+		if err != nil {
+			return nil, err
+		}
+		svc.workoutDB = db
+		if svc.workoutStore, err = workouts.NewSQLStore(db, database.Postgres); err != nil {
+			return nil, err
+		}
+	case svc.db != nil:
+		var err error
+		if svc.workoutStore, err = workouts.NewSQLStore(svc.db, database.SQLite); err != nil {
+			return nil, err
+		}
+	default:
+		svc.workoutStore = workouts.NewMemoryStore()
+	}
+
+	states, err := oauth.NewStateSignerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	svc.states = states
+
+	svc.tokenManager = oauth.NewTokenManager(svc.tokenStore)
+	backend, err := storage.FromEnv(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	svc.storage = backend
+
+	keys, err := storage.KeyTemplateFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	svc.pipeline, err = webhook.NewPipeline(backend, keys, webhook.WithProfiles(svc.profiles), webhook.WithPowerCurves(svc.curves),
+		webhook.WithTrainingLoad(svc.loads), webhook.WithWorkoutStore(svc.workoutStore))
+	if err != nil {
+		return nil, err
+	}
+	svc.backfiller = backfill.NewBackfiller(svc.tokenManager, checkpoints, svc.pipeline,
+		wahoo.WithBaseURL(utils.GetWahooApiBaseUrl()))
+
+	concurrency, err := strconv.Atoi(os.Getenv("WEBHOOK_WORKERS"))
+	if err != nil {
+		concurrency = defaultWebhookWorkers
+	}
+	svc.workers = queue.NewWorkers(svc.jobs, svc.deadLetters, concurrency)
+	svc.workers.Handle(webhook.JobKind, webhook.JobHandler(svc.pipeline))
+
+	sink, err := reports.SinkFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if sink != nil {
+		svc.digest = reports.NewDigestJob(svc.workoutStore, svc.loads, sink)
+	}
+	svc.calendars = calendar.SignerFromEnv()
+	svc.feeds = feeds.SignerFromEnv()
+
+	svc.pmc = athletes.NewPMCAggregator(svc.loads)
+	if svc.pmcInterval, err = athletes.PMCIntervalFromEnv(); err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+func (s *services) Close() {
+	if s.db != nil {
+		_ = s.db.Close()
+	}
+	if s.workoutDB != nil {
+		_ = s.workoutDB.Close()
+	}
+}
@@ -0,0 +1,126 @@
+// Package convert turns FIT files into formats understood by mapping and
+// analysis tools that cannot read FIT: GPX 1.1, Garmin TCX and GeoJSON.
+package convert
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+)
+
+// creator identifies this service in the documents it writes.
+const creator = "go-wahoo-cloud-api"
+
+// Format is an output format, named by its file extension.
+type Format string
+
+const (
+	GPX     Format = "gpx"
+	TCX     Format = "tcx"
+	GeoJSON Format = "geojson"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{GPX, TCX, GeoJSON}
+
+// ParseFormat returns the format for a file extension, ignoring case.
+func ParseFormat(extension string) (Format, error) {
+	format := Format(strings.ToLower(strings.TrimPrefix(extension, ".")))
+	for _, supported := range Formats {
+		if format == supported {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported format %q", extension)
+}
+
+// ContentType is the media type to serve the format with.
+func (f Format) ContentType() string {
+	switch f {
+	case GPX:
+		return "application/gpx+xml"
+	case TCX:
+		return "application/vnd.garmin.tcx+xml"
+	case GeoJSON:
+		return "application/geo+json"
+	}
+	return "application/octet-stream"
+}
+
+// Convert decodes a FIT file, such as one returned by
+// utils.DownloadFitFileContentsToBuffer, and writes it to w in format.
+func Convert(w io.Writer, fitData []byte, format Format) error {
+	file, err := fit.DecodeBytes(fitData)
+	if err != nil {
+		return fmt.Errorf("error decoding fit file: %w", err)
+	}
+	return Write(w, file, format)
+}
+
+// Write writes an already decoded FIT file to w in format.
+func Write(w io.Writer, file *fit.File, format Format) error {
+	switch format {
+	case GPX:
+		return WriteGPX(w, file)
+	case TCX:
+		return WriteTCX(w, file)
+	case GeoJSON:
+		return WriteGeoJSON(w, file)
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+// startTime is when the activity started: the first session's start, falling
+// back to the first record and then to when the file was created.
+func startTime(file *fit.File) time.Time {
+	if len(file.Sessions) > 0 && !file.Sessions[0].StartTime.IsZero() {
+		return file.Sessions[0].StartTime
+	}
+	if len(file.Records) > 0 && !file.Records[0].Timestamp.IsZero() {
+		return file.Records[0].Timestamp
+	}
+	return file.FileID.TimeCreated
+}
+
+// sport returns the FIT sport of the first session, or 0 (generic).
+func sport(file *fit.File) uint8 {
+	if len(file.Sessions) > 0 {
+		return file.Sessions[0].Sport
+	}
+	return 0
+}
+
+// sportNames maps the FIT sport enum to lower case names, for the sports the
+// Wahoo devices record.
+var sportNames = map[uint8]string{
+	0:  "generic",
+	1:  "running",
+	2:  "cycling",
+	5:  "swimming",
+	11: "walking",
+	17: "hiking",
+}
+
+func sportName(sport uint8) string {
+	if name, ok := sportNames[sport]; ok {
+		return name
+	}
+	return "generic"
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// round rounds an optional sensor reading to the integer most formats expect.
+func round(value *float64) *int {
+	if value == nil {
+		return nil
+	}
+	rounded := int(math.Round(*value))
+	return &rounded
+}
@@ -0,0 +1,162 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+	"github.com/stretchr/testify/require"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+// outdoorRide is a two lap ride with positions, the testdata file being an
+// indoor ride without any.
+func outdoorRide() *fit.File {
+	start := time.Date(2024, 4, 12, 17, 34, 45, 0, time.UTC)
+	record := func(second int, lat, lon float64) fit.Record {
+		return fit.Record{
+			Timestamp: start.Add(time.Duration(second) * time.Second),
+			Latitude:  float(lat),
+			Longitude: float(lon),
+			Altitude:  float(112.4),
+			HeartRate: float(141),
+			Cadence:   float(88),
+			Power:     float(231.6),
+			Speed:     float(8.2),
+			Distance:  float(float64(second) * 8.2),
+		}
+	}
+
+	return &fit.File{
+		Sessions: []fit.Session{{StartTime: start, Sport: 2, TotalDistance: 24.6, TotalElapsedTime: 3}},
+		Laps: []fit.Lap{
+			{StartTime: start, Timestamp: start.Add(time.Second), TotalTimerTime: 1, AvgPower: 230, MaxPower: 240},
+			{StartTime: start.Add(time.Second), Timestamp: start.Add(3 * time.Second), TotalTimerTime: 2},
+		},
+		Records: []fit.Record{
+			record(0, 51.5007, -0.1246),
+			record(1, 51.5010, -0.1250),
+			{Timestamp: start.Add(2 * time.Second), Power: float(0)},
+			record(3, 51.5016, -0.1258),
+		},
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+
+	for extension, expected := range map[string]Format{"gpx": GPX, ".TCX": TCX, "geojson": GeoJSON} {
+		format, err := ParseFormat(extension)
+		require.NoError(t, err)
+		require.Equal(t, expected, format)
+	}
+
+	_, err := ParseFormat("kml")
+	require.Error(t, err)
+}
+
+func TestWriteGPX(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, WriteGPX(&out, outdoorRide()))
+
+	var document struct {
+		Version string `xml:"version,attr"`
+		Type    string `xml:"trk>type"`
+		Points  []struct {
+			Lat   float64 `xml:"lat,attr"`
+			Lon   float64 `xml:"lon,attr"`
+			Time  string  `xml:"time"`
+			Power int     `xml:"extensions>power"`
+			HR    int     `xml:"extensions>TrackPointExtension>hr"`
+			Cad   int     `xml:"extensions>TrackPointExtension>cad"`
+		} `xml:"trk>trkseg>trkpt"`
+	}
+	require.NoError(t, xml.Unmarshal(out.Bytes(), &document))
+
+	require.Equal(t, "1.1", document.Version)
+	require.Equal(t, "cycling", document.Type)
+	require.Len(t, document.Points, 3, "the record without a position is left out")
+	require.Equal(t, 51.5007, document.Points[0].Lat)
+	require.Equal(t, -0.1246, document.Points[0].Lon)
+	require.Equal(t, "2024-04-12T17:34:45Z", document.Points[0].Time)
+	require.Equal(t, 232, document.Points[0].Power)
+	require.Equal(t, 141, document.Points[0].HR)
+	require.Equal(t, 88, document.Points[0].Cad)
+	require.Contains(t, out.String(), "<gpxtpx:hr>141</gpxtpx:hr>")
+}
+
+func TestWriteTCX(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, WriteTCX(&out, outdoorRide()))
+
+	var document struct {
+		Activity struct {
+			Sport string `xml:"Sport,attr"`
+			ID    string `xml:"Id"`
+			Laps  []struct {
+				StartTime   string `xml:"StartTime,attr"`
+				AvgWatts    int    `xml:"Extensions>LX>AvgWatts"`
+				Trackpoints []struct {
+					Time     string   `xml:"Time"`
+					Latitude *float64 `xml:"Position>LatitudeDegrees"`
+					Watts    int      `xml:"Extensions>TPX>Watts"`
+				} `xml:"Track>Trackpoint"`
+			} `xml:"Lap"`
+		} `xml:"Activities>Activity"`
+	}
+	require.NoError(t, xml.Unmarshal(out.Bytes(), &document))
+
+	activity := document.Activity
+	require.Equal(t, "Biking", activity.Sport)
+	require.Equal(t, "2024-04-12T17:34:45Z", activity.ID)
+	require.Len(t, activity.Laps, 2)
+	require.Equal(t, 230, activity.Laps[0].AvgWatts)
+	require.Len(t, activity.Laps[0].Trackpoints, 2)
+	require.Len(t, activity.Laps[1].Trackpoints, 2)
+	require.Nil(t, activity.Laps[1].Trackpoints[0].Latitude, "positions are optional in TCX")
+	require.Equal(t, "2024-04-12T17:34:48Z", activity.Laps[1].Trackpoints[1].Time)
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, WriteGeoJSON(&out, outdoorRide()))
+
+	var feature geoJSONFeature
+	require.NoError(t, json.Unmarshal(out.Bytes(), &feature))
+
+	require.Equal(t, "Feature", feature.Type)
+	require.Equal(t, "LineString", feature.Geometry.Type)
+	require.Len(t, feature.Geometry.Coordinates, 3)
+	require.Equal(t, []float64{-0.1246, 51.5007, 112.4}, feature.Geometry.Coordinates[0])
+	require.Equal(t, []string{"2024-04-12T17:34:45Z", "2024-04-12T17:34:46Z", "2024-04-12T17:34:48Z"}, feature.Properties.CoordTimes)
+	require.Equal(t, "cycling", feature.Properties.Sport)
+}
+
+func TestConvert_TestdataFile(t *testing.T) {
+	data, err := os.ReadFile("../utils/testdata/small-fit-file.fit")
+	require.NoError(t, err)
+
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, Convert(&out, data, format))
+
+			switch format {
+			case TCX:
+				// The indoor ride has no positions, but TCX keeps every sample.
+				require.Equal(t, 69, strings.Count(out.String(), "<Trackpoint>"))
+			case GeoJSON:
+				require.Contains(t, out.String(), `"coordinates": []`)
+			}
+		})
+	}
+
+	require.Error(t, Convert(&bytes.Buffer{}, []byte("<html></html>"), GPX))
+}
@@ -0,0 +1,67 @@
+package convert
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+)
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+// geoJSONProperties describes the activity. CoordTimes follows the
+// convention of togeojson and Mapbox, one timestamp per coordinate.
+type geoJSONProperties struct {
+	Sport            string   `json:"sport"`
+	StartTime        string   `json:"start_time"`
+	TotalDistance    float64  `json:"total_distance,omitempty"`
+	TotalElapsedTime float64  `json:"total_elapsed_time,omitempty"`
+	CoordTimes       []string `json:"coordTimes"`
+}
+
+// WriteGeoJSON writes the records' positions as a GeoJSON Feature holding a
+// LineString of [longitude, latitude, altitude] coordinates. Records without a
+// position are left out, so an indoor ride has no coordinates.
+func WriteGeoJSON(w io.Writer, file *fit.File) error {
+	feature := geoJSONFeature{
+		Type: "Feature",
+		Geometry: geoJSONLineString{
+			Type:        "LineString",
+			Coordinates: [][]float64{},
+		},
+		Properties: geoJSONProperties{
+			Sport:      sportName(sport(file)),
+			StartTime:  formatTime(startTime(file)),
+			CoordTimes: []string{},
+		},
+	}
+	if len(file.Sessions) > 0 {
+		feature.Properties.TotalDistance = file.Sessions[0].TotalDistance
+		feature.Properties.TotalElapsedTime = file.Sessions[0].TotalElapsedTime
+	}
+
+	for _, record := range file.Records {
+		if record.Latitude == nil || record.Longitude == nil {
+			continue
+		}
+		coordinate := []float64{*record.Longitude, *record.Latitude}
+		if record.Altitude != nil {
+			coordinate = append(coordinate, *record.Altitude)
+		}
+		feature.Geometry.Coordinates = append(feature.Geometry.Coordinates, coordinate)
+		feature.Properties.CoordTimes = append(feature.Properties.CoordTimes, formatTime(record.Timestamp))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(feature)
+}
@@ -0,0 +1,105 @@
+package convert
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+)
+
+type gpxDocument struct {
+	XMLName  xml.Name    `xml:"gpx"`
+	Version  string      `xml:"version,attr"`
+	Creator  string      `xml:"creator,attr"`
+	XMLNS    string      `xml:"xmlns,attr"`
+	TPX      string      `xml:"xmlns:gpxtpx,attr"`
+	Metadata gpxMetadata `xml:"metadata"`
+	Track    gpxTrack    `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Time string `xml:"time,omitempty"`
+}
+
+type gpxTrack struct {
+	Type    string     `xml:"type"`
+	Segment []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxPoint struct {
+	Lat        float64        `xml:"lat,attr"`
+	Lon        float64        `xml:"lon,attr"`
+	Elevation  *float64       `xml:"ele,omitempty"`
+	Time       string         `xml:"time,omitempty"`
+	Extensions *gpxExtensions `xml:"extensions,omitempty"`
+}
+
+// gpxExtensions carries the sensor channels: heart rate, cadence and
+// temperature in Garmin's TrackPointExtension, and power in the bare <power>
+// element that Strava and most analysis tools read.
+type gpxExtensions struct {
+	Power *int            `xml:"power,omitempty"`
+	TPX   *gpxTPExtension `xml:"gpxtpx:TrackPointExtension,omitempty"`
+}
+
+type gpxTPExtension struct {
+	Temperature *float64 `xml:"gpxtpx:atemp,omitempty"`
+	HeartRate   *int     `xml:"gpxtpx:hr,omitempty"`
+	Cadence     *int     `xml:"gpxtpx:cad,omitempty"`
+}
+
+// WriteGPX writes the records as a single GPX 1.1 track segment. GPX points
+// require a position, so records without one, such as those from indoor
+// rides, are left out.
+func WriteGPX(w io.Writer, file *fit.File) error {
+	document := gpxDocument{
+		Version:  "1.1",
+		Creator:  creator,
+		XMLNS:    "http://www.topografix.com/GPX/1/1",
+		TPX:      "http://www.garmin.com/xmlschemas/TrackPointExtension/v1",
+		Metadata: gpxMetadata{Time: formatTime(startTime(file))},
+		Track:    gpxTrack{Type: sportName(sport(file))},
+	}
+
+	for _, record := range file.Records {
+		if record.Latitude == nil || record.Longitude == nil {
+			continue
+		}
+		point := gpxPoint{
+			Lat:       *record.Latitude,
+			Lon:       *record.Longitude,
+			Elevation: record.Altitude,
+		}
+		if !record.Timestamp.IsZero() {
+			point.Time = formatTime(record.Timestamp)
+		}
+
+		extensions := gpxExtensions{Power: round(record.Power)}
+		if record.HeartRate != nil || record.Cadence != nil || record.Temperature != nil {
+			extensions.TPX = &gpxTPExtension{
+				Temperature: record.Temperature,
+				HeartRate:   round(record.HeartRate),
+				Cadence:     round(record.Cadence),
+			}
+		}
+		if extensions.Power != nil || extensions.TPX != nil {
+			point.Extensions = &extensions
+		}
+		document.Track.Segment = append(document.Track.Segment, point)
+	}
+
+	return writeXML(w, document)
+}
+
+func writeXML(w io.Writer, document any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
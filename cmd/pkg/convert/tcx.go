@@ -0,0 +1,203 @@
+package convert
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+)
+
+type tcxDocument struct {
+	XMLName    xml.Name      `xml:"TrainingCenterDatabase"`
+	XMLNS      string        `xml:"xmlns,attr"`
+	NS3        string        `xml:"xmlns:ns3,attr"`
+	Activities []tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport string   `xml:"Sport,attr"`
+	ID    string   `xml:"Id"`
+	Laps  []tcxLap `xml:"Lap"`
+}
+
+type tcxLap struct {
+	StartTime        string           `xml:"StartTime,attr"`
+	TotalTimeSeconds float64          `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64          `xml:"DistanceMeters"`
+	MaximumSpeed     *float64         `xml:"MaximumSpeed,omitempty"`
+	Calories         uint16           `xml:"Calories"`
+	AverageHeartRate *tcxHeartRate    `xml:"AverageHeartRateBpm,omitempty"`
+	MaximumHeartRate *tcxHeartRate    `xml:"MaximumHeartRateBpm,omitempty"`
+	Intensity        string           `xml:"Intensity"`
+	Cadence          *int             `xml:"Cadence,omitempty"`
+	TriggerMethod    string           `xml:"TriggerMethod"`
+	Trackpoints      []tcxTrackpoint  `xml:"Track>Trackpoint"`
+	Extensions       *tcxLapExtension `xml:"Extensions>ns3:LX,omitempty"`
+}
+
+type tcxHeartRate struct {
+	Value int `xml:"Value"`
+}
+
+type tcxLapExtension struct {
+	AvgSpeed *float64 `xml:"ns3:AvgSpeed,omitempty"`
+	AvgWatts *int     `xml:"ns3:AvgWatts,omitempty"`
+	MaxWatts *int     `xml:"ns3:MaxWatts,omitempty"`
+}
+
+type tcxTrackpoint struct {
+	Time           string             `xml:"Time"`
+	Position       *tcxPosition       `xml:"Position,omitempty"`
+	AltitudeMeters *float64           `xml:"AltitudeMeters,omitempty"`
+	DistanceMeters *float64           `xml:"DistanceMeters,omitempty"`
+	HeartRate      *tcxHeartRate      `xml:"HeartRateBpm,omitempty"`
+	Cadence        *int               `xml:"Cadence,omitempty"`
+	Extensions     *tcxPointExtension `xml:"Extensions>ns3:TPX,omitempty"`
+}
+
+type tcxPosition struct {
+	Latitude  float64 `xml:"LatitudeDegrees"`
+	Longitude float64 `xml:"LongitudeDegrees"`
+}
+
+type tcxPointExtension struct {
+	Speed *float64 `xml:"ns3:Speed,omitempty"`
+	Watts *int     `xml:"ns3:Watts,omitempty"`
+}
+
+// tcxSports maps the FIT sport enum to the three sports TCX knows.
+var tcxSports = map[uint8]string{
+	1: "Running",
+	2: "Biking",
+}
+
+// WriteTCX writes the file as a Garmin TCX activity with one Lap per FIT lap,
+// each holding the records that fall within it. Files without laps get a
+// single lap covering every record.
+func WriteTCX(w io.Writer, file *fit.File) error {
+	activity := tcxActivity{
+		Sport: "Other",
+		ID:    formatTime(startTime(file)),
+	}
+	if name, ok := tcxSports[sport(file)]; ok {
+		activity.Sport = name
+	}
+
+	laps := file.Laps
+	if len(laps) == 0 {
+		laps = []fit.Lap{wholeActivityLap(file)}
+	}
+
+	next := 0
+	for i, lap := range laps {
+		tcx := newTCXLap(lap)
+		for ; next < len(file.Records); next++ {
+			record := file.Records[next]
+			// Every record up to the lap's end timestamp belongs to the lap;
+			// the last lap also takes anything recorded after it closed.
+			if i < len(laps)-1 && record.Timestamp.After(lap.Timestamp) {
+				break
+			}
+			tcx.Trackpoints = append(tcx.Trackpoints, newTCXTrackpoint(record))
+		}
+		activity.Laps = append(activity.Laps, tcx)
+	}
+
+	return writeXML(w, tcxDocument{
+		XMLNS:      "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2",
+		NS3:        "http://www.garmin.com/xmlschemas/ActivityExtension/v2",
+		Activities: []tcxActivity{activity},
+	})
+}
+
+// wholeActivityLap summarises the first session, or just the span of the
+// records if there is no session either.
+func wholeActivityLap(file *fit.File) fit.Lap {
+	if len(file.Sessions) > 0 {
+		session := file.Sessions[0]
+		return fit.Lap{
+			Timestamp:        session.Timestamp,
+			StartTime:        session.StartTime,
+			TotalElapsedTime: session.TotalElapsedTime,
+			TotalTimerTime:   session.TotalTimerTime,
+			TotalDistance:    session.TotalDistance,
+			TotalCalories:    session.TotalCalories,
+			AvgSpeed:         session.AvgSpeed,
+			MaxSpeed:         session.MaxSpeed,
+			AvgHeartRate:     session.AvgHeartRate,
+			MaxHeartRate:     session.MaxHeartRate,
+			AvgCadence:       session.AvgCadence,
+			MaxCadence:       session.MaxCadence,
+			AvgPower:         session.AvgPower,
+			MaxPower:         session.MaxPower,
+		}
+	}
+
+	lap := fit.Lap{StartTime: startTime(file)}
+	if len(file.Records) > 0 {
+		last := file.Records[len(file.Records)-1]
+		lap.Timestamp = last.Timestamp
+		lap.TotalElapsedTime = last.Timestamp.Sub(lap.StartTime).Seconds()
+		lap.TotalTimerTime = lap.TotalElapsedTime
+		if last.Distance != nil {
+			lap.TotalDistance = *last.Distance
+		}
+	}
+	return lap
+}
+
+func newTCXLap(lap fit.Lap) tcxLap {
+	tcx := tcxLap{
+		StartTime:        formatTime(lap.StartTime),
+		TotalTimeSeconds: lap.TotalTimerTime,
+		DistanceMeters:   lap.TotalDistance,
+		Calories:         lap.TotalCalories,
+		Intensity:        "Active",
+		TriggerMethod:    "Manual",
+	}
+	if lap.MaxSpeed > 0 {
+		tcx.MaximumSpeed = &lap.MaxSpeed
+	}
+	if lap.AvgHeartRate > 0 {
+		tcx.AverageHeartRate = &tcxHeartRate{Value: int(lap.AvgHeartRate)}
+	}
+	if lap.MaxHeartRate > 0 {
+		tcx.MaximumHeartRate = &tcxHeartRate{Value: int(lap.MaxHeartRate)}
+	}
+	if lap.AvgCadence > 0 {
+		cadence := int(lap.AvgCadence)
+		tcx.Cadence = &cadence
+	}
+
+	var extension tcxLapExtension
+	if lap.AvgSpeed > 0 {
+		extension.AvgSpeed = &lap.AvgSpeed
+	}
+	if lap.AvgPower > 0 {
+		avgWatts, maxWatts := int(lap.AvgPower), int(lap.MaxPower)
+		extension.AvgWatts, extension.MaxWatts = &avgWatts, &maxWatts
+	}
+	if extension != (tcxLapExtension{}) {
+		tcx.Extensions = &extension
+	}
+	return tcx
+}
+
+func newTCXTrackpoint(record fit.Record) tcxTrackpoint {
+	point := tcxTrackpoint{
+		Time:           formatTime(record.Timestamp),
+		AltitudeMeters: record.Altitude,
+		DistanceMeters: record.Distance,
+		Cadence:        round(record.Cadence),
+	}
+	if record.Latitude != nil && record.Longitude != nil {
+		point.Position = &tcxPosition{Latitude: *record.Latitude, Longitude: *record.Longitude}
+	}
+	if heartRate := round(record.HeartRate); heartRate != nil {
+		point.HeartRate = &tcxHeartRate{Value: *heartRate}
+	}
+	if record.Speed != nil || record.Power != nil {
+		point.Extensions = &tcxPointExtension{Speed: record.Speed, Watts: round(record.Power)}
+	}
+	return point
+}
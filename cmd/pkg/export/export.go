@@ -0,0 +1,199 @@
+// Package export writes the per-second records of FIT files in tabular form,
+// as CSV or Parquet, for analysis outside the service.
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Format is a tabular output format, named by its file extension.
+type Format string
+
+const (
+	CSV     Format = "csv"
+	Parquet Format = "parquet"
+)
+
+// ParseFormat returns the format for a file extension, ignoring case.
+func ParseFormat(extension string) (Format, error) {
+	switch format := Format(strings.ToLower(strings.TrimPrefix(extension, "."))); format {
+	case CSV, Parquet:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported format %q", extension)
+}
+
+// ContentType is the media type to serve the format with.
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+// Row is one record of a workout. Channels the device did not record are nil,
+// and are written as empty CSV fields and Parquet nulls. Units are degrees,
+// metres, m/s, watts, beats and revolutions per minute and °C.
+type Row struct {
+	WorkoutID   int64
+	Timestamp   time.Time
+	Latitude    *float64
+	Longitude   *float64
+	Altitude    *float64
+	Speed       *float64
+	Power       *float64
+	HeartRate   *float64
+	Cadence     *float64
+	Temperature *float64
+}
+
+// Columns are the names of Row's fields, in order, as used in the CSV header.
+var Columns = []string{"workout_id", "timestamp", "latitude", "longitude", "altitude", "speed", "power", "heart_rate", "cadence", "temperature"}
+
+// Rows converts a workout's records.
+func Rows(workoutID int, records []fit.Record) []Row {
+	rows := make([]Row, 0, len(records))
+	for _, record := range records {
+		rows = append(rows, Row{
+			WorkoutID:   int64(workoutID),
+			Timestamp:   record.Timestamp.UTC(),
+			Latitude:    record.Latitude,
+			Longitude:   record.Longitude,
+			Altitude:    record.Altitude,
+			Speed:       record.Speed,
+			Power:       record.Power,
+			HeartRate:   record.HeartRate,
+			Cadence:     record.Cadence,
+			Temperature: record.Temperature,
+		})
+	}
+	return rows
+}
+
+// Writer writes the records of one or more workouts. Close must be called to
+// finish the output; nothing more may be written to the underlying writer
+// until it has.
+type Writer interface {
+	Write(workoutID int, records []fit.Record) error
+	Close() error
+}
+
+// NewWriter returns a Writer producing format. CSV rows are written through
+// as they arrive; Parquet rows are flushed as a row group after each workout.
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w)
+	case Parquet:
+		return newParquetWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(Columns); err != nil {
+		return nil, err
+	}
+	return &csvWriter{writer: writer}, nil
+}
+
+func (c *csvWriter) Write(workoutID int, records []fit.Record) error {
+	for _, row := range Rows(workoutID, records) {
+		err := c.writer.Write([]string{
+			strconv.FormatInt(row.WorkoutID, 10),
+			row.Timestamp.Format(time.RFC3339),
+			formatFloat(row.Latitude),
+			formatFloat(row.Longitude),
+			formatFloat(row.Altitude),
+			formatFloat(row.Speed),
+			formatFloat(row.Power),
+			formatFloat(row.HeartRate),
+			formatFloat(row.Cadence),
+			formatFloat(row.Temperature),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func formatFloat(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+// parquetRow is Row in the shape the Parquet writer expects, with the
+// timestamp as milliseconds since the Unix epoch.
+type parquetRow struct {
+	WorkoutID   int64    `parquet:"name=workout_id, type=INT64"`
+	Timestamp   int64    `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Latitude    *float64 `parquet:"name=latitude, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Longitude   *float64 `parquet:"name=longitude, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Altitude    *float64 `parquet:"name=altitude, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Speed       *float64 `parquet:"name=speed, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Power       *float64 `parquet:"name=power, type=DOUBLE, repetitiontype=OPTIONAL"`
+	HeartRate   *float64 `parquet:"name=heart_rate, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Cadence     *float64 `parquet:"name=cadence, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Temperature *float64 `parquet:"name=temperature, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+type parquetWriter struct {
+	writer *writer.ParquetWriter
+}
+
+func newParquetWriter(w io.Writer) (*parquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetWriter{writer: pw}, nil
+}
+
+func (p *parquetWriter) Write(workoutID int, records []fit.Record) error {
+	for _, row := range Rows(workoutID, records) {
+		err := p.writer.Write(parquetRow{
+			WorkoutID:   row.WorkoutID,
+			Timestamp:   row.Timestamp.UnixMilli(),
+			Latitude:    row.Latitude,
+			Longitude:   row.Longitude,
+			Altitude:    row.Altitude,
+			Speed:       row.Speed,
+			Power:       row.Power,
+			HeartRate:   row.HeartRate,
+			Cadence:     row.Cadence,
+			Temperature: row.Temperature,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return p.writer.Flush(true)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.writer.WriteStop()
+}
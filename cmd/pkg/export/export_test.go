@@ -0,0 +1,82 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func testRecords(t *testing.T) []fit.Record {
+	t.Helper()
+	data, err := os.ReadFile("../utils/testdata/small-fit-file.fit")
+	require.NoError(t, err)
+	file, err := fit.DecodeBytes(data)
+	require.NoError(t, err)
+	return file.Records
+}
+
+func TestCSV(t *testing.T) {
+	records := testRecords(t)
+
+	var out bytes.Buffer
+	writer, err := NewWriter(&out, CSV)
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(281788767, records))
+	require.NoError(t, writer.Write(281788768, records[:1]))
+	require.NoError(t, writer.Close())
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 1+len(records)+1)
+	require.Equal(t, Columns, rows[0])
+
+	// The indoor ride has no position, altitude or heart rate.
+	require.Equal(t, []string{"281788767", "2024-04-09T20:40:35Z", "", "", "", "6.138", "70", "", "73", "21"}, rows[11])
+	require.Equal(t, "281788768", rows[len(rows)-1][0])
+}
+
+func TestParquet(t *testing.T) {
+	records := testRecords(t)
+
+	var out bytes.Buffer
+	writer, err := NewWriter(&out, Parquet)
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(281788767, records))
+	require.NoError(t, writer.Write(281788768, records[:1]))
+	require.NoError(t, writer.Close())
+
+	file, err := buffer.NewBufferFile(out.Bytes())
+	require.NoError(t, err)
+	pr, err := reader.NewParquetReader(file, new(parquetRow), 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+
+	require.Equal(t, int64(len(records)+1), pr.GetNumRows())
+	rows := make([]parquetRow, pr.GetNumRows())
+	require.NoError(t, pr.Read(&rows))
+
+	row := rows[10]
+	require.Equal(t, int64(281788767), row.WorkoutID)
+	require.Equal(t, time.Date(2024, 4, 9, 20, 40, 35, 0, time.UTC), time.UnixMilli(row.Timestamp).UTC())
+	require.Equal(t, 70.0, *row.Power)
+	require.Nil(t, row.Latitude)
+	require.Nil(t, row.HeartRate)
+	require.Equal(t, int64(281788768), rows[len(rows)-1].WorkoutID)
+}
+
+func TestParseFormat(t *testing.T) {
+
+	format, err := ParseFormat("CSV")
+	require.NoError(t, err)
+	require.Equal(t, CSV, format)
+
+	_, err = ParseFormat("xlsx")
+	require.Error(t, err)
+}
@@ -0,0 +1,247 @@
+package fit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// FieldTimestamp is the field number of the timestamp common to all messages.
+const FieldTimestamp = 253
+
+// Field is one field of a data message.
+type Field struct {
+	Num   byte
+	Value Value
+}
+
+// DeveloperField is a field defined at runtime by a field_description message.
+type DeveloperField struct {
+	DeveloperDataIndex byte
+	Num                byte
+	// Name and Units come from the matching field_description, and are empty
+	// if the file did not describe the field before using it.
+	Name  string
+	Units string
+	Value Value
+}
+
+// Message is a decoded data message.
+type Message struct {
+	Num             uint16
+	Fields          []Field
+	DeveloperFields []DeveloperField
+	// Timestamp is taken from the timestamp field or, for messages with a
+	// compressed timestamp header, from the previous timestamp plus the
+	// header's offset. It is zero for messages without either.
+	Timestamp time.Time
+}
+
+// Field returns the field with the given number.
+func (m Message) Field(num byte) (Value, bool) {
+	for _, field := range m.Fields {
+		if field.Num == num {
+			return field.Value, true
+		}
+	}
+	return Value{}, false
+}
+
+// DeveloperField returns the developer field with the given name.
+func (m Message) DeveloperField(name string) (DeveloperField, bool) {
+	for _, field := range m.DeveloperFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return DeveloperField{}, false
+}
+
+type fieldDefinition struct {
+	num      byte
+	size     int
+	baseType BaseType
+}
+
+type developerFieldDefinition struct {
+	num                byte
+	size               int
+	developerDataIndex byte
+}
+
+type definition struct {
+	globalNum       uint16
+	bigEndian       bool
+	fields          []fieldDefinition
+	developerFields []developerFieldDefinition
+}
+
+type developerFieldKey struct {
+	developerDataIndex byte
+	num                byte
+}
+
+type decoder struct {
+	data          []byte
+	offset        int
+	definitions   [16]*definition
+	descriptions  map[developerFieldKey]FieldDescription
+	lastTimestamp uint32
+}
+
+func (d *decoder) fail(err error) error {
+	return &FormatError{Offset: d.offset, Err: err}
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	if n < 0 || d.offset+n > len(d.data) {
+		return nil, d.fail(ErrTruncated)
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b, nil
+}
+
+func (d *decoder) readByte() (byte, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// next reads one record. ok is false for definition messages.
+func (d *decoder) next() (message Message, ok bool, err error) {
+	header, err := d.readByte()
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	// Compressed timestamp header: bit 7 set, local type in bits 5-6 and a
+	// five bit offset from the last full timestamp in bits 0-4.
+	if header&0x80 != 0 {
+		localType := (header >> 5) & 0x03
+		offset := uint32(header & 0x1F)
+		timestamp := d.lastTimestamp&^0x1F + offset
+		if offset < d.lastTimestamp&0x1F {
+			timestamp += 0x20
+		}
+		d.lastTimestamp = timestamp
+
+		message, err := d.readData(localType)
+		if err != nil {
+			return Message{}, false, err
+		}
+		message.Timestamp = Time(timestamp)
+		return message, true, nil
+	}
+
+	localType := header & 0x0F
+	if header&0x40 != 0 {
+		return Message{}, false, d.readDefinition(localType, header&0x20 != 0)
+	}
+
+	message, err = d.readData(localType)
+	if err != nil {
+		return Message{}, false, err
+	}
+	if value, found := message.Field(FieldTimestamp); found {
+		if seconds, valid := value.Uint(); valid {
+			d.lastTimestamp = uint32(seconds)
+			message.Timestamp = Time(uint32(seconds))
+		}
+	}
+	return message, true, nil
+}
+
+func (d *decoder) readDefinition(localType byte, hasDeveloperFields bool) error {
+	fixed, err := d.read(5)
+	if err != nil {
+		return err
+	}
+
+	def := &definition{bigEndian: fixed[1] == 1}
+	if def.bigEndian {
+		def.globalNum = binary.BigEndian.Uint16(fixed[2:4])
+	} else {
+		def.globalNum = binary.LittleEndian.Uint16(fixed[2:4])
+	}
+
+	fields, err := d.read(int(fixed[4]) * 3)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(fields); i += 3 {
+		def.fields = append(def.fields, fieldDefinition{
+			num:      fields[i],
+			size:     int(fields[i+1]),
+			baseType: BaseType(fields[i+2]),
+		})
+	}
+
+	if hasDeveloperFields {
+		count, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		fields, err := d.read(int(count) * 3)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(fields); i += 3 {
+			def.developerFields = append(def.developerFields, developerFieldDefinition{
+				num:                fields[i],
+				size:               int(fields[i+1]),
+				developerDataIndex: fields[i+2],
+			})
+		}
+	}
+
+	d.definitions[localType] = def
+	return nil
+}
+
+func (d *decoder) readData(localType byte) (Message, error) {
+	def := d.definitions[localType]
+	if def == nil {
+		return Message{}, d.fail(fmt.Errorf("data message for undefined local type %d", localType))
+	}
+
+	message := Message{Num: def.globalNum}
+	for _, field := range def.fields {
+		raw, err := d.read(field.size)
+		if err != nil {
+			return Message{}, err
+		}
+		message.Fields = append(message.Fields, Field{
+			Num:   field.num,
+			Value: Value{Type: field.baseType, Raw: raw, bigEndian: def.bigEndian},
+		})
+	}
+
+	for _, field := range def.developerFields {
+		raw, err := d.read(field.size)
+		if err != nil {
+			return Message{}, err
+		}
+
+		developerField := DeveloperField{
+			DeveloperDataIndex: field.developerDataIndex,
+			Num:                field.num,
+			Value:              Value{Type: Byte, Raw: raw, bigEndian: def.bigEndian},
+		}
+		if description, ok := d.descriptions[developerFieldKey{field.developerDataIndex, field.num}]; ok {
+			developerField.Name = description.FieldName
+			developerField.Units = description.Units
+			developerField.Value.Type = description.BaseType
+		}
+		message.DeveloperFields = append(message.DeveloperFields, developerField)
+	}
+
+	if message.Num == MesgFieldDescription {
+		description := newFieldDescription(message)
+		key := developerFieldKey{description.DeveloperDataIndex, description.FieldDefinitionNumber}
+		d.descriptions[key] = description
+	}
+	return message, nil
+}
@@ -0,0 +1,186 @@
+// Package fit decodes Garmin FIT activity files into typed records.
+//
+// Decode validates the header and file CRCs, then walks the definition and
+// data messages, resolving developer fields against their field_description
+// messages and expanding compressed timestamp headers. Every message is kept
+// in File.Messages; the messages the service uses are also converted to
+// typed records with the profile's scale and offset applied.
+package fit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotFIT is returned when the data does not carry the ".FIT" signature.
+	ErrNotFIT = errors.New("fit: missing .FIT signature")
+	// ErrHeaderCRC is returned when the 14 byte header's CRC does not match.
+	ErrHeaderCRC = errors.New("fit: header CRC mismatch")
+	// ErrFileCRC is returned when the trailing file CRC does not match.
+	ErrFileCRC = errors.New("fit: file CRC mismatch")
+	// ErrTruncated is returned when the data ends before the header says it should.
+	ErrTruncated = errors.New("fit: file truncated")
+)
+
+// FormatError describes malformed data at a byte offset within the file.
+type FormatError struct {
+	Offset int
+	Err    error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("fit: invalid data at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// epoch is the FIT epoch, 1989-12-31T00:00:00Z, from which date_time
+// fields count seconds.
+var epoch = time.Date(1989, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// Time converts a FIT date_time to a time.Time.
+func Time(seconds uint32) time.Time {
+	return epoch.Add(time.Duration(seconds) * time.Second)
+}
+
+// Header is the FIT file header.
+type Header struct {
+	Size            byte
+	ProtocolVersion byte
+	ProfileVersion  uint16
+	DataSize        uint32
+	// CRC is zero for 12 byte headers and for 14 byte headers that were
+	// written without one.
+	CRC uint16
+}
+
+// File is a decoded FIT file.
+type File struct {
+	Header Header
+
+	FileID            FileID
+	Sessions          []Session
+	Laps              []Lap
+	Records           []Record
+	Events            []Event
+	DeviceInfos       []DeviceInfo
+	DeveloperDataIDs  []DeveloperDataID
+	FieldDescriptions []FieldDescription
+
+	// Messages holds every data message in file order, including those
+	// without a typed record.
+	Messages []Message
+}
+
+// ReadHeader parses and validates the header at the start of data.
+func ReadHeader(data []byte) (Header, error) {
+	if len(data) < 12 {
+		return Header{}, ErrTruncated
+	}
+
+	header := Header{
+		Size:            data[0],
+		ProtocolVersion: data[1],
+		ProfileVersion:  binary.LittleEndian.Uint16(data[2:4]),
+		DataSize:        binary.LittleEndian.Uint32(data[4:8]),
+	}
+	if string(data[8:12]) != ".FIT" {
+		return Header{}, ErrNotFIT
+	}
+	if header.Size != 12 && header.Size != 14 {
+		return Header{}, &FormatError{Offset: 0, Err: fmt.Errorf("unsupported header size %d", header.Size)}
+	}
+	if len(data) < int(header.Size) {
+		return Header{}, ErrTruncated
+	}
+
+	if header.Size == 14 {
+		header.CRC = binary.LittleEndian.Uint16(data[12:14])
+		if header.CRC != 0 && header.CRC != CRC(data[:12]) {
+			return Header{}, ErrHeaderCRC
+		}
+	}
+	return header, nil
+}
+
+// Validate checks the header signature, header CRC, length and file CRC
+// without decoding any messages.
+func Validate(data []byte) error {
+	header, err := ReadHeader(data)
+	if err != nil {
+		return err
+	}
+
+	end := int(header.Size) + int(header.DataSize)
+	if len(data) < end+2 {
+		return ErrTruncated
+	}
+	if binary.LittleEndian.Uint16(data[end:end+2]) != CRC(data[:end]) {
+		return ErrFileCRC
+	}
+	return nil
+}
+
+// Decode reads and decodes a FIT file from r.
+func Decode(r io.Reader) (*File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeBytes(data)
+}
+
+// DecodeBytes validates and decodes a FIT file. Any data following the first
+// file, such as a chained FIT file, is ignored.
+func DecodeBytes(data []byte) (*File, error) {
+	if err := Validate(data); err != nil {
+		return nil, err
+	}
+	header, _ := ReadHeader(data)
+
+	d := &decoder{
+		data:         data[:int(header.Size)+int(header.DataSize)],
+		offset:       int(header.Size),
+		descriptions: make(map[developerFieldKey]FieldDescription),
+	}
+	file := &File{Header: header}
+	for d.offset < len(d.data) {
+		message, ok, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			file.add(message)
+		}
+	}
+	return file, nil
+}
+
+func (f *File) add(message Message) {
+	f.Messages = append(f.Messages, message)
+
+	switch message.Num {
+	case MesgFileID:
+		f.FileID = newFileID(message)
+	case MesgSession:
+		f.Sessions = append(f.Sessions, newSession(message))
+	case MesgLap:
+		f.Laps = append(f.Laps, newLap(message))
+	case MesgRecord:
+		f.Records = append(f.Records, newRecord(message))
+	case MesgEvent:
+		f.Events = append(f.Events, newEvent(message))
+	case MesgDeviceInfo:
+		f.DeviceInfos = append(f.DeviceInfos, newDeviceInfo(message))
+	case MesgDeveloperDataID:
+		f.DeveloperDataIDs = append(f.DeveloperDataIDs, newDeveloperDataID(message))
+	case MesgFieldDescription:
+		f.FieldDescriptions = append(f.FieldDescriptions, newFieldDescription(message))
+	}
+}
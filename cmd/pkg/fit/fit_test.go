@@ -0,0 +1,159 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readTestFile(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("../utils/testdata/small-fit-file.fit")
+	require.NoError(t, err)
+	return data
+}
+
+func TestDecodeBytes(t *testing.T) {
+	file, err := DecodeBytes(readTestFile(t))
+	require.NoError(t, err)
+
+	require.Equal(t, Header{Size: 14, ProtocolVersion: 0x10, ProfileVersion: 2205, DataSize: 3894, CRC: 0xfcef}, file.Header)
+	require.Equal(t, time.Date(2024, 4, 9, 20, 40, 25, 0, time.UTC), file.FileID.TimeCreated)
+
+	require.Len(t, file.Records, 69)
+	require.Len(t, file.Sessions, 1)
+	require.Len(t, file.Laps, 1)
+	require.Len(t, file.Events, 3)
+	require.Len(t, file.DeviceInfos, 5)
+	require.Len(t, file.FieldDescriptions, 28)
+	require.Len(t, file.DeveloperDataIDs, 21)
+
+	session := file.Sessions[0]
+	require.Equal(t, 68.0, session.TotalElapsedTime)
+	require.Equal(t, 552.69, session.TotalDistance)
+	require.Equal(t, uint16(73), session.AvgPower)
+	require.Equal(t, uint16(80), session.NormalizedPower)
+	require.Equal(t, 0.353, session.IntensityFactor)
+	require.Equal(t, uint16(227), session.ThresholdPower)
+
+	record := file.Records[10]
+	require.Equal(t, time.Date(2024, 4, 9, 20, 40, 35, 0, time.UTC), record.Timestamp)
+	require.NotNil(t, record.Power)
+	require.Equal(t, 70.0, *record.Power)
+	require.Nil(t, record.Latitude, "indoor ride has no positions")
+	require.Nil(t, record.HeartRate, "no heart rate strap was paired")
+
+	var sessionMessage Message
+	for _, message := range file.Messages {
+		if message.Num == MesgSession {
+			sessionMessage = message
+		}
+	}
+	workoutType, found := sessionMessage.DeveloperField("workout_type")
+	require.True(t, found, "developer field is resolved against its field_description")
+	value, ok := workoutType.Value.Uint()
+	require.True(t, ok)
+	require.Equal(t, uint64(61), value)
+}
+
+func TestDecodeBytes_RejectsCorruptFiles(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		corrupt  func(data []byte) []byte
+		expected error
+	}{
+		{
+			name:     "BadSignature",
+			corrupt:  func(data []byte) []byte { data[8] = 'X'; return data },
+			expected: ErrNotFIT,
+		},
+		{
+			name:     "BadHeaderCRC",
+			corrupt:  func(data []byte) []byte { data[12]++; return data },
+			expected: ErrHeaderCRC,
+		},
+		{
+			name:     "BadFileCRC",
+			corrupt:  func(data []byte) []byte { data[100] ^= 0xFF; return data },
+			expected: ErrFileCRC,
+		},
+		{
+			name:     "Truncated",
+			corrupt:  func(data []byte) []byte { return data[:len(data)-100] },
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Empty",
+			corrupt:  func(data []byte) []byte { return nil },
+			expected: ErrTruncated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := tc.corrupt(readTestFile(t))
+
+			_, err := DecodeBytes(data)
+			require.ErrorIs(t, err, tc.expected)
+			require.ErrorIs(t, Validate(data), tc.expected)
+		})
+	}
+}
+
+// buildFile wraps records in a 14 byte header and appends the file CRC.
+func buildFile(records []byte) []byte {
+	header := make([]byte, 12, 14)
+	header[0] = 14
+	header[1] = 0x10
+	binary.LittleEndian.PutUint16(header[2:4], 2205)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(records)))
+	copy(header[8:12], ".FIT")
+	header = binary.LittleEndian.AppendUint16(header, CRC(header))
+
+	data := append(header, records...)
+	return binary.LittleEndian.AppendUint16(data, CRC(data))
+}
+
+func TestDecodeBytes_CompressedTimestamps(t *testing.T) {
+	var records bytes.Buffer
+
+	// Local type 0: record with timestamp and power.
+	records.Write([]byte{0x40, 0, 0, byte(MesgRecord), 0, 2, FieldTimestamp, 4, byte(Uint32), 7, 2, byte(Uint16)})
+	records.WriteByte(0x00)
+	records.Write(binary.LittleEndian.AppendUint32(nil, 1000))
+	records.Write(binary.LittleEndian.AppendUint16(nil, 250))
+
+	// Local type 1: record with power only, sent with compressed timestamps.
+	records.Write([]byte{0x41, 0, 0, byte(MesgRecord), 0, 1, 7, 2, byte(Uint16)})
+	// 1000 is 0x3E8, so its low five bits are 8. An offset of 10 is two
+	// seconds later; an offset of 2 wraps into the next 32 second window.
+	records.WriteByte(0x80 | 1<<5 | 10)
+	records.Write(binary.LittleEndian.AppendUint16(nil, 260))
+	records.WriteByte(0x80 | 1<<5 | 2)
+	records.Write(binary.LittleEndian.AppendUint16(nil, 0xFFFF))
+
+	file, err := DecodeBytes(buildFile(records.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, file.Records, 3)
+
+	require.Equal(t, Time(1000), file.Records[0].Timestamp)
+	require.Equal(t, Time(1002), file.Records[1].Timestamp)
+	require.Equal(t, Time(1026), file.Records[2].Timestamp)
+
+	require.Equal(t, 260.0, *file.Records[1].Power)
+	require.Nil(t, file.Records[2].Power, "invalid values decode as missing")
+}
+
+func TestDecodeBytes_UndefinedLocalType(t *testing.T) {
+	_, err := DecodeBytes(buildFile([]byte{0x03, 0x00}))
+
+	var formatErr *FormatError
+	require.True(t, errors.As(err, &formatErr))
+	require.Equal(t, 15, formatErr.Offset)
+}
@@ -0,0 +1,339 @@
+package fit
+
+import "time"
+
+// Global message numbers from the FIT profile.
+const (
+	MesgFileID           uint16 = 0
+	MesgSession          uint16 = 18
+	MesgLap              uint16 = 19
+	MesgRecord           uint16 = 20
+	MesgEvent            uint16 = 21
+	MesgDeviceInfo       uint16 = 23
+	MesgFieldDescription uint16 = 206
+	MesgDeveloperDataID  uint16 = 207
+)
+
+// semicirclesToDegrees converts the FIT position unit to degrees.
+const semicirclesToDegrees = 180.0 / (1 << 31)
+
+func (m Message) uint(num byte) uint64 {
+	value, _ := m.Field(num)
+	u, _ := value.Uint()
+	return u
+}
+
+func (m Message) str(num byte) string {
+	value, _ := m.Field(num)
+	return value.String()
+}
+
+func (m Message) time(num byte) time.Time {
+	value, _ := m.Field(num)
+	if seconds, ok := value.Uint(); ok {
+		return Time(uint32(seconds))
+	}
+	return time.Time{}
+}
+
+// scaled applies the profile's scale and offset: value / scale - offset.
+func (m Message) scaled(num byte, scale, offset float64) (float64, bool) {
+	value, found := m.Field(num)
+	if !found {
+		return 0, false
+	}
+	f, ok := value.Float()
+	if !ok {
+		return 0, false
+	}
+	return f/scale - offset, true
+}
+
+func (m Message) float(num byte, scale, offset float64) float64 {
+	f, _ := m.scaled(num, scale, offset)
+	return f
+}
+
+func (m Message) optional(num byte, scale, offset float64) *float64 {
+	if f, ok := m.scaled(num, scale, offset); ok {
+		return &f
+	}
+	return nil
+}
+
+// enhanced prefers the 32 bit enhanced field over its 16 bit predecessor.
+func (m Message) enhanced(enhancedNum, num byte, scale, offset float64) *float64 {
+	if f := m.optional(enhancedNum, scale, offset); f != nil {
+		return f
+	}
+	return m.optional(num, scale, offset)
+}
+
+// FileID identifies the file and the device that created it.
+type FileID struct {
+	Type         uint8
+	Manufacturer uint16
+	Product      uint16
+	SerialNumber uint32
+	TimeCreated  time.Time
+	Number       uint16
+	ProductName  string
+}
+
+func newFileID(m Message) FileID {
+	return FileID{
+		Type:         uint8(m.uint(0)),
+		Manufacturer: uint16(m.uint(1)),
+		Product:      uint16(m.uint(2)),
+		SerialNumber: uint32(m.uint(3)),
+		TimeCreated:  m.time(4),
+		Number:       uint16(m.uint(5)),
+		ProductName:  m.str(8),
+	}
+}
+
+// Session summarises an activity, or one sport of a multisport activity.
+// Durations are in seconds, distances in metres and speeds in m/s; missing
+// values are zero.
+type Session struct {
+	Timestamp           time.Time
+	StartTime           time.Time
+	Sport               uint8
+	SubSport            uint8
+	TotalElapsedTime    float64
+	TotalTimerTime      float64
+	TotalDistance       float64
+	TotalCalories       uint16
+	AvgSpeed            float64
+	MaxSpeed            float64
+	AvgHeartRate        uint8
+	MaxHeartRate        uint8
+	AvgCadence          uint8
+	MaxCadence          uint8
+	AvgPower            uint16
+	MaxPower            uint16
+	TotalAscent         uint16
+	TotalDescent        uint16
+	NormalizedPower     uint16
+	TrainingStressScore float64
+	IntensityFactor     float64
+	ThresholdPower      uint16
+}
+
+func newSession(m Message) Session {
+	session := Session{
+		Timestamp:           m.Timestamp,
+		StartTime:           m.time(2),
+		Sport:               uint8(m.uint(5)),
+		SubSport:            uint8(m.uint(6)),
+		TotalElapsedTime:    m.float(7, 1000, 0),
+		TotalTimerTime:      m.float(8, 1000, 0),
+		TotalDistance:       m.float(9, 100, 0),
+		TotalCalories:       uint16(m.uint(11)),
+		AvgHeartRate:        uint8(m.uint(16)),
+		MaxHeartRate:        uint8(m.uint(17)),
+		AvgCadence:          uint8(m.uint(18)),
+		MaxCadence:          uint8(m.uint(19)),
+		AvgPower:            uint16(m.uint(20)),
+		MaxPower:            uint16(m.uint(21)),
+		TotalAscent:         uint16(m.uint(22)),
+		TotalDescent:        uint16(m.uint(23)),
+		NormalizedPower:     uint16(m.uint(34)),
+		TrainingStressScore: m.float(35, 10, 0),
+		IntensityFactor:     m.float(36, 1000, 0),
+		ThresholdPower:      uint16(m.uint(45)),
+	}
+	if speed := m.enhanced(124, 14, 1000, 0); speed != nil {
+		session.AvgSpeed = *speed
+	}
+	if speed := m.enhanced(125, 15, 1000, 0); speed != nil {
+		session.MaxSpeed = *speed
+	}
+	return session
+}
+
+// Lap summarises one lap, in the same units as Session.
+type Lap struct {
+	Timestamp        time.Time
+	StartTime        time.Time
+	TotalElapsedTime float64
+	TotalTimerTime   float64
+	TotalDistance    float64
+	TotalCalories    uint16
+	AvgSpeed         float64
+	MaxSpeed         float64
+	AvgHeartRate     uint8
+	MaxHeartRate     uint8
+	AvgCadence       uint8
+	MaxCadence       uint8
+	AvgPower         uint16
+	MaxPower         uint16
+	TotalAscent      uint16
+	TotalDescent     uint16
+	NormalizedPower  uint16
+}
+
+func newLap(m Message) Lap {
+	lap := Lap{
+		Timestamp:        m.Timestamp,
+		StartTime:        m.time(2),
+		TotalElapsedTime: m.float(7, 1000, 0),
+		TotalTimerTime:   m.float(8, 1000, 0),
+		TotalDistance:    m.float(9, 100, 0),
+		TotalCalories:    uint16(m.uint(11)),
+		AvgHeartRate:     uint8(m.uint(15)),
+		MaxHeartRate:     uint8(m.uint(16)),
+		AvgCadence:       uint8(m.uint(17)),
+		MaxCadence:       uint8(m.uint(18)),
+		AvgPower:         uint16(m.uint(19)),
+		MaxPower:         uint16(m.uint(20)),
+		TotalAscent:      uint16(m.uint(21)),
+		TotalDescent:     uint16(m.uint(22)),
+		NormalizedPower:  uint16(m.uint(33)),
+	}
+	if speed := m.enhanced(110, 13, 1000, 0); speed != nil {
+		lap.AvgSpeed = *speed
+	}
+	if speed := m.enhanced(111, 14, 1000, 0); speed != nil {
+		lap.MaxSpeed = *speed
+	}
+	return lap
+}
+
+// Record is one sample of an activity's time series. Sensor channels are nil
+// when the sample does not carry them, so dropouts can be told apart from
+// zeros. Positions are in degrees, altitude and distance in metres, speed in
+// m/s, grade in percent and temperature in °C.
+type Record struct {
+	Timestamp   time.Time
+	Latitude    *float64
+	Longitude   *float64
+	Altitude    *float64
+	HeartRate   *float64
+	Cadence     *float64
+	Distance    *float64
+	Speed       *float64
+	Power       *float64
+	Grade       *float64
+	Temperature *float64
+	// DeveloperFields holds any developer data recorded with the sample.
+	DeveloperFields []DeveloperField
+}
+
+func newRecord(m Message) Record {
+	record := Record{
+		Timestamp:       m.Timestamp,
+		Altitude:        m.enhanced(78, 2, 5, 500),
+		HeartRate:       m.optional(3, 1, 0),
+		Cadence:         m.optional(4, 1, 0),
+		Distance:        m.optional(5, 100, 0),
+		Speed:           m.enhanced(73, 6, 1000, 0),
+		Power:           m.optional(7, 1, 0),
+		Grade:           m.optional(9, 100, 0),
+		Temperature:     m.optional(13, 1, 0),
+		DeveloperFields: m.DeveloperFields,
+	}
+
+	// Positions are only meaningful as a pair.
+	lat, latOK := m.scaled(0, 1/semicirclesToDegrees, 0)
+	long, longOK := m.scaled(1, 1/semicirclesToDegrees, 0)
+	if latOK && longOK {
+		record.Latitude = &lat
+		record.Longitude = &long
+	}
+	return record
+}
+
+// Event marks a change such as the timer starting or stopping.
+type Event struct {
+	Timestamp  time.Time
+	Event      uint8
+	EventType  uint8
+	Data       uint32
+	EventGroup uint8
+}
+
+func newEvent(m Message) Event {
+	return Event{
+		Timestamp:  m.Timestamp,
+		Event:      uint8(m.uint(0)),
+		EventType:  uint8(m.uint(1)),
+		Data:       uint32(m.uint(3)),
+		EventGroup: uint8(m.uint(4)),
+	}
+}
+
+// DeviceInfo describes the recording device or one of its sensors.
+type DeviceInfo struct {
+	Timestamp       time.Time
+	DeviceIndex     uint8
+	DeviceType      uint8
+	Manufacturer    uint16
+	SerialNumber    uint32
+	Product         uint16
+	SoftwareVersion float64
+	HardwareVersion uint8
+	BatteryVoltage  float64
+	BatteryStatus   uint8
+	ProductName     string
+}
+
+func newDeviceInfo(m Message) DeviceInfo {
+	return DeviceInfo{
+		Timestamp:       m.Timestamp,
+		DeviceIndex:     uint8(m.uint(0)),
+		DeviceType:      uint8(m.uint(1)),
+		Manufacturer:    uint16(m.uint(2)),
+		SerialNumber:    uint32(m.uint(3)),
+		Product:         uint16(m.uint(4)),
+		SoftwareVersion: m.float(5, 100, 0),
+		HardwareVersion: uint8(m.uint(6)),
+		BatteryVoltage:  m.float(10, 256, 0),
+		BatteryStatus:   uint8(m.uint(11)),
+		ProductName:     m.str(27),
+	}
+}
+
+// DeveloperDataID identifies the application that defined developer fields.
+type DeveloperDataID struct {
+	DeveloperID        []byte
+	ApplicationID      []byte
+	ManufacturerID     uint16
+	DeveloperDataIndex uint8
+	ApplicationVersion uint32
+}
+
+func newDeveloperDataID(m Message) DeveloperDataID {
+	developerID, _ := m.Field(0)
+	applicationID, _ := m.Field(1)
+	return DeveloperDataID{
+		DeveloperID:        developerID.Raw,
+		ApplicationID:      applicationID.Raw,
+		ManufacturerID:     uint16(m.uint(2)),
+		DeveloperDataIndex: uint8(m.uint(3)),
+		ApplicationVersion: uint32(m.uint(4)),
+	}
+}
+
+// FieldDescription defines a developer field.
+type FieldDescription struct {
+	DeveloperDataIndex    uint8
+	FieldDefinitionNumber uint8
+	BaseType              BaseType
+	FieldName             string
+	Units                 string
+	NativeMesgNum         uint16
+	NativeFieldNum        uint8
+}
+
+func newFieldDescription(m Message) FieldDescription {
+	return FieldDescription{
+		DeveloperDataIndex:    uint8(m.uint(0)),
+		FieldDefinitionNumber: uint8(m.uint(1)),
+		BaseType:              BaseType(m.uint(2)),
+		FieldName:             m.str(3),
+		Units:                 m.str(8),
+		NativeMesgNum:         uint16(m.uint(14)),
+		NativeFieldNum:        uint8(m.uint(15)),
+	}
+}
@@ -0,0 +1,200 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// BaseType is a FIT base type as it appears in field definitions.
+type BaseType byte
+
+const (
+	Enum    BaseType = 0x00
+	Sint8   BaseType = 0x01
+	Uint8   BaseType = 0x02
+	Sint16  BaseType = 0x83
+	Uint16  BaseType = 0x84
+	Sint32  BaseType = 0x85
+	Uint32  BaseType = 0x86
+	String  BaseType = 0x07
+	Float32 BaseType = 0x88
+	Float64 BaseType = 0x89
+	Uint8z  BaseType = 0x0A
+	Uint16z BaseType = 0x8B
+	Uint32z BaseType = 0x8C
+	Byte    BaseType = 0x0D
+	Sint64  BaseType = 0x8E
+	Uint64  BaseType = 0x8F
+	Uint64z BaseType = 0x90
+)
+
+type baseTypeInfo struct {
+	size    int
+	signed  bool
+	float   bool
+	invalid uint64
+}
+
+// baseTypes is indexed by the base type number, the low five bits of a BaseType.
+var baseTypes = map[byte]baseTypeInfo{
+	0x00: {size: 1, invalid: 0xFF},
+	0x01: {size: 1, signed: true, invalid: 0x7F},
+	0x02: {size: 1, invalid: 0xFF},
+	0x03: {size: 2, signed: true, invalid: 0x7FFF},
+	0x04: {size: 2, invalid: 0xFFFF},
+	0x05: {size: 4, signed: true, invalid: 0x7FFFFFFF},
+	0x06: {size: 4, invalid: 0xFFFFFFFF},
+	0x07: {size: 1, invalid: 0x00},
+	0x08: {size: 4, float: true, invalid: 0xFFFFFFFF},
+	0x09: {size: 8, float: true, invalid: 0xFFFFFFFFFFFFFFFF},
+	0x0A: {size: 1, invalid: 0x00},
+	0x0B: {size: 2, invalid: 0x0000},
+	0x0C: {size: 4, invalid: 0x00000000},
+	0x0D: {size: 1, invalid: 0xFF},
+	0x0E: {size: 8, signed: true, invalid: 0x7FFFFFFFFFFFFFFF},
+	0x0F: {size: 8, invalid: 0xFFFFFFFFFFFFFFFF},
+	0x10: {size: 8, invalid: 0x0000000000000000},
+}
+
+func (t BaseType) info() (baseTypeInfo, bool) {
+	info, ok := baseTypes[byte(t)&0x1F]
+	return info, ok
+}
+
+// Value is the raw encoding of a field, decoded on demand. A field may hold an
+// array of its base type; the scalar accessors read the first element.
+type Value struct {
+	Type      BaseType
+	Raw       []byte
+	bigEndian bool
+}
+
+func (v Value) order() binary.ByteOrder {
+	if v.bigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// elementSize is the size of one element, or 1 when the field size is not a
+// multiple of the base type size, in which case the spec treats it as bytes.
+func (v Value) elementSize() int {
+	info, ok := v.Type.info()
+	if !ok || info.size == 0 || len(v.Raw)%info.size != 0 {
+		return 1
+	}
+	return info.size
+}
+
+// Len returns the number of elements in the value.
+func (v Value) Len() int {
+	if v.Type == String {
+		return 1
+	}
+	return len(v.Raw) / v.elementSize()
+}
+
+// Index returns element i of an array value.
+func (v Value) Index(i int) Value {
+	size := v.elementSize()
+	return Value{Type: v.Type, Raw: v.Raw[i*size : (i+1)*size], bigEndian: v.bigEndian}
+}
+
+func (v Value) bits() (uint64, bool) {
+	info, ok := v.Type.info()
+	if !ok || len(v.Raw) < info.size {
+		return 0, false
+	}
+
+	var bits uint64
+	switch info.size {
+	case 1:
+		bits = uint64(v.Raw[0])
+	case 2:
+		bits = uint64(v.order().Uint16(v.Raw))
+	case 4:
+		bits = uint64(v.order().Uint32(v.Raw))
+	case 8:
+		bits = v.order().Uint64(v.Raw)
+	}
+	return bits, bits != info.invalid
+}
+
+// Valid reports whether the first element holds a value other than the base
+// type's invalid marker.
+func (v Value) Valid() bool {
+	if v.Type == String {
+		return v.String() != ""
+	}
+	if byte(v.Type)&0x1F == byte(Byte) {
+		return len(bytes.Trim(v.Raw, "\xff")) > 0
+	}
+	_, ok := v.bits()
+	return ok
+}
+
+// Uint returns the first element as an unsigned integer.
+func (v Value) Uint() (uint64, bool) {
+	info, _ := v.Type.info()
+	if info.signed || info.float {
+		i, ok := v.Int()
+		return uint64(i), ok && i >= 0
+	}
+	return v.bits()
+}
+
+// Int returns the first element as a signed integer.
+func (v Value) Int() (int64, bool) {
+	info, _ := v.Type.info()
+	bits, ok := v.bits()
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case info.float:
+		f, _ := v.Float()
+		return int64(f), true
+	case !info.signed:
+		return int64(bits), true
+	}
+
+	switch info.size {
+	case 1:
+		return int64(int8(bits)), true
+	case 2:
+		return int64(int16(bits)), true
+	case 4:
+		return int64(int32(bits)), true
+	default:
+		return int64(bits), true
+	}
+}
+
+// Float returns the first element as a float64, converting integers.
+func (v Value) Float() (float64, bool) {
+	info, _ := v.Type.info()
+	bits, ok := v.bits()
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case info.float && info.size == 4:
+		return float64(math.Float32frombits(uint32(bits))), true
+	case info.float:
+		return math.Float64frombits(bits), true
+	case info.signed:
+		i, _ := v.Int()
+		return float64(i), true
+	default:
+		return float64(bits), true
+	}
+}
+
+// String returns a string value up to its NUL terminator.
+func (v Value) String() string {
+	if i := bytes.IndexByte(v.Raw, 0); i >= 0 {
+		return string(v.Raw[:i])
+	}
+	return string(v.Raw)
+}
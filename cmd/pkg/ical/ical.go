@@ -0,0 +1,112 @@
+// Package ical writes iCalendar (RFC 5545) calendars of timed events, as
+// subscribed to by calendar apps.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentType is the media type of an iCalendar document.
+const ContentType = "text/calendar; charset=utf-8"
+
+// Calendar is a VCALENDAR of events.
+type Calendar struct {
+	// ProdID identifies the product that wrote the calendar.
+	ProdID string
+	// Name is shown by calendar apps for the subscription.
+	Name   string
+	Events []Event
+}
+
+// Event is a VEVENT. Times are written in UTC.
+type Event struct {
+	// UID identifies the event across versions of the calendar, so that apps
+	// update an event rather than adding a copy.
+	UID string
+	// Stamp is when this version of the event was created.
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	// LastModified is left out when zero.
+	LastModified time.Time
+}
+
+// maxLineOctets is the longest a content line may be before it is folded.
+const maxLineOctets = 75
+
+const dateTimeLayout = "20060102T150405Z"
+
+// Write writes the calendar with CRLF line endings and long lines folded.
+// Events without an End, or ending before they start, are written without
+// one and so end when they start.
+func Write(w io.Writer, calendar Calendar) error {
+	b := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeLine(b, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", escape(calendar.ProdID))
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if calendar.Name != "" {
+		line("X-WR-CALNAME", escape(calendar.Name))
+	}
+	for _, event := range calendar.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", escape(event.UID))
+		line("DTSTAMP", event.Stamp.UTC().Format(dateTimeLayout))
+		line("DTSTART", event.Start.UTC().Format(dateTimeLayout))
+		if event.End.After(event.Start) {
+			line("DTEND", event.End.UTC().Format(dateTimeLayout))
+		}
+		if !event.LastModified.IsZero() {
+			line("LAST-MODIFIED", event.LastModified.UTC().Format(dateTimeLayout))
+		}
+		line("SUMMARY", escape(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION", escape(event.Description))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return b.Flush()
+}
+
+// escape escapes a TEXT value.
+func escape(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(value)
+}
+
+// writeLine writes a content line, folding it into lines of at most 75 octets
+// continued by a leading space. Lines are never split inside a UTF-8
+// character.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		_, _ = w.WriteString(line[:cut])
+		_, _ = w.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines lose an octet to the leading space.
+		limit = maxLineOctets - 1
+	}
+	_, _ = w.WriteString(line)
+	_, _ = w.WriteString("\r\n")
+}
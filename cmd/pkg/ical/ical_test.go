@@ -0,0 +1,82 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+
+	start := time.Date(2024, 4, 9, 20, 40, 25, 0, time.UTC)
+	calendar := Calendar{
+		ProdID: "-//go-wahoo-cloud-api//EN",
+		Name:   "Workouts",
+		Events: []Event{
+			{
+				UID:          "workout-281788767@go-wahoo-cloud-api",
+				Stamp:        start.Add(2 * time.Hour),
+				Start:        start,
+				End:          start.Add(time.Hour),
+				LastModified: start.Add(time.Hour),
+				Summary:      "Hills, repeats; again",
+				Description:  "Distance: 24.3 km\nTSS: 65",
+			},
+			{
+				UID:     "workout-1@go-wahoo-cloud-api",
+				Stamp:   start,
+				Start:   start,
+				Summary: "No end",
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, Write(&out, calendar))
+	require.Equal(t, strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//go-wahoo-cloud-api//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Workouts",
+		"BEGIN:VEVENT",
+		"UID:workout-281788767@go-wahoo-cloud-api",
+		"DTSTAMP:20240409T224025Z",
+		"DTSTART:20240409T204025Z",
+		"DTEND:20240409T214025Z",
+		"LAST-MODIFIED:20240409T214025Z",
+		`SUMMARY:Hills\, repeats\; again`,
+		`DESCRIPTION:Distance: 24.3 km\nTSS: 65`,
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:workout-1@go-wahoo-cloud-api",
+		"DTSTAMP:20240409T204025Z",
+		"DTSTART:20240409T204025Z",
+		"SUMMARY:No end",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n"), out.String())
+}
+
+func TestWrite_FoldsLongLines(t *testing.T) {
+
+	summary := strings.Repeat("é", 60)
+	var out bytes.Buffer
+	require.NoError(t, Write(&out, Calendar{Events: []Event{{Summary: summary}}}))
+
+	var unfolded string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n") {
+		require.LessOrEqual(t, len(line), maxLineOctets, line)
+		if strings.HasPrefix(line, " ") {
+			unfolded += line[1:]
+		} else {
+			unfolded += "\n" + line
+		}
+	}
+	require.Contains(t, unfolded, "\nSUMMARY:"+summary+"\n")
+}
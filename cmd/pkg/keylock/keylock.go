@@ -0,0 +1,19 @@
+// Package keylock serialises work on the same key, such as an athlete or a
+// workout summary, without keeping a mutex for every key ever seen.
+package keylock
+
+import "sync"
+
+// Striped stripes a fixed set of mutexes over integer keys. Keys that share a
+// stripe serialise with each other too, which only costs some concurrency.
+// The zero value is ready to use.
+type Striped struct {
+	mutexes [64]sync.Mutex
+}
+
+// Lock locks the key's mutex and returns the function that unlocks it.
+func (s *Striped) Lock(key int) (unlock func()) {
+	mu := &s.mutexes[uint(key)%uint(len(s.mutexes))]
+	mu.Lock()
+	return mu.Unlock
+}
@@ -0,0 +1,34 @@
+package keylock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStriped_Lock(t *testing.T) {
+
+	var locks Striped
+	unlock := locks.Lock(1120489)
+
+	// Another key's stripe is free.
+	locks.Lock(1120490)()
+
+	locked := make(chan struct{})
+	go func() {
+		locks.Lock(1120489)()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Expected the key to stay locked until it is unlocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the key to be locked again once unlocked")
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import "github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+
+// CurveDurations are the durations, in seconds, a power curve is computed for.
+var CurveDurations = []int{1, 5, 10, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 5400, 7200, 10800, 14400, 18000}
+
+// CurvePoint is the highest average power held for a duration.
+type CurvePoint struct {
+	Duration int     `json:"duration"`
+	Watts    float64 `json:"watts"`
+}
+
+// Curve is a mean-maximal power curve, ordered by duration. It only has
+// points for durations no longer than the workout.
+type Curve []CurvePoint
+
+// Watts returns the curve's power for a duration, with ok false when the
+// curve has no point for it.
+func (c Curve) Watts(duration int) (watts float64, ok bool) {
+	for _, point := range c {
+		if point.Duration == duration {
+			return point.Watts, true
+		}
+	}
+	return 0, false
+}
+
+// ComputeCurve computes the mean-maximal power curve of a workout. ok is false
+// when the records have no power data.
+func ComputeCurve(records []fit.Record) (curve Curve, ok bool) {
+	if !has(records, recordPower) {
+		return nil, false
+	}
+	watts := series(records, recordPower, true)
+
+	// sums[i] is the total of the first i seconds, so any window's average
+	// takes a single subtraction.
+	sums := make([]float64, len(watts)+1)
+	for i, value := range watts {
+		sums[i+1] = sums[i] + value
+	}
+
+	for _, duration := range CurveDurations {
+		if duration > len(watts) {
+			break
+		}
+		var best float64
+		for end := duration; end <= len(watts); end++ {
+			if sum := sums[end] - sums[end-duration]; sum > best {
+				best = sum
+			}
+		}
+		curve = append(curve, CurvePoint{Duration: duration, Watts: round(best/float64(duration), 1)})
+	}
+	return curve, true
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeCurve(t *testing.T) {
+
+	start := time.Date(2024, 4, 9, 20, 40, 0, 0, time.UTC)
+	// Ten seconds of 100W, a 5 second 500W sprint, then 50W to the minute.
+	values := make([]float64, 60)
+	for i := range values {
+		switch {
+		case i < 10:
+			values[i] = 100
+		case i < 15:
+			values[i] = 500
+		default:
+			values[i] = 50
+		}
+	}
+
+	curve, ok := ComputeCurve(records(start, values...))
+	require.True(t, ok)
+	require.Equal(t, Curve{
+		{Duration: 1, Watts: 500},
+		{Duration: 5, Watts: 500},
+		{Duration: 10, Watts: 300},
+		{Duration: 15, Watts: 233.3},
+		{Duration: 30, Watts: 141.7},
+		{Duration: 60, Watts: 95.8},
+	}, curve)
+
+	watts, ok := curve.Watts(10)
+	require.True(t, ok)
+	require.Equal(t, 300.0, watts)
+	_, ok = curve.Watts(120)
+	require.False(t, ok)
+
+	_, ok = ComputeCurve(records(start, -1, -1))
+	require.False(t, ok)
+}
+
+func TestComputeCurve_FitFile(t *testing.T) {
+
+	file := testFile(t)
+	curve, ok := ComputeCurve(file.Records)
+	require.True(t, ok)
+	require.Len(t, curve, 6)
+	require.Equal(t, CurvePoint{Duration: 1, Watts: 176}, curve[0])
+
+	// Power can only fall as the duration grows.
+	for i := 1; i < len(curve); i++ {
+		require.LessOrEqual(t, curve[i].Watts, curve[i-1].Watts)
+	}
+}
@@ -0,0 +1,259 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+)
+
+// HeartRateZoneModel is a way of deriving heart rate zones from an athlete's
+// settings.
+type HeartRateZoneModel string
+
+const (
+	// ZonesMaxHeartRate is five zones at 60, 70, 80 and 90% of max heart rate.
+	ZonesMaxHeartRate HeartRateZoneModel = "max"
+	// ZonesHeartRateReserve is five zones at 60, 70, 80 and 90% of heart rate
+	// reserve, the range between resting and max heart rate (Karvonen).
+	ZonesHeartRateReserve HeartRateZoneModel = "reserve"
+	// ZonesThreshold is Friel's seven cycling zones relative to lactate
+	// threshold heart rate.
+	ZonesThreshold HeartRateZoneModel = "threshold"
+	// ZonesCustom takes the zones' upper bounds from the settings.
+	ZonesCustom HeartRateZoneModel = "custom"
+)
+
+// HeartRateSettings are an athlete's heart rate settings, in beats per minute.
+// Zero values mean the setting is not known.
+type HeartRateSettings struct {
+	Max       float64
+	Resting   float64
+	Threshold float64
+	// ZoneModel chooses the zones. When it is empty the most specific
+	// model the settings allow is used: reserve, then max, then threshold.
+	ZoneModel HeartRateZoneModel
+	// Zones are the upper bounds of every zone but the last, for ZonesCustom.
+	Zones []float64
+	// Female selects the women's weighting factor for TRIMP.
+	Female bool
+}
+
+type heartRateZone struct {
+	Name  string
+	Upper float64
+}
+
+var (
+	fiveZones = []heartRateZone{
+		{"Recovery", 0.60},
+		{"Endurance", 0.70},
+		{"Tempo", 0.80},
+		{"Threshold", 0.90},
+		{"Maximum", math.Inf(1)},
+	}
+	frielZones = []heartRateZone{
+		{"Recovery", 0.81},
+		{"Aerobic", 0.90},
+		{"Tempo", 0.94},
+		{"Sub-Threshold", 1.00},
+		{"Super-Threshold", 1.03},
+		{"Aerobic Capacity", 1.06},
+		{"Anaerobic Capacity", math.Inf(1)},
+	}
+)
+
+// Validate reports settings that cannot be used to compute zones.
+func (s HeartRateSettings) Validate() error {
+	if s.Max < 0 || s.Resting < 0 || s.Threshold < 0 {
+		return fmt.Errorf("heart rates must not be negative")
+	}
+	if s.Max > 0 && s.Resting >= s.Max {
+		return fmt.Errorf("resting heart rate must be below max heart rate")
+	}
+
+	switch s.ZoneModel {
+	case "":
+	case ZonesMaxHeartRate:
+		if s.Max == 0 {
+			return fmt.Errorf("%s zones need a max heart rate", s.ZoneModel)
+		}
+	case ZonesHeartRateReserve:
+		if s.Max == 0 || s.Resting == 0 {
+			return fmt.Errorf("%s zones need max and resting heart rates", s.ZoneModel)
+		}
+	case ZonesThreshold:
+		if s.Threshold == 0 {
+			return fmt.Errorf("%s zones need a threshold heart rate", s.ZoneModel)
+		}
+	case ZonesCustom:
+		if len(s.Zones) == 0 || !sort.Float64sAreSorted(s.Zones) || s.Zones[0] <= 0 {
+			return fmt.Errorf("%s zones need ascending, positive upper bounds", s.ZoneModel)
+		}
+	default:
+		return fmt.Errorf("unknown heart rate zone model %q", s.ZoneModel)
+	}
+	return nil
+}
+
+// zoneModel is the model the settings' zones are computed with, or "" when
+// they do not define any.
+func (s HeartRateSettings) zoneModel() HeartRateZoneModel {
+	switch {
+	case s.ZoneModel != "":
+		return s.ZoneModel
+	case s.Max > 0 && s.Resting > 0:
+		return ZonesHeartRateReserve
+	case s.Max > 0:
+		return ZonesMaxHeartRate
+	case s.Threshold > 0:
+		return ZonesThreshold
+	}
+	return ""
+}
+
+// zones returns the zones' names and upper bounds in beats per minute, with ok
+// false when the settings do not define any.
+func (s HeartRateSettings) zones() (names []string, uppers []float64, ok bool) {
+	if s.Validate() != nil {
+		return nil, nil, false
+	}
+
+	var zones []heartRateZone
+	bound := func(fraction float64) float64 { return fraction }
+	switch s.zoneModel() {
+	case ZonesMaxHeartRate:
+		zones = fiveZones
+		bound = func(fraction float64) float64 { return fraction * s.Max }
+	case ZonesHeartRateReserve:
+		zones = fiveZones
+		bound = func(fraction float64) float64 { return s.Resting + fraction*(s.Max-s.Resting) }
+	case ZonesThreshold:
+		zones = frielZones
+		bound = func(fraction float64) float64 { return fraction * s.Threshold }
+	case ZonesCustom:
+		for i, upper := range s.Zones {
+			zones = append(zones, heartRateZone{fmt.Sprintf("Zone %d", i+1), upper})
+		}
+		zones = append(zones, heartRateZone{fmt.Sprintf("Zone %d", len(s.Zones)+1), math.Inf(1)})
+	default:
+		return nil, nil, false
+	}
+
+	for _, zone := range zones {
+		names = append(names, zone.Name)
+		uppers = append(uppers, bound(zone.Upper))
+	}
+	return names, uppers, true
+}
+
+// HeartRate holds the heart rate based metrics of a workout. Zones and TRIMP
+// are only computed when the athlete's settings allow, and Decoupling only for
+// workouts long enough to measure it.
+type HeartRate struct {
+	AverageHeartRate float64    `json:"average_heart_rate"`
+	MaxHeartRate     float64    `json:"max_heart_rate"`
+	Seconds          int        `json:"seconds"`
+	ZoneModel        string     `json:"zone_model,omitempty"`
+	Zones            []ZoneTime `json:"zones,omitempty"`
+	// TRIMP is Banister's training impulse.
+	TRIMP float64 `json:"trimp,omitempty"`
+	// Decoupling is the percentage by which the ratio of output to heart
+	// rate fell from the first half of the workout to the second (Pa:HR).
+	Decoupling *float64 `json:"decoupling,omitempty"`
+	// DecouplingBasis is the output Decoupling compares heart rate with:
+	// "power", or "speed" for workouts without power.
+	DecouplingBasis string `json:"decoupling_basis,omitempty"`
+}
+
+// MinDecouplingSeconds is the shortest workout aerobic decoupling is computed
+// for.
+const MinDecouplingSeconds = 20 * 60
+
+// TRIMP weighting factors from Banister's model.
+const (
+	trimpFactorMale   = 1.92
+	trimpFactorFemale = 1.67
+)
+
+func recordHeartRate(record fit.Record) *float64 { return record.HeartRate }
+func recordSpeed(record fit.Record) *float64     { return record.Speed }
+
+// ComputeHeartRate computes heart rate metrics using the athlete's settings.
+// ok is false when the records have no heart rate data.
+func ComputeHeartRate(records []fit.Record, settings HeartRateSettings) (heartRate HeartRate, ok bool) {
+	if !has(records, recordHeartRate) {
+		return HeartRate{}, false
+	}
+	// Seconds without a heart rate, where the strap dropped out, are
+	// excluded; filling keeps the series aligned with power and speed.
+	bpm := series(records, recordHeartRate, true)
+
+	var measured []float64
+	var trimp float64
+	weighting := trimpFactorMale
+	if settings.Female {
+		weighting = trimpFactorFemale
+	}
+	for _, value := range bpm {
+		if value == 0 {
+			continue
+		}
+		measured = append(measured, value)
+		heartRate.MaxHeartRate = math.Max(heartRate.MaxHeartRate, value)
+
+		if settings.Max > 0 && settings.Resting > 0 && settings.Max > settings.Resting {
+			reserve := math.Max(0, (value-settings.Resting)/(settings.Max-settings.Resting))
+			trimp += reserve * 0.64 * math.Exp(weighting*reserve) / 60
+		}
+	}
+	if len(measured) == 0 {
+		return HeartRate{}, false
+	}
+
+	heartRate.Seconds = len(measured)
+	heartRate.AverageHeartRate = round(mean(measured), 1)
+	heartRate.TRIMP = round(trimp, 1)
+	if names, uppers, ok := settings.zones(); ok {
+		heartRate.ZoneModel = string(settings.zoneModel())
+		heartRate.Zones = zoneTimes(measured, names, uppers)
+	}
+
+	var basis string
+	var output []float64
+	switch {
+	case has(records, recordPower):
+		basis, output = "power", series(records, recordPower, true)
+	case has(records, recordSpeed):
+		basis, output = "speed", series(records, recordSpeed, true)
+	}
+	if value, ok := decoupling(output, bpm); ok {
+		heartRate.Decoupling = &value
+		heartRate.DecouplingBasis = basis
+	}
+	return heartRate, true
+}
+
+// decoupling compares the output:heart rate ratio of the two halves of the
+// seconds that have a heart rate. Both series are aligned, one value a second.
+func decoupling(output, bpm []float64) (float64, bool) {
+	var outputs, rates []float64
+	for i, value := range bpm {
+		if value > 0 && i < len(output) {
+			outputs = append(outputs, output[i])
+			rates = append(rates, value)
+		}
+	}
+	if len(rates) < MinDecouplingSeconds {
+		return 0, false
+	}
+
+	half := len(rates) / 2
+	first := mean(outputs[:half]) / mean(rates[:half])
+	second := mean(outputs[half:]) / mean(rates[half:])
+	if first == 0 {
+		return 0, false
+	}
+	return round((first-second)/first*100, 2), true
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+	"github.com/stretchr/testify/require"
+)
+
+// steadyRide is an hour at constant power whose heart rate drifts from 130 to
+// 143bpm over the second half, as a rider tires.
+func steadyRide() []fit.Record {
+	start := time.Date(2024, 4, 9, 7, 0, 0, 0, time.UTC)
+	out := make([]fit.Record, 3600)
+	for i := range out {
+		power, heartRate := 200.0, 130.0
+		if i >= 1800 {
+			heartRate = 143
+		}
+		out[i] = fit.Record{Timestamp: start.Add(time.Duration(i) * time.Second), Power: &power, HeartRate: &heartRate}
+	}
+	return out
+}
+
+func TestComputeHeartRate(t *testing.T) {
+
+	heartRate, ok := ComputeHeartRate(steadyRide(), HeartRateSettings{Max: 190, Resting: 50})
+	require.True(t, ok)
+	require.Equal(t, 3600, heartRate.Seconds)
+	require.Equal(t, 136.5, heartRate.AverageHeartRate)
+	require.Equal(t, 143.0, heartRate.MaxHeartRate)
+
+	// 200/130 falling to 200/143 is a 9.09% decoupling.
+	require.NotNil(t, heartRate.Decoupling)
+	require.Equal(t, 9.09, *heartRate.Decoupling)
+	require.Equal(t, "power", heartRate.DecouplingBasis)
+
+	// Half an hour at 57.1% and half at 66.4% of heart rate reserve.
+	require.InDelta(t, 30*0.571*0.64*2.9935+30*0.664*0.64*3.5783, heartRate.TRIMP, 0.5)
+
+	require.Equal(t, "reserve", heartRate.ZoneModel)
+	require.Equal(t, []ZoneTime{
+		{Zone: 1, Name: "Recovery", Min: 0, Max: 134, Seconds: 1800},
+		{Zone: 2, Name: "Endurance", Min: 134, Max: 148, Seconds: 1800},
+		{Zone: 3, Name: "Tempo", Min: 148, Max: 162},
+		{Zone: 4, Name: "Threshold", Min: 162, Max: 176},
+		{Zone: 5, Name: "Maximum", Min: 176},
+	}, heartRate.Zones)
+
+	female, ok := ComputeHeartRate(steadyRide(), HeartRateSettings{Max: 190, Resting: 50, Female: true})
+	require.True(t, ok)
+	require.Less(t, female.TRIMP, heartRate.TRIMP)
+}
+
+func TestComputeHeartRate_ZoneModels(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		settings HeartRateSettings
+		model    string
+		zones    int
+		first    ZoneTime
+	}{
+		{name: "none", settings: HeartRateSettings{}},
+		{name: "max", settings: HeartRateSettings{Max: 200}, model: "max", zones: 5,
+			first: ZoneTime{Zone: 1, Name: "Recovery", Max: 120}},
+		{name: "threshold", settings: HeartRateSettings{Max: 190, Threshold: 160, ZoneModel: ZonesThreshold}, model: "threshold", zones: 7,
+			first: ZoneTime{Zone: 1, Name: "Recovery", Max: 130}},
+		{name: "custom", settings: HeartRateSettings{ZoneModel: ZonesCustom, Zones: []float64{135, 150}}, model: "custom", zones: 3,
+			first: ZoneTime{Zone: 1, Name: "Zone 1", Max: 135, Seconds: 1800}},
+		{name: "invalid", settings: HeartRateSettings{ZoneModel: ZonesCustom, Zones: []float64{150, 135}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			heartRate, ok := ComputeHeartRate(steadyRide(), tc.settings)
+			require.True(t, ok)
+			require.Equal(t, tc.model, heartRate.ZoneModel)
+			require.Len(t, heartRate.Zones, tc.zones)
+			if tc.zones > 0 {
+				require.Equal(t, tc.first, heartRate.Zones[0])
+			}
+			require.Zero(t, heartRate.TRIMP)
+		})
+	}
+}
+
+func TestComputeHeartRate_ShortOrMissing(t *testing.T) {
+
+	// The indoor ride is too short to decouple and has no heart rate at all.
+	_, ok := ComputeHeartRate(testFile(t).Records, HeartRateSettings{Max: 190})
+	require.False(t, ok)
+
+	heartRate, ok := ComputeHeartRate(steadyRide()[:600], HeartRateSettings{})
+	require.True(t, ok)
+	require.Nil(t, heartRate.Decoupling)
+}
+
+func TestHeartRateSettings_Validate(t *testing.T) {
+
+	require.NoError(t, HeartRateSettings{}.Validate())
+	require.NoError(t, HeartRateSettings{Max: 190, Resting: 50, ZoneModel: ZonesHeartRateReserve}.Validate())
+	require.Error(t, HeartRateSettings{Max: 190, ZoneModel: ZonesHeartRateReserve}.Validate())
+	require.Error(t, HeartRateSettings{Max: 50, Resting: 60}.Validate())
+	require.Error(t, HeartRateSettings{ZoneModel: "polarised"}.Validate())
+}
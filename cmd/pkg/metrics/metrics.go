@@ -0,0 +1,133 @@
+// Package metrics derives training metrics from the record stream of a
+// decoded FIT file.
+//
+// Records are resampled to one value per second before anything is computed,
+// so the results do not depend on the device's recording interval. Gaps
+// longer than MaxGap, where the device was paused, are not filled in.
+package metrics
+
+import (
+	"math"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+)
+
+// MaxGap is the longest interval between records that is treated as
+// continuous recording.
+const MaxGap = 5 * time.Second
+
+// Athlete is what is known about the athlete who recorded a workout.
+// Zero values mean the setting is not known.
+type Athlete struct {
+	// FTP is the athlete's functional threshold power in watts.
+	FTP float64
+	// HeartRate holds the settings heart rate zones and TRIMP are computed
+	// from.
+	HeartRate HeartRateSettings
+}
+
+// Analysis is everything computed from one workout. Sections are nil when the
+// workout has no data for them.
+type Analysis struct {
+	Power      *Power     `json:"power,omitempty"`
+	PowerCurve Curve      `json:"power_curve,omitempty"`
+	HeartRate  *HeartRate `json:"heart_rate,omitempty"`
+}
+
+// Analyse computes the metrics of a decoded workout.
+func Analyse(file *fit.File, athlete Athlete) Analysis {
+	var analysis Analysis
+	if power, ok := ComputePower(file.Records, athlete.FTP); ok {
+		analysis.Power = &power
+	}
+	analysis.PowerCurve, _ = ComputeCurve(file.Records)
+	if heartRate, ok := ComputeHeartRate(file.Records, athlete.HeartRate); ok {
+		analysis.HeartRate = &heartRate
+	}
+	return analysis
+}
+
+// series resamples a channel of records to 1 Hz. Each record's value is held
+// until the next record, or for a single second across a pause. Records
+// without a value count as zero when fill is true, as a power meter reporting
+// nothing means no power, and are skipped otherwise.
+func series(records []fit.Record, channel func(fit.Record) *float64, fill bool) []float64 {
+	var out []float64
+	for i, record := range records {
+		seconds := 1
+		if i+1 < len(records) {
+			gap := records[i+1].Timestamp.Sub(record.Timestamp)
+			if gap <= 0 {
+				continue
+			}
+			if gap <= MaxGap {
+				seconds = int(gap.Round(time.Second) / time.Second)
+			}
+		}
+
+		value := channel(record)
+		if value == nil {
+			if !fill {
+				continue
+			}
+			value = new(float64)
+		}
+		for ; seconds > 0; seconds-- {
+			out = append(out, *value)
+		}
+	}
+	return out
+}
+
+// has reports whether any record has a value for the channel.
+func has(records []fit.Record, channel func(fit.Record) *float64) bool {
+	for _, record := range records {
+		if channel(record) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneTimes counts the seconds values spend in each zone, given the zones'
+// names and upper bounds. The last bound must be +Inf.
+func zoneTimes(values []float64, names []string, uppers []float64) []ZoneTime {
+	zones := make([]ZoneTime, len(uppers))
+	lower := 0.0
+	for i, upper := range uppers {
+		zones[i] = ZoneTime{Zone: i + 1, Name: names[i], Min: math.Round(lower)}
+		if !math.IsInf(upper, 1) {
+			zones[i].Max = math.Round(upper)
+		}
+		lower = upper
+	}
+
+	for _, value := range values {
+		for i, upper := range uppers {
+			if value < upper {
+				zones[i].Seconds++
+				break
+			}
+		}
+	}
+	return zones
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// round rounds to the given number of decimal places, to keep stored results
+// free of floating point noise.
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
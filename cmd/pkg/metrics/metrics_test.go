@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+	"github.com/stretchr/testify/require"
+)
+
+func testFile(t *testing.T) *fit.File {
+	t.Helper()
+	data, err := os.ReadFile("../utils/testdata/small-fit-file.fit")
+	require.NoError(t, err)
+	file, err := fit.DecodeBytes(data)
+	require.NoError(t, err)
+	return file
+}
+
+// records builds a record per second from values, with nil for -1.
+func records(start time.Time, values ...float64) []fit.Record {
+	out := make([]fit.Record, len(values))
+	for i, value := range values {
+		out[i].Timestamp = start.Add(time.Duration(i) * time.Second)
+		if value >= 0 {
+			value := value
+			out[i].Power = &value
+			out[i].HeartRate = &value
+		}
+	}
+	return out
+}
+
+func TestSeries(t *testing.T) {
+
+	start := time.Date(2024, 4, 9, 20, 40, 0, 0, time.UTC)
+	input := records(start, 100, -1, 200, 300)
+	// A three second gap is filled and a paused minute is not.
+	input[2].Timestamp = start.Add(2 * time.Second)
+	input[3].Timestamp = start.Add(5 * time.Second)
+	input = append(input, records(start.Add(time.Minute), 400)...)
+
+	require.Equal(t, []float64{100, 0, 200, 200, 200, 300, 400}, series(input, recordPower, true))
+	require.Equal(t, []float64{100, 200, 200, 200, 300, 400}, series(input, recordPower, false))
+}
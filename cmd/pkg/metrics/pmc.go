@@ -0,0 +1,48 @@
+package metrics
+
+import "math"
+
+// Time constants, in days, of the performance management model.
+const (
+	CTLDays = 42
+	ATLDays = 7
+)
+
+// PMCDay is one day of an athlete's performance management chart. Date is the
+// UTC day, formatted as 2006-01-02.
+type PMCDay struct {
+	Date string `json:"date"`
+	// TSS is the total training stress of the day's workouts.
+	TSS float64 `json:"tss"`
+	// CTL, chronic training load, is fitness: the exponentially weighted
+	// average of daily TSS over CTLDays.
+	CTL float64 `json:"ctl"`
+	// ATL, acute training load, is fatigue: the same over ATLDays.
+	ATL float64 `json:"atl"`
+	// TSB, training stress balance, is form: the previous day's CTL less its
+	// ATL, so a day's training does not count against that day's form.
+	TSB float64 `json:"tsb"`
+}
+
+// NextPMCDay continues the chart from previous, the zero value at the start,
+// to a day with the given total TSS.
+func NextPMCDay(previous PMCDay, date string, tss float64) PMCDay {
+	ctl := previous.CTL + (tss-previous.CTL)*(1-math.Exp(-1.0/CTLDays))
+	atl := previous.ATL + (tss-previous.ATL)*(1-math.Exp(-1.0/ATLDays))
+	return PMCDay{
+		Date: date,
+		TSS:  tss,
+		CTL:  ctl,
+		ATL:  atl,
+		TSB:  previous.CTL - previous.ATL,
+	}
+}
+
+// Rounded returns the day with its loads rounded for display.
+func (d PMCDay) Rounded() PMCDay {
+	d.TSS = round(d.TSS, 1)
+	d.CTL = round(d.CTL, 1)
+	d.ATL = round(d.ATL, 1)
+	d.TSB = round(d.TSB, 1)
+	return d
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextPMCDay(t *testing.T) {
+
+	// A rest day leaves the chart where it is.
+	require.Equal(t, PMCDay{Date: "2024-04-01"}, NextPMCDay(PMCDay{}, "2024-04-01", 0))
+
+	day := NextPMCDay(PMCDay{}, "2024-04-01", 100)
+	require.Equal(t, PMCDay{Date: "2024-04-01", TSS: 100, CTL: 2.4, ATL: 13.3, TSB: 0}, day.Rounded())
+
+	next := NextPMCDay(day, "2024-04-02", 0)
+	require.Equal(t, PMCDay{Date: "2024-04-02", TSS: 0, CTL: 2.3, ATL: 11.5, TSB: -11}, next.Rounded())
+
+	// Training every day at the same load converges on it.
+	for i := 0; i < 1000; i++ {
+		day = NextPMCDay(day, "", 80)
+	}
+	require.InDelta(t, 80, day.CTL, 0.01)
+	require.InDelta(t, 80, day.ATL, 0.01)
+	require.InDelta(t, 0, day.TSB, 0.01)
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"math"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
+)
+
+// Power holds the power based metrics of a workout. Those relative to FTP are
+// zero, and Zones empty, when no FTP was known.
+type Power struct {
+	// FTP is the functional threshold power the metrics were computed
+	// against, in watts.
+	FTP              float64 `json:"ftp,omitempty"`
+	AveragePower     float64 `json:"average_power"`
+	MaxPower         float64 `json:"max_power"`
+	NormalizedPower  float64 `json:"normalized_power"`
+	IntensityFactor  float64 `json:"intensity_factor,omitempty"`
+	TSS              float64 `json:"tss,omitempty"`
+	VariabilityIndex float64 `json:"variability_index"`
+	// Work is the total work done, in kilojoules.
+	Work float64 `json:"work"`
+	// Seconds is the recorded time the metrics cover.
+	Seconds int        `json:"seconds"`
+	Zones   []ZoneTime `json:"zones,omitempty"`
+}
+
+// ZoneTime is the time spent in one zone. Min and Max are the zone's bounds;
+// Max is zero for the open-ended top zone.
+type ZoneTime struct {
+	Zone    int     `json:"zone"`
+	Name    string  `json:"name"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max,omitempty"`
+	Seconds int     `json:"seconds"`
+}
+
+// PowerZones are Coggan's seven power zones, as upper bounds relative to FTP.
+var PowerZones = []struct {
+	Name  string
+	Upper float64
+}{
+	{"Active Recovery", 0.55},
+	{"Endurance", 0.75},
+	{"Tempo", 0.90},
+	{"Lactate Threshold", 1.05},
+	{"VO2 Max", 1.20},
+	{"Anaerobic Capacity", 1.50},
+	{"Neuromuscular Power", math.Inf(1)},
+}
+
+// normalizedPowerWindow is the length of the rolling average that normalized
+// power is computed over, in seconds.
+const normalizedPowerWindow = 30
+
+func recordPower(record fit.Record) *float64 { return record.Power }
+
+// ComputePower computes power metrics against ftp, which may be zero if it is
+// not known. ok is false when the records have no power data.
+func ComputePower(records []fit.Record, ftp float64) (power Power, ok bool) {
+	if !has(records, recordPower) {
+		return Power{}, false
+	}
+	watts := series(records, recordPower, true)
+
+	power.Seconds = len(watts)
+	power.AveragePower = mean(watts)
+	var work float64
+	for _, value := range watts {
+		work += value
+		power.MaxPower = math.Max(power.MaxPower, value)
+	}
+	power.Work = round(work/1000, 1)
+	power.NormalizedPower = normalizedPower(watts)
+	if power.AveragePower > 0 {
+		power.VariabilityIndex = round(power.NormalizedPower/power.AveragePower, 3)
+	}
+
+	if ftp > 0 {
+		power.FTP = ftp
+		power.IntensityFactor = power.NormalizedPower / ftp
+		power.TSS = round(float64(power.Seconds)*power.NormalizedPower*power.IntensityFactor/(ftp*3600)*100, 1)
+		power.IntensityFactor = round(power.IntensityFactor, 3)
+		power.Zones = powerZones(watts, ftp)
+	}
+
+	power.AveragePower = round(power.AveragePower, 1)
+	power.NormalizedPower = round(power.NormalizedPower, 1)
+	return power, true
+}
+
+// normalizedPower is the fourth root of the mean of the fourth powers of the
+// 30 second rolling average. Workouts shorter than the window fall back to
+// their average power.
+func normalizedPower(watts []float64) float64 {
+	if len(watts) < normalizedPowerWindow {
+		return mean(watts)
+	}
+
+	var sum, fourths float64
+	for i, value := range watts {
+		sum += value
+		if i >= normalizedPowerWindow {
+			sum -= watts[i-normalizedPowerWindow]
+		}
+		if i >= normalizedPowerWindow-1 {
+			fourths += math.Pow(sum/normalizedPowerWindow, 4)
+		}
+	}
+	return math.Pow(fourths/float64(len(watts)-normalizedPowerWindow+1), 0.25)
+}
+
+func powerZones(watts []float64, ftp float64) []ZoneTime {
+	names := make([]string, len(PowerZones))
+	uppers := make([]float64, len(PowerZones))
+	for i, zone := range PowerZones {
+		names[i] = zone.Name
+		uppers[i] = zone.Upper * ftp
+	}
+	return zoneTimes(watts, names, uppers)
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputePower(t *testing.T) {
+
+	file := testFile(t)
+	session := file.Sessions[0]
+
+	power, ok := ComputePower(file.Records, float64(session.ThresholdPower))
+	require.True(t, ok)
+
+	// The head unit's own figures for the same ride. Its average excludes
+	// zeros, and its maximum comes from samples between records.
+	require.Equal(t, 69, power.Seconds)
+	require.Equal(t, 67.8, power.AveragePower)
+	require.InDelta(t, float64(session.MaxPower), power.MaxPower, 1)
+	require.InDelta(t, float64(session.NormalizedPower), power.NormalizedPower, 1)
+	require.InDelta(t, session.IntensityFactor, power.IntensityFactor, 0.005)
+	require.InDelta(t, session.TrainingStressScore, power.TSS, 0.05)
+	require.Equal(t, 1.17, power.VariabilityIndex)
+	require.Equal(t, 4.7, power.Work)
+
+	require.Len(t, power.Zones, 7)
+	require.Equal(t, ZoneTime{Zone: 1, Name: "Active Recovery", Min: 0, Max: 125, Seconds: 54}, power.Zones[0])
+	require.Equal(t, ZoneTime{Zone: 7, Name: "Neuromuscular Power", Min: 341}, power.Zones[6])
+	total := 0
+	for _, zone := range power.Zones {
+		total += zone.Seconds
+	}
+	require.Equal(t, power.Seconds, total)
+}
+
+func TestComputePower_WithoutFTP(t *testing.T) {
+
+	start := time.Date(2024, 4, 9, 20, 40, 0, 0, time.UTC)
+	steady := make([]float64, 120)
+	for i := range steady {
+		steady[i] = 200
+	}
+
+	power, ok := ComputePower(records(start, steady...), 0)
+	require.True(t, ok)
+	require.Equal(t, 200.0, power.NormalizedPower)
+	require.Equal(t, 1.0, power.VariabilityIndex)
+	require.Equal(t, 24.0, power.Work)
+	require.Zero(t, power.TSS)
+	require.Nil(t, power.Zones)
+
+	// An hour at FTP is 100 TSS.
+	hour := make([]float64, 3600)
+	for i := range hour {
+		hour[i] = 250
+	}
+	power, ok = ComputePower(records(start, hour...), 250)
+	require.True(t, ok)
+	require.Equal(t, 1.0, power.IntensityFactor)
+	require.Equal(t, 100.0, power.TSS)
+
+	_, ok = ComputePower(records(start, -1, -1), 250)
+	require.False(t, ok)
+}
@@ -0,0 +1,184 @@
+// Package retry runs operations with exponential backoff, retrying only the
+// failures that are likely to succeed on a later attempt.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultBaseDelay   = 500 * time.Millisecond
+	DefaultMaxDelay    = 30 * time.Second
+)
+
+// Policy controls how many times an operation is attempted and how long to
+// wait between attempts. The wait doubles after every failure, capped at
+// MaxDelay, and is jittered into [delay/2, delay] so that concurrent workers
+// do not retry in lockstep.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: DefaultMaxAttempts,
+		BaseDelay:   DefaultBaseDelay,
+		MaxDelay:    DefaultMaxDelay,
+	}
+}
+
+// PolicyFromEnv reads RETRY_MAX_ATTEMPTS, RETRY_BASE_DELAY and RETRY_MAX_DELAY,
+// falling back to the defaults for anything unset or invalid.
+func PolicyFromEnv() Policy {
+	policy := DefaultPolicy()
+
+	if value := os.Getenv("RETRY_MAX_ATTEMPTS"); value != "" {
+		if attempts, err := strconv.Atoi(value); err == nil && attempts > 0 {
+			policy.MaxAttempts = attempts
+		} else {
+			log.Printf("Ignoring invalid RETRY_MAX_ATTEMPTS %q", value)
+		}
+	}
+	if value := os.Getenv("RETRY_BASE_DELAY"); value != "" {
+		if delay, err := time.ParseDuration(value); err == nil && delay >= 0 {
+			policy.BaseDelay = delay
+		} else {
+			log.Printf("Ignoring invalid RETRY_BASE_DELAY %q", value)
+		}
+	}
+	if value := os.Getenv("RETRY_MAX_DELAY"); value != "" {
+		if delay, err := time.ParseDuration(value); err == nil && delay >= 0 {
+			policy.MaxDelay = delay
+		} else {
+			log.Printf("Ignoring invalid RETRY_MAX_DELAY %q", value)
+		}
+	}
+	return policy
+}
+
+// Do calls fn until it succeeds, returns an error that is not Retryable, the
+// policy runs out of attempts or ctx is done. The last error from fn is
+// returned, annotated with the number of attempts made.
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if !Retryable(err) {
+			return err
+		}
+		if attempt >= maxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		delay := p.Backoff(attempt)
+		log.Printf("Attempt %d of %d failed, retrying in %s: %v", attempt, maxAttempts, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Backoff returns the jittered wait after the given failed attempt, counting from 1.
+func (p Policy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one that retrying cannot fix.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, was marked Permanent.
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+// statusCoder is implemented by errors that carry an HTTP response status,
+// including utils.StatusError, wahoo.APIError and the AWS SDK's response errors.
+type statusCoder interface {
+	HTTPStatusCode() int
+}
+
+// Retryable reports whether err is worth retrying: network failures and the
+// HTTP statuses in RetryableStatus. Anything else, including errors marked
+// Permanent, fails immediately.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if IsPermanent(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var status statusCoder
+	if errors.As(err, &status) {
+		return RetryableStatus(status.HTTPStatusCode())
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// RetryableStatus reports whether an HTTP status indicates a transient failure.
+func RetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return statusCode >= 500
+}
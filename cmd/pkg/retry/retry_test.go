@@ -0,0 +1,129 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type statusError int
+
+func (e statusError) Error() string       { return fmt.Sprintf("status %d", int(e)) }
+func (e statusError) HTTPStatusCode() int { return int(e) }
+
+func TestRetryable(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil, expected: false},
+		{name: "500", err: statusError(http.StatusInternalServerError), expected: true},
+		{name: "503 wrapped", err: fmt.Errorf("upload: %w", statusError(http.StatusServiceUnavailable)), expected: true},
+		{name: "429", err: statusError(http.StatusTooManyRequests), expected: true},
+		{name: "408", err: statusError(http.StatusRequestTimeout), expected: true},
+		{name: "404", err: statusError(http.StatusNotFound), expected: false},
+		{name: "403", err: statusError(http.StatusForbidden), expected: false},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, expected: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, expected: true},
+		{name: "permanent", err: Permanent(statusError(http.StatusBadGateway)), expected: false},
+		{name: "permanent wrapped", err: fmt.Errorf("download: %w", Permanent(io.ErrUnexpectedEOF)), expected: false},
+		{name: "cancelled", err: context.Canceled, expected: false},
+		{name: "unknown", err: errors.New("boom"), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, Retryable(tc.err))
+		})
+	}
+}
+
+func TestPolicy_Do(t *testing.T) {
+
+	policy := Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	t.Run("Succeeds after transient failures", func(t *testing.T) {
+		attempts := 0
+		err := policy.Do(context.Background(), func(ctx context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return statusError(http.StatusBadGateway)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, attempts)
+	})
+
+	t.Run("Gives up after max attempts", func(t *testing.T) {
+		attempts := 0
+		err := policy.Do(context.Background(), func(ctx context.Context) error {
+			attempts++
+			return statusError(http.StatusBadGateway)
+		})
+		require.Error(t, err)
+		require.Equal(t, 3, attempts)
+
+		var status statusError
+		require.ErrorAs(t, err, &status)
+	})
+
+	t.Run("Stops on permanent errors", func(t *testing.T) {
+		attempts := 0
+		err := policy.Do(context.Background(), func(ctx context.Context) error {
+			attempts++
+			return statusError(http.StatusUnauthorized)
+		})
+		require.Error(t, err)
+		require.Equal(t, 1, attempts)
+	})
+
+	t.Run("Stops when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		slow := Policy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+		attempts := 0
+		err := slow.Do(ctx, func(ctx context.Context) error {
+			attempts++
+			cancel()
+			return statusError(http.StatusBadGateway)
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 1, attempts)
+	})
+}
+
+func TestPolicy_Backoff(t *testing.T) {
+
+	policy := Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, expected := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	} {
+		delay := policy.Backoff(attempt)
+		require.GreaterOrEqual(t, delay, expected/2, "attempt %d", attempt)
+		require.LessOrEqual(t, delay, expected, "attempt %d", attempt)
+	}
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+
+	t.Setenv("RETRY_MAX_ATTEMPTS", "5")
+	t.Setenv("RETRY_BASE_DELAY", "2s")
+	t.Setenv("RETRY_MAX_DELAY", "not-a-duration")
+
+	require.Equal(t, Policy{MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: DefaultMaxDelay}, PolicyFromEnv())
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FSBackend stores objects as files under a root directory. Content type and
+// metadata are kept in a hidden ".<name>.meta" file next to each object.
+type FSBackend struct {
+	root string
+}
+
+type fsMeta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func NewFSBackend(root string) (*FSBackend, error) {
+	if root == "" {
+		return nil, errors.New("STORAGE_PATH must be set for the fs storage backend")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating storage directory %s: %w", root, err)
+	}
+	return &FSBackend{root: root}, nil
+}
+
+// path maps a key to a file under root, rejecting keys that would escape it.
+func (b *FSBackend) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if key == "" || strings.HasSuffix(key, "/") || cleaned == "/" {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	if strings.HasPrefix(path.Base(cleaned), ".") {
+		return "", fmt.Errorf("invalid storage key %q: names may not start with a dot", key)
+	}
+	return filepath.Join(b.root, filepath.FromSlash(cleaned)), nil
+}
+
+func metaPath(file string) string {
+	return filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".meta")
+}
+
+func (b *FSBackend) Put(_ context.Context, key string, body []byte, opts PutOptions) error {
+	file, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("error creating directory for %s: %w", key, err)
+	}
+
+	meta, err := json.Marshal(fsMeta{ContentType: opts.ContentType, Metadata: opts.Metadata})
+	if err != nil {
+		return fmt.Errorf("error encoding metadata for %s: %w", key, err)
+	}
+	if err := writeFileAtomic(metaPath(file), meta); err != nil {
+		return fmt.Errorf("error writing metadata for %s: %w", key, err)
+	}
+	if err := writeFileAtomic(file, body); err != nil {
+		return fmt.Errorf("error writing %s: %w", key, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes via a temporary file so readers never see a partial object.
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (b *FSBackend) Get(_ context.Context, key string) ([]byte, Object, error) {
+	file, err := b.path(key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+
+	body, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, Object{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("error reading %s: %w", key, err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("error reading %s: %w", key, err)
+	}
+
+	object := Object{Key: key, Size: info.Size(), LastModified: info.ModTime().UTC()}
+	if raw, err := os.ReadFile(metaPath(file)); err == nil {
+		var meta fsMeta
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, Object{}, fmt.Errorf("error decoding metadata for %s: %w", key, err)
+		}
+		object.ContentType = meta.ContentType
+		object.Metadata = meta.Metadata
+	}
+	return body, object, nil
+}
+
+func (b *FSBackend) List(_ context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := filepath.WalkDir(b.root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.root, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), LastModified: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %w", prefix, err)
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (b *FSBackend) Delete(_ context.Context, key string) error {
+	file, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error deleting %s: %w", key, err)
+	}
+	if err := os.Remove(metaPath(file)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error deleting metadata for %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultKeyTemplate partitions FIT files by athlete and by the month the
+// workout started.
+const DefaultKeyTemplate = "{user_id}/{yyyy}/{mm}/{workout_id}-{summary_id}.fit"
+
+// KeyFields are the values available to a KeyTemplate.
+type KeyFields struct {
+	UserID    int
+	WorkoutID int
+	SummaryID int
+	// Starts supplies {yyyy}, {mm} and {dd}, in UTC.
+	Starts time.Time
+}
+
+func (f KeyFields) values() map[string]string {
+	starts := f.Starts.UTC()
+	return map[string]string{
+		"user_id":    strconv.Itoa(f.UserID),
+		"workout_id": strconv.Itoa(f.WorkoutID),
+		"summary_id": strconv.Itoa(f.SummaryID),
+		"yyyy":       fmt.Sprintf("%04d", starts.Year()),
+		"mm":         fmt.Sprintf("%02d", int(starts.Month())),
+		"dd":         fmt.Sprintf("%02d", starts.Day()),
+	}
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// KeyTemplate builds object keys from placeholders such as {user_id}. The
+// zero value uses DefaultKeyTemplate.
+type KeyTemplate struct {
+	template string
+}
+
+// ParseKeyTemplate validates template. It must end in ".fit", so the sidecar
+// key can be derived from it, and may only use the placeholders {user_id},
+// {workout_id}, {summary_id}, {yyyy}, {mm} and {dd}. It must use {workout_id}
+// or {summary_id}, so that different workouts do not share a key.
+func ParseKeyTemplate(template string) (KeyTemplate, error) {
+	if !strings.HasSuffix(template, ".fit") {
+		return KeyTemplate{}, fmt.Errorf("key template %q must end in .fit", template)
+	}
+	if strings.HasPrefix(template, "/") {
+		return KeyTemplate{}, fmt.Errorf("key template %q must not start with /", template)
+	}
+
+	known := KeyFields{}.values()
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if _, ok := known[match[1]]; !ok {
+			return KeyTemplate{}, fmt.Errorf("key template %q uses unknown placeholder %s", template, match[0])
+		}
+	}
+	if !strings.Contains(template, "{workout_id}") && !strings.Contains(template, "{summary_id}") {
+		return KeyTemplate{}, fmt.Errorf("key template %q must use {workout_id} or {summary_id}", template)
+	}
+	return KeyTemplate{template: template}, nil
+}
+
+// KeyTemplateFromEnv parses STORAGE_KEY_TEMPLATE, defaulting to DefaultKeyTemplate.
+func KeyTemplateFromEnv() (KeyTemplate, error) {
+	template := os.Getenv("STORAGE_KEY_TEMPLATE")
+	if template == "" {
+		template = DefaultKeyTemplate
+	}
+	return ParseKeyTemplate(template)
+}
+
+// Key returns the FIT file key for fields.
+func (t KeyTemplate) Key(fields KeyFields) string {
+	values := fields.values()
+	return placeholderPattern.ReplaceAllStringFunc(t.String(), func(placeholder string) string {
+		return values[placeholder[1:len(placeholder)-1]]
+	})
+}
+
+func (t KeyTemplate) String() string {
+	if t.template == "" {
+		return DefaultKeyTemplate
+	}
+	return t.template
+}
+
+// SidecarKey returns the key of the JSON document stored alongside a FIT file.
+func SidecarKey(fitKey string) string {
+	return strings.TrimSuffix(fitKey, ".fit") + ".json"
+}
+
+// AnalysisKey returns the key of the training metrics computed from a FIT file.
+func AnalysisKey(fitKey string) string {
+	return strings.TrimSuffix(fitKey, ".fit") + ".analysis.json"
+}
+
+// IndexKey returns the key of the object that records where a workout's FIT
+// file is stored, so it can be found by workout ID whatever the key template.
+func IndexKey(workoutID int) string {
+	return "index/workouts/" + strconv.Itoa(workoutID)
+}
+
+// DateIndexPrefix is the prefix of the objects written by DateIndexKey.
+const DateIndexPrefix = "index/dates/"
+
+// DateIndexKey returns the key of the object that files a workout under the
+// UTC day it started, so workouts can be listed by date range.
+func DateIndexKey(starts time.Time, workoutID int) string {
+	return DateIndexPrefix + starts.UTC().Format(time.DateOnly) + "/" + strconv.Itoa(workoutID)
+}
+
+// DateIndexMonthPrefix returns the prefix of the DateIndexKeys of workouts
+// that started in the UTC month t falls in.
+func DateIndexMonthPrefix(t time.Time) string {
+	return DateIndexPrefix + t.UTC().Format("2006-01-")
+}
+
+// ParseDateIndexKey returns the day and workout ID of a DateIndexKey.
+func ParseDateIndexKey(key string) (day time.Time, workoutID int, err error) {
+	date, id, ok := strings.Cut(strings.TrimPrefix(key, DateIndexPrefix), "/")
+	if !ok || !strings.HasPrefix(key, DateIndexPrefix) {
+		return time.Time{}, 0, fmt.Errorf("%q is not a date index key", key)
+	}
+	if day, err = time.Parse(time.DateOnly, date); err != nil {
+		return time.Time{}, 0, fmt.Errorf("%q is not a date index key: %w", key, err)
+	}
+	if workoutID, err = strconv.Atoi(id); err != nil {
+		return time.Time{}, 0, fmt.Errorf("%q is not a date index key: %w", key, err)
+	}
+	return day, workoutID, nil
+}
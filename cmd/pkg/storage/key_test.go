@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyTemplate(t *testing.T) {
+
+	fields := KeyFields{
+		UserID:    1120489,
+		WorkoutID: 281788767,
+		SummaryID: 252869305,
+		Starts:    time.Date(2024, 4, 2, 23, 34, 45, 0, time.FixedZone("BST", 3600)),
+	}
+
+	testCases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "Default", template: DefaultKeyTemplate, expected: "1120489/2024/04/281788767-252869305.fit"},
+		{name: "Flat", template: "{workout_id}.fit", expected: "281788767.fit"},
+		{name: "Summary", template: "{user_id}/{summary_id}.fit", expected: "1120489/252869305.fit"},
+		{name: "Daily", template: "fit/{yyyy}-{mm}-{dd}/{user_id}-{workout_id}.fit", expected: "fit/2024-04-02/1120489-281788767.fit"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			template, err := ParseKeyTemplate(tc.template)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, template.Key(fields))
+		})
+	}
+
+	require.Equal(t, "1120489/2024/04/281788767-252869305.fit", KeyTemplate{}.Key(fields))
+	require.Equal(t, "1120489/2024/04/281788767-252869305.json", SidecarKey(KeyTemplate{}.Key(fields)))
+	require.Equal(t, "1120489/2024/04/281788767-252869305.analysis.json", AnalysisKey(KeyTemplate{}.Key(fields)))
+}
+
+func TestParseKeyTemplate_Invalid(t *testing.T) {
+
+	for _, template := range []string{
+		"{workout_id}",
+		"{workout_id}.fit.gz",
+		"/{workout_id}.fit",
+		"{athlete}/{workout_id}.fit",
+		"{user_id}/{yyyy}-{mm}-{dd}.fit",
+		"latest.fit",
+	} {
+		_, err := ParseKeyTemplate(template)
+		require.Error(t, err, template)
+	}
+}
+
+func TestDateIndexKey(t *testing.T) {
+
+	starts := time.Date(2024, 4, 2, 23, 34, 45, 0, time.FixedZone("BST", 3600))
+	key := DateIndexKey(starts, 281788767)
+	require.Equal(t, "index/dates/2024-04-02/281788767", key)
+	require.Equal(t, "index/dates/2024-04-", DateIndexMonthPrefix(starts))
+
+	day, workoutID, err := ParseDateIndexKey(key)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), day)
+	require.Equal(t, 281788767, workoutID)
+
+	for _, invalid := range []string{"index/workouts/281788767", "index/dates/2024-04-02", "index/dates/yesterday/1", "index/dates/2024-04-02/abc"} {
+		_, _, err := ParseDateIndexKey(invalid)
+		require.Error(t, err, invalid)
+	}
+}
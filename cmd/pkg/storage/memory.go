@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	body   []byte
+	object Object
+}
+
+// MemoryBackend keeps objects in process memory. It is intended for tests and
+// local development.
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{objects: make(map[string]memoryObject)}
+}
+
+func (b *MemoryBackend) Put(_ context.Context, key string, body []byte, opts PutOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.objects[key] = memoryObject{
+		body: append([]byte(nil), body...),
+		object: Object{
+			Key:          key,
+			Size:         int64(len(body)),
+			ContentType:  opts.ContentType,
+			Metadata:     copyMetadata(opts.Metadata),
+			LastModified: time.Now().UTC(),
+		},
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Get(_ context.Context, key string) ([]byte, Object, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stored, ok := b.objects[key]
+	if !ok {
+		return nil, Object{}, ErrNotFound
+	}
+	object := stored.object
+	object.Metadata = copyMetadata(object.Metadata)
+	return append([]byte(nil), stored.body...), object, nil
+}
+
+func (b *MemoryBackend) List(_ context.Context, prefix string) ([]Object, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	objects := []Object{}
+	for key, stored := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			object := stored.object
+			object.Metadata = nil
+			objects = append(objects, object)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (b *MemoryBackend) Delete(_ context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.objects, key)
+	return nil
+}
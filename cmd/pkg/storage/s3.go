@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Config configures an S3-compatible bucket. Endpoint and Region may be
+// left empty to use AWS defaults; ForcePathStyle is needed by most
+// self-hosted implementations such as MinIO.
+type S3Config struct {
+	Bucket         string
+	Endpoint       string
+	Region         string
+	ForcePathStyle bool
+}
+
+// S3Backend stores objects in an S3-compatible bucket.
+type S3Backend struct {
+	client *s3.Client
+	bucket string
+}
+
+func NewS3Backend(ctx context.Context, cfg S3Config) (*S3Backend, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("BUCKET_NAME must be set for the s3 storage backend")
+	}
+
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading default AWS configuration: %w", err)
+	}
+
+	client := s3.NewFromConfig(sdkConfig, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		if cfg.Region != "" {
+			o.Region = cfg.Region
+		}
+		o.UsePathStyle = cfg.ForcePathStyle
+	})
+	return &S3Backend{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (b *S3Backend) Put(ctx context.Context, key string, body []byte, opts PutOptions) error {
+	input := &s3.PutObjectInput{
+		Bucket:   aws.String(b.bucket),
+		Key:      aws.String(key),
+		Body:     bytes.NewReader(body),
+		Metadata: opts.Metadata,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+
+	if _, err := b.client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("error uploading %s to S3: %w", key, err)
+	}
+	return nil
+}
+
+func (b *S3Backend) Get(ctx context.Context, key string) ([]byte, Object, error) {
+	output, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return nil, Object{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("error downloading %s from S3: %w", key, err)
+	}
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("error reading %s from S3: %w", key, err)
+	}
+
+	object := Object{
+		Key:         key,
+		Size:        int64(len(body)),
+		ContentType: aws.ToString(output.ContentType),
+		Metadata:    output.Metadata,
+	}
+	if output.LastModified != nil {
+		object.LastModified = output.LastModified.UTC()
+	}
+	return body, object, nil
+}
+
+func (b *S3Backend) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s in S3: %w", prefix, err)
+		}
+		for _, item := range page.Contents {
+			object := Object{Key: aws.ToString(item.Key), Size: aws.ToInt64(item.Size)}
+			if item.LastModified != nil {
+				object.LastModified = item.LastModified.UTC()
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (b *S3Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("error deleting %s from S3: %w", key, err)
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey")
+}
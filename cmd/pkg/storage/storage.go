@@ -0,0 +1,106 @@
+// Package storage abstracts where workout artifacts such as FIT files are
+// kept: any S3-compatible bucket, a local directory or process memory.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ErrNotFound is returned when a key does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Object describes a stored object.
+type Object struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	LastModified time.Time         `json:"last_modified"`
+}
+
+// PutOptions are optional attributes stored with an object.
+type PutOptions struct {
+	ContentType string
+	// Metadata is stored as user-defined object metadata. Keys should be
+	// lower case, as S3 normalises them.
+	Metadata map[string]string
+}
+
+// Backend stores objects by key. Keys use "/" as a separator regardless of
+// the backend. Put overwrites any existing object with the same key.
+type Backend interface {
+	Put(ctx context.Context, key string, body []byte, opts PutOptions) error
+	Get(ctx context.Context, key string) ([]byte, Object, error)
+	// List returns every object whose key starts with prefix, ordered by key.
+	// Metadata is not populated.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+const (
+	BackendS3     = "s3"
+	BackendFS     = "fs"
+	BackendMemory = "memory"
+
+	tigrisEndpoint = "https://fly.storage.tigris.dev"
+)
+
+// FromEnv constructs the backend selected by STORAGE_BACKEND:
+//
+//   - "s3" uses BUCKET_NAME with S3_ENDPOINT, S3_REGION and S3_FORCE_PATH_STYLE,
+//     and credentials from the standard AWS environment.
+//   - "fs" writes under STORAGE_PATH.
+//   - "memory" keeps objects in process memory.
+//
+// When STORAGE_BACKEND is unset, TIGRIS_ENABLED=true selects S3 against the
+// Tigris endpoint for compatibility, and otherwise storage is disabled and a
+// nil Backend is returned.
+func FromEnv(ctx context.Context) (Backend, error) {
+	kind := os.Getenv("STORAGE_BACKEND")
+	if kind == "" {
+		tigrisEnabled, _ := strconv.ParseBool(os.Getenv("TIGRIS_ENABLED"))
+		if !tigrisEnabled {
+			log.Println("No STORAGE_BACKEND configured; FIT files will not be stored.")
+			return nil, nil
+		}
+		kind = BackendS3
+	}
+
+	switch kind {
+	case BackendS3:
+		cfg := S3Config{
+			Bucket:   os.Getenv("BUCKET_NAME"),
+			Endpoint: os.Getenv("S3_ENDPOINT"),
+			Region:   os.Getenv("S3_REGION"),
+		}
+		cfg.ForcePathStyle, _ = strconv.ParseBool(os.Getenv("S3_FORCE_PATH_STYLE"))
+		if cfg.Endpoint == "" && os.Getenv("STORAGE_BACKEND") == "" {
+			cfg.Endpoint = tigrisEndpoint
+			cfg.Region = "auto"
+		}
+		return NewS3Backend(ctx, cfg)
+	case BackendFS:
+		return NewFSBackend(os.Getenv("STORAGE_PATH"))
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", kind)
+	}
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackends(t *testing.T) {
+
+	fsBackend, err := NewFSBackend(t.TempDir())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		backend Backend
+	}{
+		{name: "Memory", backend: NewMemoryBackend()},
+		{name: "FS", backend: fsBackend},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, _, err := tc.backend.Get(ctx, "1120489/281788767.fit")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			opts := PutOptions{ContentType: "application/vnd.ant.fit", Metadata: map[string]string{"workout-name": "Cycling"}}
+			require.NoError(t, tc.backend.Put(ctx, "1120489/281788767.fit", []byte("first"), opts))
+			require.NoError(t, tc.backend.Put(ctx, "1120489/281788767.fit", []byte("fit-file"), opts))
+			require.NoError(t, tc.backend.Put(ctx, "1120489/281788768.fit", []byte("other"), PutOptions{}))
+			require.NoError(t, tc.backend.Put(ctx, "99/1.fit", []byte("someone else"), PutOptions{}))
+
+			body, object, err := tc.backend.Get(ctx, "1120489/281788767.fit")
+			require.NoError(t, err)
+			require.Equal(t, "fit-file", string(body))
+			require.Equal(t, int64(8), object.Size)
+			require.Equal(t, "application/vnd.ant.fit", object.ContentType)
+			require.Equal(t, map[string]string{"workout-name": "Cycling"}, object.Metadata)
+
+			objects, err := tc.backend.List(ctx, "1120489/")
+			require.NoError(t, err)
+			require.Len(t, objects, 2)
+			require.Equal(t, "1120489/281788767.fit", objects[0].Key)
+			require.Equal(t, "1120489/281788768.fit", objects[1].Key)
+
+			require.NoError(t, tc.backend.Delete(ctx, "1120489/281788767.fit"))
+			require.NoError(t, tc.backend.Delete(ctx, "1120489/281788767.fit"))
+			_, _, err = tc.backend.Get(ctx, "1120489/281788767.fit")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			objects, err = tc.backend.List(ctx, "")
+			require.NoError(t, err)
+			require.Len(t, objects, 2)
+		})
+	}
+}
+
+func TestFSBackend_RejectsEscapingKeys(t *testing.T) {
+
+	root := t.TempDir()
+	backend, err := NewFSBackend(root)
+	require.NoError(t, err)
+
+	// Keys are confined to the root even when they try to climb out of it.
+	require.NoError(t, backend.Put(context.Background(), "../../outside.fit", []byte("x"), PutOptions{}))
+	objects, err := backend.List(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	require.Equal(t, "outside.fit", objects[0].Key)
+
+	require.Error(t, backend.Put(context.Background(), "dir/", []byte("x"), PutOptions{}))
+	require.Error(t, backend.Put(context.Background(), "dir/.hidden", []byte("x"), PutOptions{}))
+}
+
+func TestS3Backend_UsesConfiguredEndpoint(t *testing.T) {
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+
+	var putPath, putMeta string
+	var putBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			putPath = r.URL.Path
+			putMeta = r.Header.Get("X-Amz-Meta-Workout-Name")
+			putBody, _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			if r.URL.Path != "/workouts/1120489/281788767.fit" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`))
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.ant.fit")
+			w.Header().Set("X-Amz-Meta-Workout-Name", "Cycling")
+			_, _ = w.Write([]byte("fit-file"))
+		}
+	}))
+	defer server.Close()
+
+	backend, err := NewS3Backend(context.Background(), S3Config{
+		Bucket:         "workouts",
+		Endpoint:       server.URL,
+		Region:         "us-east-1",
+		ForcePathStyle: true,
+	})
+	require.NoError(t, err)
+
+	err = backend.Put(context.Background(), "1120489/281788767.fit", []byte("fit-file"), PutOptions{
+		ContentType: "application/vnd.ant.fit",
+		Metadata:    map[string]string{"workout-name": "Cycling"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "/workouts/1120489/281788767.fit", putPath)
+	require.Equal(t, "Cycling", putMeta)
+	require.Equal(t, "fit-file", string(putBody))
+
+	body, object, err := backend.Get(context.Background(), "1120489/281788767.fit")
+	require.NoError(t, err)
+	require.Equal(t, "fit-file", string(body))
+	require.Equal(t, "Cycling", object.Metadata["workout-name"])
+
+	_, _, err = backend.Get(context.Background(), "missing.fit")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFromEnv(t *testing.T) {
+
+	t.Run("Disabled by default", func(t *testing.T) {
+		t.Setenv("STORAGE_BACKEND", "")
+		t.Setenv("TIGRIS_ENABLED", "")
+		backend, err := FromEnv(context.Background())
+		require.NoError(t, err)
+		require.Nil(t, backend)
+	})
+
+	t.Run("Filesystem", func(t *testing.T) {
+		t.Setenv("STORAGE_BACKEND", "fs")
+		t.Setenv("STORAGE_PATH", t.TempDir())
+		backend, err := FromEnv(context.Background())
+		require.NoError(t, err)
+		require.IsType(t, &FSBackend{}, backend)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		t.Setenv("STORAGE_BACKEND", "floppy")
+		_, err := FromEnv(context.Background())
+		require.Error(t, err)
+	})
+}
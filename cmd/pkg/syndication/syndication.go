@@ -0,0 +1,161 @@
+// Package syndication writes feeds of entries as Atom 1.0 (RFC 4287) and
+// RSS 2.0 documents, as polled by feed readers and dashboards.
+package syndication
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// Content types of the two formats.
+const (
+	AtomContentType = "application/atom+xml; charset=utf-8"
+	RSSContentType  = "application/rss+xml; charset=utf-8"
+)
+
+// Feed is a list of entries, newest first.
+type Feed struct {
+	// ID identifies the feed permanently, as an IRI.
+	ID          string
+	Title       string
+	Description string
+	// Link is the feed's own URL.
+	Link    string
+	Updated time.Time
+	Entries []Entry
+}
+
+// Entry is a feed entry, with plain text content.
+type Entry struct {
+	// ID identifies the entry across versions of the feed, as an IRI.
+	ID        string
+	Title     string
+	Author    string
+	Content   string
+	Published time.Time
+	Updated   time.Time
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Author    atomPerson  `xml:"author"`
+	Published string      `xml:"published,omitempty"`
+	Updated   string      `xml:"updated"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// WriteAtom writes the feed as an Atom document. Every entry needs an Author,
+// since the feed itself has none.
+func WriteAtom(w io.Writer, feed Feed) error {
+	doc := atomFeed{
+		ID:      feed.ID,
+		Title:   feed.Title,
+		Links:   []atomLink{{Rel: "self", Href: feed.Link}},
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+	}
+	for _, entry := range feed.Entries {
+		atom := atomEntry{
+			ID:      entry.ID,
+			Title:   entry.Title,
+			Author:  atomPerson{Name: entry.Author},
+			Updated: entry.Updated.UTC().Format(time.RFC3339),
+			Content: atomContent{Type: "text", Text: entry.Content},
+		}
+		if !entry.Published.IsZero() {
+			atom.Published = entry.Published.UTC().Format(time.RFC3339)
+		}
+		doc.Entries = append(doc.Entries, atom)
+	}
+	return write(w, doc)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Text        string `xml:",chardata"`
+}
+
+// WriteRSS writes the feed as an RSS document. Items are dated by when their
+// entry was published, or else last updated.
+func WriteRSS(w io.Writer, feed Feed) error {
+	doc := rssDocument{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         feed.Title,
+			Link:          feed.Link,
+			Description:   feed.Description,
+			LastBuildDate: feed.Updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, entry := range feed.Entries {
+		published := entry.Published
+		if published.IsZero() {
+			published = entry.Updated
+		}
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Description: entry.Content,
+			GUID:        rssGUID{Text: entry.ID},
+			PubDate:     published.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return write(w, doc)
+}
+
+func write(w io.Writer, doc any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,86 @@
+package syndication
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testFeed() Feed {
+	received := time.Date(2024, 4, 9, 22, 40, 25, 0, time.UTC)
+	return Feed{
+		ID:          "urn:go-wahoo-cloud-api:workouts",
+		Title:       "Wahoo workouts",
+		Description: "Recently received workouts",
+		Link:        "https://example.com/feeds/workouts.atom",
+		Updated:     received.Add(time.Minute),
+		Entries: []Entry{
+			{
+				ID:        "urn:go-wahoo-cloud-api:workout:281788767",
+				Title:     "Hills & repeats",
+				Author:    "Athlete 1120489",
+				Content:   "biking, 24.3 km <fast>",
+				Published: received,
+				Updated:   received.Add(time.Minute),
+			},
+		},
+	}
+}
+
+func TestWriteAtom(t *testing.T) {
+
+	var out bytes.Buffer
+	require.NoError(t, WriteAtom(&out, testFeed()))
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <id>urn:go-wahoo-cloud-api:workouts</id>
+  <title>Wahoo workouts</title>
+  <link rel="self" href="https://example.com/feeds/workouts.atom"></link>
+  <updated>2024-04-09T22:41:25Z</updated>
+  <entry>
+    <id>urn:go-wahoo-cloud-api:workout:281788767</id>
+    <title>Hills &amp; repeats</title>
+    <author>
+      <name>Athlete 1120489</name>
+    </author>
+    <published>2024-04-09T22:40:25Z</published>
+    <updated>2024-04-09T22:41:25Z</updated>
+    <content type="text">biking, 24.3 km &lt;fast&gt;</content>
+  </entry>
+</feed>
+`, out.String())
+}
+
+func TestWriteRSS(t *testing.T) {
+
+	feed := testFeed()
+	feed.Entries = append(feed.Entries, Entry{ID: "urn:go-wahoo-cloud-api:workout:1", Title: "Unpublished",
+		Updated: time.Date(2024, 4, 8, 7, 0, 0, 0, time.UTC)})
+
+	var out bytes.Buffer
+	require.NoError(t, WriteRSS(&out, feed))
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Wahoo workouts</title>
+    <link>https://example.com/feeds/workouts.atom</link>
+    <description>Recently received workouts</description>
+    <lastBuildDate>Tue, 09 Apr 2024 22:41:25 +0000</lastBuildDate>
+    <item>
+      <title>Hills &amp; repeats</title>
+      <description>biking, 24.3 km &lt;fast&gt;</description>
+      <guid isPermaLink="false">urn:go-wahoo-cloud-api:workout:281788767</guid>
+      <pubDate>Tue, 09 Apr 2024 22:40:25 +0000</pubDate>
+    </item>
+    <item>
+      <title>Unpublished</title>
+      <description></description>
+      <guid isPermaLink="false">urn:go-wahoo-cloud-api:workout:1</guid>
+      <pubDate>Mon, 08 Apr 2024 07:00:00 +0000</pubDate>
+    </item>
+  </channel>
+</rss>
+`, out.String())
+}
@@ -0,0 +1,132 @@
+// Package units holds the typed, nullable quantities of Wahoo workout
+// summaries.
+//
+// Wahoo encodes every metric as a JSON string, such as "24323.54", or as null
+// when the device did not record it. Decimal accepts strings, bare numbers and
+// null, and keeps the original encoding so that a payload re-marshalled for
+// forwarding is byte-for-byte what Wahoo sent. The unit types wrap Decimal to
+// make the unit of each field explicit.
+package units
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Decimal is a nullable decimal number. The zero value is null.
+type Decimal struct {
+	value float64
+	valid bool
+	// raw is the JSON the value was decoded from, or empty for values built
+	// with NewDecimal and for null.
+	raw string
+}
+
+// NewDecimal returns a valid Decimal, encoded as a JSON string like Wahoo's.
+func NewDecimal(value float64) Decimal {
+	return Decimal{value: value, valid: true}
+}
+
+// Valid reports whether the value is present rather than null.
+func (d Decimal) Valid() bool {
+	return d.valid
+}
+
+// Float64 returns the value, with ok false when it is null.
+func (d Decimal) Float64() (value float64, ok bool) {
+	return d.value, d.valid
+}
+
+// Or returns the value, or fallback when it is null.
+func (d Decimal) Or(fallback float64) float64 {
+	if !d.valid {
+		return fallback
+	}
+	return d.value
+}
+
+// String formats the value as Wahoo does, or returns "" when it is null.
+func (d Decimal) String() string {
+	if !d.valid {
+		return ""
+	}
+	if d.raw != "" {
+		var s string
+		if json.Unmarshal([]byte(d.raw), &s) == nil {
+			return s
+		}
+		return d.raw
+	}
+	return strconv.FormatFloat(d.value, 'f', -1, 64)
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		*d = Decimal{}
+		return nil
+	}
+
+	text := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		// Empty strings carry no value, but are kept so they round trip.
+		if text == "" {
+			*d = Decimal{raw: string(data)}
+			return nil
+		}
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("invalid decimal %s", data)
+	}
+	*d = Decimal{value: value, valid: true, raw: string(data)}
+	return nil
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	switch {
+	case d.raw != "":
+		return []byte(d.raw), nil
+	case !d.valid:
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.String())
+}
+
+// Metres is a distance or an elevation.
+type Metres struct{ Decimal }
+
+// Seconds is a duration.
+type Seconds struct{ Decimal }
+
+// Duration converts the value to a time.Duration, with ok false when it is null.
+func (s Seconds) Duration() (duration time.Duration, ok bool) {
+	seconds, ok := s.Float64()
+	return time.Duration(seconds * float64(time.Second)), ok
+}
+
+// MetresPerSecond is a speed.
+type MetresPerSecond struct{ Decimal }
+
+// Watts is a power.
+type Watts struct{ Decimal }
+
+// Joules is an amount of work.
+type Joules struct{ Decimal }
+
+// Kilocalories is energy expended.
+type Kilocalories struct{ Decimal }
+
+// BeatsPerMinute is a heart rate.
+type BeatsPerMinute struct{ Decimal }
+
+// RevolutionsPerMinute is a cadence.
+type RevolutionsPerMinute struct{ Decimal }
@@ -0,0 +1,73 @@
+package units
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecimal_UnmarshalJSON(t *testing.T) {
+
+	testCases := []struct {
+		name   string
+		json   string
+		valid  bool
+		value  float64
+		string string
+	}{
+		{name: "String", json: `"24323.54"`, valid: true, value: 24323.54, string: "24323.54"},
+		{name: "TrailingZero", json: `"179.0"`, valid: true, value: 179, string: "179.0"},
+		{name: "Number", json: `6.84`, valid: true, value: 6.84, string: "6.84"},
+		{name: "Zero", json: `"0.0"`, valid: true, value: 0, string: "0.0"},
+		{name: "Null", json: `null`},
+		{name: "EmptyString", json: `""`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var d Decimal
+			require.NoError(t, json.Unmarshal([]byte(tc.json), &d))
+
+			value, ok := d.Float64()
+			require.Equal(t, tc.valid, ok)
+			require.Equal(t, tc.value, value)
+			require.Equal(t, tc.string, d.String())
+
+			encoded, err := json.Marshal(d)
+			require.NoError(t, err)
+			require.Equal(t, tc.json, string(encoded), "the wire format is preserved")
+		})
+	}
+
+	for _, invalid := range []string{`"fast"`, `"NaN"`, `true`, `{}`} {
+		var d Decimal
+		require.Error(t, json.Unmarshal([]byte(invalid), &d), invalid)
+	}
+}
+
+func TestDecimal_MarshalJSON(t *testing.T) {
+
+	encoded, err := json.Marshal(struct {
+		Distance Metres  `json:"distance"`
+		Duration Seconds `json:"duration"`
+	}{
+		Distance: Metres{NewDecimal(24323.54)},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"distance":"24323.54","duration":null}`, string(encoded))
+}
+
+func TestSeconds_Duration(t *testing.T) {
+
+	var s Seconds
+	require.NoError(t, json.Unmarshal([]byte(`"3557.5"`), &s))
+	duration, ok := s.Duration()
+	require.True(t, ok)
+	require.Equal(t, 59*time.Minute+17*time.Second+500*time.Millisecond, duration)
+
+	_, ok = Seconds{}.Duration()
+	require.False(t, ok)
+	require.Equal(t, 42.0, Watts{}.Or(42))
+}
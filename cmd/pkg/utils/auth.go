@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// RequireAdminToken guards administrative endpoints with the bearer token in
+// ADMIN_API_TOKEN. When no token is configured the endpoints are disabled.
+func RequireAdminToken(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+
+	adminToken := os.Getenv("ADMIN_API_TOKEN")
+	if adminToken == "" {
+		log.Println("No ADMIN_API_TOKEN configured; admin endpoints are disabled.")
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "Admin endpoints are disabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(adminToken)) != 1 {
+			log.Printf("Rejected unauthenticated admin request to %s", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
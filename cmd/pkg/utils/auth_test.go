@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminToken(t *testing.T) {
+
+	testCases := []struct {
+		name           string
+		adminToken     string
+		authorization  string
+		expectedStatus int
+	}{
+		{name: "Valid token", adminToken: "s3cret", authorization: "Bearer s3cret", expectedStatus: http.StatusOK},
+		{name: "Wrong token", adminToken: "s3cret", authorization: "Bearer nope", expectedStatus: http.StatusUnauthorized},
+		{name: "Missing token", adminToken: "s3cret", authorization: "", expectedStatus: http.StatusUnauthorized},
+		{name: "Not configured", adminToken: "", authorization: "Bearer ", expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("ADMIN_API_TOKEN", tc.adminToken)
+
+			request, _ := http.NewRequest("GET", "/admin", nil)
+			request.Header.Set("Authorization", tc.authorization)
+
+			response := httptest.NewRecorder()
+			handler := http.HandlerFunc(RequireAdminToken(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			handler.ServeHTTP(response, request)
+
+			if response.Code != tc.expectedStatus {
+				t.Errorf("Expected status code %d, but got %v", tc.expectedStatus, response.Code)
+			}
+		})
+	}
+}
@@ -1,41 +1,55 @@
 package utils
 
 import (
-	"log"
-	"net/http"
 	"net/url"
 	"os"
 )
 
-func CheckIfAuthCodeDoesntExist(w http.ResponseWriter, r *http.Request, code string, wahooClientId string, wahooRedirectUri string) bool {
-	if code == "" {
-		log.Printf("No code found in the URL")
-		http.Redirect(w, r, os.Getenv("WAHOO_AUTH_BASE_URL")+"?"+
-			"client_id="+wahooClientId+
-			"&redirect_uri="+wahooRedirectUri+
-			"&scope=user_read%20workouts_read%20offline_data"+
-			"&response_type=code", 301)
-		return true
+func GetWahooOAuthExchangeURL(wahooClientId, wahooClientSecret, code, wahooRedirectUri, codeVerifier string) (*url.URL, error) {
+	tokenUrl := os.Getenv("WAHOO_TOKEN_BASE_URL")
+	exchangeUrl := tokenUrl + "?" +
+		"client_id=" + wahooClientId +
+		"&client_secret=" + wahooClientSecret +
+		"&code=" + code +
+		"&grant_type=authorization_code" +
+		"&redirect_uri=" + wahooRedirectUri
+	if codeVerifier != "" {
+		exchangeUrl += "&code_verifier=" + url.QueryEscape(codeVerifier)
 	}
-	return false
+	return url.Parse(exchangeUrl)
 }
 
-func GetWahooOAuthExchangeURL(wahooClientId, wahooClientSecret, code, wahooRedirectUri string) (*url.URL, error) {
+func GetWahooRefreshTokenURL(wahooClientId, wahooClientSecret, refreshToken string) (*url.URL, error) {
 	tokenUrl := os.Getenv("WAHOO_TOKEN_BASE_URL")
 	return url.Parse(tokenUrl + "?" +
 		"client_id=" + wahooClientId +
 		"&client_secret=" + wahooClientSecret +
-		"&code=" + code +
-		"&grant_type=authorization_code" +
-		"&redirect_uri=" + wahooRedirectUri)
+		"&grant_type=refresh_token" +
+		"&refresh_token=" + url.QueryEscape(refreshToken))
 }
 
-func GetWahooAuthorizeUrl(wahooClientId, wahooRedirectUri string) (*url.URL, error) {
+func GetWahooAuthorizeUrl(wahooClientId, wahooRedirectUri, state, codeChallenge string) (*url.URL, error) {
 
 	authUrl := os.Getenv("WAHOO_AUTH_BASE_URL")
-	return url.Parse(authUrl + "?" +
+	authorizeUrl := authUrl + "?" +
 		"client_id=" + wahooClientId +
 		"&redirect_uri=" + wahooRedirectUri +
 		"&scope=user_read%20workouts_read%20offline_data" +
-		"&response_type=code")
+		"&response_type=code"
+	if state != "" {
+		authorizeUrl += "&state=" + url.QueryEscape(state)
+	}
+	if codeChallenge != "" {
+		authorizeUrl += "&code_challenge=" + url.QueryEscape(codeChallenge) +
+			"&code_challenge_method=S256"
+	}
+	return url.Parse(authorizeUrl)
+}
+
+func GetWahooApiBaseUrl() string {
+	apiUrl := os.Getenv("WAHOO_API_BASE_URL")
+	if apiUrl == "" {
+		return "https://api.wahooligan.com"
+	}
+	return apiUrl
 }
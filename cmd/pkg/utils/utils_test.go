@@ -46,7 +46,7 @@ func TestGetWahooAuthorizeURL(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := GetWahooAuthorizeUrl(tc.wahooClientId, tc.wahooRedirectUri)
+			result, err := GetWahooAuthorizeUrl(tc.wahooClientId, tc.wahooRedirectUri, "", "")
 			fmt.Println(result)
 
 			if tc.expectedError {
@@ -66,6 +66,22 @@ func TestGetWahooAuthorizeURL(t *testing.T) {
 	}
 }
 
+func TestGetWahooAuthorizeURL_WithStateAndCodeChallenge(t *testing.T) {
+
+	t.Setenv("WAHOO_AUTH_BASE_URL", "https://api.wahooligan.com/oauth/authorize")
+
+	result, err := GetWahooAuthorizeUrl("client123", "https://example.com/callback", "nonce.123.sig", "challenge")
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expectedResult := "https://api.wahooligan.com/oauth/authorize?client_id=client123&redirect_uri=https://example.com/callback&scope=user_read%20workouts_read%20offline_data&response_type=code" +
+		"&state=nonce.123.sig&code_challenge=challenge&code_challenge_method=S256"
+	if result.String() != expectedResult {
+		t.Errorf("Expected %s, but got %s", expectedResult, result.String())
+	}
+}
+
 func TestGetWahooOAuthExchangeURLL(t *testing.T) {
 
 	t.Setenv("WAHOO_CLIENT_ID", "client123")
@@ -107,7 +123,7 @@ func TestGetWahooOAuthExchangeURLL(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := GetWahooOAuthExchangeURL(tc.wahooClientId, tc.wahooRedirectUri, "123", tc.wahooRedirectUri)
+			result, err := GetWahooOAuthExchangeURL(tc.wahooClientId, tc.wahooRedirectUri, "123", tc.wahooRedirectUri, "")
 			fmt.Println(result)
 
 			if tc.expectedError {
@@ -126,3 +142,33 @@ func TestGetWahooOAuthExchangeURLL(t *testing.T) {
 		})
 	}
 }
+
+func TestGetWahooOAuthExchangeURL_WithCodeVerifier(t *testing.T) {
+
+	t.Setenv("WAHOO_TOKEN_BASE_URL", "https://api.wahooligan.com/oauth/token")
+
+	result, err := GetWahooOAuthExchangeURL("client123", "client_secret", "123", "https://example.com/callback", "verifier")
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expectedResult := "https://api.wahooligan.com/oauth/token?client_id=client123&client_secret=client_secret&code=123&grant_type=authorization_code&redirect_uri=https://example.com/callback&code_verifier=verifier"
+	if result.String() != expectedResult {
+		t.Errorf("Expected %s, but got %s", expectedResult, result.String())
+	}
+}
+
+func TestGetWahooRefreshTokenURL(t *testing.T) {
+
+	t.Setenv("WAHOO_TOKEN_BASE_URL", "https://api.wahooligan.com/oauth/token")
+
+	result, err := GetWahooRefreshTokenURL("client123", "client_secret", "refresh+token")
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expectedResult := "https://api.wahooligan.com/oauth/token?client_id=client123&client_secret=client_secret&grant_type=refresh_token&refresh_token=refresh%2Btoken"
+	if result.String() != expectedResult {
+		t.Errorf("Expected %s, but got %s", expectedResult, result.String())
+	}
+}
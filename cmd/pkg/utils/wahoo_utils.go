@@ -7,8 +7,49 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"sort"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
 )
 
+// StatusError is returned when a remote service answers with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+// HTTPStatusCode lets the retry package classify the failure.
+func (e *StatusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+}
+
+// InvalidFitFileError is returned when a download succeeds but the body is not
+// an intact FIT file, such as an HTML error page or a truncated transfer.
+type InvalidFitFileError struct {
+	Size int
+	Err  error
+}
+
+func (e *InvalidFitFileError) Error() string {
+	return fmt.Sprintf("invalid fit file (%d bytes): %v", e.Size, e.Err)
+}
+
+func (e *InvalidFitFileError) Unwrap() error {
+	return e.Err
+}
+
+// DownloadFitFileContentsToBuffer downloads a FIT file, rejecting non-2xx
+// responses with a StatusError and bodies that fail the FIT signature or CRC
+// checks with an InvalidFitFileError.
 func DownloadFitFileContentsToBuffer(wahooFitUrl string) (*bytes.Reader, error) {
 	resp, err := http.Get(wahooFitUrl)
 	if err != nil {
@@ -17,6 +58,10 @@ func DownloadFitFileContentsToBuffer(wahooFitUrl string) (*bytes.Reader, error)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fit file download returned %w", newStatusError(resp))
+	}
+
 	// Convert response body to io.Reader
 	var buf bytes.Buffer
 	_, err = io.Copy(&buf, resp.Body)
@@ -25,15 +70,32 @@ func DownloadFitFileContentsToBuffer(wahooFitUrl string) (*bytes.Reader, error)
 		return nil, err
 	}
 
+	if err := fit.Validate(buf.Bytes()); err != nil {
+		return nil, &InvalidFitFileError{Size: buf.Len(), Err: err}
+	}
+
 	reader := bytes.NewReader(buf.Bytes())
 	return reader, nil
 }
 
-func PostFitFileToExternalService(fileData []byte, fileName string, serviceURL string) error {
+// PostFitFileToExternalService posts the file as the "file" part of a
+// multipart form, alongside any extra form fields.
+func PostFitFileToExternalService(fileData []byte, fileName string, serviceURL string, fields map[string]string) error {
 	// Create a buffer to write our multipart form data
 	var requestBody bytes.Buffer
 	writer := multipart.NewWriter(&requestBody)
 
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writer.WriteField(name, fields[name]); err != nil {
+			return fmt.Errorf("error writing form field %s: %w", name, err)
+		}
+	}
+
 	// Create a form file field
 	part, err := writer.CreateFormFile("file", fileName)
 	if err != nil {
@@ -71,8 +133,7 @@ func PostFitFileToExternalService(fileData []byte, fileName string, serviceURL s
 
 	// Check response status
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("external service returned status %d: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("external service returned %w", newStatusError(resp))
 	}
 
 	log.Printf("Successfully posted FIT file to external service. Status: %d", resp.StatusCode)
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/pkg/fit"
 	"github.com/ory/dockertest/v3"
 	"github.com/stretchr/testify/require"
 	"github.com/wiremock/go-wiremock"
@@ -9,6 +10,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 )
@@ -94,3 +96,32 @@ func loadTestFitFile() []byte {
 	}
 	return file
 }
+
+func TestDownloadFitFileContentsToBuffer_ReturnsStatusError(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone fishing", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := DownloadFitFileContentsToBuffer(server.URL + "/fit.fit")
+
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusServiceUnavailable, statusErr.HTTPStatusCode())
+}
+
+func TestDownloadFitFileContentsToBuffer_RejectsInvalidFitFiles(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html><body>Sign in</body></html>"))
+	}))
+	defer server.Close()
+
+	_, err := DownloadFitFileContentsToBuffer(server.URL + "/fit.fit")
+
+	var invalidErr *InvalidFitFileError
+	require.ErrorAs(t, err, &invalidErr)
+	require.ErrorIs(t, err, fit.ErrNotFIT)
+	require.Equal(t, 33, invalidErr.Size)
+}
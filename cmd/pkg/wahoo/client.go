@@ -0,0 +1,119 @@
+package wahoo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the production Wahoo Cloud API.
+const DefaultBaseURL = "https://api.wahooligan.com"
+
+// TokenSource supplies the bearer token used to authenticate each request.
+type TokenSource interface {
+	AccessToken(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+func (f TokenSourceFunc) AccessToken(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticToken is a TokenSource that always returns the same access token.
+type StaticToken string
+
+func (t StaticToken) AccessToken(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// Client is a typed client for the Wahoo Cloud API.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	tokens     TokenSource
+}
+
+type Option func(*Client)
+
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+func NewClient(tokens TokenSource, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    DefaultBaseURL,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		tokens:     tokens,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// do sends a request to path and decodes a JSON response into out, which may be nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, form url.Values, out any) error {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	accessToken, err := c.tokens.AccessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("error obtaining access token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(method, path, resp, respBody)
+	}
+
+	if out == nil || len(bytes.TrimSpace(respBody)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("error decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package wahoo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewClient(StaticToken("my_access_token"), WithBaseURL(server.URL))
+}
+
+func TestClient_User(t *testing.T) {
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/user", r.URL.Path)
+		require.Equal(t, "Bearer my_access_token", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"id":1120489,"first":"James","last":"Millner","created_at":"2024-04-12T18:36:11.000Z"}`))
+	})
+
+	user, err := client.User(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1120489, user.ID)
+	require.Equal(t, "James", user.First)
+	require.Equal(t, time.Date(2024, 4, 12, 18, 36, 11, 0, time.UTC), user.CreatedAt)
+}
+
+func TestClient_APIError(t *testing.T) {
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":"Rate limit exceeded"}`))
+	})
+
+	_, err := client.User(context.Background())
+
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	require.Equal(t, "Rate limit exceeded", apiErr.Message)
+	require.Equal(t, 30*time.Second, apiErr.RetryAfter)
+	require.True(t, IsRateLimited(err))
+	require.False(t, IsNotFound(err))
+}
+
+func TestClient_TokenSourceError(t *testing.T) {
+
+	client := NewClient(TokenSourceFunc(func(context.Context) (string, error) {
+		return "", errors.New("no grant")
+	}))
+
+	_, err := client.User(context.Background())
+	require.ErrorContains(t, err, "no grant")
+}
+
+func TestClient_PlansRoutesAndPowerZones(t *testing.T) {
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/plans":
+			_, _ = w.Write([]byte(`[{"id":1,"name":"Sweet spot","file":{"url":"https://example.com/plan.json"}}]`))
+		case "/v1/routes/7":
+			_, _ = w.Write([]byte(`{"id":7,"name":"Loop","distance":42195.0,"start_lat":51.5,"start_lng":-0.12}`))
+		case "/v1/power_zones":
+			_, _ = w.Write([]byte(`[{"id":3,"ftp":250,"zone_1":137,"zone_count":7}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	plans, err := client.ListPlans(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/plan.json", plans[0].File.URL)
+
+	route, err := client.GetRoute(context.Background(), 7)
+	require.NoError(t, err)
+	require.Equal(t, 42195.0, route.Distance)
+
+	zones, err := client.ListPowerZones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 250, zones[0].Ftp)
+
+	_, err = client.GetPlan(context.Background(), 99)
+	require.True(t, IsNotFound(err))
+}
@@ -0,0 +1,84 @@
+package wahoo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is returned for any non-2xx response from the Wahoo Cloud API.
+type APIError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Message    string
+	Body       []byte
+	// RetryAfter is set from the Retry-After header on rate limited responses.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("wahoo api %s %s returned status %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("wahoo api %s %s returned status %d", e.Method, e.Path, e.StatusCode)
+}
+
+// HTTPStatusCode lets the retry package classify the failure.
+func (e *APIError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+func newAPIError(method, path string, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     method,
+		Path:       path,
+		Body:       body,
+	}
+
+	var payload struct {
+		Error   string   `json:"error"`
+		Message string   `json:"message"`
+		Errors  []string `json:"errors"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		switch {
+		case payload.Error != "":
+			apiErr.Message = payload.Error
+		case payload.Message != "":
+			apiErr.Message = payload.Message
+		case len(payload.Errors) > 0:
+			apiErr.Message = strings.Join(payload.Errors, "; ")
+		}
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+func hasStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// IsNotFound reports whether err is a 404 from the Wahoo Cloud API.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized reports whether err is a 401, typically a revoked or expired grant.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsRateLimited reports whether err is a 429 from the Wahoo Cloud API.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
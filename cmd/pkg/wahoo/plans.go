@@ -0,0 +1,47 @@
+package wahoo
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type PlanFile struct {
+	URL string `json:"url"`
+}
+
+type Plan struct {
+	ID                    int       `json:"id"`
+	UserID                int       `json:"user_id"`
+	Name                  string    `json:"name"`
+	Description           string    `json:"description"`
+	File                  PlanFile  `json:"file"`
+	WorkoutTypeFamilyID   int       `json:"workout_type_family_id"`
+	WorkoutTypeLocationID int       `json:"workout_type_location_id"`
+	ExternalID            string    `json:"external_id"`
+	ProviderUpdatedAt     time.Time `json:"provider_updated_at"`
+	Deleted               bool      `json:"deleted"`
+	CreatedAt             time.Time `json:"created_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+func (c *Client) ListPlans(ctx context.Context) ([]Plan, error) {
+	var plans []Plan
+	if err := c.do(ctx, http.MethodGet, "/v1/plans", nil, nil, &plans); err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+func (c *Client) GetPlan(ctx context.Context, id int) (*Plan, error) {
+	var plan Plan
+	if err := c.do(ctx, http.MethodGet, "/v1/plans/"+strconv.Itoa(id), nil, nil, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (c *Client) DeletePlan(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/v1/plans/"+strconv.Itoa(id), nil, nil, nil)
+}
@@ -0,0 +1,44 @@
+package wahoo
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type PowerZone struct {
+	ID                    int       `json:"id"`
+	UserID                int       `json:"user_id"`
+	Zone1                 int       `json:"zone_1"`
+	Zone2                 int       `json:"zone_2"`
+	Zone3                 int       `json:"zone_3"`
+	Zone4                 int       `json:"zone_4"`
+	Zone5                 int       `json:"zone_5"`
+	Zone6                 int       `json:"zone_6"`
+	Zone7                 int       `json:"zone_7"`
+	Ftp                   int       `json:"ftp"`
+	ZoneCount             int       `json:"zone_count"`
+	WorkoutTypeID         int       `json:"workout_type_id"`
+	WorkoutTypeFamilyID   int       `json:"workout_type_family_id"`
+	WorkoutTypeLocationID int       `json:"workout_type_location_id"`
+	CriticalPower         int       `json:"critical_power"`
+	CreatedAt             time.Time `json:"created_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+func (c *Client) ListPowerZones(ctx context.Context) ([]PowerZone, error) {
+	var zones []PowerZone
+	if err := c.do(ctx, http.MethodGet, "/v1/power_zones", nil, nil, &zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+func (c *Client) GetPowerZone(ctx context.Context, id int) (*PowerZone, error) {
+	var zone PowerZone
+	if err := c.do(ctx, http.MethodGet, "/v1/power_zones/"+strconv.Itoa(id), nil, nil, &zone); err != nil {
+		return nil, err
+	}
+	return &zone, nil
+}
@@ -0,0 +1,51 @@
+package wahoo
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RouteFile struct {
+	URL string `json:"url"`
+}
+
+type Route struct {
+	ID                  int       `json:"id"`
+	UserID              int       `json:"user_id"`
+	Name                string    `json:"name"`
+	Description         string    `json:"description"`
+	File                RouteFile `json:"file"`
+	WorkoutTypeFamilyID int       `json:"workout_type_family_id"`
+	ExternalID          string    `json:"external_id"`
+	StartLat            float64   `json:"start_lat"`
+	StartLng            float64   `json:"start_lng"`
+	Distance            float64   `json:"distance"`
+	Ascent              float64   `json:"ascent"`
+	Descent             float64   `json:"descent"`
+	ProviderUpdatedAt   time.Time `json:"provider_updated_at"`
+	Deleted             bool      `json:"deleted"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+func (c *Client) ListRoutes(ctx context.Context) ([]Route, error) {
+	var routes []Route
+	if err := c.do(ctx, http.MethodGet, "/v1/routes", nil, nil, &routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+func (c *Client) GetRoute(ctx context.Context, id int) (*Route, error) {
+	var route Route
+	if err := c.do(ctx, http.MethodGet, "/v1/routes/"+strconv.Itoa(id), nil, nil, &route); err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+func (c *Client) DeleteRoute(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/v1/routes/"+strconv.Itoa(id), nil, nil, nil)
+}
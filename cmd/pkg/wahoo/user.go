@@ -0,0 +1,29 @@
+package wahoo
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type User struct {
+	ID        int       `json:"id"`
+	Height    string    `json:"height"`
+	Weight    string    `json:"weight"`
+	First     string    `json:"first"`
+	Last      string    `json:"last"`
+	Email     string    `json:"email"`
+	Birth     string    `json:"birth"`
+	Gender    int       `json:"gender"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// User returns the athlete the access token belongs to.
+func (c *Client) User(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "/v1/user", nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,146 @@
+package wahoo
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/james-millner/go-wahoo-cloud-api/cmd/internal/webhook"
+)
+
+// Workout and WorkoutSummary share their shape with the webhook payload.
+type (
+	Workout        = webhook.Workout
+	WorkoutSummary = webhook.WorkoutSummary
+)
+
+// DefaultPerPage is the page size used when ListOptions.PerPage is unset.
+const DefaultPerPage = 30
+
+type ListOptions struct {
+	Page    int
+	PerPage int
+}
+
+func (o ListOptions) values() url.Values {
+	query := url.Values{}
+	if o.Page > 0 {
+		query.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage > 0 {
+		query.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+	return query
+}
+
+// WorkoutPage is a single page of the athlete's workouts, newest first.
+type WorkoutPage struct {
+	Workouts []Workout `json:"workouts"`
+	Total    int       `json:"total"`
+	Page     int       `json:"page"`
+	PerPage  int       `json:"per_page"`
+	Order    string    `json:"order"`
+	Sort     string    `json:"sort"`
+}
+
+// HasNext reports whether further pages follow this one.
+func (p *WorkoutPage) HasNext() bool {
+	return p.PerPage > 0 && p.Page*p.PerPage < p.Total
+}
+
+// WorkoutInput holds the writable attributes of a workout.
+type WorkoutInput struct {
+	Name          string
+	WorkoutToken  string
+	WorkoutTypeID int
+	Starts        time.Time
+	Minutes       int
+	PlanID        int
+}
+
+func (in WorkoutInput) form() url.Values {
+	form := url.Values{}
+	form.Set("workout[name]", in.Name)
+	form.Set("workout[workout_token]", in.WorkoutToken)
+	form.Set("workout[workout_type_id]", strconv.Itoa(in.WorkoutTypeID))
+	form.Set("workout[starts]", in.Starts.UTC().Format(time.RFC3339))
+	form.Set("workout[minutes]", strconv.Itoa(in.Minutes))
+	if in.PlanID != 0 {
+		form.Set("workout[plan_id]", strconv.Itoa(in.PlanID))
+	}
+	return form
+}
+
+func (c *Client) ListWorkouts(ctx context.Context, opts ListOptions) (*WorkoutPage, error) {
+	var page WorkoutPage
+	if err := c.do(ctx, http.MethodGet, "/v1/workouts", opts.values(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// EachWorkout walks every page of the athlete's workouts starting at
+// opts.Page, calling fn for each one until fn returns an error.
+func (c *Client) EachWorkout(ctx context.Context, opts ListOptions, fn func(page int, workout Workout) error) error {
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+	if opts.PerPage < 1 {
+		opts.PerPage = DefaultPerPage
+	}
+
+	for {
+		page, err := c.ListWorkouts(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, workout := range page.Workouts {
+			if err := fn(opts.Page, workout); err != nil {
+				return err
+			}
+		}
+		if !page.HasNext() || len(page.Workouts) == 0 {
+			return nil
+		}
+		opts.Page++
+	}
+}
+
+func (c *Client) GetWorkout(ctx context.Context, id int) (*Workout, error) {
+	var workout Workout
+	if err := c.do(ctx, http.MethodGet, "/v1/workouts/"+strconv.Itoa(id), nil, nil, &workout); err != nil {
+		return nil, err
+	}
+	return &workout, nil
+}
+
+func (c *Client) CreateWorkout(ctx context.Context, in WorkoutInput) (*Workout, error) {
+	var workout Workout
+	if err := c.do(ctx, http.MethodPost, "/v1/workouts", nil, in.form(), &workout); err != nil {
+		return nil, err
+	}
+	return &workout, nil
+}
+
+func (c *Client) UpdateWorkout(ctx context.Context, id int, in WorkoutInput) (*Workout, error) {
+	var workout Workout
+	if err := c.do(ctx, http.MethodPut, "/v1/workouts/"+strconv.Itoa(id), nil, in.form(), &workout); err != nil {
+		return nil, err
+	}
+	return &workout, nil
+}
+
+func (c *Client) DeleteWorkout(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/v1/workouts/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+func (c *Client) GetWorkoutSummary(ctx context.Context, workoutID int) (*WorkoutSummary, error) {
+	var summary WorkoutSummary
+	path := "/v1/workouts/" + strconv.Itoa(workoutID) + "/workout_summary"
+	if err := c.do(ctx, http.MethodGet, path, nil, nil, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
@@ -0,0 +1,99 @@
+package wahoo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_EachWorkout_WalksAllPages(t *testing.T) {
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/workouts", r.URL.Path)
+		require.Equal(t, "2", r.URL.Query().Get("per_page"))
+
+		switch r.URL.Query().Get("page") {
+		case "1":
+			_, _ = w.Write([]byte(`{"workouts":[{"id":5},{"id":4}],"total":5,"page":1,"per_page":2}`))
+		case "2":
+			_, _ = w.Write([]byte(`{"workouts":[{"id":3},{"id":2}],"total":5,"page":2,"per_page":2}`))
+		case "3":
+			_, _ = w.Write([]byte(`{"workouts":[{"id":1}],"total":5,"page":3,"per_page":2}`))
+		default:
+			t.Fatalf("Unexpected page %s", r.URL.Query().Get("page"))
+		}
+	})
+
+	var ids []int
+	err := client.EachWorkout(context.Background(), ListOptions{PerPage: 2}, func(page int, workout Workout) error {
+		ids = append(ids, workout.ID)
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []int{5, 4, 3, 2, 1}, ids)
+}
+
+func TestClient_EachWorkout_StopsOnError(t *testing.T) {
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"workouts":[{"id":5},{"id":4}],"total":4,"page":1,"per_page":2}`))
+	})
+
+	err := client.EachWorkout(context.Background(), ListOptions{PerPage: 2}, func(page int, workout Workout) error {
+		return fmt.Errorf("stop at %d", workout.ID)
+	})
+
+	require.EqualError(t, err, "stop at 5")
+}
+
+func TestClient_CreateUpdateDeleteWorkout(t *testing.T) {
+
+	starts := time.Date(2024, 4, 12, 17, 34, 45, 0, time.UTC)
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/workouts":
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "Cycling", r.PostForm.Get("workout[name]"))
+			require.Equal(t, "2024-04-12T17:34:45Z", r.PostForm.Get("workout[starts]"))
+			_, _ = w.Write([]byte(`{"id":281788767,"name":"Cycling","minutes":61}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/v1/workouts/281788767":
+			require.NoError(t, r.ParseForm())
+			_, _ = w.Write([]byte(`{"id":281788767,"name":"` + r.PostForm.Get("workout[name]") + `"}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1/workouts/281788767":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	input := WorkoutInput{Name: "Cycling", WorkoutToken: "token", Starts: starts, Minutes: 61}
+	created, err := client.CreateWorkout(context.Background(), input)
+	require.NoError(t, err)
+	require.Equal(t, 281788767, created.ID)
+
+	input.Name = "Evening ride"
+	updated, err := client.UpdateWorkout(context.Background(), created.ID, input)
+	require.NoError(t, err)
+	require.Equal(t, "Evening ride", updated.Name)
+
+	require.NoError(t, client.DeleteWorkout(context.Background(), created.ID))
+}
+
+func TestClient_GetWorkoutSummary(t *testing.T) {
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/workouts/281788767/workout_summary", r.URL.Path)
+		_, _ = w.Write([]byte(`{"id":252869305,"power_avg":"122.0","file":{"url":"https://cdn.wahooligan.com/file.fit"}}`))
+	})
+
+	summary, err := client.GetWorkoutSummary(context.Background(), 281788767)
+	require.NoError(t, err)
+	require.Equal(t, 252869305, summary.ID)
+	require.Equal(t, "https://cdn.wahooligan.com/file.fit", summary.File.URL)
+}
@@ -0,0 +1,70 @@
+package workouttype
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestByID(t *testing.T) {
+
+	require.Equal(t, Type{ID: 61, Name: "biking_indoor_trainer", Family: FamilyBiking, Indoor: true}, ByID(61))
+	require.Equal(t, Type{ID: 1, Name: "running", Family: FamilyRunning}, ByID(1))
+	require.Equal(t, Type{ID: 255, Name: "unknown", Family: FamilyOther}, ByID(Unknown))
+	require.Equal(t, Type{ID: 99, Name: "unknown_99", Family: FamilyOther}, ByID(99))
+
+	_, ok := Lookup(99)
+	require.False(t, ok)
+}
+
+func TestCatalogueNamesAreUnique(t *testing.T) {
+
+	seen := make(map[string]int)
+	for _, workoutType := range All() {
+		id, duplicate := seen[workoutType.Name]
+		require.False(t, duplicate, "%s is used by %d and %d", workoutType.Name, id, workoutType.ID)
+		seen[workoutType.Name] = workoutType.ID
+
+		byName, ok := ByName(workoutType.Name)
+		require.True(t, ok)
+		require.Equal(t, workoutType, byName)
+	}
+}
+
+func TestFilter(t *testing.T) {
+
+	testCases := []struct {
+		spec    string
+		matches []int
+		misses  []int
+	}{
+		{spec: "", matches: []int{0, 5, 25, 99}},
+		{spec: "running", matches: []int{1, 4, 5, 71}, misses: []int{0, 6}},
+		{spec: "biking_road, Hiking", matches: []int{15, 9}, misses: []int{0, 13}},
+		{spec: "biking,indoor", matches: []int{12, 61, 68}, misses: []int{0, 15, 5}},
+		{spec: "outdoor", matches: []int{0, 1, 26}, misses: []int{12, 25}},
+		{spec: "61,99", matches: []int{61, 99}, misses: []int{0}},
+		{spec: "type:running", matches: []int{1}, misses: []int{4, 5, 71}},
+		{spec: "Type:Biking,family:running", matches: []int{0, 1, 4}, misses: []int{15, 61}},
+		{spec: "type:61", matches: []int{61}, misses: []int{12}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			filter, err := ParseFilter(tc.spec)
+			require.NoError(t, err)
+			require.Equal(t, tc.spec == "", filter.MatchesAll())
+			for _, id := range tc.matches {
+				require.True(t, filter.Match(ByID(id)), "%d", id)
+			}
+			for _, id := range tc.misses {
+				require.False(t, filter.Match(ByID(id)), "%d", id)
+			}
+		})
+	}
+
+	for _, spec := range []string{"cycling", "indoor,outdoor", "type:cycling", "family:biking_road", "type:"} {
+		_, err := ParseFilter(spec)
+		require.Error(t, err, spec)
+	}
+}